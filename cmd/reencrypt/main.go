@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"suglider-auth/configs"
+	sqltable "suglider-auth/init/sql_table"
+	mariadb "suglider-auth/internal/database"
+)
+
+// reencrypt encrypts the TOTP secrets, the personal information and the
+// generated JWT signing keys which are plaintext or encrypted by a retired key with the active key of [encryption].
+// It takes the same flags as the server:
+//
+//   go run ./cmd/reencrypt -c configs/configuration/dev.toml
+//
+// It can be run again after it fails, the rows which have been encrypted by
+// the active key are skipped.
+
+func main() {
+	configs.Load()
+
+	if err := mariadb.Connect(); err != nil {
+		fmt.Printf("Can not connect to database: %v\n", err)
+		os.Exit(1)
+	}
+
+	keyRing := mariadb.FieldKeyRing()
+	if keyRing == nil {
+		fmt.Println("The [encryption] of the config is not configured, nothing to encrypt.")
+		mariadb.Close()
+		os.Exit(1)
+	}
+
+	// Make sure the columns are wide enough for the encrypted values
+	sqltable.SugliderTableInit()
+
+	fmt.Printf("Encrypt with the key %s ...\n", keyRing.Active())
+
+	steps := []struct {
+		name      string
+		reencrypt func() (int, error)
+	}{
+		{"totp_device", mariadb.ReencryptTotpDevices},
+		{"user_info", mariadb.ReencryptUserInfo},
+		{"personal_info", mariadb.ReencryptPersonalInfo},
+		{"jwt_signing_key", mariadb.ReencryptJwtSigningKeys},
+	}
+
+	for _, step := range steps {
+		count, err := step.reencrypt()
+		if err != nil {
+			fmt.Printf("Fail to encrypt %s after %d rows: %v\n", step.name, count, err)
+			mariadb.Close()
+			os.Exit(1)
+		}
+		fmt.Printf("%s: %d rows encrypted.\n", step.name, count)
+	}
+
+	mariadb.Close()
+	fmt.Println("Encrypt successfully, the retired keys can be removed from the config.")
+}
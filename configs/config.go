@@ -16,6 +16,9 @@ var (
 	Args              *Arguments
 )
 
+// The functions which apply the config to the packages, see OnLoad
+var loadHooks []func()
+
 type Arguments struct {
 	Host    string
 	Config  string
@@ -27,14 +30,23 @@ type Arguments struct {
 
 type (
 	Config struct {
-		Database *Database        `toml:"database"`
-		Redis    *Redis           `toml:"redis"`
-		Session  *Session         `toml:"session"`
-		Server   *serverSettings  `toml:"server"`
-		Log      *logSettings     `toml:"log"`
-		Swagger  *swaggerSettings `toml:"swagger"`
-		Mail     *mailSettings    `toml:"mail"`
-		Oauth    *Oauth           `toml:"oauth"`
+		Database       *Database        `toml:"database"`
+		Redis          *Redis           `toml:"redis"`
+		Session        *Session         `toml:"session"`
+		Jwt            *Jwt             `toml:"jwt"`
+		Server         *serverSettings  `toml:"server"`
+		Log            *logSettings     `toml:"log"`
+		Swagger        *swaggerSettings `toml:"swagger"`
+		Mail           *mailSettings    `toml:"mail"`
+		Oauth          *Oauth           `toml:"oauth"`
+		Oidc           *Oidc            `toml:"oidc"`
+		LoginChallenge *LoginChallenge  `toml:"login_challenge"`
+		Sms            *Sms             `toml:"sms"`
+		WebAuthn       *WebAuthn        `toml:"webauthn"`
+		Totp           *Totp            `toml:"totp"`
+		Encryption     *Encryption      `toml:"encryption"`
+		Lockout        *Lockout         `toml:"lockout"`
+		RateLimit      *RateLimit       `toml:"rate_limit"`
 	}
 	Database struct {
 		Host       string `toml:"host"`
@@ -51,27 +63,55 @@ type (
 		Password string `toml:"password"`
 	}
 	Session struct {
-		Timeout  string `toml:"timeout"`
-		Path     string `toml:"path"`
-		HttpOnly bool   `toml:"http_only"`
+		Timeout         string         `toml:"timeout"` // deprecated, the default of idle_timeout and absolute_timeout
+		IdleTimeout     string         `toml:"idle_timeout"`
+		AbsoluteTimeout string         `toml:"absolute_timeout"`
+		ReauthWindow    string         `toml:"reauth_window"`
+		Store           string         `toml:"store"`
+		MaxSessions     int            `toml:"max_sessions"`
+		LimitPolicy     string         `toml:"limit_policy"`
+		RoleMaxSessions map[string]int `toml:"role_max_sessions"`
+		AuthKey         string         `toml:"auth_key"`
+		EncryptionKey   string         `toml:"encryption_key"`
+		PreviousKeys    []SessionKey   `toml:"previous_keys"`
+		Path            string         `toml:"path"`
+		Domain          string         `toml:"domain"`
+		Secure          bool           `toml:"secure"`
+		SameSite        string         `toml:"same_site"`
+		HttpOnly        bool           `toml:"http_only"`
+	}
+	SessionKey struct {
+		AuthKey       string `toml:"auth_key"`
+		EncryptionKey string `toml:"encryption_key"`
+	}
+	Jwt struct {
+		Algorithm        string   `toml:"algorithm"`
+		KeyLength        int      `toml:"key_length"`
+		KeyFiles         []string `toml:"key_files"`
+		RotationInterval string   `toml:"rotation_interval"`
+		AccessTokenTTL   string   `toml:"access_token_ttl"`
+		RefreshTokenTTL  string   `toml:"refresh_token_ttl"`
+		Audience         string   `toml:"audience"`
 	}
 	serverSettings struct {
-		TemplatePath    string `toml:"template_path"`
-		StaticPath      string `toml:"static_path"`
-		SwaggerPath     string `toml:"swagger_path"`
-		CasbinConfig    string `toml:"casbin_config"`
-		CasbinTable     string `toml:"casbin_table"`
-		GracefulTimeout int    `toml:"graceful_timeout"`
-		ReadTimeout     int    `toml:"read_timeout"`
-		WriteTimeout    int    `toml:"write_timeout"`
-		MaxHeaderBytes  int    `toml:"max_header_bytes"`
-		EnableRbac      bool   `toml:"enable_rbac"`
-		EnableCors      bool   `toml:"enable_cors"`
-		CorsCredentials bool   `toml:"cors_credentials"`
-		CorsOrigin      string `toml:"cors_origin"`
-		CorsMethods     string `toml:"cors_methods"`
-		CorsHeaders     string `toml:"cors_headers"`
-		CasbinCache     bool   `toml:"casbin_cache"`
+		TemplatePath    string   `toml:"template_path"`
+		StaticPath      string   `toml:"static_path"`
+		SwaggerPath     string   `toml:"swagger_path"`
+		CasbinConfig    string   `toml:"casbin_config"`
+		CasbinTable     string   `toml:"casbin_table"`
+		GracefulTimeout int      `toml:"graceful_timeout"`
+		ReadTimeout     int      `toml:"read_timeout"`
+		WriteTimeout    int      `toml:"write_timeout"`
+		MaxHeaderBytes  int      `toml:"max_header_bytes"`
+		EnableRbac      bool     `toml:"enable_rbac"`
+		EnableCors      bool     `toml:"enable_cors"`
+		CorsCredentials bool     `toml:"cors_credentials"`
+		CorsOrigin      string   `toml:"cors_origin"`
+		CorsMethods     string   `toml:"cors_methods"`
+		CorsHeaders     string   `toml:"cors_headers"`
+		CasbinCache     bool     `toml:"casbin_cache"`
+		EnableCsrf      bool     `toml:"enable_csrf"`
+		CsrfExemptPaths []string `toml:"csrf_exempt_paths"`
 	}
 	logSettings struct {
 		Filelog *lumberjack.Logger `toml:"filelog"`
@@ -105,7 +145,8 @@ type (
 		Port       int    `toml:"port"`
 	}
 	mailExpired struct {
-		TTL int64 `toml:"ttl"`
+		TTL       int64  `toml:"ttl"`
+		MagicLink string `toml:"magic_link"`
 	}
 
 	Oauth struct {
@@ -116,6 +157,71 @@ type (
 		ClientID     string `toml:"client_id"`
 		ClientSecret string `toml:"client_secret"`
 	}
+
+	LoginChallenge struct {
+		TTL             string `toml:"ttl"`
+		MaxFailures     int    `toml:"max_failures"`
+		RequiredFactors int    `toml:"required_factors"`
+	}
+	Sms struct {
+		Provider    string `toml:"provider"` // hinet (or cht) and twilio
+		Username    string `toml:"username"` // hinet
+		Password    string `toml:"password"` // hinet, base64 encoded
+		AccountSid  string `toml:"account_sid"`
+		AuthToken   string `toml:"auth_token"`
+		ApiKey      string `toml:"api_key"`
+		ApiSecret   string `toml:"api_secret"`
+		PhoneNumber string `toml:"phone_number"` // twilio, the number which sends the messages
+		CountryCode string `toml:"country_code"`
+		Timeout     int64  `toml:"timeout"`
+		OTPTTL      string `toml:"otp_ttl"`
+	}
+	WebAuthn struct {
+		RPID          string   `toml:"rp_id"`
+		RPDisplayName string   `toml:"rp_display_name"`
+		RPOrigins     []string `toml:"rp_origins"`
+		CeremonyTTL   string   `toml:"ceremony_ttl"`
+	}
+	Totp struct {
+		Issuer    string `toml:"issuer"`
+		Digits    int    `toml:"digits"`
+		Period    uint   `toml:"period"` // in seconds
+		Algorithm string `toml:"algorithm"`
+		Skew      uint   `toml:"skew"` // how many periods before and after now are accepted
+	}
+	Lockout struct {
+		Threshold   int64  `toml:"threshold"`    // failed attempts of an account before it's locked
+		IPThreshold int64  `toml:"ip_threshold"` // failed attempts from an IP before it's locked
+		Window      string `toml:"window"`
+		BackoffBase string `toml:"backoff_base"`
+		BackoffMax  string `toml:"backoff_max"`
+		Duration    string `toml:"duration"`
+		MaxDuration string `toml:"max_duration"`
+	}
+	RateLimit struct {
+		Enabled bool            `toml:"enabled"`
+		Rules   []RateLimitRule `toml:"rules"`
+	}
+	RateLimitRule struct {
+		Path         string `toml:"path"`
+		Method       string `toml:"method"` // empty means every method
+		IPLimit      int64  `toml:"ip_limit"`
+		IPPeriod     string `toml:"ip_period"`
+		Target       string `toml:"target"` // the query parameter or the JSON field which identifies the target
+		TargetLimit  int64  `toml:"target_limit"`
+		TargetPeriod string `toml:"target_period"`
+	}
+	Encryption struct {
+		ActiveKey string            `toml:"active_key"`
+		IndexKey  string            `toml:"index_key"` // base64, the HMAC key of the blind indexes
+		Keys      map[string]string `toml:"keys"`      // version to base64 AES key
+	}
+	Oidc struct {
+		Issuer     string `toml:"issuer"`
+		LoginUrl   string `toml:"login_url"`
+		CodeTTL    string `toml:"code_ttl"`
+		IDTokenTTL string `toml:"id_token_ttl"`
+	}
 )
 
 func parseFlags() *Arguments {
@@ -136,8 +242,33 @@ func parseFlags() *Arguments {
 	return args
 }
 
-func loadConfig() {
+// OnLoad registers a function which applies the config to a package, the
+// packages register it in their init. The functions run after the config is
+// loaded, in the order of the package dependencies.
+func OnLoad(hook func()) {
+	loadHooks = append(loadHooks, hook)
+}
+
+// Load reads the config by the flags of the command, it must be called
+// before the packages are used.
+func Load() {
 	Args = parseFlags()
+	loadConfig()
+}
+
+// LoadFile reads the config file without parsing the flags, the flags of a
+// test binary belong to go test.
+func LoadFile(path string) {
+	Args = &Arguments{
+		Host:    "127.0.0.1",
+		Port:    9527,
+		Subpath: "/",
+		Config:  path,
+	}
+	loadConfig()
+}
+
+func loadConfig() {
 	if Args.Config == "" {
 		// Database
 		ApplicationConfig.Database.Host = os.Getenv("DB_HOST")
@@ -165,6 +296,10 @@ func loadConfig() {
 			panic(err)
 		}
 	}
+
+	for _, hook := range loadHooks {
+		hook()
+	}
 }
 
 func (l *syslogSettings) CreateSyslogLogger(name string) (*syslog.Writer, error) {
@@ -191,7 +326,3 @@ func (l *syslogSettings) CreateSyslogLogger(name string) (*syslog.Writer, error)
 
 	return logger, err
 }
-
-func init() {
-	loadConfig()
-}
@@ -0,0 +1,16 @@
+package configtest
+
+import (
+	"path/filepath"
+	"runtime"
+
+	"suglider-auth/configs"
+)
+
+// Load reads the dev config for the tests, the packages which need the
+// config call it in TestMain.
+func Load() {
+	_, file, _, _ := runtime.Caller(0)
+
+	configs.LoadFile(filepath.Join(filepath.Dir(file), "..", "configuration", "dev.toml"))
+}
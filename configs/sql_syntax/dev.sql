@@ -8,7 +8,8 @@ CREATE TABLE IF NOT EXISTS suglider.user_info (
     password VARCHAR(256),
     last_name VARCHAR(10),
     first_name VARCHAR(10),
-    phone_number VARCHAR(15) DEFAULT NULL,
+    phone_number VARCHAR(256) DEFAULT NULL,
+    phone_number_hash CHAR(64) DEFAULT NULL,
     mail VARCHAR(256) NOT NULL,
     mail_verified INT UNSIGNED NOT NULL DEFAULT 0,
     mail_otp_enabled BOOL DEFAULT false,
@@ -19,17 +20,27 @@ CREATE TABLE IF NOT EXISTS suglider.user_info (
     PRIMARY KEY(user_id),
     UNIQUE(username),
     UNIQUE(phone_number),
+    UNIQUE(phone_number_hash),
     UNIQUE(mail));
 
+-- The phone number is encrypted when [encryption] is configured, it's unique
+-- by the blind index phone_number_hash
+ALTER TABLE suglider.user_info MODIFY phone_number VARCHAR(256) DEFAULT NULL,
+    ADD COLUMN IF NOT EXISTS phone_number_hash CHAR(64) DEFAULT NULL AFTER phone_number,
+    ADD UNIQUE INDEX IF NOT EXISTS phone_number_hash (phone_number_hash);
+
 CREATE TABLE IF NOT EXISTS suglider.personal_info (
     user_id BINARY(16) NOT NULL,
-    address VARCHAR(256) DEFAULT NULL,
-    birthday DATE DEFAULT NULL,
+    address VARCHAR(1024) DEFAULT NULL,
+    birthday VARCHAR(256) DEFAULT NULL,
     sex VARCHAR(10) DEFAULT NULL,
     blood_type VARCHAR(10) DEFAULT NULL,
     PRIMARY KEY(user_id),
 	FOREIGN KEY(user_id) REFERENCES user_info(user_id) ON DELETE CASCADE);
 
+ALTER TABLE suglider.personal_info MODIFY address VARCHAR(1024) DEFAULT NULL,
+    MODIFY birthday VARCHAR(256) DEFAULT NULL;
+
 CREATE TABLE IF NOT EXISTS aes (
     user_id BINARY(16) NOT NULL,
     aes_encrypt_key VARCHAR(32) NOT NULL,
@@ -47,9 +58,108 @@ CREATE TABLE IF NOT EXISTS suglider.totp (
     totp_verified BOOL DEFAULT false,
     totp_secret VARCHAR(256) NOT NULL,
     totp_url VARCHAR(256) NOT NULL,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
     PRIMARY KEY(user_id),
     FOREIGN KEY(user_id) REFERENCES user_info(user_id) ON DELETE CASCADE);
 
+ALTER TABLE suglider.totp ADD COLUMN IF NOT EXISTS last_used_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS suglider.totp_device (
+    device_id VARCHAR(32) NOT NULL,
+    user_id BINARY(16) NOT NULL,
+    name VARCHAR(256) NOT NULL,
+    totp_secret VARCHAR(1024) NOT NULL,
+    totp_url VARCHAR(1024) NOT NULL,
+    verified BOOL DEFAULT false,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    last_used_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(device_id),
+    UNIQUE(user_id, name),
+    FOREIGN KEY(user_id) REFERENCES user_info(user_id) ON DELETE CASCADE);
+
+ALTER TABLE suglider.totp_device MODIFY totp_secret VARCHAR(1024) NOT NULL,
+    MODIFY totp_url VARCHAR(1024) NOT NULL;
+
+-- The secret of the totp table becomes the default device of the user
+INSERT IGNORE INTO suglider.totp_device(device_id, user_id, name, totp_secret, totp_url, verified, last_used_step)
+    SELECT LOWER(HEX(user_id)), user_id, 'default', totp_secret, totp_url, totp_verified, last_used_step
+    FROM suglider.totp WHERE totp_secret <> '';
+
+UPDATE suglider.totp SET totp_secret = '', totp_url = '' WHERE totp_secret <> '';
+
+CREATE TABLE IF NOT EXISTS suglider.totp_recovery_code (
+    user_id BINARY(16) NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(user_id, code_hash),
+    FOREIGN KEY(user_id) REFERENCES user_info(user_id) ON DELETE CASCADE);
+
+CREATE TABLE IF NOT EXISTS suglider.oidc_client (
+    client_id VARCHAR(64) NOT NULL,
+    client_secret VARCHAR(256) DEFAULT NULL,
+    client_name VARCHAR(256) NOT NULL,
+    redirect_uris TEXT NOT NULL,
+    scopes VARCHAR(256) NOT NULL DEFAULT 'openid profile email',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(client_id));
+
+CREATE TABLE IF NOT EXISTS suglider.oauth2_client (
+    client_id VARCHAR(64) NOT NULL,
+    client_secret VARCHAR(256) NOT NULL,
+    client_name VARCHAR(256) NOT NULL,
+    scopes VARCHAR(1024) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(client_id));
+
+CREATE TABLE IF NOT EXISTS suglider.api_key (
+    key_id VARCHAR(32) NOT NULL,
+    user_id BINARY(16) NOT NULL,
+    name VARCHAR(256) NOT NULL,
+    key_prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) NOT NULL,
+    scopes VARCHAR(1024) NOT NULL DEFAULT '',
+    expires_at TIMESTAMP NULL DEFAULT NULL,
+    last_used_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(key_id),
+    UNIQUE(key_hash),
+    FOREIGN KEY(user_id) REFERENCES user_info(user_id) ON DELETE CASCADE);
+
+-- Every API key expires, the keys created without expiry get the default lifetime
+UPDATE suglider.api_key SET expires_at = DATE_ADD(created_at, INTERVAL 90 DAY) WHERE expires_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS suglider.webauthn_credential (
+    credential_id VARCHAR(32) NOT NULL,
+    user_id BINARY(16) NOT NULL,
+    name VARCHAR(256) NOT NULL,
+    raw_id VARBINARY(1023) NOT NULL,
+    credential TEXT NOT NULL,
+    last_used_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(credential_id),
+    UNIQUE(raw_id),
+    UNIQUE(user_id, name),
+    FOREIGN KEY(user_id) REFERENCES user_info(user_id) ON DELETE CASCADE);
+
+CREATE TABLE IF NOT EXISTS suglider.user_session (
+    sid VARCHAR(64) NOT NULL,
+    mail VARCHAR(256) NOT NULL,
+    data TEXT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    PRIMARY KEY(sid),
+    INDEX(mail),
+    INDEX(expires_at));
+
+CREATE TABLE IF NOT EXISTS suglider.jwt_signing_key (
+    kid VARCHAR(64) NOT NULL,
+    private_key TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    retired_at BIGINT NULL,
+    PRIMARY KEY(kid),
+    INDEX(retired_at));
+
 CREATE TABLE IF NOT EXISTS `casbin_policies` (
     `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
     `p_type` VARCHAR(32) NOT NULL DEFAULT '',
@@ -98,6 +98,10 @@ func DisableCustomHandker(hdlf gin.HandlerFunc, env string) gin.HandlerFunc {
 }
 
 func init() {
+	configs.OnLoad(loadSwagger)
+}
+
+func loadSwagger() {
 	// set SWAGGER_OFF env to disable swagger.
 	if configs.ApplicationConfig.Swagger.Theme == "redoc" {
 		swag = DisableCustomHandker(redocHandler, "SWAGGER_OFF")
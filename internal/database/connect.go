@@ -10,7 +10,9 @@ import (
 
 var DataBase *sqlx.DB
 
-func init() {
+// Connect opens the MariaDB connection, it's called once at startup so that
+// the packages using the database can be imported without a live MariaDB.
+func Connect() error {
 
 	var dbErr error
 
@@ -31,10 +33,7 @@ func init() {
 	// Connect to MariaDB
 	DataBase, dbErr = sqlx.Connect("mysql", DataBaseURL)
 	if dbErr != nil {
-		errorMessage := fmt.Sprintf("Can not connect to database: %v", dbErr)
-		slog.Error(errorMessage)
-		
-		panic(dbErr)
+		return dbErr
 	}
 
 	// Set DB max connection
@@ -42,7 +41,7 @@ func init() {
 
 	slog.Info(fmt.Sprintf("Connected to DataBase successfully！"))
 
-
+	return nil
 }
 
 // Close DB connection
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+
+	"suglider-auth/configs"
+	"suglider-auth/pkg/encrypt"
+)
+
+// The TOTP secrets, phone numbers, addresses and birthdays are sealed by the
+// key ring of [encryption] before they are written and opened after they are
+// read, so the callers only see the plaintext. Without [encryption] they are
+// stored as plaintext like before.
+
+var fieldKeyRing *encrypt.KeyRing
+
+func init() {
+	configs.OnLoad(loadEncryptionConfig)
+}
+
+func loadEncryptionConfig() {
+	encryptionConfig := configs.ApplicationConfig.Encryption
+	if encryptionConfig == nil || encryptionConfig.ActiveKey == "" {
+		return
+	}
+
+	keys := make(map[string][]byte, len(encryptionConfig.Keys))
+	for version, encoded := range encryptionConfig.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Decode the encryption key %s failed: %v", version, err)
+			slog.Error(errorMessage)
+
+			panic(err)
+		}
+		keys[version] = key
+	}
+
+	indexKey, err := base64.StdEncoding.DecodeString(encryptionConfig.IndexKey)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Decode the encryption index key failed: %v", err)
+		slog.Error(errorMessage)
+
+		panic(err)
+	}
+
+	fieldKeyRing, err = encrypt.NewKeyRing(encryptionConfig.ActiveKey, keys, indexKey)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Initial encryption key ring failed: %v", err)
+		slog.Error(errorMessage)
+
+		panic(err)
+	}
+}
+
+// FieldKeyRing returns the key ring of [encryption], it's nil when the
+// encryption is not configured.
+func FieldKeyRing() *encrypt.KeyRing {
+	return fieldKeyRing
+}
+
+func sealField(value string) (string, error) {
+	if fieldKeyRing == nil || value == "" {
+		return value, nil
+	}
+	return fieldKeyRing.Seal(value)
+}
+
+func sealNullField(value *string) (*string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	sealed, err := sealField(*value)
+	if err != nil {
+		return nil, err
+	}
+	return &sealed, nil
+}
+
+func openField(value string) (string, error) {
+	if !encrypt.IsSealed(value) {
+		return value, nil
+	}
+	if fieldKeyRing == nil {
+		return "", fmt.Errorf("the value is encrypted but [encryption] is not configured")
+	}
+	return fieldKeyRing.Open(value)
+}
+
+func openNullField(value *sql.NullString) (err error) {
+	if !value.Valid {
+		return nil
+	}
+	value.String, err = openField(value.String)
+	return err
+}
+
+// phoneNumberIndex is the blind index of the phone number, it's nil when the
+// encryption is not configured and the phone number itself is unique.
+func phoneNumberIndex(phoneNumber *string) *string {
+	if fieldKeyRing == nil || phoneNumber == nil || *phoneNumber == "" {
+		return nil
+	}
+	index := fieldKeyRing.BlindIndex(*phoneNumber)
+	return &index
+}
+
+// resealField returns the value sealed by the active key and whether it has
+// been changed.
+func resealField(value string) (string, bool, error) {
+	if !fieldKeyRing.NeedsReseal(value) {
+		return value, false, nil
+	}
+
+	opened, err := openField(value)
+	if err != nil {
+		return "", false, err
+	}
+
+	sealed, err := sealField(opened)
+	if err != nil {
+		return "", false, err
+	}
+	return sealed, true, nil
+}
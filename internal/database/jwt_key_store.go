@@ -0,0 +1,40 @@
+package database
+
+import (
+	"time"
+
+	"suglider-auth/pkg/jwt"
+)
+
+// JwtKeyStore keeps the generated JWT signing keys in the jwt_signing_key
+// table, so the instances share them and a restart keeps them.
+type JwtKeyStore struct{}
+
+func (JwtKeyStore) Load(retiredSince time.Time) ([]jwt.StoredKey, error) {
+	jwtSigningKeyInfo, err := JwtSigningKeyList(retiredSince.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]jwt.StoredKey, 0, len(jwtSigningKeyInfo))
+	for _, info := range jwtSigningKeyInfo {
+		key := jwt.StoredKey{
+			Kid:        info.Kid,
+			PrivatePem: info.PrivateKey,
+			CreatedAt:  time.Unix(info.CreatedAt, 0),
+		}
+		if info.RetiredAt.Valid {
+			key.RetiredAt = time.Unix(info.RetiredAt.Int64, 0)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (JwtKeyStore) Rotate(key jwt.StoredKey, previousKid string, retiredBefore time.Time) (bool, error) {
+	return JwtSigningKeyRotate(JwtSigningKeyInfo{
+		Kid:        key.Kid,
+		PrivateKey: key.PrivatePem,
+		CreatedAt:  key.CreatedAt.Unix(),
+	}, previousKid, retiredBefore.Unix())
+}
@@ -8,23 +8,97 @@ type UserInfo struct {
 	Mail               string         `db:"mail"`
 	Password           sql.NullString `db:"password"`
 	FirstName          sql.NullString `db:"first_name"`
+	PhoneNumber        sql.NullString `db:"phone_number"`
 	PasswordExpireDate string         `db:"password_expire_date"`
 }
 
+// UserPIIInfo has the encrypted personal information of the user
+type UserPIIInfo struct {
+	UserID      string         `db:"user_id"`
+	PhoneNumber sql.NullString `db:"phone_number"`
+	Address     sql.NullString `db:"address"`
+	Birthday    sql.NullString `db:"birthday"`
+}
+
 type TotpUserInfo struct {
 	UserName     sql.NullString `db:"username"`
 	UserID       string         `db:"user_id"`
 	TotpEnabled  bool           `db:"totp_enabled"`
 	TotpVerified bool           `db:"totp_verified"`
-	TotpSecret   string         `db:"totp_secret"`
-	TotpURL      string         `db:"totp_url"`
+}
+
+type TotpDeviceInfo struct {
+	DeviceID   string  `db:"device_id" json:"device_id"`
+	Name       string  `db:"name" json:"name"`
+	Verified   bool    `db:"verified" json:"verified"`
+	TotpSecret string  `db:"totp_secret" json:"-"`
+	TotpURL    string  `db:"totp_url" json:"-"`
+	LastUsedAt *string `db:"last_used_at" json:"last_used_at"`
+	CreatedAt  string  `db:"created_at" json:"created_at"`
 }
 
 type UserTwoFactorAuthInfo struct {
-	Mail           string         `db:"mail"`
-	UserName       sql.NullString `db:"username"`
-	UserID         sql.NullString `db:"user_id"`
-	TotpEnabled    sql.NullBool   `db:"totp_enabled"`
-	MailOTPEnabled bool           `db:"mail_otp_enabled"`
-	SmsOTPEnabled  bool           `db:"sms_otp_enabled"`
+	Mail            string         `db:"mail"`
+	UserName        sql.NullString `db:"username"`
+	UserID          sql.NullString `db:"user_id"`
+	TotpEnabled     sql.NullBool   `db:"totp_enabled"`
+	MailOTPEnabled  bool           `db:"mail_otp_enabled"`
+	SmsOTPEnabled   bool           `db:"sms_otp_enabled"`
+	WebAuthnEnabled bool           `db:"webauthn_enabled"`
+}
+
+type OidcClientInfo struct {
+	ClientID     string         `db:"client_id" json:"client_id"`
+	ClientSecret sql.NullString `db:"client_secret" json:"-"`
+	ClientName   string         `db:"client_name" json:"client_name"`
+	RedirectURIs string         `db:"redirect_uris" json:"redirect_uris"`
+	Scopes       string         `db:"scopes" json:"scopes"`
+	CreatedAt    string         `db:"created_at" json:"created_at"`
+}
+
+type OidcUserInfo struct {
+	UserID       string         `db:"user_id"`
+	Mail         string         `db:"mail"`
+	MailVerified bool           `db:"mail_verified"`
+	UserName     sql.NullString `db:"username"`
+	FirstName    sql.NullString `db:"first_name"`
+	LastName     sql.NullString `db:"last_name"`
+}
+
+type OAuth2ClientInfo struct {
+	ClientID     string `db:"client_id" json:"client_id"`
+	ClientSecret string `db:"client_secret" json:"-"`
+	ClientName   string `db:"client_name" json:"client_name"`
+	Scopes       string `db:"scopes" json:"scopes"`
+	CreatedAt    string `db:"created_at" json:"created_at"`
+}
+
+type APIKeyInfo struct {
+	KeyID      string  `db:"key_id" json:"key_id"`
+	Mail       string  `db:"mail" json:"-"`
+	Name       string  `db:"name" json:"name"`
+	KeyPrefix  string  `db:"key_prefix" json:"key_prefix"`
+	Scopes     string  `db:"scopes" json:"scopes"`
+	ExpiresAt  *string `db:"expires_at" json:"expires_at"`
+	LastUsedAt *string `db:"last_used_at" json:"last_used_at"`
+	CreatedAt  string  `db:"created_at" json:"created_at"`
+}
+
+type WebAuthnCredentialInfo struct {
+	CredentialID string         `db:"credential_id" json:"credential_id"`
+	UserID       string         `db:"user_id" json:"-"`
+	Mail         string         `db:"mail" json:"-"`
+	UserName     sql.NullString `db:"username" json:"-"`
+	Name         string         `db:"name" json:"name"`
+	RawID        []byte         `db:"raw_id" json:"-"`
+	Credential   string         `db:"credential" json:"-"` // the WebAuthn credential in JSON
+	LastUsedAt   *string        `db:"last_used_at" json:"last_used_at"`
+	CreatedAt    string         `db:"created_at" json:"created_at"`
+}
+
+type JwtSigningKeyInfo struct {
+	Kid        string        `db:"kid"`
+	PrivateKey string        `db:"private_key"` // PEM, encrypted when [encryption] is configured
+	CreatedAt  int64         `db:"created_at"`
+	RetiredAt  sql.NullInt64 `db:"retired_at"`
 }
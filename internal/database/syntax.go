@@ -12,6 +12,10 @@ import (
 var dbTimeOut time.Duration
 
 func init() {
+	configs.OnLoad(loadDatabaseConfig)
+}
+
+func loadDatabaseConfig() {
 	var DatabaseConfig = configs.ApplicationConfig.Database
 	var err error
 
@@ -29,9 +33,14 @@ func UserSignUp(mail, password string, userName, firstName, lastName, phoneNumbe
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
-	sqlUserInfo := "INSERT INTO suglider.user_info(user_id, mail, password, username, first_name, last_name, phone_number, password_expire_date) " +
-		"VALUES (UNHEX(REPLACE(UUID(), '-', '')),?,?,?,?,?,?,DATE_ADD(CURRENT_DATE, INTERVAL 90 DAY))"
-	_, err = DataBase.ExecContext(ctx, sqlUserInfo, mail, password, userName, firstName, lastName, phoneNumber)
+	sealedPhoneNumber, err := sealNullField(phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	sqlUserInfo := "INSERT INTO suglider.user_info(user_id, mail, password, username, first_name, last_name, phone_number, phone_number_hash, password_expire_date) " +
+		"VALUES (UNHEX(REPLACE(UUID(), '-', '')),?,?,?,?,?,?,?,DATE_ADD(CURRENT_DATE, INTERVAL 90 DAY))"
+	_, err = DataBase.ExecContext(ctx, sqlUserInfo, mail, password, userName, firstName, lastName, sealedPhoneNumber, phoneNumberIndex(phoneNumber))
 	if err != nil {
 		return err
 	}
@@ -43,10 +52,15 @@ func UpdateSignUp(mail, password string, userName, firstName, lastName, phoneNum
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
+	sealedPhoneNumber, err := sealNullField(phoneNumber)
+	if err != nil {
+		return err
+	}
+
 	sqlStr := "UPDATE suglider.user_info " +
-		"SET password = ?, username = ?, first_name = ?, last_name = ?, phone_number = ?, password_expire_date = DATE_ADD(CURRENT_DATE, INTERVAL 90 DAY), password_updated_at = CURRENT_TIMESTAMP " +
+		"SET password = ?, username = ?, first_name = ?, last_name = ?, phone_number = ?, phone_number_hash = ?, password_expire_date = DATE_ADD(CURRENT_DATE, INTERVAL 90 DAY), password_updated_at = CURRENT_TIMESTAMP " +
 		"WHERE user_info.mail = ?"
-	_, err = DataBase.ExecContext(ctx, sqlStr, password, userName, firstName, lastName, phoneNumber, mail)
+	_, err = DataBase.ExecContext(ctx, sqlStr, password, userName, firstName, lastName, sealedPhoneNumber, phoneNumberIndex(phoneNumber), mail)
 	if err != nil {
 		return err
 	}
@@ -169,53 +183,86 @@ func UserGetNameByMail(ctx context.Context, mail string) (string, error) {
 	return name, nil
 }
 
-func TotpStoreSecret(userID, totpSecret, totpURL string) (err error) {
+// TotpEnsureUser creates the TOTP row of the user, the secrets are kept by
+// the TOTP devices.
+func TotpEnsureUser(userID string) (err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
-	sqlStr := "INSERT INTO suglider.totp(user_id, totp_secret, totp_url) " +
-		"VALUES (UNHEX(?),?,?)"
-	_, err = DataBase.ExecContext(ctx, sqlStr, userID, totpSecret, totpURL)
-	if err != nil {
+	sqlStr := "INSERT IGNORE INTO suglider.totp(user_id, totp_secret, totp_url) " +
+		"VALUES (UNHEX(?),'','')"
+	_, err = DataBase.ExecContext(ctx, sqlStr, userID)
+	return err
+}
+
+func TotpDeviceStore(deviceID, userID, name, totpSecret, totpURL string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	if totpSecret, err = sealField(totpSecret); err != nil {
+		return err
+	}
+	if totpURL, err = sealField(totpURL); err != nil {
 		return err
 	}
 
-	return nil
+	sqlStr := "INSERT INTO suglider.totp_device(device_id, user_id, name, totp_secret, totp_url) " +
+		"VALUES (?,UNHEX(?),?,?,?)"
+	_, err = DataBase.ExecContext(ctx, sqlStr, deviceID, userID, name, totpSecret, totpURL)
+	return err
 }
 
-func TotpUpdateSecret(mail, totpSecret, totpURL string) (err error) {
+func TotpDeviceList(mail string) (totpDeviceInfo []TotpDeviceInfo, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
-	sqlStr := "UPDATE suglider.totp " +
-		"JOIN suglider.user_info ON user_info.user_id = totp.user_id " +
-		"SET totp_secret = ?, totp_url = ? " +
-		"WHERE user_info.mail = ?"
-	_, err = DataBase.ExecContext(ctx, sqlStr, totpSecret, totpURL, mail)
+	sqlStr := "SELECT totp_device.device_id, totp_device.name, totp_device.verified, totp_device.totp_secret, " +
+		"totp_device.totp_url, totp_device.last_used_at, totp_device.created_at " +
+		"FROM suglider.totp_device " +
+		"JOIN suglider.user_info ON user_info.user_id = totp_device.user_id " +
+		"WHERE user_info.mail=? " +
+		"ORDER BY totp_device.created_at"
+	err = DataBase.SelectContext(ctx, &totpDeviceInfo, sqlStr, mail)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	for i := range totpDeviceInfo {
+		if totpDeviceInfo[i].TotpSecret, err = openField(totpDeviceInfo[i].TotpSecret); err != nil {
+			return nil, err
+		}
+		if totpDeviceInfo[i].TotpURL, err = openField(totpDeviceInfo[i].TotpURL); err != nil {
+			return nil, err
+		}
+	}
+	return totpDeviceInfo, nil
 }
 
-func TotpUserCheck(userID string) (rowCount int, err error) {
-	var count int
+func TotpDeviceVerify(deviceID string) (err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
-	sqlStr := "SELECT COUNT(*) " +
-		"FROM suglider.totp " +
-		"WHERE user_id=UNHEX(?)"
-	err = DataBase.GetContext(ctx, &count, sqlStr, userID)
-	return count, err
+	sqlStr := "UPDATE suglider.totp_device SET verified = true WHERE device_id = ?"
+	_, err = DataBase.ExecContext(ctx, sqlStr, deviceID)
+	return err
+}
+
+func TotpDeviceDelete(deviceID, mail string) (result sql.Result, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "DELETE totp_device FROM suglider.totp_device " +
+		"JOIN suglider.user_info ON user_info.user_id = totp_device.user_id " +
+		"WHERE totp_device.device_id=? AND user_info.mail=?"
+	result, err = DataBase.ExecContext(ctx, sqlStr, deviceID, mail)
+	return result, err
 }
 
 func TotpUserData(mail string) (totpUserInfo TotpUserInfo, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
-	sqlStr := "SELECT user_info.username, totp.user_id, totp.totp_enabled, totp_verified, totp.totp_secret, totp_url " +
+	sqlStr := "SELECT user_info.username, totp.user_id, totp.totp_enabled, totp_verified " +
 		"FROM suglider.user_info " +
 		"INNER JOIN suglider.totp ON user_info.user_id = totp.user_id " +
 		"WHERE user_info.mail=?"
@@ -223,6 +270,28 @@ func TotpUserData(mail string) (totpUserInfo TotpUserInfo, err error) {
 	return totpUserInfo, err
 }
 
+// TotpUseStep records the time step of an accepted code of the TOTP device,
+// it returns false when a code of the same or a later step has been accepted.
+func TotpUseStep(deviceID string, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "UPDATE suglider.totp_device " +
+		"SET last_used_step = ?, last_used_at = NOW() " +
+		"WHERE device_id = ? AND last_used_step < ?"
+	result, err := DataBase.ExecContext(ctx, sqlStr, step, deviceID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
 func TotpUpdateVerify(mail string, totpEnabled, totpVerified bool) (err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
@@ -255,6 +324,89 @@ func TotpUpdateEnabled(mail string, totpEnabled bool) (err error) {
 	return nil
 }
 
+// ReplaceTotpRecoveryCodes removes the old recovery codes of the user and
+// stores the hashes of the new ones.
+func ReplaceTotpRecoveryCodes(mail string, codeHashes []string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	tx, err := DataBase.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sqlStr := "DELETE totp_recovery_code FROM suglider.totp_recovery_code " +
+		"JOIN suglider.user_info ON user_info.user_id = totp_recovery_code.user_id " +
+		"WHERE user_info.mail=?"
+	if _, err = tx.ExecContext(ctx, sqlStr, mail); err != nil {
+		return err
+	}
+
+	sqlStr = "INSERT INTO suglider.totp_recovery_code(user_id, code_hash) " +
+		"SELECT user_id, ? FROM suglider.user_info WHERE mail=?"
+	for _, codeHash := range codeHashes {
+		result, err := tx.ExecContext(ctx, sqlStr, codeHash, mail)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return sql.ErrNoRows
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseTotpRecoveryCode marks the recovery code consumed, it returns false when
+// the code doesn't exist or has been used.
+func UseTotpRecoveryCode(mail, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "UPDATE suglider.totp_recovery_code " +
+		"JOIN suglider.user_info ON user_info.user_id = totp_recovery_code.user_id " +
+		"SET totp_recovery_code.used_at=NOW() " +
+		"WHERE user_info.mail=? AND totp_recovery_code.code_hash=? AND totp_recovery_code.used_at IS NULL"
+	result, err := DataBase.ExecContext(ctx, sqlStr, mail, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func CountTotpRecoveryCodes(mail string) (remaining int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "SELECT COUNT(*) FROM suglider.totp_recovery_code " +
+		"JOIN suglider.user_info ON user_info.user_id = totp_recovery_code.user_id " +
+		"WHERE user_info.mail=? AND totp_recovery_code.used_at IS NULL"
+	err = DataBase.GetContext(ctx, &remaining, sqlStr, mail)
+	return remaining, err
+}
+
+func DeleteTotpRecoveryCodes(mail string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "DELETE totp_recovery_code FROM suglider.totp_recovery_code " +
+		"JOIN suglider.user_info ON user_info.user_id = totp_recovery_code.user_id " +
+		"WHERE user_info.mail=?"
+	_, err = DataBase.ExecContext(ctx, sqlStr, mail)
+	return err
+}
+
 func LookupUserID(mail string) (userInfo UserInfo, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
@@ -308,14 +460,44 @@ func MailOTPUpdateEnabled(mail string, mailOTPEnabled bool) (int64, int64, error
 	return rowsAffected, errCode, err
 }
 
+func SmsOTPUpdateEnabled(mail string, smsOTPEnabled bool) (int64, int64, error) {
+	var errCode int64
+	errCode = 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "UPDATE suglider.user_info " +
+		"SET sms_otp_enabled = ? " +
+		"WHERE mail = ?"
+	result, err := DataBase.ExecContext(ctx, sqlStr, smsOTPEnabled, mail)
+	if err != nil {
+		errCode = 1002
+		return 0, errCode, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		errCode = 1049
+		return 0, errCode, err
+	}
+
+	return rowsAffected, errCode, err
+}
+
 func GetUserInfo(mail string) (userInfo UserInfo, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
-	sqlStr := "SELECT first_name, username, mail " +
+	sqlStr := "SELECT first_name, username, mail, phone_number " +
 		"FROM suglider.user_info " +
 		"WHERE user_info.mail=?"
 	err = DataBase.GetContext(ctx, &userInfo, sqlStr, mail)
+	if err != nil {
+		return userInfo, err
+	}
+
+	err = openNullField(&userInfo.PhoneNumber)
 	return userInfo, err
 }
 
@@ -334,7 +516,8 @@ func GetTwoFactorAuthByUserName(userName string) (userTwoFactorAuthInfo UserTwoF
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
-	sqlStr := "SELECT totp.user_id, user_info.username ,user_info.mail, totp.totp_enabled, user_info.mail_otp_enabled, user_info.sms_otp_enabled " +
+	sqlStr := "SELECT totp.user_id, user_info.username ,user_info.mail, totp.totp_enabled, user_info.mail_otp_enabled, user_info.sms_otp_enabled, " +
+		"EXISTS(SELECT 1 FROM suglider.webauthn_credential WHERE webauthn_credential.user_id = user_info.user_id) AS webauthn_enabled " +
 		"FROM suglider.user_info " +
 		"LEFT JOIN suglider.totp ON user_info.user_id = totp.user_id " +
 		"WHERE user_info.username=?"
@@ -346,7 +529,8 @@ func GetTwoFactorAuthByMail(mail string) (userTwoFactorAuthInfo UserTwoFactorAut
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
-	sqlStr := "SELECT totp.user_id, user_info.username ,user_info.mail, totp.totp_enabled, user_info.mail_otp_enabled, user_info.sms_otp_enabled " +
+	sqlStr := "SELECT totp.user_id, user_info.username ,user_info.mail, totp.totp_enabled, user_info.mail_otp_enabled, user_info.sms_otp_enabled, " +
+		"EXISTS(SELECT 1 FROM suglider.webauthn_credential WHERE webauthn_credential.user_id = user_info.user_id) AS webauthn_enabled " +
 		"FROM suglider.user_info " +
 		"LEFT JOIN suglider.totp ON user_info.user_id = totp.user_id " +
 		"WHERE user_info.mail=?"
@@ -385,13 +569,27 @@ func UpdatePersonalInfoByMail(mail string, userName, lastName, firstName, phoneN
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
+	sealedPhoneNumber, err := sealNullField(phoneNumber)
+	if err != nil {
+		return err
+	}
+	sealedAddress, err := sealNullField(address)
+	if err != nil {
+		return err
+	}
+	sealedBirthday, err := sealNullField(birthday)
+	if err != nil {
+		return err
+	}
+
 	sqlStr := "UPDATE suglider.user_info " +
 		"JOIN suglider.personal_info ON user_info.user_id = personal_info.user_id " +
 		"SET user_info.username = ?, user_info.last_name = ?, user_info.first_name = ?, " +
-		"user_info.phone_number = ?, personal_info.address = ?, personal_info.birthday = ?, " +
+		"user_info.phone_number = ?, user_info.phone_number_hash = ?, personal_info.address = ?, personal_info.birthday = ?, " +
 		"personal_info.sex = ?, personal_info.blood_type = ? " +
 		"WHERE user_info.mail = ?"
-	_, err = DataBase.ExecContext(ctx, sqlStr, userName, lastName, firstName, phoneNumber, address, birthday, sex, bloodType, mail)
+	_, err = DataBase.ExecContext(ctx, sqlStr, userName, lastName, firstName, sealedPhoneNumber, phoneNumberIndex(phoneNumber),
+		sealedAddress, sealedBirthday, sex, bloodType, mail)
 	if err != nil {
 		return err
 	}
@@ -404,7 +602,531 @@ func CheckPhoneNumberExists(phoneNumber string) (rowCount int, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
 	defer cancel()
 
-	sqlStr := "SELECT COUNT(*) FROM suglider.user_info WHERE phone_number=?"
-	err = DataBase.GetContext(ctx, &count, sqlStr, phoneNumber)
+	// The encrypted phone numbers are found by the blind index
+	sqlStr := "SELECT COUNT(*) FROM suglider.user_info WHERE phone_number=? OR phone_number_hash=?"
+	err = DataBase.GetContext(ctx, &count, sqlStr, phoneNumber, phoneNumberIndex(&phoneNumber))
 	return count, err
 }
+
+func InsertOidcClient(clientID, clientName, redirectURIs, scopes string, clientSecret *string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "INSERT INTO suglider.oidc_client(client_id, client_secret, client_name, redirect_uris, scopes) " +
+		"VALUES (?,?,?,?,?)"
+	_, err = DataBase.ExecContext(ctx, sqlStr, clientID, clientSecret, clientName, redirectURIs, scopes)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetOidcClient(clientID string) (oidcClientInfo OidcClientInfo, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "SELECT client_id, client_secret, client_name, redirect_uris, scopes, created_at " +
+		"FROM suglider.oidc_client " +
+		"WHERE client_id=?"
+	err = DataBase.GetContext(ctx, &oidcClientInfo, sqlStr, clientID)
+	return oidcClientInfo, err
+}
+
+func ListOidcClients() (oidcClientInfo []OidcClientInfo, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "SELECT client_id, client_secret, client_name, redirect_uris, scopes, created_at " +
+		"FROM suglider.oidc_client"
+	err = DataBase.SelectContext(ctx, &oidcClientInfo, sqlStr)
+	return oidcClientInfo, err
+}
+
+func DeleteOidcClient(clientID string) (result sql.Result, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "DELETE FROM suglider.oidc_client WHERE client_id=?"
+	result, err = DataBase.ExecContext(ctx, sqlStr, clientID)
+	return result, err
+}
+
+func GetOidcUserInfo(mail string) (oidcUserInfo OidcUserInfo, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "SELECT LOWER(HEX(user_id)) AS user_id, mail, mail_verified, username, first_name, last_name " +
+		"FROM suglider.user_info " +
+		"WHERE mail=?"
+	err = DataBase.GetContext(ctx, &oidcUserInfo, sqlStr, mail)
+	return oidcUserInfo, err
+}
+
+func InsertOAuth2Client(clientID, clientSecret, clientName, scopes string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "INSERT INTO suglider.oauth2_client(client_id, client_secret, client_name, scopes) " +
+		"VALUES (?,?,?,?)"
+	_, err = DataBase.ExecContext(ctx, sqlStr, clientID, clientSecret, clientName, scopes)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetOAuth2Client(clientID string) (oauth2ClientInfo OAuth2ClientInfo, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "SELECT client_id, client_secret, client_name, scopes, created_at " +
+		"FROM suglider.oauth2_client " +
+		"WHERE client_id=?"
+	err = DataBase.GetContext(ctx, &oauth2ClientInfo, sqlStr, clientID)
+	return oauth2ClientInfo, err
+}
+
+func ListOAuth2Clients() (oauth2ClientInfo []OAuth2ClientInfo, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "SELECT client_id, client_secret, client_name, scopes, created_at " +
+		"FROM suglider.oauth2_client"
+	err = DataBase.SelectContext(ctx, &oauth2ClientInfo, sqlStr)
+	return oauth2ClientInfo, err
+}
+
+func DeleteOAuth2Client(clientID string) (result sql.Result, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "DELETE FROM suglider.oauth2_client WHERE client_id=?"
+	result, err = DataBase.ExecContext(ctx, sqlStr, clientID)
+	return result, err
+}
+
+// InsertAPIKey stores the API key of the user, every API key expires after
+// expireSec seconds.
+func InsertAPIKey(keyID, mail, name, keyPrefix, keyHash, scopes string, expireSec int64) (err error) {
+	if expireSec <= 0 {
+		return fmt.Errorf("the API key must expire, expireSec is %d", expireSec)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "INSERT INTO suglider.api_key(key_id, user_id, name, key_prefix, key_hash, scopes, expires_at) " +
+		"SELECT ?, user_id, ?, ?, ?, ?, DATE_ADD(NOW(), INTERVAL ? SECOND) FROM suglider.user_info WHERE mail=?"
+	result, err := DataBase.ExecContext(ctx, sqlStr, keyID, name, keyPrefix, keyHash, scopes, expireSec, mail)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetAPIKeyByHash only returns the API key which has not expired
+func GetAPIKeyByHash(keyHash string) (apiKeyInfo APIKeyInfo, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "SELECT api_key.key_id, user_info.mail, api_key.name, api_key.key_prefix, api_key.scopes, " +
+		"api_key.expires_at, api_key.last_used_at, api_key.created_at " +
+		"FROM suglider.api_key " +
+		"JOIN suglider.user_info ON user_info.user_id = api_key.user_id " +
+		"WHERE api_key.key_hash=? AND api_key.expires_at > NOW()"
+	err = DataBase.GetContext(ctx, &apiKeyInfo, sqlStr, keyHash)
+	return apiKeyInfo, err
+}
+
+func ListAPIKeys(mail string) (apiKeyInfo []APIKeyInfo, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "SELECT api_key.key_id, user_info.mail, api_key.name, api_key.key_prefix, api_key.scopes, " +
+		"api_key.expires_at, api_key.last_used_at, api_key.created_at " +
+		"FROM suglider.api_key " +
+		"JOIN suglider.user_info ON user_info.user_id = api_key.user_id " +
+		"WHERE user_info.mail=? " +
+		"ORDER BY api_key.created_at"
+	err = DataBase.SelectContext(ctx, &apiKeyInfo, sqlStr, mail)
+	return apiKeyInfo, err
+}
+
+func RenameAPIKey(keyID, mail, name string) (result sql.Result, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "UPDATE suglider.api_key " +
+		"JOIN suglider.user_info ON user_info.user_id = api_key.user_id " +
+		"SET api_key.name=? " +
+		"WHERE api_key.key_id=? AND user_info.mail=?"
+	result, err = DataBase.ExecContext(ctx, sqlStr, name, keyID, mail)
+	return result, err
+}
+
+func DeleteAPIKey(keyID, mail string) (result sql.Result, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "DELETE api_key FROM suglider.api_key " +
+		"JOIN suglider.user_info ON user_info.user_id = api_key.user_id " +
+		"WHERE api_key.key_id=? AND user_info.mail=?"
+	result, err = DataBase.ExecContext(ctx, sqlStr, keyID, mail)
+	return result, err
+}
+
+func UpdateAPIKeyLastUsed(keyID string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "UPDATE suglider.api_key SET last_used_at=NOW() WHERE key_id=?"
+	_, err = DataBase.ExecContext(ctx, sqlStr, keyID)
+	return err
+}
+
+const webAuthnCredentialColumns = "SELECT webauthn_credential.credential_id, LOWER(HEX(user_info.user_id)) AS user_id, " +
+	"user_info.mail, user_info.username, webauthn_credential.name, webauthn_credential.raw_id, " +
+	"webauthn_credential.credential, webauthn_credential.last_used_at, webauthn_credential.created_at " +
+	"FROM suglider.webauthn_credential " +
+	"JOIN suglider.user_info ON user_info.user_id = webauthn_credential.user_id "
+
+func InsertWebAuthnCredential(credentialID, mail, name string, rawID []byte, credential string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "INSERT INTO suglider.webauthn_credential(credential_id, user_id, name, raw_id, credential) " +
+		"SELECT ?, user_id, ?, ?, ? FROM suglider.user_info WHERE mail=?"
+	result, err := DataBase.ExecContext(ctx, sqlStr, credentialID, name, rawID, credential, mail)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func ListWebAuthnCredentials(mail string) (credentialInfo []WebAuthnCredentialInfo, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := webAuthnCredentialColumns +
+		"WHERE user_info.mail=? " +
+		"ORDER BY webauthn_credential.created_at"
+	err = DataBase.SelectContext(ctx, &credentialInfo, sqlStr, mail)
+	return credentialInfo, err
+}
+
+func GetWebAuthnCredentialByRawID(rawID []byte) (credentialInfo WebAuthnCredentialInfo, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := webAuthnCredentialColumns + "WHERE webauthn_credential.raw_id=?"
+	err = DataBase.GetContext(ctx, &credentialInfo, sqlStr, rawID)
+	return credentialInfo, err
+}
+
+// UpdateWebAuthnCredential saves the credential after a login, the sign
+// count of the authenticator is changed.
+func UpdateWebAuthnCredential(rawID []byte, credential string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "UPDATE suglider.webauthn_credential SET credential=?, last_used_at=NOW() WHERE raw_id=?"
+	_, err = DataBase.ExecContext(ctx, sqlStr, credential, rawID)
+	return err
+}
+
+func DeleteWebAuthnCredential(credentialID, mail string) (result sql.Result, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "DELETE webauthn_credential FROM suglider.webauthn_credential " +
+		"JOIN suglider.user_info ON user_info.user_id = webauthn_credential.user_id " +
+		"WHERE webauthn_credential.credential_id=? AND user_info.mail=?"
+	result, err = DataBase.ExecContext(ctx, sqlStr, credentialID, mail)
+	return result, err
+}
+
+// ReencryptTotpDevices encrypts the TOTP secrets which are plaintext or
+// encrypted by a retired key with the active key, it returns how many devices
+// have been changed.
+func ReencryptTotpDevices() (count int, err error) {
+	var totpDeviceInfo []TotpDeviceInfo
+
+	if fieldKeyRing == nil {
+		return 0, fmt.Errorf("[encryption] is not configured")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "SELECT device_id, totp_secret, totp_url FROM suglider.totp_device"
+	if err = DataBase.SelectContext(ctx, &totpDeviceInfo, sqlStr); err != nil {
+		return 0, err
+	}
+
+	for _, device := range totpDeviceInfo {
+		totpSecret, secretChanged, err := resealField(device.TotpSecret)
+		if err != nil {
+			return count, fmt.Errorf("TOTP device %s: %w", device.DeviceID, err)
+		}
+		totpURL, urlChanged, err := resealField(device.TotpURL)
+		if err != nil {
+			return count, fmt.Errorf("TOTP device %s: %w", device.DeviceID, err)
+		}
+		if !secretChanged && !urlChanged {
+			continue
+		}
+
+		// The row is skipped when it's changed after it's read
+		updateCtx, updateCancel := context.WithTimeout(context.Background(), dbTimeOut)
+		sqlStr = "UPDATE suglider.totp_device SET totp_secret = ?, totp_url = ? " +
+			"WHERE device_id = ? AND totp_secret = ? AND totp_url = ?"
+		result, err := DataBase.ExecContext(updateCtx, sqlStr, totpSecret, totpURL, device.DeviceID, device.TotpSecret, device.TotpURL)
+		updateCancel()
+		if err != nil {
+			return count, err
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// ReencryptUserInfo encrypts the phone numbers with the active key and sets
+// their blind indexes, it returns how many users have been changed.
+func ReencryptUserInfo() (count int, err error) {
+	var userPIIInfo []UserPIIInfo
+
+	if fieldKeyRing == nil {
+		return 0, fmt.Errorf("[encryption] is not configured")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "SELECT LOWER(HEX(user_id)) AS user_id, phone_number FROM suglider.user_info " +
+		"WHERE phone_number IS NOT NULL AND phone_number <> ''"
+	if err = DataBase.SelectContext(ctx, &userPIIInfo, sqlStr); err != nil {
+		return 0, err
+	}
+
+	for _, user := range userPIIInfo {
+		phoneNumber, changed, err := resealField(user.PhoneNumber.String)
+		if err != nil {
+			return count, fmt.Errorf("user %s: %w", user.UserID, err)
+		}
+		if !changed {
+			continue
+		}
+
+		plainPhoneNumber, err := openField(user.PhoneNumber.String)
+		if err != nil {
+			return count, fmt.Errorf("user %s: %w", user.UserID, err)
+		}
+
+		updateCtx, updateCancel := context.WithTimeout(context.Background(), dbTimeOut)
+		sqlStr = "UPDATE suglider.user_info SET phone_number = ?, phone_number_hash = ? " +
+			"WHERE user_id = UNHEX(?) AND phone_number = ?"
+		result, err := DataBase.ExecContext(updateCtx, sqlStr, phoneNumber, phoneNumberIndex(&plainPhoneNumber), user.UserID, user.PhoneNumber.String)
+		updateCancel()
+		if err != nil {
+			return count, err
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// ReencryptPersonalInfo encrypts the addresses and birthdays with the active
+// key, it returns how many users have been changed.
+func ReencryptPersonalInfo() (count int, err error) {
+	var userPIIInfo []UserPIIInfo
+
+	if fieldKeyRing == nil {
+		return 0, fmt.Errorf("[encryption] is not configured")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "SELECT LOWER(HEX(user_id)) AS user_id, address, birthday FROM suglider.personal_info " +
+		"WHERE address IS NOT NULL OR birthday IS NOT NULL"
+	if err = DataBase.SelectContext(ctx, &userPIIInfo, sqlStr); err != nil {
+		return 0, err
+	}
+
+	for _, user := range userPIIInfo {
+		address, addressChanged, err := resealField(user.Address.String)
+		if err != nil {
+			return count, fmt.Errorf("user %s: %w", user.UserID, err)
+		}
+		birthday, birthdayChanged, err := resealField(user.Birthday.String)
+		if err != nil {
+			return count, fmt.Errorf("user %s: %w", user.UserID, err)
+		}
+		if !addressChanged && !birthdayChanged {
+			continue
+		}
+
+		newAddress, newBirthday := user.Address, user.Birthday
+		newAddress.String, newBirthday.String = address, birthday
+
+		updateCtx, updateCancel := context.WithTimeout(context.Background(), dbTimeOut)
+		sqlStr = "UPDATE suglider.personal_info SET address = ?, birthday = ? " +
+			"WHERE user_id = UNHEX(?) AND address <=> ? AND birthday <=> ?"
+		result, err := DataBase.ExecContext(updateCtx, sqlStr, newAddress, newBirthday, user.UserID, user.Address, user.Birthday)
+		updateCancel()
+		if err != nil {
+			return count, err
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// JwtSigningKeyList returns the active JWT signing key and the keys retired
+// after retiredSince, the newest key comes first.
+func JwtSigningKeyList(retiredSince int64) ([]JwtSigningKeyInfo, error) {
+	var jwtSigningKeyInfo []JwtSigningKeyInfo
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "SELECT kid, private_key, created_at, retired_at FROM suglider.jwt_signing_key " +
+		"WHERE retired_at IS NULL OR retired_at > ? ORDER BY created_at DESC"
+	if err := DataBase.SelectContext(ctx, &jwtSigningKeyInfo, sqlStr, retiredSince); err != nil {
+		return nil, err
+	}
+
+	for i := range jwtSigningKeyInfo {
+		privateKey, err := openField(jwtSigningKeyInfo[i].PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("JWT signing key %s: %w", jwtSigningKeyInfo[i].Kid, err)
+		}
+		jwtSigningKeyInfo[i].PrivateKey = privateKey
+	}
+
+	return jwtSigningKeyInfo, nil
+}
+
+// JwtSigningKeyRotate retires the active key previousKid and adds the new
+// active key, the keys retired before retiredBefore are removed. It returns
+// false without any change when the active key is not previousKid.
+func JwtSigningKeyRotate(key JwtSigningKeyInfo, previousKid string, retiredBefore int64) (bool, error) {
+	var activeKids []string
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	privateKey, err := sealField(key.PrivateKey)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := DataBase.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// The instances rotating at the same time wait for each other here
+	sqlStr := "SELECT kid FROM suglider.jwt_signing_key WHERE retired_at IS NULL FOR UPDATE"
+	if err = tx.SelectContext(ctx, &activeKids, sqlStr); err != nil {
+		return false, err
+	}
+	activeKid := ""
+	if len(activeKids) > 0 {
+		activeKid = activeKids[0]
+	}
+	if activeKid != previousKid {
+		return false, nil
+	}
+
+	sqlStr = "UPDATE suglider.jwt_signing_key SET retired_at = ? WHERE retired_at IS NULL"
+	if _, err = tx.ExecContext(ctx, sqlStr, key.CreatedAt); err != nil {
+		return false, err
+	}
+
+	sqlStr = "DELETE FROM suglider.jwt_signing_key WHERE retired_at <= ?"
+	if _, err = tx.ExecContext(ctx, sqlStr, retiredBefore); err != nil {
+		return false, err
+	}
+
+	sqlStr = "INSERT INTO suglider.jwt_signing_key(kid, private_key, created_at) VALUES (?, ?, ?)"
+	if _, err = tx.ExecContext(ctx, sqlStr, key.Kid, privateKey, key.CreatedAt); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReencryptJwtSigningKeys encrypts the JWT signing keys with the active key.
+func ReencryptJwtSigningKeys() (count int, err error) {
+	var jwtSigningKeyInfo []JwtSigningKeyInfo
+
+	if fieldKeyRing == nil {
+		return 0, fmt.Errorf("[encryption] is not configured")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeOut)
+	defer cancel()
+
+	sqlStr := "SELECT kid, private_key, created_at, retired_at FROM suglider.jwt_signing_key"
+	if err = DataBase.SelectContext(ctx, &jwtSigningKeyInfo, sqlStr); err != nil {
+		return 0, err
+	}
+
+	for _, key := range jwtSigningKeyInfo {
+		privateKey, changed, err := resealField(key.PrivateKey)
+		if err != nil {
+			return count, fmt.Errorf("JWT signing key %s: %w", key.Kid, err)
+		}
+		if !changed {
+			continue
+		}
+
+		// The row is skipped when it's changed after it's read
+		updateCtx, updateCancel := context.WithTimeout(context.Background(), dbTimeOut)
+		sqlStr = "UPDATE suglider.jwt_signing_key SET private_key = ? WHERE kid = ? AND private_key = ?"
+		result, err := DataBase.ExecContext(updateCtx, sqlStr, privateKey, key.Kid, key.PrivateKey)
+		updateCancel()
+		if err != nil {
+			return count, err
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+			count++
+		}
+	}
+
+	return count, nil
+}
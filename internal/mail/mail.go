@@ -3,19 +3,22 @@ package mail
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/url"
 	"suglider-auth/configs"
 	db "suglider-auth/internal/database"
 	rds "suglider-auth/internal/redis"
 	"suglider-auth/pkg/encrypt"
 	smtp "suglider-auth/pkg/mail"
+	"suglider-auth/pkg/time_convert"
 	"time"
 )
 
 var (
-	mail       *smtp.SmtpMail
-	requestUrl *smtp.RequestUrl
-	htmlMail   *smtp.HtmlMail
+	mail         *smtp.SmtpMail
+	requestUrl   *smtp.RequestUrl
+	htmlMail     *smtp.HtmlMail
+	magicLinkTTL = 15 * time.Minute
 )
 
 type UserMailVerification struct {
@@ -87,6 +90,10 @@ func (umv *UserMailVerification) Verify(ctx context.Context) (bool, error) {
 }
 
 func init() {
+	configs.OnLoad(loadConfig)
+}
+
+func loadConfig() {
 	mail = &smtp.SmtpMail{
 		Username: configs.ApplicationConfig.Mail.Smtp.Username,
 		Password: configs.ApplicationConfig.Mail.Smtp.Password,
@@ -117,6 +124,16 @@ func init() {
 		TemplatePath: configs.ApplicationConfig.Server.TemplatePath,
 		TTL:          configs.ApplicationConfig.Mail.Expired.TTL,
 	}
+	if magicLink := configs.ApplicationConfig.Mail.Expired.MagicLink; magicLink != "" {
+		duration, _, err := time_convert.ConvertTimeFormat(magicLink)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Magic link TTL string convert to duration failed: %v", err)
+			slog.Error(errorMessage)
+
+			panic(err)
+		}
+		magicLinkTTL = duration
+	}
 }
 
 func SendVerifyMail(ctx context.Context, user, email string) error {
@@ -253,3 +270,119 @@ func SendMailOTP(ctx context.Context, user, email, code string) error {
 
 	return nil
 }
+
+// SendRecoveryCodeUsedMail tells the user that a TOTP recovery code has been
+// used to log in, remaining is the number of unused codes.
+func SendRecoveryCodeUsedMail(ctx context.Context, user, email string, remaining int) error {
+	tempFile := fmt.Sprintf("%s/recovery-code-used.tmpl", htmlMail.TemplatePath)
+	usedAt := time.Now().UTC().Format("2006-01-02 15:04:05 UTC")
+	cont, err := htmlMail.GenerateRecoveryCodeUsedMail(ctx, user, usedAt, remaining, tempFile)
+	if err != nil {
+		return err
+	}
+
+	errSend := mail.Send(ctx, "Suglider recovery code used", cont, "", email)
+	if errSend != nil {
+		return errSend
+	}
+
+	return nil
+}
+
+// SendAccountLockedMail tells the user that the account is locked because of
+// too many failed logins, the link with the unlock token removes the lockout.
+func SendAccountLockedMail(ctx context.Context, user, email, unlockToken string, lockedUntil time.Time) error {
+	tempFile := fmt.Sprintf("%s/account-locked.tmpl", htmlMail.TemplatePath)
+	params := url.Values{}
+	params.Add("unlock-token", unlockToken)
+	until := lockedUntil.UTC().Format("2006-01-02 15:04:05 UTC")
+	cont, err := htmlMail.GenerateAccountLockedMail(ctx, tempFile, user, until, params.Encode())
+	if err != nil {
+		return err
+	}
+
+	errSend := mail.Send(ctx, "Suglider account locked", cont, "", email)
+	if errSend != nil {
+		return errSend
+	}
+
+	return nil
+}
+
+// UserMagicLink is the login link mailed to the user, it can only be used
+// once before it expires.
+type UserMagicLink struct {
+	Mail string
+	Id   string
+	Code string
+}
+
+func NewUserMagicLink(mail string) (*UserMagicLink, error) {
+	code, err := encrypt.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	magicLink := UserMagicLink{Mail: mail, Code: code}
+	magicLink.Id = encrypt.RandomString(12, "")
+	return &magicLink, nil
+}
+
+func (uml *UserMagicLink) key() string {
+	return fmt.Sprintf("magic_link:%s/%s", uml.Mail, uml.Id)
+}
+
+func (uml *UserMagicLink) Register(ctx context.Context, ttl time.Duration) (string, error) {
+	// Only the hash of the code is kept, the link in the mail is the only copy
+	err := rds.Set(uml.key(), encrypt.HashWithSHA(uml.Code, "sha256"), ttl)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Add("mail", uml.Mail)
+	params.Add("link-id", uml.Id)
+	params.Add("link-code", uml.Code)
+
+	return params.Encode(), nil
+}
+
+// Verify consumes the link, it can't be used again even when the code is
+// wrong.
+func (uml *UserMagicLink) Verify(ctx context.Context) (bool, error) {
+	code, errCode, err := rds.GetDel(uml.key())
+	if errCode == 1043 {
+		return false, fmt.Errorf("The login link has been expired or used, request a new one and try again.")
+	} else if err != nil {
+		return false, err
+	}
+	return code == encrypt.HashWithSHA(uml.Code, "sha256"), nil
+}
+
+func SendMagicLinkMail(ctx context.Context, user, email string) error {
+	uml, err := NewUserMagicLink(email)
+	if err != nil {
+		return err
+	}
+	params, err := uml.Register(ctx, magicLinkTTL)
+	if err != nil {
+		return err
+	}
+	tempFile := fmt.Sprintf("%s/magic-link.tmpl", htmlMail.TemplatePath)
+	cont, err := htmlMail.GenerateMagicLinkMail(ctx, tempFile, user, magicLinkTTL.String(), params)
+	if err != nil {
+		return err
+	}
+	if err = mail.Send(ctx, "Sign in to Suglider", cont, "", email); err != nil {
+		return err
+	}
+	return nil
+}
+
+func CheckMagicLink(ctx context.Context, email, id, code string) (bool, error) {
+	uml := &UserMagicLink{
+		Mail: email,
+		Id:   id,
+		Code: code,
+	}
+	return uml.Verify(ctx)
+}
@@ -0,0 +1,82 @@
+package mail
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	rds "suglider-auth/internal/redis"
+)
+
+func TestMagicLink(t *testing.T) {
+	server := miniredis.RunT(t)
+	if err := rds.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+
+	ctx := context.Background()
+
+	register := func(t *testing.T) url.Values {
+		uml, err := NewUserMagicLink("alice@example.com")
+		if err != nil {
+			t.Fatalf("Unit Test (New magic link) Fail: %v\n", err)
+		}
+		encoded, err := uml.Register(ctx, 15*time.Minute)
+		if err != nil {
+			t.Fatalf("Unit Test (Register magic link) Fail: %v\n", err)
+		}
+		params, err := url.ParseQuery(encoded)
+		if err != nil {
+			t.Fatalf("Unit Test (Parse magic link) Fail: %v\n", err)
+		}
+
+		key := uml.key()
+		if stored, _ := server.Get(key); stored == "" || stored == uml.Code {
+			t.Errorf("Result: %s (%s)\n", stored, "Only the hash of the code must be stored.")
+		}
+		if ttl := server.TTL(key); ttl != 15*time.Minute {
+			t.Errorf("Result: %v (%s)\n", ttl, "The link must expire.")
+		}
+		return params
+	}
+
+	t.Run("Test the link can be used once", func(t *testing.T) {
+		params := register(t)
+
+		ok, err := CheckMagicLink(ctx, params.Get("mail"), params.Get("link-id"), params.Get("link-code"))
+		if err != nil || !ok {
+			t.Fatalf("Result: %v, %v (%s)\n", ok, err, "The link must be accepted.")
+		}
+
+		ok, err = CheckMagicLink(ctx, params.Get("mail"), params.Get("link-id"), params.Get("link-code"))
+		if err == nil || ok {
+			t.Errorf("Result: %v, %v (%s)\n", ok, err, "The used link must be rejected.")
+		}
+	})
+
+	t.Run("Test a wrong code consumes the link", func(t *testing.T) {
+		params := register(t)
+
+		ok, err := CheckMagicLink(ctx, params.Get("mail"), params.Get("link-id"), "wrong-code")
+		if err != nil || ok {
+			t.Fatalf("Result: %v, %v (%s)\n", ok, err, "The wrong code must be rejected.")
+		}
+
+		ok, err = CheckMagicLink(ctx, params.Get("mail"), params.Get("link-id"), params.Get("link-code"))
+		if err == nil || ok {
+			t.Errorf("Result: %v, %v (%s)\n", ok, err, "The link can't be tried again after a wrong code.")
+		}
+	})
+
+	t.Run("Test the link of another user", func(t *testing.T) {
+		params := register(t)
+
+		ok, err := CheckMagicLink(ctx, "bob@example.com", params.Get("link-id"), params.Get("link-code"))
+		if err == nil || ok {
+			t.Errorf("Result: %v, %v (%s)\n", ok, err, "The link must only log in its own user.")
+		}
+	})
+}
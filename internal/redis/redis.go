@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"suglider-auth/configs"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -13,23 +12,26 @@ import (
 var rdb *redis.Client
 var ctx = context.Background()
 
-func init() {
+// KeepTTL makes Set keep the current expiration of the key
+const KeepTTL = redis.KeepTTL
+
+// Connect creates the redis client, it's called once at startup so that the
+// packages using redis can be imported without a live Redis.
+func Connect(host, port, password string) error {
 	rdb = redis.NewClient(&redis.Options{
-		Addr:     configs.ApplicationConfig.Redis.Host + ":" + configs.ApplicationConfig.Redis.Port,
-		Password: configs.ApplicationConfig.Redis.Password,
+		Addr:     host + ":" + port,
+		Password: password,
 		DB:       0, // use default DB
 	})
 
 	pong, err := rdb.Ping(ctx).Result()
 	if err != nil {
-		errorMessage := fmt.Sprintf("Can not connect to redis: %v", err)
-		slog.Error(errorMessage)
-
-		panic(err)
+		return err
 	}
 
 	slog.Info(fmt.Sprintf("Connected to Redis successfully, redis master return: %s", pong))
 
+	return nil
 }
 
 // Redis SET
@@ -43,6 +45,28 @@ func Set(key, value string, ttl time.Duration) error {
 	return nil
 }
 
+// Redis SETNX, return false if the key already exists
+func SetNX(key, value string, ttl time.Duration) (bool, error) {
+
+	ok, err := rdb.SetNX(ctx, key, value, ttl).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+// Redis SET XX, the key is only updated when it exists
+func SetXX(key, value string, ttl time.Duration) (bool, error) {
+
+	ok, err := rdb.SetXX(ctx, key, value, ttl).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
 // Redis GET
 func Get(key string) (string, int64, error) {
 
@@ -65,6 +89,50 @@ func Get(key string) (string, int64, error) {
 	}
 }
 
+// Redis GETDEL, the key can only be read once
+func GetDel(key string) (string, int64, error) {
+
+	var errCode int64
+	errCode = 0
+
+	value, err := rdb.GetDel(ctx, key).Result()
+
+	// Check whether key exist or not
+	if err == redis.Nil {
+		errCode = 1043
+		return "", errCode, err
+
+	} else if err != nil {
+		errCode = 1044
+		return "", errCode, err
+
+	} else {
+		return value, errCode, nil
+	}
+}
+
+// Redis TTL, negative duration means the key does not exist or has no expiration
+func TTL(key string) (time.Duration, error) {
+
+	ttl, err := rdb.TTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return ttl, nil
+}
+
+// Redis EXPIRE
+func Expire(key string, ttl time.Duration) error {
+
+	err := rdb.Expire(ctx, key, ttl).Err()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Redis EXISTS
 func Exists(key string) (bool, error) {
 
@@ -95,6 +163,81 @@ func Delete(key string) error {
 	return nil
 }
 
+// Redis INCR, the expiration is set to ttl when the key is created, so the
+// counter is reset ttl after the first increment
+func Incr(key string, ttl time.Duration) (int64, error) {
+
+	count, err := rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if count == 1 {
+		if err = rdb.Expire(ctx, key, ttl).Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
+
+// Redis SADD, the expiration of the set is extended to ttl
+func SAdd(key, member string, ttl time.Duration) error {
+
+	pipe := rdb.TxPipeline()
+	pipe.SAdd(ctx, key, member)
+	pipe.Expire(ctx, key, ttl)
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Redis SREM
+func SRem(key, member string) error {
+
+	err := rdb.SRem(ctx, key, member).Err()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Redis SMEMBERS
+func SMembers(key string) ([]string, error) {
+
+	members, err := rdb.SMembers(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// Script is a Lua script, it's run by EVALSHA and loaded when Redis doesn't
+// have it yet
+type Script = redis.Script
+
+func NewScript(src string) *Script {
+	return redis.NewScript(src)
+}
+
+// RunScript runs the script atomically, the result is an []interface{} of
+// int64 when the script returns a table of integers
+func RunScript(script *Script, keys []string, args ...interface{}) (interface{}, error) {
+
+	result, err := script.Run(ctx, rdb, keys, args...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
 // Close redis connection
 func Close() {
 	rdb.Close()
@@ -82,9 +82,49 @@ func ResponseStatusCode() {
 		1071: "mail, totp_verify or username are not exists.",
 		1072: "It's either that the mail doesn't exist or token.",
 		1073: "The token and mail not matched with info from google oauth2.",
+		1074: "Refresh token is invalid or expired.",
+		1075: "Refresh token has already been used, all sessions of the token have been revoked.",
+		1076: "Generate refresh token failed.",
+		1077: "Token has been revoked.",
+		1078: "Revoke token failed.",
+		1079: "OIDC authorization code is invalid or expired.",
+		1080: "OIDC client not found.",
+		1081: "Register OIDC client failed.",
+		1082: "OAuth2 client not found.",
+		1083: "Register OAuth2 client failed.",
+		1084: "The scope of the credential doesn't allow this request.",
+		1085: "API key not found.",
+		1086: "Create API key failed.",
+		1087: "API key is invalid or expired.",
+		1088: "Session not found.",
+		1089: "Revoke session failed.",
+		1090: "Session has expired due to inactivity or reached its maximum lifetime, please log in again.",
+		1091: "The maximum number of sessions has been reached.",
+		1092: "CSRF token is missing or invalid.",
+		1093: "Login challenge is invalid or expired, please log in again.",
+		1094: "Too many failed attempts, please log in again.",
+		1095: "The 2FA method is not enabled or has already passed.",
+		1096: "The user doesn't have a phone number.",
+		1097: "SMS provider is not configured.",
+		1098: "Failed to send SMS.",
+		1099: "WebAuthn is not configured.",
 		1101: "Fail to parse POST form data.",
 		1102: "Fail to bind POST form data.",
 		1103: "Fail to parse path parameters.",
 		1104: "Invalid data to request.",
+		1105: "WebAuthn ceremony is invalid or expired.",
+		1106: "WebAuthn verification failed.",
+		1107: "WebAuthn credential not found.",
+		1108: "The name of the WebAuthn credential has already been used.",
+		1109: "The name of the TOTP device has already been used.",
+		1110: "TOTP device not found.",
+		1111: "Too many failed attempts, please try again later.",
+		1112: "The account is locked because of too many failed attempts, an unlock link has been sent by mail.",
+		1113: "Unlock token is invalid or expired.",
+		1114: "Too many requests, please try again later.",
+		1116: "Login link is invalid, expired or already used.",
+		1117: "Recent authentication is required, verify the password or a second factor again.",
+		1118: "The factor can't be used to authenticate again.",
+		1119: "Another login of the user is in progress, please try again.",
 	}
 }
@@ -0,0 +1,17 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BearerToken returns the token of the `Authorization: Bearer <token>` header,
+// it's empty when the header is not set.
+func BearerToken(c *gin.Context) string {
+	authorization := c.GetHeader("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+	return ""
+}
@@ -9,6 +9,8 @@ import (
 	"suglider-auth/internal/redis"
 	"suglider-auth/configs"
 	"suglider-auth/pkg/api-server"
+	"suglider-auth/pkg/jwt"
+	"suglider-auth/pkg/oidc"
 	"suglider-auth/pkg/time_convert"
 	"suglider-auth/pkg/logger"
 	"log/slog"
@@ -44,11 +46,66 @@ var (
 // @name                       X-API-KEY
 
 func init() {
+	configs.Load()
 	logger.SlogMultiWriter(ServiceName)
+	err := mariadb.Connect()
+	if err != nil {
+		errorMessage := fmt.Sprintf("Can not connect to database: %v", err)
+		slog.Error(errorMessage)
+		panic(err)
+	}
 	sqltable.SugliderTableInit()
-	_, _, err := time_convert.ConvertTimeFormat(configs.ApplicationConfig.Session.Timeout)
+
+	// Redis keeps the refresh tokens, the token denylist, the OTPs, the rate
+	// limits and the session lock of users, so it's required whichever
+	// session store is used
+	err = redis.Connect(
+		configs.ApplicationConfig.Redis.Host,
+		configs.ApplicationConfig.Redis.Port,
+		configs.ApplicationConfig.Redis.Password,
+	)
 	if err != nil {
-		errorMessage := fmt.Sprintf("TTL string convert to duration failed: %v", err)
+		errorMessage := fmt.Sprintf("Can not connect to redis: %v", err)
+		slog.Error(errorMessage)
+		panic(err)
+	}
+	if configs.ApplicationConfig.Session.Timeout != "" {
+		_, _, err = time_convert.ConvertTimeFormat(configs.ApplicationConfig.Session.Timeout)
+		if err != nil {
+			errorMessage := fmt.Sprintf("TTL string convert to duration failed: %v", err)
+			slog.Error(errorMessage)
+			panic(err)
+		}
+	}
+
+	// The generated keys are shared by the instances through the database
+	jwtSettings := &jwt.KeyRingSettings{Store: mariadb.JwtKeyStore{}}
+	jwt.SetIDTokenTTL(oidc.IDTokenTTL)
+	if jwtConfig := configs.ApplicationConfig.Jwt; jwtConfig != nil {
+		jwtSettings.Algorithm = jwtConfig.Algorithm
+		jwtSettings.KeyLength = jwtConfig.KeyLength
+		jwtSettings.KeyFiles = jwtConfig.KeyFiles
+		jwt.SetAccessTokenAudience(jwtConfig.Audience)
+		if jwtConfig.AccessTokenTTL != "" {
+			accessTokenTTL, _, err := time_convert.ConvertTimeFormat(jwtConfig.AccessTokenTTL)
+			if err != nil {
+				errorMessage := fmt.Sprintf("Access token TTL string convert to duration failed: %v", err)
+				slog.Error(errorMessage)
+				panic(err)
+			}
+			jwt.SetAccessTokenTTL(accessTokenTTL)
+		}
+		if jwtConfig.RotationInterval != "" {
+			jwtSettings.RotationInterval, _, err = time_convert.ConvertTimeFormat(jwtConfig.RotationInterval)
+			if err != nil {
+				errorMessage := fmt.Sprintf("JWT rotation interval convert to duration failed: %v", err)
+				slog.Error(errorMessage)
+				panic(err)
+			}
+		}
+	}
+	if err = jwt.InitKeyRing(jwtSettings); err != nil {
+		errorMessage := fmt.Sprintf("Initial JWT signing keys failed: %v", err)
 		slog.Error(errorMessage)
 		panic(err)
 	}
@@ -60,8 +117,6 @@ func main() {
 		Version:          Version,
 		TemplatePath:     configs.ApplicationConfig.Server.TemplatePath,
 		StaticPath:       configs.ApplicationConfig.Server.StaticPath,
-		SessionsPath:     configs.ApplicationConfig.Session.Path,
-		SessionsHttpOnly: configs.ApplicationConfig.Session.HttpOnly,
 		CasbinConfig:     configs.ApplicationConfig.Server.CasbinConfig,
 		CasbinTable:      configs.ApplicationConfig.Server.CasbinTable,
 		CasbinCache:      configs.ApplicationConfig.Server.CasbinCache,
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	mariadb "suglider-auth/internal/database"
+	"suglider-auth/internal/utils"
+	"suglider-auth/pkg/encrypt"
+	"suglider-auth/pkg/rbac"
+	"suglider-auth/pkg/time_convert"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API keys look like "sga_<random>", only the SHA-256 of a key is stored and
+// the prefix is kept to tell the keys apart in the list.
+const apiKeyPrefix = "sga_"
+
+// Every API key expires, a key without expires_in lives apiKeyDefaultTTL and
+// no key lives longer than apiKeyMaxTTL. The expiry is kept in seconds, so a
+// key lives at least a second.
+const (
+	apiKeyDefaultTTL = 90 * 24 * time.Hour
+	apiKeyMaxTTL     = 365 * 24 * time.Hour
+)
+
+// loginUser returns the mail of the user who has logged in, the request is
+// rejected with msg when it's authenticated by an API key or a scoped token.
+func loginUser(c *gin.Context, msg string) (string, bool) {
+	mail, isMailExists := c.Get("mail")
+	_, isAPIKey := c.Get("api_key_id")
+	if !isMailExists || isAPIKey || c.GetString("scope") != "" {
+		c.JSON(http.StatusForbidden, utils.ErrorResponse(c, 1064, map[string]interface{}{
+			"msg": msg,
+		}))
+		return "", false
+	}
+
+	return fmt.Sprintf("%v", mail), true
+}
+
+// apiKeyOwner returns the mail of the user who manages the API keys. An API
+// key or a scoped token can't manage API keys, otherwise a narrowed
+// credential could create a key without limit.
+func apiKeyOwner(c *gin.Context) (string, bool) {
+	return loginUser(c, "API keys can only be managed by the login of the user.")
+}
+
+// @Summary Create API Key
+// @Description Create a personal API key, the key is only shown in this response.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Param name body string true "Key Name"
+// @Param scope body string true "Scopes separated by spaces, e.g. user:read totp:write"
+// @Param expires_in body string false "Lifetime of the key, e.g. 720h, default is 2160h, at least 1s and at most 8760h"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/api-keys [post]
+func APIKeyCreate(c *gin.Context) {
+	var request apiKeyCreate
+
+	mail, ok := apiKeyOwner(c)
+	if !ok {
+		return
+	}
+
+	// Check the parameter trasnfer from POST
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+		return
+	}
+
+	scope := strings.Join(strings.Fields(request.Scope), " ")
+	if !rbac.ValidScope(scope) {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1104, map[string]interface{}{
+			"scope": request.Scope,
+		}))
+		return
+	}
+
+	expiresIn := apiKeyDefaultTTL
+	if request.ExpiresIn != "" {
+		duration, _, err := time_convert.ConvertTimeFormat(request.ExpiresIn)
+		if err != nil || duration < time.Second || duration > apiKeyMaxTTL {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1069, map[string]interface{}{
+				"expires_in": request.ExpiresIn,
+				"max":        apiKeyMaxTTL.String(),
+			}))
+			return
+		}
+		expiresIn = duration
+	}
+	expireSec := int64(expiresIn.Seconds())
+
+	randomPart, err := encrypt.RandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1086, err))
+		return
+	}
+	apiKey := apiKeyPrefix + randomPart
+	keyID := encrypt.GenertateUUID(true)
+
+	err = mariadb.InsertAPIKey(keyID, mail, request.Name, apiKey[:12], encrypt.HashWithSHA(apiKey, "sha256"), scope, expireSec)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1057, nil))
+		return
+	} else if err != nil {
+		errorMessage := fmt.Sprintf("Insert API key failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1086, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"key_id":     keyID,
+		"api_key":    apiKey,
+		"name":       request.Name,
+		"scope":      scope,
+		"expires_in": expiresIn.String(),
+	}))
+}
+
+// @Summary List API Keys
+// @Description Show the personal API keys of the user, the keys themselves are not shown.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/api-keys [get]
+func APIKeyList(c *gin.Context) {
+	mail, ok := apiKeyOwner(c)
+	if !ok {
+		return
+	}
+
+	apiKeys, err := mariadb.ListAPIKeys(mail)
+	if err != nil {
+		errorMessage := fmt.Sprintf("List API keys failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"api_keys": apiKeys,
+	}))
+}
+
+// @Summary Rename API Key
+// @Description Change the name of a personal API key.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Param key_id path string true "Key ID"
+// @Param name body string true "Key Name"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/api-keys/{key_id} [patch]
+func APIKeyRename(c *gin.Context) {
+	var request apiKeyRename
+
+	mail, ok := apiKeyOwner(c)
+	if !ok {
+		return
+	}
+
+	// Check the parameter trasnfer from POST
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+		return
+	}
+
+	keyID := c.Param("key_id")
+
+	result, err := mariadb.RenameAPIKey(keyID, mail, request.Name)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Rename API key failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	// MariaDB doesn't count the row whose name is not changed, so it's only
+	// checked whether the key exists when nothing was affected.
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		apiKeys, err := mariadb.ListAPIKeys(mail)
+		if err != nil {
+			errorMessage := fmt.Sprintf("List API keys failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+			return
+		}
+		found := false
+		for _, apiKey := range apiKeys {
+			if apiKey.KeyID == keyID {
+				found = true
+			}
+		}
+		if !found {
+			c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1085, nil))
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"key_id": keyID,
+		"name":   request.Name,
+	}))
+}
+
+// @Summary Revoke API Key
+// @Description Delete a personal API key, it can't be used anymore.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Param key_id path string true "Key ID"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/api-keys/{key_id} [delete]
+func APIKeyRevoke(c *gin.Context) {
+	mail, ok := apiKeyOwner(c)
+	if !ok {
+		return
+	}
+
+	keyID := c.Param("key_id")
+
+	result, err := mariadb.DeleteAPIKey(keyID, mail)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Delete API key failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1085, nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"key_id": keyID,
+		"msg":    "API key has been revoked.",
+	}))
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+
+	mariadb "suglider-auth/internal/database"
+)
+
+func TestAPIKeyCreate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unit Test (Mock database) Fail: %v\n", err)
+	}
+	defaultDataBase := mariadb.DataBase
+	mariadb.DataBase = sqlx.NewDb(db, "mysql")
+	defer func() {
+		mariadb.DataBase = defaultDataBase
+		db.Close()
+	}()
+
+	// The mail is set by CheckUserJWT in the server
+	router := gin.New()
+	router.POST("/user/api-keys", func(c *gin.Context) {
+		c.Set("mail", "alice@example.com")
+		APIKeyCreate(c)
+	})
+
+	request := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/user/api-keys", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	insertAPIKey := `INSERT INTO suglider\.api_key\(key_id, user_id, name, key_prefix, key_hash, scopes, expires_at\) ` +
+		`SELECT \?, user_id, \?, \?, \?, \?, DATE_ADD\(NOW\(\), INTERVAL \? SECOND\) FROM suglider\.user_info WHERE mail=\?`
+
+	t.Run("Test the lifetime of the key", func(t *testing.T) {
+		testCases := []struct {
+			expiresIn string
+			reason    string
+		}{
+			{"500ms", "A key shorter than a second would never expire."},
+			{"0s", "A key must expire."},
+			{"-1h", "A key must not expire in the past."},
+			{"9000h", "A key must not outlive the max lifetime."},
+			{"forever", "The lifetime must be a duration."},
+		}
+		for _, testCase := range testCases {
+			body := `{"name": "ci", "scope": "user:read", "expires_in": "` + testCase.expiresIn + `"}`
+			if w := request(body); w.Code != http.StatusBadRequest {
+				t.Errorf("Result: %s -> %d (%s)\n", testCase.expiresIn, w.Code, testCase.reason)
+			}
+		}
+	})
+
+	t.Run("Test the key expires in seconds", func(t *testing.T) {
+		mock.ExpectExec(insertAPIKey).
+			WithArgs(sqlmock.AnyArg(), "ci", sqlmock.AnyArg(), sqlmock.AnyArg(), "user:read", int64(1), "alice@example.com").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if w := request(`{"name": "ci", "scope": "user:read", "expires_in": "1500ms"}`); w.Code != http.StatusOK {
+			t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The key must be created.")
+		}
+	})
+
+	t.Run("Test a key without expiry is not stored", func(t *testing.T) {
+		err := mariadb.InsertAPIKey("key-1", "alice@example.com", "ci", "sga_", "hash", "user:read", 0)
+		if err == nil {
+			t.Errorf("Result: %v (%s)\n", err, "Every API key must expire.")
+		}
+	})
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unit Test (Database expectations) Fail: %v\n", err)
+	}
+}
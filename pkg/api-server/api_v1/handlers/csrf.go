@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"suglider-auth/internal/utils"
+	"suglider-auth/pkg/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary CSRF Token
+// @Description Get the CSRF token of the login session, cookie authenticated POST, PUT, PATCH and DELETE requests must send it in the X-CSRF-Token header.
+// @Tags csrf
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/csrf [get]
+func CSRFToken(c *gin.Context) {
+	sid := c.GetString("sid")
+	if sid == "" {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1088, nil))
+		return
+	}
+
+	csrfToken, errCode, err := session.CSRFToken(sid)
+	if errCode == 1043 {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1088, nil))
+		return
+	} else if err != nil {
+		errorMessage := fmt.Sprintf("Get CSRF token of session failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"csrf_token": csrfToken,
+	}))
+}
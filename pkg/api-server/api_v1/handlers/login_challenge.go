@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	mariadb "suglider-auth/internal/database"
+	"suglider-auth/internal/utils"
+	"suglider-auth/pkg/login_challenge"
+	"suglider-auth/pkg/passkey"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loginFactors returns the 2FA methods enabled by the user, the login needs
+// a challenge when it's not empty.
+func loginFactors(userTwoFactorAuthData mariadb.UserTwoFactorAuthInfo) []string {
+	factors := []string{}
+	if userTwoFactorAuthData.TotpEnabled.Bool {
+		factors = append(factors, login_challenge.FactorTOTP)
+	}
+	if userTwoFactorAuthData.MailOTPEnabled {
+		factors = append(factors, login_challenge.FactorMailOTP)
+	}
+	if userTwoFactorAuthData.SmsOTPEnabled {
+		factors = append(factors, login_challenge.FactorSmsOTP)
+	}
+	if userTwoFactorAuthData.WebAuthnEnabled && passkey.Enabled() {
+		factors = append(factors, login_challenge.FactorWebAuthn)
+	}
+	return factors
+}
+
+func challengeStatus(ch *login_challenge.Challenge) map[string]interface{} {
+	return map[string]interface{}{
+		"mail":               ch.Mail,
+		"username":           ch.UserName,
+		"factors":            ch.Factors,
+		"passed_factors":     ch.Passed,
+		"remaining_factors":  ch.Remaining(),
+		"remaining_attempts": ch.RemainingAttempts(),
+		"totp_enabled":       hasLoginFactor(ch.Factors, login_challenge.FactorTOTP),
+		"mail_otp_enabled":   hasLoginFactor(ch.Factors, login_challenge.FactorMailOTP),
+		"sms_otp_enabled":    hasLoginFactor(ch.Factors, login_challenge.FactorSmsOTP),
+		"totp_passed":        hasLoginFactor(ch.Passed, login_challenge.FactorTOTP),
+		"mail_otp_passed":    hasLoginFactor(ch.Passed, login_challenge.FactorMailOTP),
+		"sms_otp_passed":     hasLoginFactor(ch.Passed, login_challenge.FactorSmsOTP),
+	}
+}
+
+func hasLoginFactor(factors []string, factor string) bool {
+	for _, f := range factors {
+		if f == factor {
+			return true
+		}
+	}
+	return false
+}
+
+func challengeError(c *gin.Context, errCode int64, err error) {
+	switch errCode {
+	case 1093, 1094:
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, errCode, nil))
+	case 1095:
+		c.JSON(http.StatusForbidden, utils.ErrorResponse(c, errCode, nil))
+	default:
+		errorMessage := fmt.Sprintf("Login challenge failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+	}
+}
+
+// startLoginChallenge answers a login which needs 2FA with the challenge ID,
+// the 2FA endpoints accept the ID instead of the mail of the user.
+func startLoginChallenge(c *gin.Context, mail, userName string, factors []string) {
+	challengeID, ch, errCode, err := login_challenge.Create(mail, userName, factors)
+	if err != nil {
+		challengeError(c, errCode, err)
+		return
+	}
+
+	data := challengeStatus(ch)
+	data["challenge_id"] = challengeID
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, data))
+}
+
+// readChallenge looks up the login challenge of a 2FA request, the factor
+// must be enabled by the user and not passed yet, and the account must not be
+// locked.
+func readChallenge(c *gin.Context, challengeID, factor string) (*login_challenge.Challenge, bool) {
+	ch, errCode, err := login_challenge.Get(challengeID)
+	if err != nil {
+		challengeError(c, errCode, err)
+		return nil, false
+	}
+	if !ch.Allows(factor) {
+		challengeError(c, 1095, nil)
+		return nil, false
+	}
+	if loginBlocked(c, ch.Mail) {
+		return nil, false
+	}
+	return ch, true
+}
+
+// finishChallengeFactor records the result of the factor, the session and
+// the tokens are issued once the required factors have passed.
+func finishChallengeFactor(c *gin.Context, csbn *CasbinEnforcerConfig, challengeID, factor string, passed bool) (*login_challenge.Challenge, bool) {
+	if !passed {
+		// The failures of 2FA are counted with the ones of the password
+		ch, errCode, err := login_challenge.Fail(challengeID)
+		if ch != nil {
+			recordLoginFailure(c, ch.Mail, ch.UserName)
+		}
+		if err != nil {
+			challengeError(c, errCode, err)
+			return nil, false
+		}
+		return ch, true
+	}
+
+	ch, errCode, err := login_challenge.Pass(challengeID, factor)
+	if err != nil {
+		challengeError(c, errCode, err)
+		return nil, false
+	}
+
+	if ch.Completed() {
+		if !setSession(c, csbn, ch.Mail) || !setJWT(c, ch.Mail) {
+			return nil, false
+		}
+		clearLoginFailures(ch.Mail)
+	}
+	return ch, true
+}
+
+// loginVerified answers a 2FA request after the factor has been verified,
+// verifyKey is the context key of the result of the factor.
+func loginVerified(c *gin.Context, verifyKey string) {
+	value, isChallengeExists := c.Get("login_challenge")
+	result, isVerifyExists := c.Get(verifyKey)
+
+	ch, okChallenge := value.(*login_challenge.Challenge)
+	verifyResult, okResult := result.(bool)
+	if !isChallengeExists || !isVerifyExists || !okChallenge || !okResult {
+		slog.Error(fmt.Sprintf("login_challenge or %s are not exists.", verifyKey))
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1051, nil))
+		return
+	}
+
+	data := challengeStatus(ch)
+	data[verifyKey] = verifyResult
+
+	if !verifyResult {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1047, data))
+		return
+	}
+
+	if remaining, isRecoveryCodeUsed := c.Get("recovery_codes_remaining"); isRecoveryCodeUsed {
+		data["recovery_code_used"] = true
+		data["recovery_codes_remaining"] = remaining
+	}
+
+	data["login_completed"] = ch.Completed()
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, withTokens(c, data)))
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	smtp "suglider-auth/internal/mail"
+	"suglider-auth/internal/utils"
+	fmtv "suglider-auth/pkg/fmt_validator"
+	"suglider-auth/pkg/login_lockout"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setRetryAfter(c *gin.Context, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+}
+
+// loginBlocked answers the login attempt when the account or the IP of the
+// request has to wait after failed attempts, or is locked.
+func loginBlocked(c *gin.Context, mail string) bool {
+	retryAfter, lockout, err := login_lockout.Check(mail, c.ClientIP())
+	if err != nil {
+		errorMessage := fmt.Sprintf("Check login lockout failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1044, err))
+		return true
+	}
+	if retryAfter <= 0 {
+		return false
+	}
+
+	setRetryAfter(c, retryAfter)
+	data := map[string]interface{}{
+		"retry_after": int(math.Ceil(retryAfter.Seconds())),
+	}
+	if lockout != nil && lockout.Scope == login_lockout.ScopeAccount {
+		data["locked_until"] = lockout.ExpiresAt
+		c.JSON(http.StatusTooManyRequests, utils.ErrorResponse(c, 1112, data))
+		return true
+	}
+	c.JSON(http.StatusTooManyRequests, utils.ErrorResponse(c, 1111, data))
+	return true
+}
+
+// recordLoginFailure counts a failed login attempt, mail is empty when the
+// account doesn't exist. The user gets the unlock mail when the account is
+// locked by this failure, the response of the attempt is not changed.
+func recordLoginFailure(c *gin.Context, mail, userName string) {
+	retryAfter, lockout, unlockToken, err := login_lockout.Fail(mail, c.ClientIP())
+	if err != nil {
+		errorMessage := fmt.Sprintf("Record failed login failed: %v", err)
+		slog.Error(errorMessage)
+		return
+	}
+	if retryAfter > 0 {
+		setRetryAfter(c, retryAfter)
+	}
+	if lockout == nil {
+		return
+	}
+
+	slog.Warn(fmt.Sprintf("The account %s is locked after %d failed attempts.", mail, lockout.Failures))
+
+	if userName == "" {
+		userName = mail
+	}
+	lockedUntil := time.Unix(lockout.ExpiresAt, 0)
+	if err = smtp.SendAccountLockedMail(c, userName, mail, unlockToken, lockedUntil); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// clearLoginFailures resets the failed attempts of the account after the
+// login has completed.
+func clearLoginFailures(mail string) {
+	if err := login_lockout.Succeed(mail); err != nil {
+		errorMessage := fmt.Sprintf("Reset failed logins failed: %v", err)
+		slog.Error(errorMessage)
+	}
+}
+
+// @Summary Unlock Account
+// @Description Remove the lockout of the account by the token of the unlock mail.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Param unlock_token formData string false "Unlock token from the mail"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/unlock [post]
+func UnlockAccount(c *gin.Context) {
+	var request unlockAccount
+
+	// Check the parameter trasnfer from POST
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+		return
+	}
+
+	lockout, errCode, err := login_lockout.Unlock(request.UnlockToken)
+	if errCode == 1113 {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, errCode, nil))
+		return
+	} else if err != nil {
+		errorMessage := fmt.Sprintf("Unlock account failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"mail": lockout.Subject,
+		"msg":  "The account has been unlocked.",
+	}))
+}
+
+// @Summary List Lockouts
+// @Description Show the accounts and the IPs which are locked because of failed logins, it's used by administrators.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/lockouts [get]
+func LockoutList(c *gin.Context) {
+	lockouts, err := login_lockout.List()
+	if err != nil {
+		errorMessage := fmt.Sprintf("List lockouts failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1044, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"lockouts": lockouts,
+	}))
+}
+
+// @Summary Clear Lockout
+// @Description Remove the lockout and the failed attempts of an account or an IP, it's used by administrators.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Param scope path string true "account or ip"
+// @Param subject path string true "The mail of the account or the IP"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/lockouts/{scope}/{subject} [delete]
+func LockoutClear(c *gin.Context) {
+	scope := c.Param("scope")
+
+	subject, err := url.QueryUnescape(c.Param("subject"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1103, err))
+		return
+	}
+
+	switch scope {
+	case login_lockout.ScopeAccount:
+		if !fmtv.MailValidator(subject) {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1062, nil))
+			return
+		}
+	case login_lockout.ScopeIP:
+		if net.ParseIP(subject) == nil {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1104, map[string]interface{}{
+				"ip": subject,
+			}))
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1104, map[string]interface{}{
+			"scope": scope,
+		}))
+		return
+	}
+
+	lockout, err := login_lockout.Clear(scope, subject)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Clear lockout failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1040, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"scope":   scope,
+		"subject": subject,
+		"locked":  lockout != nil,
+		"msg":     "The lockout and the failed attempts have been cleared.",
+	}))
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	mariadb "suglider-auth/internal/database"
+	smtp "suglider-auth/internal/mail"
+	"suglider-auth/internal/utils"
+	fmtv "suglider-auth/pkg/fmt_validator"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Send Login Link
+// @Description Send a single-use login link to the mail of the user, the response doesn't tell whether the account exists.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Param mail body string true "Email"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/magic-link [post]
+func MagicLinkSend(c *gin.Context) {
+	var request magicLinkSend
+
+	// Check the parameter trasnfer from POST
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+		return
+	}
+
+	if !fmtv.MailValidator(request.Mail) {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1062, nil))
+		return
+	}
+
+	userName, err := mariadb.UserGetNameByMail(c, request.Mail)
+	if err != nil && err != sql.ErrNoRows {
+		errorMessage := fmt.Sprintf("Send login link failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	// The link is only sent to an existing account, a failed mail isn't told
+	// to the client either, otherwise it would show the account exists
+	if err == nil {
+		if err = smtp.SendMagicLinkMail(c, userName, request.Mail); err != nil {
+			errorMessage := fmt.Sprintf("Send login link mail failed: %v", err)
+			slog.Error(errorMessage)
+		}
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"mail": request.Mail,
+		"msg":  "If the account exists, a login link has been sent to the mail.",
+	}))
+}
+
+// @Summary Login By Link
+// @Description Log in by the link in the mail, the link can only be used once. The user who has enabled 2FA gets a login challenge like the password login.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Param mail body string true "Email"
+// @Param link_id body string true "Link ID from the mail"
+// @Param link_code body string true "Link code from the mail"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/magic-link/login [post]
+func MagicLinkLogin(csbn *CasbinEnforcerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request magicLinkLogin
+
+		// Check the parameter trasnfer from POST
+		err := c.ShouldBindJSON(&request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+			return
+		}
+
+		// The link doesn't bypass the lockout of the account or the IP
+		if loginBlocked(c, request.Mail) {
+			return
+		}
+
+		pass, err := smtp.CheckMagicLink(c, request.Mail, request.LinkID, request.LinkCode)
+		if err != nil || !pass {
+			if err != nil {
+				errorMessage := fmt.Sprintf("Check login link failed: %v", err)
+				slog.Error(errorMessage)
+			}
+			// Guessing the links is counted against the IP only
+			recordLoginFailure(c, "", "")
+			c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1116, nil))
+			return
+		}
+
+		userTwoFactorAuthData, err := mariadb.GetTwoFactorAuthByMail(request.Mail)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1003, err))
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+			return
+		}
+
+		// The link replaces the password, the enabled 2FA is still required
+		if factors := loginFactors(userTwoFactorAuthData); len(factors) > 0 {
+			startLoginChallenge(c, userTwoFactorAuthData.Mail, userTwoFactorAuthData.UserName.String, factors)
+			return
+		}
+
+		if !setSession(c, csbn, userTwoFactorAuthData.Mail) || !setJWT(c, userTwoFactorAuthData.Mail) {
+			return
+		}
+		clearLoginFailures(userTwoFactorAuthData.Mail)
+
+		c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, withTokens(c, map[string]interface{}{
+			"mail":             userTwoFactorAuthData.Mail,
+			"username":         userTwoFactorAuthData.UserName.String,
+			"totp_enabled":     userTwoFactorAuthData.TotpEnabled.Bool,
+			"mail_otp_enabled": userTwoFactorAuthData.MailOTPEnabled,
+			"sms_otp_enabled":  userTwoFactorAuthData.SmsOTPEnabled,
+		})))
+	}
+}
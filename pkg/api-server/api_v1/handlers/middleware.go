@@ -1,26 +1,30 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
 	mariadb "suglider-auth/internal/database"
+	smtp "suglider-auth/internal/mail"
 	"suglider-auth/internal/redis"
 	"suglider-auth/internal/utils"
 	"suglider-auth/pkg/encrypt"
 	fmtv "suglider-auth/pkg/fmt_validator"
 	"suglider-auth/pkg/jwt"
+	"suglider-auth/pkg/login_challenge"
+	"suglider-auth/pkg/refresh_token"
 	"suglider-auth/pkg/session"
 	"suglider-auth/pkg/totp"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func ValidateMailOTP() gin.HandlerFunc {
+func ValidateMailOTP(csbn *CasbinEnforcerConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var request otpData
+		var request challengeOTP
 
 		// Check the parameter transfer from POST
 		err := c.ShouldBindJSON(&request)
@@ -30,8 +34,14 @@ func ValidateMailOTP() gin.HandlerFunc {
 			return
 		}
 
+		ch, ok := readChallenge(c, request.ChallengeID, login_challenge.FactorMailOTP)
+		if !ok {
+			c.Abort()
+			return
+		}
+
 		// Check whether user enable 2FA or not.
-		userTwoFactorAuthData, err := mariadb.GetTwoFactorAuthByMail(request.Mail)
+		userTwoFactorAuthData, err := mariadb.GetTwoFactorAuthByMail(ch.Mail)
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
@@ -47,19 +57,77 @@ func ValidateMailOTP() gin.HandlerFunc {
 			return
 		}
 
-		redisKey := encrypt.HashWithSHA(request.Mail, "sha1")
+		// The code can be tried only once, a wrong code needs a new one. A
+		// code which has been used or has expired fails like a wrong one.
+		redisKey := encrypt.HashWithSHA(ch.Mail, "sha1")
 
-		value, errCode, err := redis.Get("mail_otp:" + redisKey)
+		value, errCode, err := redis.GetDel("mail_otp:" + redisKey)
 
-		switch errCode {
-		case 1043:
-			errorMessage := fmt.Sprintf("Redis key does not exist: %v", err)
+		if errCode == 1044 {
+			errorMessage := fmt.Sprintf("Redis GET data failed: %v", err)
 			slog.Error(errorMessage)
 			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
 			c.Abort()
 			return
+		}
 
-		case 1044:
+		// Verify OTP Code from user input
+		verified := errCode == 0 && subtle.ConstantTimeCompare([]byte(value), []byte(request.OTPCode)) == 1
+		ch, ok = finishChallengeFactor(c, csbn, request.ChallengeID, login_challenge.FactorMailOTP, verified)
+		if !ok {
+			c.Abort()
+			return
+		}
+
+		c.Set("mail_otp_verify", verified)
+		c.Set("login_challenge", ch)
+		c.Set("mail", ch.Mail)
+		c.Set("userName", userTwoFactorAuthData.UserName)
+		c.Next()
+	}
+}
+
+func ValidateSmsOTP(csbn *CasbinEnforcerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request challengeOTP
+
+		// Check the parameter transfer from POST
+		err := c.ShouldBindJSON(&request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+			c.Abort()
+			return
+		}
+
+		ch, ok := readChallenge(c, request.ChallengeID, login_challenge.FactorSmsOTP)
+		if !ok {
+			c.Abort()
+			return
+		}
+
+		// Check whether user enable 2FA or not.
+		userTwoFactorAuthData, err := mariadb.GetTwoFactorAuthByMail(ch.Mail)
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+			c.Abort()
+			return
+		}
+
+		if !userTwoFactorAuthData.SmsOTPEnabled {
+			c.JSON(http.StatusForbidden, utils.ErrorResponse(c, 1053, map[string]interface{}{
+				"sms_otp_enabled": userTwoFactorAuthData.SmsOTPEnabled,
+			}))
+			c.Abort()
+			return
+		}
+
+		// Take the code out before comparing, so it's gone even if it's wrong
+		redisKey := encrypt.HashWithSHA(ch.Mail, "sha1")
+
+		value, errCode, err := redis.GetDel("sms_otp:" + redisKey)
+
+		if errCode == 1044 {
 			errorMessage := fmt.Sprintf("Redis GET data failed: %v", err)
 			slog.Error(errorMessage)
 			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
@@ -68,31 +136,25 @@ func ValidateMailOTP() gin.HandlerFunc {
 		}
 
 		// Verify OTP Code from user input
-		if value == request.OTPCode {
-			c.Set("mail_otp_verify", true)
-			okSetSession := setSession(c, request.Mail)
-			if !okSetSession {
-				c.Abort()
-				return
-			}
-			okSetJWT := setJWT(c, request.Mail)
-			if !okSetJWT {
-				c.Abort()
-				return
-			}
-		} else {
-			c.Set("mail_otp_verify", false)
+		verified := errCode == 0 && subtle.ConstantTimeCompare([]byte(value), []byte(request.OTPCode)) == 1
+
+		ch, ok = finishChallengeFactor(c, csbn, request.ChallengeID, login_challenge.FactorSmsOTP, verified)
+		if !ok {
+			c.Abort()
+			return
 		}
 
-		c.Set("mail", request.Mail)
+		c.Set("sms_otp_verify", verified)
+		c.Set("login_challenge", ch)
+		c.Set("mail", ch.Mail)
 		c.Set("userName", userTwoFactorAuthData.UserName)
 		c.Next()
 	}
 }
 
-func ValidateTOTP() gin.HandlerFunc {
+func ValidateTOTP(csbn *CasbinEnforcerConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var request otpData
+		var request challengeOTP
 
 		// Check the parameter trasnfer from POST
 		err := c.ShouldBindJSON(&request)
@@ -102,8 +164,14 @@ func ValidateTOTP() gin.HandlerFunc {
 			return
 		}
 
+		ch, ok := readChallenge(c, request.ChallengeID, login_challenge.FactorTOTP)
+		if !ok {
+			c.Abort()
+			return
+		}
+
 		// To get TOTP secret
-		totpData, err := mariadb.TotpUserData(request.Mail)
+		totpData, err := mariadb.TotpUserData(ch.Mail)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1006, err))
@@ -123,37 +191,93 @@ func ValidateTOTP() gin.HandlerFunc {
 			return
 		}
 
-		// Verify TOTP Code from user input
-		valid := totp.TotpValidate(request.OTPCode, totpData.TotpSecret)
-
-		if valid {
-			c.Set("totp_verify", true)
-			okSetSession := setSession(c, request.Mail)
-			if !okSetSession {
+		// Verify TOTP Code from user input, a recovery code is consumed once
+		// it's valid
+		var valid, recoveryCodeUsed bool
+		if totp.IsRecoveryCode(request.OTPCode) {
+			valid, err = mariadb.UseTotpRecoveryCode(ch.Mail, totp.HashRecoveryCode(request.OTPCode))
+			if err != nil {
+				errorMessage := fmt.Sprintf("Use TOTP recovery code failed: %v", err)
+				slog.Error(errorMessage)
+				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
 				c.Abort()
 				return
 			}
-			okSetJWT := setJWT(c, request.Mail)
-			if !okSetJWT {
+			recoveryCodeUsed = valid
+		} else {
+			valid, err = acceptTotpCode(ch.Mail, request.OTPCode)
+			if err != nil {
+				errorMessage := fmt.Sprintf("Record TOTP time step failed: %v", err)
+				slog.Error(errorMessage)
+				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
 				c.Abort()
 				return
 			}
+		}
 
-		} else {
-			c.Set("totp_verify", false)
+		ch, ok = finishChallengeFactor(c, csbn, request.ChallengeID, login_challenge.FactorTOTP, valid)
+		if !ok {
+			c.Abort()
+			return
 		}
 
-		c.Set("mail", request.Mail)
+		if recoveryCodeUsed {
+			notifyRecoveryCodeUsed(c, ch.Mail, totpData.UserName.String)
+		}
+
+		c.Set("totp_verify", valid)
+		c.Set("login_challenge", ch)
+		c.Set("mail", ch.Mail)
 		c.Set("userName", totpData.UserName)
 		c.Next()
 	}
 }
 
+// acceptTotpCode checks the code with the verified TOTP devices of the user,
+// a code which has been accepted can't be used again.
+func acceptTotpCode(mail, code string) (bool, error) {
+	devices, err := mariadb.TotpDeviceList(mail)
+	if err != nil {
+		return false, err
+	}
+
+	verifiedDevices := []mariadb.TotpDeviceInfo{}
+	for _, d := range devices {
+		if d.Verified {
+			verifiedDevices = append(verifiedDevices, d)
+		}
+	}
+
+	device, err := totp.TotpAcceptAny(verifiedDevices, code)
+	return device != nil, err
+}
+
+// notifyRecoveryCodeUsed mails the user that a recovery code has been used,
+// the login doesn't fail when the mail can't be sent.
+func notifyRecoveryCodeUsed(c *gin.Context, mail, userName string) {
+	remaining, err := mariadb.CountTotpRecoveryCodes(mail)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Count TOTP recovery codes failed: %v", err)
+		slog.Error(errorMessage)
+		return
+	}
+
+	if userName == "" {
+		userName = mail
+	}
+
+	c.Set("recovery_codes_remaining", remaining)
+	if err = smtp.SendRecoveryCodeUsedMail(c, userName, mail, remaining); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// LoginStatusCheck resolves the account of the login to the mail of the user.
+// The progress of 2FA is kept by the login challenge, see startLoginChallenge.
 func LoginStatusCheck() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var request userLogin
 		var account string
-		var data rdsValeData
 
 		// Check the parameter trasnfer from POST
 		err := c.ShouldBindJSON(&request)
@@ -184,49 +308,72 @@ func LoginStatusCheck() gin.HandlerFunc {
 			account = userInfo.Mail
 		}
 
-		// Check whether login status exists in redis or not.
-		value, errCode, err := redis.Get("login_status:" + account)
+		c.Set("mail", account)
+		c.Set("password", request.Password)
+		c.Next()
+	}
+}
 
-		switch errCode {
-		// Key not exists
-		case 1043:
+// RequireRecentAuth guards the sensitive operations, the password or a second
+// factor must have been verified in the session within the reauth window. The
+// client is told the factors it can use to authenticate again by
+// /api/v1/user/reauth. API keys and client credentials have no session to
+// authenticate again, so they can't do sensitive operations.
+func RequireRecentAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mail, ok := loginUser(c, "Sensitive operations can only be done by the login session of the user.")
+		if !ok {
+			c.Abort()
+			return
+		}
 
-			c.Set("mail", account)
-			c.Set("password", request.Password)
-			c.Next()
+		sid := c.GetString("sid")
+		if sid == "" {
+			c.JSON(http.StatusForbidden, utils.ErrorResponse(c, 1064, map[string]interface{}{
+				"msg": "Sensitive operations can only be done by the login session of the user.",
+			}))
+			c.Abort()
+			return
+		}
 
-		case 1044:
-			errorMessage := fmt.Sprintf("Redis GET data failed: %v", err)
+		data, _, errCode, err := session.GetSession(sid)
+		if errCode == 1043 {
+			c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1090, nil))
+			c.Abort()
+			return
+		} else if err != nil {
+			errorMessage := fmt.Sprintf("Get session failed: %v", err)
 			slog.Error(errorMessage)
 			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
 			c.Abort()
 			return
-		// Key exists
-		case 0:
-			err := json.Unmarshal([]byte(value), &data)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1063, err))
-				c.Abort()
-				return
-			}
-			c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
-				"mail":             data.Mail,
-				"username":         data.UserName,
-				"account_passed":   data.AccountPassed,
-				"mail_otp_passed":  data.MailOTPPassed,
-				"sms_otp_passed":   data.SmsOTPPassed,
-				"totp_passed":      data.TotpPassed,
-				"totp_enabled":     data.TotpEnabled,
-				"mail_otp_enabled": data.MailOTPEnabled,
-				"sms_otp_enabled":  data.SmsOTPEnabled,
-			}))
+		}
+
+		authenticatedAt := session.AuthenticatedAt(data)
+		if time.Since(authenticatedAt) <= session.ReauthWindow() {
+			c.Next()
+			return
+		}
+
+		factors, err := reauthFactors(mail)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Get reauthentication factors failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
 			c.Abort()
 			return
 		}
+
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1117, map[string]interface{}{
+			"factors":          factors,
+			"authenticated_at": authenticatedAt.Unix(),
+			"reauth_window":    int(session.ReauthWindow().Seconds()),
+		}))
+		c.Abort()
 	}
 }
 
-func setSession(c *gin.Context, mail string) bool {
+func setSession(c *gin.Context, csbn *CasbinEnforcerConfig, mail string) bool {
 
 	// Check session exist or not
 	ok, err := session.CheckSession(c)
@@ -237,18 +384,7 @@ func setSession(c *gin.Context, mail string) bool {
 		return false
 	}
 	if !ok {
-		_, errCode, err := session.AddSession(c, mail)
-		switch errCode {
-		case 1041:
-			errorMessage := fmt.Sprintf("Failed to create session value JSON data: %v", err)
-			slog.Error(errorMessage)
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
-			return false
-
-		case 1042:
-			errorMessage := fmt.Sprintf("Redis SET data failed: %v", err)
-			slog.Error(errorMessage)
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		if !addLimitedSession(c, csbn, mail) {
 			return false
 		}
 	} else {
@@ -281,28 +417,68 @@ func setSession(c *gin.Context, mail string) bool {
 			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1040, err))
 			return false
 		}
-		_, errCode, err = session.AddSession(c, mail)
-		switch errCode {
-		case 1041:
-			errorMessage := fmt.Sprintf("Failed to create session value JSON data: %v", err)
-			slog.Error(errorMessage)
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
-			return false
-
-		case 1042:
-			errorMessage := fmt.Sprintf("Redis SET data failed: %v", err)
-			slog.Error(errorMessage)
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		if !addLimitedSession(c, csbn, mail) {
 			return false
 		}
 	}
 
+	// The CSRF token of the new session is returned by withTokens
+	csrfToken, errCode, err := session.CSRFToken(session.CurrentSessionID(c))
+	if err != nil {
+		errorMessage := fmt.Sprintf("Get CSRF token of session failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return false
+	}
+	c.Set("csrf_token", csrfToken)
+
+	return true
+}
+
+// addLimitedSession creates the session of the login within the session
+// limit of the user. The logins of the user are serialized by the session
+// lock, so the limit holds even when they run concurrently.
+func addLimitedSession(c *gin.Context, csbn *CasbinEnforcerConfig, mail string) bool {
+	unlock, err := session.LockUser(mail)
+	if err == session.ErrUserLocked {
+		c.JSON(http.StatusConflict, utils.ErrorResponse(c, 1119, err))
+		return false
+	} else if err != nil {
+		errorMessage := fmt.Sprintf("Acquire the session lock of user failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1042, err))
+		return false
+	}
+	defer unlock()
+
+	if !enforceSessionLimit(c, csbn, mail) {
+		return false
+	}
+
+	_, errCode, err := session.AddSession(c, mail)
+	switch errCode {
+	case 1041:
+		errorMessage := fmt.Sprintf("Failed to create session value JSON data: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return false
+
+	case 1042:
+		errorMessage := fmt.Sprintf("Redis SET data failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return false
+	}
+
 	return true
 }
 
 func setJWT(c *gin.Context, mail string) bool {
 
-	token, expireTimeSec, err := jwt.GenerateJWT(mail)
+	// setSession is always called before, the tokens are bound to the new session
+	sid := session.CurrentSessionID(c)
+
+	token, expireTimeSec, err := jwt.GenerateSessionJWT(mail, sid)
 
 	if err != nil {
 		errorMessage := fmt.Sprintf("Generate the JWT string failed: %v", err)
@@ -311,6 +487,57 @@ func setJWT(c *gin.Context, mail string) bool {
 		return false
 	}
 
-	c.SetCookie("token", token, expireTimeSec, "/", "localhost", false, true)
+	// The refresh token doesn't outlive the absolute timeout of the session
+	_, absoluteTimeout := session.Timeouts()
+	refreshToken, refreshExpireTimeSec, errCode, err := refresh_token.Issue(mail, sid, absoluteTimeout)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Generate refresh token failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return false
+	}
+
+	// The tokens are added to the response by withTokens instead of cookies
+	if tokenModeBody(c) {
+		c.Set("access_token", token)
+		c.Set("expires_in", expireTimeSec)
+		c.Set("refresh_token", refreshToken)
+		c.Set("refresh_expires_in", refreshExpireTimeSec)
+		return true
+	}
+
+	session.SetCookie(c, "token", token, expireTimeSec)
+	session.SetCookie(c, "refresh_token", refreshToken, refreshExpireTimeSec)
 	return true
 }
+
+// tokenModeBody reports whether the client asks for the tokens in the JSON
+// body instead of cookies, by the `X-Token-Mode: body` header or the
+// `token_mode=body` query.
+func tokenModeBody(c *gin.Context) bool {
+	return c.GetHeader("X-Token-Mode") == "body" || c.Query("token_mode") == "body" || c.GetBool("token_mode_body")
+}
+
+// withTokens adds the tokens issued by setJWT to the response data when the
+// client has chosen the body token mode. The CSRF token of the new session
+// and the sessions evicted by the session limit are reported as well.
+func withTokens(c *gin.Context, data map[string]interface{}) map[string]interface{} {
+	if evicted, exists := c.Get("evicted_sessions"); exists {
+		data["evicted_sessions"] = evicted
+	}
+	if csrfToken := c.GetString("csrf_token"); csrfToken != "" {
+		data["csrf_token"] = csrfToken
+	}
+
+	token, exists := c.Get("access_token")
+	if !exists || !tokenModeBody(c) {
+		return data
+	}
+
+	data["access_token"] = token
+	data["token_type"] = "Bearer"
+	data["expires_in"] = c.GetInt("expires_in")
+	data["refresh_token"] = c.GetString("refresh_token")
+	data["refresh_expires_in"] = c.GetInt("refresh_expires_in")
+	return data
+}
@@ -12,8 +12,6 @@ import (
 
 	mariadb "suglider-auth/internal/database"
 	"suglider-auth/internal/utils"
-	"suglider-auth/internal/redis"
-	"suglider-auth/pkg/time_convert"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/oauth2"
@@ -32,288 +30,176 @@ var (
 // @Produce application/json
 // @Param mail formData string false "Mail"
 // @Param token formData string false "Token"
+// @Param X-Token-Mode header string false "Set to body to get the tokens in the response instead of cookies"
 // @Success 200 {string} string "Success"
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
 // @Failure 403 {string} string "Forbidden"
 // @Failure 404 {string} string "Not found"
 // @Router /api/v1/oauth/google/verify [post]
-func OAuthGoogleVerification(c *gin.Context) {
-	var (
-		oAuthResponse oAuthResponse
-		username      string
-		err           error
-	)
-
-	if err = c.Request.ParseForm(); err != nil {
-		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1101, err))
-		return
-	}
-
-	postData := &googleOauth2Verification{}
-	if err = c.Bind(&postData); err != nil {
-		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1102, err))
-		return
-	}
-
-	if postData.Mail == "" || postData.AccessToken == "" {
-		slog.Error("It's either that the mail doesn't exist or token.")
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1072, nil))
-		return
-	}
-
-	client := googleOauthConfig.Client(
-		oauth2.NoContext,
-		&oauth2.Token {
-			AccessToken: postData.AccessToken,
-			TokenType:   "Bearer",
-		 },
-	)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
-	if err != nil {
-		log.Println("Failed to get user info:", err)
-		c.JSON(http.StatusInternalServerError, gin.H { "error": "Failed to get user info" })
-		return
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Println("Failed to read response body:", err)
-		c.JSON(http.StatusInternalServerError, gin.H { "error": "Failed to read response body" })
-		return
-	}
-
-	if err = json.Unmarshal(body, &oAuthResponse); err != nil {
-		log.Println("Failed to parse user info:", err)
-		c.JSON(http.StatusInternalServerError, gin.H { "error": "Failed to parse user info" })
-		return
-	}
-
-	if postData.Mail != oAuthResponse.Email {
-		c.JSON(http.StatusForbidden, utils.ErrorResponse(c, 1073, err))
-		return
-	}
+func OAuthGoogleVerification(csbn *CasbinEnforcerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var (
+			oAuthResponse oAuthResponse
+			username      string
+			err           error
+		)
+
+		if err = c.Request.ParseForm(); err != nil {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1101, err))
+			return
+		}
 
-	exist, err := mariadb.CheckMailExists(oAuthResponse.Email)
-	if err != nil {
-		errorMessage := fmt.Sprintf("Check whether the mail exists or not failed: %v", err)
-		slog.Error(errorMessage)
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1046, err))
-	}
+		postData := &googleOauth2Verification{}
+		if err = c.Bind(&postData); err != nil {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1102, err))
+			return
+		}
 
-	if exist == 1 {
-		userTwoFactorAuthData, err := mariadb.GetTwoFactorAuthByMail(oAuthResponse.Email)
-		if userTwoFactorAuthData.UserName.Valid {
-			// Valid is true if String is not NULL
-			username = userTwoFactorAuthData.UserName.String
-		} else {
-			username = ""
+		if postData.Mail == "" || postData.AccessToken == "" {
+			slog.Error("It's either that the mail doesn't exist or token.")
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1072, nil))
+			return
 		}
 
+		client := googleOauthConfig.Client(
+			oauth2.NoContext,
+			&oauth2.Token {
+				AccessToken: postData.AccessToken,
+				TokenType:   "Bearer",
+			 },
+		)
+		resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
+		if err != nil {
+			log.Println("Failed to get user info:", err)
+			c.JSON(http.StatusInternalServerError, gin.H { "error": "Failed to get user info" })
+			return
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+			log.Println("Failed to read response body:", err)
+			c.JSON(http.StatusInternalServerError, gin.H { "error": "Failed to read response body" })
 			return
 		}
 
-		if userTwoFactorAuthData.TotpEnabled.Bool ||
-			userTwoFactorAuthData.SmsOTPEnabled ||
-			userTwoFactorAuthData.MailOTPEnabled {
+		if err = json.Unmarshal(body, &oAuthResponse); err != nil {
+			log.Println("Failed to parse user info:", err)
+			c.JSON(http.StatusInternalServerError, gin.H { "error": "Failed to parse user info" })
+			return
+		}
 
-			userNameData := &UserName {
-				String: username,
-				Valid:  true,
-			}
+		if postData.Mail != oAuthResponse.Email {
+			c.JSON(http.StatusForbidden, utils.ErrorResponse(c, 1073, err))
+			return
+		}
 
-			rdsValue := &rdsValeData {
-				Mail:           oAuthResponse.Email,
-				UserName:       *userNameData,
-				AccountPassed:  true,
-				MailOTPPassed:  false,
-				SmsOTPPassed:   false,
-				TotpPassed:     false,
-				TotpEnabled:    userTwoFactorAuthData.TotpEnabled.Bool,
-				MailOTPEnabled: userTwoFactorAuthData.MailOTPEnabled,
-				SmsOTPEnabled:  userTwoFactorAuthData.SmsOTPEnabled,
-			}
+		exist, err := mariadb.CheckMailExists(oAuthResponse.Email)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Check whether the mail exists or not failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1046, err))
+		}
 
-			jsonData, err := json.Marshal(rdsValue)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1063, err))
-				return
+		if exist == 1 {
+			userTwoFactorAuthData, err := mariadb.GetTwoFactorAuthByMail(oAuthResponse.Email)
+			if userTwoFactorAuthData.UserName.Valid {
+				// Valid is true if String is not NULL
+				username = userTwoFactorAuthData.UserName.String
+			} else {
+				username = ""
 			}
 
-			redisTTL, _, err := time_convert.ConvertTimeFormat("15m")
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1069, err))
+				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
 				return
 			}
 
-			err = redis.Set("login_status:" + oAuthResponse.Email, string(jsonData), redisTTL)
-
-			if err != nil {
-				errorMessage := fmt.Sprintf("Redis SET data failed.: %v", err)
-				slog.Error(errorMessage)
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1042, err))
-				return
-			}
+			if factors := loginFactors(userTwoFactorAuthData); len(factors) > 0 {
 
-			c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
-				"mail":             oAuthResponse.Email,
-				"username":         *userNameData,
-				"totp_enabled":     userTwoFactorAuthData.TotpEnabled.Bool,
-				"totp_passed":      false,
-				"mail_otp_enabled": userTwoFactorAuthData.MailOTPEnabled,
-				"mail_otp__passed": false,
-				"sms_otp_enabled":  userTwoFactorAuthData.SmsOTPEnabled,
-				"sms_otp_passed":   false,
-			}))
+				startLoginChallenge(c, oAuthResponse.Email, username, factors)
 
+			} else {
+				okSetSession := setSession(c, csbn, oAuthResponse.Email)
+				okSetJWT := okSetSession && setJWT(c, oAuthResponse.Email)
+
+				userNameData := &UserName {
+					String: username,
+					Valid:  true,
+				}
+
+				if okSetSession && okSetJWT {
+					c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, withTokens(c, map[string]interface{}{
+						"mail":             oAuthResponse.Email,
+						"username":         *userNameData,
+						"totp_enabled":     userTwoFactorAuthData.TotpEnabled.Bool,
+						"totp_passed":      false,
+						"mail_otp_enabled": userTwoFactorAuthData.MailOTPEnabled,
+						"mail_otp__passed": false,
+						"sms_otp_enabled":  userTwoFactorAuthData.SmsOTPEnabled,
+						"sms_otp_passed":   false,
+					})))
+				} else {
+					return
+				}
+			}
 		} else {
-			okSetSession := setSession(c, oAuthResponse.Email)
-			okSetJWT := setJWT(c, oAuthResponse.Email)
+			err = mariadb.OAuthSignUp(oAuthResponse.Email, oAuthResponse.GivenName, oAuthResponse.FamilyName)
+			if err != nil {
+				errorMessage := fmt.Sprintf("Insert user_info table failed: %v", err)
+				slog.Error(errorMessage)
 
-			userNameData := &UserName {
-				String: username,
-				Valid:  true,
+				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+				return
 			}
 
-			rdsValue := &rdsValeData {
-				Mail:           oAuthResponse.Email,
-				UserName:       *userNameData,
-				AccountPassed:  true,
-				MailOTPPassed:  false,
-				SmsOTPPassed:   false,
-				TotpPassed:     false,
-				TotpEnabled:    userTwoFactorAuthData.TotpEnabled.Bool,
-				MailOTPEnabled: userTwoFactorAuthData.MailOTPEnabled,
-				SmsOTPEnabled:  userTwoFactorAuthData.SmsOTPEnabled,
+			if err = mariadb.UserSetMailVerified(c, oAuthResponse.Email); err != nil {
+				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1023, err))
+				return
 			}
 
-			jsonData, err := json.Marshal(rdsValue)
+			userInfo, err := mariadb.LookupUserID(oAuthResponse.Email)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1063, err))
+				if err == sql.ErrNoRows {
+					c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1006, err))
+					return
+				}
+				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
 				return
 			}
 
-			redisTTL, _, err := time_convert.ConvertTimeFormat("15m")
+			err = mariadb.InsertPersonalInfo(userInfo.UserID)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1069, err))
+				errorMessage := fmt.Sprintf("Insert personal_info table failed: %v", err)
+				slog.Error(errorMessage)
+
+				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
 				return
 			}
 
-			err = redis.Set("login_status:" + oAuthResponse.Email, string(jsonData), redisTTL)
+			okSetSession := setSession(c, csbn, oAuthResponse.Email)
+			okSetJWT := okSetSession && setJWT(c, oAuthResponse.Email)
 
-			if err != nil {
-				errorMessage := fmt.Sprintf("Redis SET data failed.: %v", err)
-				slog.Error(errorMessage)
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1042, err))
-				return
+			userNameData := &UserName{
+				String: "",
+				Valid:  true,
 			}
 
 			if okSetSession && okSetJWT {
-				c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+				c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, withTokens(c, map[string]interface{}{
 					"mail":             oAuthResponse.Email,
 					"username":         *userNameData,
-					"totp_enabled":     userTwoFactorAuthData.TotpEnabled.Bool,
+					"totp_enabled":     false,
 					"totp_passed":      false,
-					"mail_otp_enabled": userTwoFactorAuthData.MailOTPEnabled,
+					"mail_otp_enabled": false,
 					"mail_otp__passed": false,
-					"sms_otp_enabled":  userTwoFactorAuthData.SmsOTPEnabled,
+					"sms_otp_enabled":  false,
 					"sms_otp_passed":   false,
-				}))
+				})))
 			} else {
 				return
 			}
 		}
-	} else {
-		err = mariadb.OAuthSignUp(oAuthResponse.Email, oAuthResponse.GivenName, oAuthResponse.FamilyName)
-		if err != nil {
-			errorMessage := fmt.Sprintf("Insert user_info table failed: %v", err)
-			slog.Error(errorMessage)
-
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
-			return
-		}
-
-		if err = mariadb.UserSetMailVerified(c, oAuthResponse.Email); err != nil {
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1023, err))
-			return
-		}
-
-		userInfo, err := mariadb.LookupUserID(oAuthResponse.Email)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1006, err))
-				return
-			}
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
-			return
-		}
-
-		err = mariadb.InsertPersonalInfo(userInfo.UserID)
-		if err != nil {
-			errorMessage := fmt.Sprintf("Insert personal_info table failed: %v", err)
-			slog.Error(errorMessage)
-
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
-			return
-		}
-
-		okSetSession := setSession(c, oAuthResponse.Email)
-		okSetJWT := setJWT(c, oAuthResponse.Email)
-
-		userNameData := &UserName{
-			String: "",
-			Valid:  true,
-		}
-
-		rdsValue := &rdsValeData{
-			Mail:           oAuthResponse.Email,
-			UserName:       *userNameData,
-			AccountPassed:  true,
-			MailOTPPassed:  false,
-			SmsOTPPassed:   false,
-			TotpPassed:     false,
-			TotpEnabled:    false,
-			MailOTPEnabled: false,
-			SmsOTPEnabled:  false,
-		}
-
-		jsonData, err := json.Marshal(rdsValue)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1063, err))
-			return
-		}
-
-		redisTTL, _, err := time_convert.ConvertTimeFormat("15m")
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1069, err))
-			return
-		}
-
-		err = redis.Set("login_status:" + oAuthResponse.Email, string(jsonData), redisTTL)
-
-		if err != nil {
-			errorMessage := fmt.Sprintf("Redis SET data failed.: %v", err)
-			slog.Error(errorMessage)
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1042, err))
-			return
-		}
-
-		if okSetSession && okSetJWT {
-			c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
-				"mail":             oAuthResponse.Email,
-				"username":         *userNameData,
-				"totp_enabled":     false,
-				"totp_passed":      false,
-				"mail_otp_enabled": false,
-				"mail_otp__passed": false,
-				"sms_otp_enabled":  false,
-				"sms_otp_passed":   false,
-			}))
-		} else {
-			return
-		}
 	}
 }
 
@@ -322,6 +208,7 @@ func OAuthGoogleVerification(c *gin.Context) {
 // @Tags oauth2
 // @Accept multipart/form-data
 // @Produce application/json
+// @Param token_mode query string false "Set to body to get the tokens in the callback response instead of cookies"
 // @Success 200 {string} string "Success"
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
@@ -337,7 +224,7 @@ func OAuthGoogleSignUp(c *gin.Context) {
 		Endpoint:     google.Endpoint,
 	}
 
-	url := googleOauthConfig.AuthCodeURL(oauthStateString)
+	url := googleOauthConfig.AuthCodeURL(oauthState(c))
 	c.Redirect(http.StatusTemporaryRedirect, url)
 }
 
@@ -346,6 +233,7 @@ func OAuthGoogleSignUp(c *gin.Context) {
 // @Tags oauth2
 // @Accept multipart/form-data
 // @Produce application/json
+// @Param token_mode query string false "Set to body to get the tokens in the callback response instead of cookies"
 // @Success 200 {string} string "Success"
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
@@ -361,84 +249,139 @@ func OAuthGoogleLogin(c *gin.Context) {
 		Endpoint:     google.Endpoint,
 	}
 
-	url := googleOauthConfig.AuthCodeURL(oauthStateString)
+	url := googleOauthConfig.AuthCodeURL(oauthState(c))
 	c.Redirect(http.StatusTemporaryRedirect, url)
 }
 
-func OAuthGoogleCallback(c *gin.Context) {
-
-	var oAuthResponse oAuthResponse
-
-	code := c.Query("code")
-	token, err := googleOauthConfig.Exchange(oauth2.NoContext, code)
-	if err != nil {
-		log.Println("Code exchange failed:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange code"})
-		return
-	}
-
-	client := googleOauthConfig.Client(oauth2.NoContext, token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v3/userinfo")
-	if err != nil {
-		log.Println("Failed to get user info:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info"})
-		return
+// oauthState carries the token mode through Google, the callback is a browser
+// redirect so it can't have the X-Token-Mode header.
+func oauthState(c *gin.Context) string {
+	if tokenModeBody(c) {
+		return oauthStateString + ":body"
 	}
+	return oauthStateString
+}
 
-	defer resp.Body.Close()
+func OAuthGoogleCallback(csbn *CasbinEnforcerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var oAuthResponse oAuthResponse
 
-	// Read the response body
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Println("Failed to read response body:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read response body"})
-		return
-	}
+		if c.Query("state") == oauthStateString+":body" {
+			c.Set("token_mode_body", true)
+		}
 
-	if err := json.Unmarshal(body, &oAuthResponse); err != nil {
-		log.Println("Failed to parse user info:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user info"})
-		return
-	}
+		code := c.Query("code")
+		token, err := googleOauthConfig.Exchange(oauth2.NoContext, code)
+		if err != nil {
+			log.Println("Code exchange failed:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange code"})
+			return
+		}
 
-	// Check whether the mail exists or not
-	count, err := mariadb.CheckMailExists(oAuthResponse.Email)
-	if err != nil {
-		errorMessage := fmt.Sprintf("Check whether the mail exists or not failed: %v", err)
-		slog.Error(errorMessage)
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1046, err))
-	}
+		client := googleOauthConfig.Client(oauth2.NoContext, token)
+		resp, err := client.Get("https://www.googleapis.com/oauth2/v3/userinfo")
+		if err != nil {
+			log.Println("Failed to get user info:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info"})
+			return
+		}
 
-	if count == 1 {
-		userTwoFactorAuthData, err := mariadb.GetTwoFactorAuthByMail(oAuthResponse.Email)
+		defer resp.Body.Close()
 
+		// Read the response body
+		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+			log.Println("Failed to read response body:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read response body"})
+			return
+		}
+
+		if err := json.Unmarshal(body, &oAuthResponse); err != nil {
+			log.Println("Failed to parse user info:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user info"})
 			return
 		}
 
-		// These conditions indicate that user have enabled the 2FA feature.
-		if userTwoFactorAuthData.TotpEnabled.Bool ||
-			userTwoFactorAuthData.SmsOTPEnabled ||
-			userTwoFactorAuthData.MailOTPEnabled {
+		// Check whether the mail exists or not
+		count, err := mariadb.CheckMailExists(oAuthResponse.Email)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Check whether the mail exists or not failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1046, err))
+		}
+
+		if count == 1 {
+			userTwoFactorAuthData, err := mariadb.GetTwoFactorAuthByMail(oAuthResponse.Email)
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+				return
+			}
 
-			c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
-				"mail":             oAuthResponse.Email,
-				"username":         userTwoFactorAuthData.UserName,
-				"totp_enabled":     userTwoFactorAuthData.TotpEnabled.Bool,
-				"mail_otp_enabled": userTwoFactorAuthData.MailOTPEnabled,
-				"sms_otp_enabled":  userTwoFactorAuthData.SmsOTPEnabled,
-			}))
+			// These conditions indicate that user have enabled the 2FA feature.
+			if factors := loginFactors(userTwoFactorAuthData); len(factors) > 0 {
 
+				startLoginChallenge(c, oAuthResponse.Email, userTwoFactorAuthData.UserName.String, factors)
+
+			} else {
+				okSetSession := setSession(c, csbn, oAuthResponse.Email)
+				okSetJWT := okSetSession && setJWT(c, oAuthResponse.Email)
+
+				if okSetSession && okSetJWT {
+					c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, withTokens(c, map[string]interface{}{
+						"mail":    oAuthResponse.Email,
+						"message": "Google login successful!",
+					})))
+				} else {
+					return
+					// c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, map[string]interface{}{
+					// 	"mail":        oAuthResponse.Email,
+					// 	"set_session": okSetSession,
+					// 	"set_jwt":     okSetJWT,
+					// }))
+				}
+			}
+			// if mail is not exist, program will automatic to create
 		} else {
-			okSetSession := setSession(c, oAuthResponse.Email)
-			okSetJWT := setJWT(c, oAuthResponse.Email)
+
+			err := mariadb.OAuthSignUp(oAuthResponse.Email, oAuthResponse.GivenName, oAuthResponse.FamilyName)
+			if err != nil {
+				errorMessage := fmt.Sprintf("Insert user_info table failed: %v", err)
+				slog.Error(errorMessage)
+
+				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+				return
+			}
+
+			// Look up user ID
+			userInfo, err := mariadb.LookupUserID(oAuthResponse.Email)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1006, err))
+					return
+				}
+				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+				return
+			}
+
+			// Insert personal_info table by user_id
+			err = mariadb.InsertPersonalInfo(userInfo.UserID)
+			if err != nil {
+				errorMessage := fmt.Sprintf("Insert personal_info table failed: %v", err)
+				slog.Error(errorMessage)
+
+				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+				return
+			}
+
+			okSetSession := setSession(c, csbn, oAuthResponse.Email)
+			okSetJWT := okSetSession && setJWT(c, oAuthResponse.Email)
 
 			if okSetSession && okSetJWT {
-				c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+				c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, withTokens(c, map[string]interface{}{
 					"mail":    oAuthResponse.Email,
 					"message": "Google login successful!",
-				}))
+				})))
 			} else {
 				return
 				// c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, map[string]interface{}{
@@ -448,55 +391,6 @@ func OAuthGoogleCallback(c *gin.Context) {
 				// }))
 			}
 		}
-		// if mail is not exist, program will automatic to create
-	} else {
-
-		err := mariadb.OAuthSignUp(oAuthResponse.Email, oAuthResponse.GivenName, oAuthResponse.FamilyName)
-		if err != nil {
-			errorMessage := fmt.Sprintf("Insert user_info table failed: %v", err)
-			slog.Error(errorMessage)
 
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
-			return
-		}
-
-		// Look up user ID
-		userInfo, err := mariadb.LookupUserID(oAuthResponse.Email)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1006, err))
-				return
-			}
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
-			return
-		}
-
-		// Insert personal_info table by user_id
-		err = mariadb.InsertPersonalInfo(userInfo.UserID)
-		if err != nil {
-			errorMessage := fmt.Sprintf("Insert personal_info table failed: %v", err)
-			slog.Error(errorMessage)
-
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
-			return
-		}
-
-		okSetSession := setSession(c, oAuthResponse.Email)
-		okSetJWT := setJWT(c, oAuthResponse.Email)
-
-		if okSetSession && okSetJWT {
-			c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
-				"mail":    oAuthResponse.Email,
-				"message": "Google login successful!",
-			}))
-		} else {
-			return
-			// c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, map[string]interface{}{
-			// 	"mail":        oAuthResponse.Email,
-			// 	"set_session": okSetSession,
-			// 	"set_jwt":     okSetJWT,
-			// }))
-		}
 	}
-
 }
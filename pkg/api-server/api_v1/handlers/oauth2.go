@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	mariadb "suglider-auth/internal/database"
+	"suglider-auth/internal/utils"
+	"suglider-auth/pkg/encrypt"
+	"suglider-auth/pkg/jwt"
+	"suglider-auth/pkg/oidc"
+	"suglider-auth/pkg/rbac"
+	"suglider-auth/pkg/refresh_token"
+	"suglider-auth/pkg/session"
+	"suglider-auth/pkg/token_denylist"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oauth2ClientAuth authenticates a machine client by HTTP basic auth or the
+// client_id and client_secret form parameters.
+func oauth2ClientAuth(c *gin.Context) (*mariadb.OAuth2ClientInfo, bool) {
+	clientID, clientSecret, hasBasic := c.Request.BasicAuth()
+	if !hasBasic {
+		clientID = c.PostForm("client_id")
+		clientSecret = c.PostForm("client_secret")
+	}
+
+	if clientID == "" || clientSecret == "" {
+		c.Header("WWW-Authenticate", `Basic realm="suglider-auth"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "The client authentication is required.")
+		return nil, false
+	}
+
+	client, err := mariadb.GetOAuth2Client(clientID)
+	if err == sql.ErrNoRows {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "The client authentication failed.")
+		return nil, false
+	} else if err != nil {
+		errorMessage := fmt.Sprintf("Get OAuth2 client failed: %v", err)
+		slog.Error(errorMessage)
+		oauthError(c, http.StatusInternalServerError, "server_error", "Get client failed.")
+		return nil, false
+	}
+
+	if !encrypt.VerifySaltedPasswordHash(client.ClientSecret, clientSecret) {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "The client authentication failed.")
+		return nil, false
+	}
+
+	return &client, true
+}
+
+// @Summary OAuth2 Token
+// @Description Issue an access token to a machine client by the client credentials grant, the client ID is the subject of the token.
+// @Tags oauth2
+// @Accept application/x-www-form-urlencoded
+// @Produce application/json
+// @Param grant_type formData string true "Must be client_credentials"
+// @Param scope formData string false "Requested scopes, default is all allowed scopes"
+// @Param client_id formData string false "Client ID, when HTTP basic auth is not used"
+// @Param client_secret formData string false "Client secret, when HTTP basic auth is not used"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /api/v1/oauth2/token [post]
+func OAuth2Token(c *gin.Context) {
+	client, ok := oauth2ClientAuth(c)
+	if !ok {
+		return
+	}
+
+	if c.PostForm("grant_type") != "client_credentials" {
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "Only the client_credentials grant is supported.")
+		return
+	}
+
+	scope := client.Scopes
+	if requested := strings.Fields(c.PostForm("scope")); len(requested) > 0 {
+		scope = oidc.FilterScope(strings.Join(requested, " "), client.Scopes)
+		if len(strings.Fields(scope)) != len(requested) {
+			oauthError(c, http.StatusBadRequest, "invalid_scope", "The requested scope is not allowed for this client.")
+			return
+		}
+	}
+
+	accessToken, expireTimeSec, err := jwt.GenerateClientJWT(client.ClientID, scope)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Generate the JWT string failed: %v", err)
+		slog.Error(errorMessage)
+		oauthError(c, http.StatusInternalServerError, "server_error", "Generate access token failed.")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   expireTimeSec,
+		"scope":        scope,
+	})
+}
+
+// @Summary Register OAuth2 Client
+// @Description Register a machine client, the client secret is only shown in this response. Grant permissions to the client ID with the RBAC API.
+// @Tags oauth2
+// @Accept application/json
+// @Produce application/json
+// @Param client_name body string true "Client Name"
+// @Param scope body string true "Allowed scopes separated by spaces, e.g. user:read totp:write"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/oauth2/client [post]
+func OAuth2RegisterClient(c *gin.Context) {
+	var request oauth2ClientRegister
+
+	// Check the parameter trasnfer from POST
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+		return
+	}
+
+	// The client can only do what the scopes allow, see rbac.ScopeAllows
+	scope := strings.Join(strings.Fields(request.Scope), " ")
+	if !rbac.ValidScope(scope) {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1104, map[string]interface{}{
+			"scope": request.Scope,
+		}))
+		return
+	}
+
+	clientID := encrypt.GenertateUUID(true)
+
+	clientSecret, err := encrypt.RandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1083, err))
+		return
+	}
+	hashedSecret, err := encrypt.SaltedPasswordHash(clientSecret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1083, err))
+		return
+	}
+
+	err = mariadb.InsertOAuth2Client(clientID, hashedSecret, request.ClientName, scope)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Insert OAuth2 client failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1083, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"client_id":     clientID,
+		"client_secret": clientSecret,
+		"client_name":   request.ClientName,
+		"scope":         scope,
+	}))
+}
+
+// @Summary List OAuth2 Clients
+// @Description Show all registered machine clients.
+// @Tags oauth2
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/oauth2/clients [get]
+func OAuth2ListClients(c *gin.Context) {
+	clients, err := mariadb.ListOAuth2Clients()
+	if err != nil {
+		errorMessage := fmt.Sprintf("List OAuth2 clients failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"clients": clients,
+	}))
+}
+
+// @Summary Delete OAuth2 Client
+// @Description Delete a machine client, the tokens issued to it are revoked.
+// @Tags oauth2
+// @Accept application/json
+// @Produce application/json
+// @Param client_id path string true "Client ID"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/oauth2/client/{client_id} [delete]
+func OAuth2DeleteClient(c *gin.Context) {
+	clientID := c.Param("client_id")
+
+	result, err := mariadb.DeleteOAuth2Client(clientID)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Delete OAuth2 client failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1082, nil))
+		return
+	}
+
+	// The client ID takes the place of the mail in the denylist
+	if err = token_denylist.RevokeUser(clientID); err != nil {
+		errorMessage := fmt.Sprintf("Revoke tokens of client failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1078, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"client_id": clientID,
+	}))
+}
+
+// oauth2ClientAuthWithScope authenticates the client, which must also be
+// allowed the scope of the endpoint, e.g. introspect or revoke.
+func oauth2ClientAuthWithScope(c *gin.Context, scope string) (*mariadb.OAuth2ClientInfo, bool) {
+	client, ok := oauth2ClientAuth(c)
+	if !ok {
+		return nil, false
+	}
+
+	if !oidc.HasScope(client.Scopes, scope) {
+		oauthError(c, http.StatusForbidden, "insufficient_scope", fmt.Sprintf("The client is not allowed the %s scope.", scope))
+		return nil, false
+	}
+
+	return client, true
+}
+
+// @Summary OAuth2 Token Introspection
+// @Description Show the state of an access token or a session ID (RFC 7662), the client must be allowed the introspect scope.
+// @Tags oauth2
+// @Accept application/x-www-form-urlencoded
+// @Produce application/json
+// @Param token formData string true "Access token or session ID"
+// @Param token_type_hint formData string false "access_token or session_id"
+// @Param client_id formData string false "Client ID, when HTTP basic auth is not used"
+// @Param client_secret formData string false "Client secret, when HTTP basic auth is not used"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Router /api/v1/oauth2/introspect [post]
+func OAuth2Introspect(csbn *CasbinEnforcerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := oauth2ClientAuthWithScope(c, "introspect"); !ok {
+			return
+		}
+
+		token := c.PostForm("token")
+		if token == "" {
+			oauthError(c, http.StatusBadRequest, "invalid_request", "The token parameter is required.")
+			return
+		}
+
+		var response gin.H
+		var err error
+		if c.PostForm("token_type_hint") == "session_id" {
+			response, err = introspectSession(token)
+		} else {
+			response, err = introspectAccessToken(token)
+			if err == nil && response == nil {
+				response, err = introspectSession(token)
+			}
+		}
+		if err != nil {
+			errorMessage := fmt.Sprintf("Token introspection failed: %v", err)
+			slog.Error(errorMessage)
+			oauthError(c, http.StatusInternalServerError, "server_error", "Token introspection failed.")
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+
+		// Nothing else is told about an unknown, expired or revoked token.
+		if response == nil {
+			c.JSON(http.StatusOK, gin.H{"active": false})
+			return
+		}
+
+		roles, err := csbn.GetRolesOfMember(response["sub"].(string))
+		if err != nil {
+			errorMessage := fmt.Sprintf("Get roles of member failed: %v", err)
+			slog.Error(errorMessage)
+			oauthError(c, http.StatusInternalServerError, "server_error", "Get roles failed.")
+			return
+		}
+		response["active"] = true
+		response["roles"] = roles
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// introspectAccessToken returns nil when the token is not an active JWT.
+func introspectAccessToken(token string) (gin.H, error) {
+	claims, _, err := jwt.ParseJWT(token)
+	if err != nil {
+		return nil, nil
+	}
+
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	isRevoked, err := token_denylist.IsTokenRevoked(claims.ID, claims.SessionID, claims.Principal(), issuedAt)
+	if err != nil {
+		return nil, err
+	}
+	if isRevoked {
+		return nil, nil
+	}
+
+	response := gin.H{
+		"sub":        claims.Principal(),
+		"exp":        claims.ExpiresAt.Unix(),
+		"iat":        issuedAt.Unix(),
+		"jti":        claims.ID,
+		"scope":      claims.Scope,
+		"token_type": "access_token",
+	}
+	if claims.ClientID != "" {
+		response["client_id"] = claims.ClientID
+	}
+
+	return response, nil
+}
+
+// introspectSession returns nil when the session does not exist.
+func introspectSession(sid string) (gin.H, error) {
+	data, ttl, errCode, err := session.GetSession(sid)
+	if errCode == 1043 {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	createdAt := session.IssuedAt(data)
+	isRevoked, err := token_denylist.IsUserRevoked(data.Mail, createdAt)
+	if err != nil {
+		return nil, err
+	}
+	if isRevoked {
+		return nil, nil
+	}
+
+	return gin.H{
+		"sub":        data.Mail,
+		"exp":        time.Now().Add(ttl).Unix(),
+		"iat":        createdAt.Unix(),
+		"scope":      "",
+		"token_type": "session_id",
+	}, nil
+}
+
+// @Summary OAuth2 Token Revocation
+// @Description Revoke an access token, a refresh token or a session ID (RFC 7009), the client must be allowed the revoke scope.
+// @Tags oauth2
+// @Accept application/x-www-form-urlencoded
+// @Produce application/json
+// @Param token formData string true "Access token, refresh token or session ID"
+// @Param token_type_hint formData string false "access_token, refresh_token or session_id"
+// @Param client_id formData string false "Client ID, when HTTP basic auth is not used"
+// @Param client_secret formData string false "Client secret, when HTTP basic auth is not used"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Router /api/v1/oauth2/revoke [post]
+func OAuth2Revoke(c *gin.Context) {
+	if _, ok := oauth2ClientAuthWithScope(c, "revoke"); !ok {
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "The token parameter is required.")
+		return
+	}
+
+	hint := c.PostForm("token_type_hint")
+
+	// An invalid or unknown token is not an error, the response is the same
+	// whether the token existed or not.
+	if hint == "" || hint == "access_token" {
+		if claims, _, err := jwt.ParseJWT(token); err == nil {
+			if err = token_denylist.RevokeToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+				errorMessage := fmt.Sprintf("Revoke access token failed: %v", err)
+				slog.Error(errorMessage)
+				oauthError(c, http.StatusServiceUnavailable, "server_error", "Revoke token failed.")
+				return
+			}
+			c.Status(http.StatusOK)
+			return
+		}
+	}
+
+	if hint == "" || hint == "refresh_token" {
+		errCode, err := refresh_token.Revoke(token)
+		if err == nil {
+			c.Status(http.StatusOK)
+			return
+		} else if errCode != 1074 {
+			errorMessage := fmt.Sprintf("Revoke refresh token failed: %v", err)
+			slog.Error(errorMessage)
+			oauthError(c, http.StatusServiceUnavailable, "server_error", "Revoke token failed.")
+			return
+		}
+	}
+
+	if hint == "" || hint == "session_id" {
+		// The session is signed out like the remote sign-out, so the access
+		// and refresh tokens issued for it stop working as well
+		_, _, errCode, err := session.GetSession(token)
+		if errCode == 1044 {
+			errorMessage := fmt.Sprintf("Get session failed: %v", err)
+			slog.Error(errorMessage)
+			oauthError(c, http.StatusServiceUnavailable, "server_error", "Revoke token failed.")
+			return
+		} else if errCode == 0 {
+			if err = revokeSession(token); err != nil {
+				errorMessage := fmt.Sprintf("Revoke session failed: %v", err)
+				slog.Error(errorMessage)
+				oauthError(c, http.StatusServiceUnavailable, "server_error", "Revoke token failed.")
+				return
+			}
+		}
+	}
+
+	c.Status(http.StatusOK)
+}
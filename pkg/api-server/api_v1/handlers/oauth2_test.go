@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/refresh_token"
+	"suglider-auth/pkg/session"
+	"suglider-auth/pkg/session_store"
+	"suglider-auth/pkg/token_denylist"
+)
+
+func TestIntrospectAndRevokeSession(t *testing.T) {
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+
+	store := session_store.NewMemoryStore()
+	session.SetStore(store)
+
+	createdAt := time.Now().Add(-time.Minute).Unix()
+	for _, sid := range []string{"alice-laptop", "alice-phone"} {
+		data := session_store.SessionData{Mail: "alice@example.com", CreatedAt: createdAt, LastSeen: createdAt}
+		if err := store.Create(sid, data, time.Hour); err != nil {
+			t.Fatalf("Unit Test (Create session) Fail: %v\n", err)
+		}
+	}
+
+	t.Run("Test introspect an active session", func(t *testing.T) {
+		response, err := introspectSession("alice-laptop")
+		if err != nil || response == nil {
+			t.Fatalf("Result: %v, %v (%s)\n", response, err, "The session must be active.")
+		}
+		if response["sub"] != "alice@example.com" || response["token_type"] != "session_id" || response["iat"] != createdAt {
+			t.Errorf("Result: %v (%s)\n", response, "The session is not described correctly.")
+		}
+
+		if response, err = introspectSession("missing"); err != nil || response != nil {
+			t.Errorf("Result: %v, %v (%s)\n", response, err, "A missing session must be inactive.")
+		}
+	})
+
+	t.Run("Test revoke a session signs out its tokens", func(t *testing.T) {
+		refreshToken, _, _, err := refresh_token.Issue("alice@example.com", "alice-laptop", time.Hour)
+		if err != nil {
+			t.Fatalf("Unit Test (Issue refresh token) Fail: %v\n", err)
+		}
+
+		if err = revokeSession("alice-laptop"); err != nil {
+			t.Fatalf("Unit Test (Revoke session) Fail: %v\n", err)
+		}
+
+		if response, err := introspectSession("alice-laptop"); err != nil || response != nil {
+			t.Errorf("Result: %v, %v (%s)\n", response, err, "The revoked session must be inactive.")
+		}
+		isRevoked, err := token_denylist.IsTokenRevoked("jti-1", "alice-laptop", "alice@example.com", time.Now())
+		if err != nil || !isRevoked {
+			t.Errorf("Result: %v, %v (%s)\n", isRevoked, err, "The access tokens of the session must be revoked.")
+		}
+		if _, _, _, _, errCode, err := refresh_token.Rotate(refreshToken, 0); err == nil || errCode != 1074 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "The refresh tokens of the session must be revoked.")
+		}
+
+		if response, err := introspectSession("alice-phone"); err != nil || response == nil {
+			t.Errorf("Result: %v, %v (%s)\n", response, err, "The other session must stay active.")
+		}
+	})
+
+	t.Run("Test introspect a session of a revoked user", func(t *testing.T) {
+		if err := token_denylist.RevokeUser("alice@example.com"); err != nil {
+			t.Fatalf("Unit Test (Revoke user) Fail: %v\n", err)
+		}
+
+		if response, err := introspectSession("alice-phone"); err != nil || response != nil {
+			t.Errorf("Result: %v, %v (%s)\n", response, err, "The session created before the user was revoked must be inactive.")
+		}
+	})
+}
@@ -0,0 +1,534 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	mariadb "suglider-auth/internal/database"
+	"suglider-auth/internal/utils"
+	"suglider-auth/pkg/encrypt"
+	"suglider-auth/pkg/jwt"
+	"suglider-auth/pkg/oidc"
+	"suglider-auth/pkg/session"
+	"suglider-auth/pkg/token_denylist"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The OAuth2 endpoints (authorize, token, userinfo) answer with the error
+// format of RFC 6749 instead of utils.ErrorResponse, relying parties only
+// understand the standard one.
+func oauthError(c *gin.Context, status int, code, description string) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, gin.H{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+func oauthRedirectError(c *gin.Context, redirectURI, state, code, description string) {
+	query := url.Values{}
+	query.Set("error", code)
+	query.Set("error_description", description)
+	if state != "" {
+		query.Set("state", state)
+	}
+	c.Redirect(http.StatusFound, appendQuery(redirectURI, query))
+}
+
+func appendQuery(uri string, query url.Values) string {
+	if strings.Contains(uri, "?") {
+		return uri + "&" + query.Encode()
+	}
+	return uri + "?" + query.Encode()
+}
+
+// oidcCurrentUser finds the user who has logged in by UserLogin, TOTP or mail
+// OTP, both the JWT cookie and the session are accepted.
+func oidcCurrentUser(c *gin.Context) (string, time.Time, bool) {
+	if cookie, err := c.Cookie("token"); err == nil {
+		claims, _, err := jwt.ParseJWT(cookie)
+		if err == nil && claims.Scope == "" {
+			var issuedAt time.Time
+			if claims.IssuedAt != nil {
+				issuedAt = claims.IssuedAt.Time
+			}
+			isRevoked, err := token_denylist.IsTokenRevoked(claims.ID, claims.SessionID, claims.Mail, issuedAt)
+			if err == nil && !isRevoked {
+				return claims.Mail, issuedAt, true
+			}
+		}
+	}
+
+	if isExists, _ := session.CheckSession(c); isExists {
+		_, data, _, err := session.ReadSession(c)
+		if err != nil {
+			return "", time.Time{}, false
+		}
+		createdAt := session.IssuedAt(data)
+		isRevoked, err := token_denylist.IsUserRevoked(data.Mail, createdAt)
+		if err == nil && !isRevoked {
+			return data.Mail, createdAt, true
+		}
+	}
+
+	return "", time.Time{}, false
+}
+
+// @Summary OIDC Authorize
+// @Description Authorization code flow with PKCE (S256), the user logs in by the normal login and 2FA flow when no session exists.
+// @Tags oidc
+// @Accept application/json
+// @Produce application/json
+// @Param response_type query string true "Must be code"
+// @Param client_id query string true "Client ID"
+// @Param redirect_uri query string true "Registered redirect URI"
+// @Param scope query string true "Must include openid"
+// @Param state query string false "State"
+// @Param nonce query string false "Nonce"
+// @Param code_challenge query string true "PKCE code challenge"
+// @Param code_challenge_method query string true "Must be S256"
+// @Param prompt query string false "none"
+// @Success 302 {string} string "Found"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /api/v1/oidc/authorize [get]
+func OidcAuthorize(c *gin.Context) {
+	clientID := c.Query("client_id")
+	redirectURI := c.Query("redirect_uri")
+	state := c.Query("state")
+
+	client, err := mariadb.GetOidcClient(clientID)
+	if err == sql.ErrNoRows {
+		oauthError(c, http.StatusBadRequest, "invalid_client", "The client is not registered.")
+		return
+	} else if err != nil {
+		errorMessage := fmt.Sprintf("Get OIDC client failed: %v", err)
+		slog.Error(errorMessage)
+		oauthError(c, http.StatusInternalServerError, "server_error", "Get client failed.")
+		return
+	}
+
+	// Never redirect to an unregistered URI, the error is shown to the user instead.
+	if !oidc.HasRedirectURI(client.RedirectURIs, redirectURI) {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "The redirect_uri is not registered.")
+		return
+	}
+
+	if c.Query("response_type") != "code" {
+		oauthRedirectError(c, redirectURI, state, "unsupported_response_type", "Only the code response type is supported.")
+		return
+	}
+
+	scope := oidc.FilterScope(c.Query("scope"), client.Scopes)
+	if !oidc.HasScope(scope, "openid") {
+		oauthRedirectError(c, redirectURI, state, "invalid_scope", "The openid scope is required.")
+		return
+	}
+
+	codeChallenge := c.Query("code_challenge")
+	if codeChallenge == "" || c.Query("code_challenge_method") != "S256" {
+		oauthRedirectError(c, redirectURI, state, "invalid_request", "PKCE with the S256 method is required.")
+		return
+	}
+
+	mail, authTime, ok := oidcCurrentUser(c)
+	if !ok {
+		if c.Query("prompt") == "none" {
+			oauthRedirectError(c, redirectURI, state, "login_required", "The user has not logged in.")
+			return
+		}
+		if oidc.LoginUrl == "" {
+			c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1019, map[string]interface{}{
+				"msg": "Both of JWT and sessionID can't found.",
+			}))
+			return
+		}
+
+		// The login page goes back to this request after UserLogin and 2FA have passed.
+		query := url.Values{}
+		query.Set("return_to", oidc.Issuer+c.Request.URL.RequestURI())
+		c.Redirect(http.StatusFound, appendQuery(oidc.LoginUrl, query))
+		return
+	}
+
+	code, errCode, err := oidc.IssueCode(&oidc.AuthorizationCode{
+		ClientID:      client.ClientID,
+		RedirectURI:   redirectURI,
+		Mail:          mail,
+		Scope:         scope,
+		Nonce:         c.Query("nonce"),
+		CodeChallenge: codeChallenge,
+		AuthTime:      authTime.UnixMilli(),
+	})
+	if err != nil {
+		errorMessage := fmt.Sprintf("Issue authorization code failed (%d): %v", errCode, err)
+		slog.Error(errorMessage)
+		oauthRedirectError(c, redirectURI, state, "server_error", "Issue authorization code failed.")
+		return
+	}
+
+	query := url.Values{}
+	query.Set("code", code)
+	if state != "" {
+		query.Set("state", state)
+	}
+	c.Redirect(http.StatusFound, appendQuery(redirectURI, query))
+}
+
+// oidcClientAuth authenticates the client by HTTP basic auth or form
+// parameters, public clients only send the client_id.
+func oidcClientAuth(c *gin.Context) (*mariadb.OidcClientInfo, bool) {
+	clientID, clientSecret, hasBasic := c.Request.BasicAuth()
+	if !hasBasic {
+		clientID = c.PostForm("client_id")
+		clientSecret = c.PostForm("client_secret")
+	}
+
+	client, err := mariadb.GetOidcClient(clientID)
+	if err == sql.ErrNoRows {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "The client is not registered.")
+		return nil, false
+	} else if err != nil {
+		errorMessage := fmt.Sprintf("Get OIDC client failed: %v", err)
+		slog.Error(errorMessage)
+		oauthError(c, http.StatusInternalServerError, "server_error", "Get client failed.")
+		return nil, false
+	}
+
+	if client.ClientSecret.Valid && !encrypt.VerifySaltedPasswordHash(client.ClientSecret.String, clientSecret) {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "The client authentication failed.")
+		return nil, false
+	}
+
+	return &client, true
+}
+
+// @Summary OIDC Token
+// @Description Exchange the authorization code for an access token and an ID token.
+// @Tags oidc
+// @Accept application/x-www-form-urlencoded
+// @Produce application/json
+// @Param grant_type formData string true "Must be authorization_code"
+// @Param code formData string true "Authorization code"
+// @Param redirect_uri formData string true "The redirect URI of the authorize request"
+// @Param code_verifier formData string true "PKCE code verifier"
+// @Param client_id formData string false "Client ID, when HTTP basic auth is not used"
+// @Param client_secret formData string false "Client secret, when HTTP basic auth is not used"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /api/v1/oidc/token [post]
+func OidcToken(c *gin.Context) {
+	client, ok := oidcClientAuth(c)
+	if !ok {
+		return
+	}
+
+	if c.PostForm("grant_type") != "authorization_code" {
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "Only the authorization_code grant is supported.")
+		return
+	}
+
+	data, errCode, err := oidc.ExchangeCode(c.PostForm("code"))
+	if errCode == 1079 {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	} else if err != nil {
+		errorMessage := fmt.Sprintf("Exchange authorization code failed (%d): %v", errCode, err)
+		slog.Error(errorMessage)
+		oauthError(c, http.StatusInternalServerError, "server_error", "Exchange authorization code failed.")
+		return
+	}
+
+	if data.ClientID != client.ClientID || data.RedirectURI != c.PostForm("redirect_uri") {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "The code was not issued to this client or redirect_uri.")
+		return
+	}
+	if !oidc.VerifyPKCE(c.PostForm("code_verifier"), data.CodeChallenge) {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "The code_verifier does not match the code_challenge.")
+		return
+	}
+
+	// The user may have been revoked or deleted after the code was issued.
+	isRevoked, err := token_denylist.IsUserRevoked(data.Mail, time.UnixMilli(data.AuthTime))
+	if err != nil {
+		errorMessage := fmt.Sprintf("Check token denylist failed: %v", err)
+		slog.Error(errorMessage)
+		oauthError(c, http.StatusInternalServerError, "server_error", "Check token denylist failed.")
+		return
+	}
+	if isRevoked {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "The login of the user has been revoked.")
+		return
+	}
+
+	userInfo, err := mariadb.GetOidcUserInfo(data.Mail)
+	if err == sql.ErrNoRows {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "The user does not exist.")
+		return
+	} else if err != nil {
+		errorMessage := fmt.Sprintf("Get OIDC user info failed: %v", err)
+		slog.Error(errorMessage)
+		oauthError(c, http.StatusInternalServerError, "server_error", "Get user info failed.")
+		return
+	}
+
+	accessToken, expireTimeSec, err := jwt.GenerateScopedJWT(data.Mail, data.Scope)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Generate the JWT string failed: %v", err)
+		slog.Error(errorMessage)
+		oauthError(c, http.StatusInternalServerError, "server_error", "Generate access token failed.")
+		return
+	}
+
+	idTokenSettings := &jwt.IDTokenSettings{
+		Issuer:   oidc.Issuer,
+		Subject:  userInfo.UserID,
+		Audience: client.ClientID,
+		Nonce:    data.Nonce,
+		AuthTime: time.UnixMilli(data.AuthTime),
+		TTL:      oidc.IDTokenTTL,
+	}
+	if oidc.HasScope(data.Scope, "email") {
+		idTokenSettings.Mail = userInfo.Mail
+		idTokenSettings.MailVerified = userInfo.MailVerified
+	}
+	idToken, err := jwt.GenerateIDToken(idTokenSettings)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Generate the ID token failed: %v", err)
+		slog.Error(errorMessage)
+		oauthError(c, http.StatusInternalServerError, "server_error", "Generate ID token failed.")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   expireTimeSec,
+		"scope":        data.Scope,
+		"id_token":     idToken,
+	})
+}
+
+// @Summary OIDC UserInfo
+// @Description Show the claims of the user, the access token from the token endpoint is sent by the Authorization header.
+// @Tags oidc
+// @Accept application/json
+// @Produce application/json
+// @Param Authorization header string true "Bearer access token"
+// @Success 200 {string} string "Success"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /api/v1/oidc/userinfo [get]
+func OidcUserInfo(c *gin.Context) {
+	accessToken := utils.BearerToken(c)
+	if accessToken == "" {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_token", "The bearer access token is required.")
+		return
+	}
+
+	claims, _, err := jwt.ParseJWT(accessToken)
+	if err != nil || !oidc.HasScope(claims.Scope, "openid") {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_token", "The access token is invalid.")
+		return
+	}
+
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	isRevoked, err := token_denylist.IsTokenRevoked(claims.ID, claims.SessionID, claims.Mail, issuedAt)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Check token denylist failed: %v", err)
+		slog.Error(errorMessage)
+		oauthError(c, http.StatusInternalServerError, "server_error", "Check token denylist failed.")
+		return
+	}
+	if isRevoked {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_token", "The access token has been revoked.")
+		return
+	}
+
+	userInfo, err := mariadb.GetOidcUserInfo(claims.Mail)
+	if err == sql.ErrNoRows {
+		oauthError(c, http.StatusUnauthorized, "invalid_token", "The user does not exist.")
+		return
+	} else if err != nil {
+		errorMessage := fmt.Sprintf("Get OIDC user info failed: %v", err)
+		slog.Error(errorMessage)
+		oauthError(c, http.StatusInternalServerError, "server_error", "Get user info failed.")
+		return
+	}
+
+	response := gin.H{
+		"sub": userInfo.UserID,
+	}
+	if oidc.HasScope(claims.Scope, "profile") {
+		response["preferred_username"] = userInfo.UserName.String
+		response["given_name"] = userInfo.FirstName.String
+		response["family_name"] = userInfo.LastName.String
+		response["name"] = strings.TrimSpace(userInfo.FirstName.String + " " + userInfo.LastName.String)
+	}
+	if oidc.HasScope(claims.Scope, "email") {
+		response["email"] = userInfo.Mail
+		response["email_verified"] = userInfo.MailVerified
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary OIDC JSON Web Key Set
+// @Description show the public keys which verify the ID token and access token
+// @Tags oidc
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Router /api/v1/oidc/jwks [get]
+func OidcJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwt.GetKeyRing().JWKS())
+}
+
+// @Summary Register OIDC Client
+// @Description Register a relying party, the client secret is only shown in this response.
+// @Tags oidc
+// @Accept application/json
+// @Produce application/json
+// @Param client_name body string true "Client Name"
+// @Param redirect_uris body []string true "Redirect URIs"
+// @Param scope body string false "Allowed scopes, default is openid profile email"
+// @Param public body bool false "Public client without secret"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/oidc/client [post]
+func OidcRegisterClient(c *gin.Context) {
+	var request oidcClientRegister
+
+	// Check the parameter trasnfer from POST
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+		return
+	}
+
+	for _, uri := range request.RedirectURIs {
+		parsed, err := url.Parse(uri)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || parsed.Fragment != "" || strings.Contains(uri, " ") {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1104, map[string]interface{}{
+				"redirect_uri": uri,
+			}))
+			return
+		}
+	}
+
+	scope := oidc.FilterScope(request.Scope, strings.Join(oidc.SupportedScopes, " "))
+	if scope == "" {
+		scope = strings.Join(oidc.SupportedScopes, " ")
+	}
+
+	clientID := encrypt.GenertateUUID(true)
+
+	var clientSecret string
+	var hashedSecret *string
+	if !request.Public {
+		clientSecret, err = encrypt.RandomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1081, err))
+			return
+		}
+		hashed, err := encrypt.SaltedPasswordHash(clientSecret)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1081, err))
+			return
+		}
+		hashedSecret = &hashed
+	}
+
+	err = mariadb.InsertOidcClient(clientID, request.ClientName, strings.Join(request.RedirectURIs, " "), scope, hashedSecret)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Insert OIDC client failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1081, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"client_id":     clientID,
+		"client_secret": clientSecret,
+		"client_name":   request.ClientName,
+		"redirect_uris": request.RedirectURIs,
+		"scope":         scope,
+	}))
+}
+
+// @Summary List OIDC Clients
+// @Description Show all registered relying parties.
+// @Tags oidc
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/oidc/clients [get]
+func OidcListClients(c *gin.Context) {
+	clients, err := mariadb.ListOidcClients()
+	if err != nil {
+		errorMessage := fmt.Sprintf("List OIDC clients failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"clients": clients,
+	}))
+}
+
+// @Summary Delete OIDC Client
+// @Description Delete a registered relying party.
+// @Tags oidc
+// @Accept application/json
+// @Produce application/json
+// @Param client_id path string true "Client ID"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/oidc/client/{client_id} [delete]
+func OidcDeleteClient(c *gin.Context) {
+	clientID := c.Param("client_id")
+
+	result, err := mariadb.DeleteOidcClient(clientID)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Delete OIDC client failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1080, nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"client_id": clientID,
+	}))
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+
+	mariadb "suglider-auth/internal/database"
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/jwt"
+	"suglider-auth/pkg/oidc"
+	"suglider-auth/pkg/session"
+)
+
+func TestOidcAuthorizationCode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+	if err := jwt.InitKeyRing(&jwt.KeyRingSettings{Algorithm: "ES256"}); err != nil {
+		t.Fatalf("Unit Test (Init key ring) Fail: %v\n", err)
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unit Test (Mock database) Fail: %v\n", err)
+	}
+	defaultDataBase := mariadb.DataBase
+	mariadb.DataBase = sqlx.NewDb(db, "mysql")
+	defer func() {
+		mariadb.DataBase = defaultDataBase
+		db.Close()
+	}()
+
+	// A public client, it authenticates by PKCE only
+	expectClient := func() {
+		mock.ExpectQuery(`SELECT client_id, client_secret, client_name, redirect_uris, scopes, created_at FROM suglider\.oidc_client WHERE client_id=\?`).
+			WithArgs("app").
+			WillReturnRows(sqlmock.NewRows([]string{"client_id", "client_secret", "client_name", "redirect_uris", "scopes", "created_at"}).
+				AddRow("app", nil, "App", "https://app.example.com/callback", "openid email profile", "2024-01-01 00:00:00"))
+	}
+
+	router := gin.New()
+	router.Use(sessions.Sessions("session-key", cookie.NewStore(session.KeyPairs()...)))
+	router.GET("/oidc/authorize", OidcAuthorize)
+	router.POST("/oidc/token", OidcToken)
+
+	accessToken, _, err := jwt.GenerateSessionJWT("alice@example.com", "alice-laptop")
+	if err != nil {
+		t.Fatalf("Unit Test (Generate JWT) Fail: %v\n", err)
+	}
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	authorize := func(query url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/oidc/authorize?"+query.Encode(), nil)
+		req.AddCookie(&http.Cookie{Name: "token", Value: accessToken})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	authorizeQuery := func(redirectURI, method string) url.Values {
+		return url.Values{
+			"response_type":         {"code"},
+			"client_id":             {"app"},
+			"redirect_uri":          {redirectURI},
+			"scope":                 {"openid email"},
+			"state":                 {"state-1"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {method},
+		}
+	}
+	token := func(code, redirectURI, codeVerifier string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {"app"},
+			"code":          {code},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {codeVerifier},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/oidc/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		response := map[string]interface{}{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+	issueCode := func() string {
+		code, _, err := oidc.IssueCode(&oidc.AuthorizationCode{
+			ClientID:      "app",
+			RedirectURI:   "https://app.example.com/callback",
+			Mail:          "alice@example.com",
+			Scope:         "openid",
+			CodeChallenge: challenge,
+		})
+		if err != nil {
+			t.Fatalf("Unit Test (Issue code) Fail: %v\n", err)
+		}
+		return code
+	}
+
+	t.Run("Test the unregistered redirect URI is not redirected to", func(t *testing.T) {
+		for _, redirectURI := range []string{
+			"https://evil.example.com/callback",
+			"https://app.example.com/callback/evil",
+			"https://app.example.com/callback?next=https://evil.example.com",
+		} {
+			expectClient()
+			w := authorize(authorizeQuery(redirectURI, "S256"))
+			if w.Code != http.StatusBadRequest || w.Header().Get("Location") != "" || !strings.Contains(w.Body.String(), "invalid_request") {
+				t.Errorf("Result: %s -> %d %s (%s)\n", redirectURI, w.Code, w.Body.String(), "The unregistered redirect URI must be rejected.")
+			}
+		}
+	})
+
+	t.Run("Test PKCE must use the S256 method", func(t *testing.T) {
+		for _, method := range []string{"plain", ""} {
+			expectClient()
+			w := authorize(authorizeQuery("https://app.example.com/callback", method))
+
+			location, err := url.Parse(w.Header().Get("Location"))
+			if w.Code != http.StatusFound || err != nil {
+				t.Fatalf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The error must be redirected to the client.")
+			}
+			query := location.Query()
+			if query.Get("error") != "invalid_request" || query.Get("state") != "state-1" || query.Get("code") != "" {
+				t.Errorf("Result: %s -> %s (%s)\n", method, location, "The method other than S256 must be rejected.")
+			}
+		}
+	})
+
+	t.Run("Test the code is issued to the registered redirect URI", func(t *testing.T) {
+		expectClient()
+		w := authorize(authorizeQuery("https://app.example.com/callback", "S256"))
+
+		location, err := url.Parse(w.Header().Get("Location"))
+		if w.Code != http.StatusFound || err != nil {
+			t.Fatalf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The code must be redirected to the client.")
+		}
+		if location.Host != "app.example.com" || location.Query().Get("code") == "" || location.Query().Get("state") != "state-1" {
+			t.Errorf("Result: %s (%s)\n", location, "The code and state must be sent to the redirect URI.")
+		}
+	})
+
+	t.Run("Test the redirect URI of the token request must match", func(t *testing.T) {
+		expectClient()
+		w, response := token(issueCode(), "https://app.example.com/other", verifier)
+		if w.Code != http.StatusBadRequest || response["error"] != "invalid_grant" {
+			t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The redirect URI of the authorize request must be sent.")
+		}
+	})
+
+	t.Run("Test the code verifier must match", func(t *testing.T) {
+		expectClient()
+		w, response := token(issueCode(), "https://app.example.com/callback", "wrong-verifier")
+		if w.Code != http.StatusBadRequest || response["error"] != "invalid_grant" {
+			t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The wrong code verifier must be rejected.")
+		}
+	})
+
+	t.Run("Test the code can only be used once", func(t *testing.T) {
+		code := issueCode()
+
+		expectClient()
+		mock.ExpectQuery(`SELECT LOWER\(HEX\(user_id\)\) AS user_id, mail, mail_verified, username, first_name, last_name FROM suglider\.user_info WHERE mail=\?`).
+			WithArgs("alice@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "mail", "mail_verified", "username", "first_name", "last_name"}).
+				AddRow("0123456789abcdef", "alice@example.com", true, "alice", nil, nil))
+		w, response := token(code, "https://app.example.com/callback", verifier)
+		if w.Code != http.StatusOK || response["access_token"] == nil || response["id_token"] == nil {
+			t.Fatalf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The code must be exchanged for the tokens.")
+		}
+
+		expectClient()
+		w, response = token(code, "https://app.example.com/callback", verifier)
+		if w.Code != http.StatusBadRequest || response["error"] != "invalid_grant" {
+			t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The used code must be rejected.")
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unit Test (Database queries) Fail: %v\n", err)
+	}
+}
@@ -1,28 +1,110 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"regexp"
+	"sync"
+	"time"
+
+	"suglider-auth/configs"
 	mariadb "suglider-auth/internal/database"
 	smtp "suglider-auth/internal/mail"
 	"suglider-auth/internal/redis"
 	"suglider-auth/internal/utils"
 	"suglider-auth/pkg/encrypt"
+	"suglider-auth/pkg/login_challenge"
+	"suglider-auth/pkg/sms"
 	"suglider-auth/pkg/time_convert"
 
 	"github.com/gin-gonic/gin"
 )
 
+var (
+	smsOnce    sync.Once
+	smsSender  *sms.SmsClient
+	smsOTPTTL  = 5 * time.Minute
+	smsCountry string
+	smsErr     error
+)
+
+// smsClient returns the client of the provider configured in [sms], it's nil
+// when SMS is not configured.
+func smsClient() (*sms.SmsClient, error) {
+	smsOnce.Do(func() {
+		smsConfig := configs.ApplicationConfig.Sms
+		if smsConfig == nil || smsConfig.Provider == "" {
+			return
+		}
+
+		if smsConfig.OTPTTL != "" {
+			smsOTPTTL, _, smsErr = time_convert.ConvertTimeFormat(smsConfig.OTPTTL)
+			if smsErr != nil {
+				return
+			}
+		}
+		smsCountry = smsConfig.CountryCode
+
+		switch smsConfig.Provider {
+		case "hinet", "cht":
+			smsSender = &sms.SmsClient{
+				Sender: &sms.HinetSmsClient{
+					Username: smsConfig.Username,
+					Password: smsConfig.Password,
+					Timeout:  smsConfig.Timeout,
+				},
+			}
+		case "twilio":
+			client, err := sms.NewTwilioClient(smsConfig.AccountSid, smsConfig.AuthToken, smsConfig.PhoneNumber, smsConfig.CountryCode)
+			if err != nil {
+				smsErr = err
+				return
+			}
+			client.ApiKey = smsConfig.ApiKey
+			client.ApiSecret = smsConfig.ApiSecret
+			client.Timeout = smsConfig.Timeout
+			smsSender = &sms.SmsClient{Sender: client}
+		default:
+			smsErr = fmt.Errorf("unsupported SMS provider: %s", smsConfig.Provider)
+		}
+	})
+
+	return smsSender, smsErr
+}
+
+// @Summary Mail OTP Setup
+// @Description Send a code to the mail of the login user, Mail OTP is enabled with the code.
+// @Tags otp
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/otp/mail/setup [post]
+func MailOTPSetup(c *gin.Context) {
+	mail, ok := loginUser(c, "Mail OTP can only be managed by the login of the user.")
+	if !ok {
+		return
+	}
+
+	if !sendMailCode(c, mail, "mail_otp_setup:") {
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, nil))
+}
+
 // @Summary Mail OTP Enable
-// @Description Enable Mail OTP feature
+// @Description Enable Mail OTP feature of the login user with the code sent by the setup API.
 // @Tags otp
-// @Accept multipart/form-data
+// @Accept application/json
 // @Produce application/json
-// @Param mail formData string false "Mail"
+// @Param otp_code body string true "The code sent by /api/v1/otp/mail/setup"
 // @Success 200 {string} string "Success"
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
@@ -30,7 +112,12 @@ import (
 // @Failure 404 {string} string "Not found"
 // @Router /api/v1/otp/mail/enable [put]
 func MailOTPEnable(c *gin.Context) {
-	var request mailOperate
+	var request otpEnable
+
+	mail, ok := loginUser(c, "Mail OTP can only be managed by the login of the user.")
+	if !ok {
+		return
+	}
 
 	// Check the parameter trasnfer from POST
 	err := c.ShouldBindJSON(&request)
@@ -39,44 +126,55 @@ func MailOTPEnable(c *gin.Context) {
 		return
 	}
 
+	// The setup code can be tried only once, a wrong code needs a new one
+	redisKey := encrypt.HashWithSHA(mail, "sha1")
+	value, errCode, err := redis.GetDel("mail_otp_setup:" + redisKey)
+
+	switch errCode {
+	case 1043:
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1047, nil))
+		return
+
+	case 1044:
+		errorMessage := fmt.Sprintf("Redis GET data failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(value), []byte(request.OTPCode)) != 1 {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1047, nil))
+		return
+	}
+
 	// Enable Mail OTP
-	rowsAffected, errCode, errMailOTPUpdateEnabled := mariadb.MailOTPUpdateEnabled(request.Mail, true)
+	rowsAffected, errCode, errMailOTPUpdateEnabled := mariadb.MailOTPUpdateEnabled(mail, true)
 	if errMailOTPUpdateEnabled != nil {
-		switch errCode {
-		case 1002:
-			errorMessage := fmt.Sprintf("Failed to execute SQL syntax: %v", err)
-			slog.Error(errorMessage)
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
-			return
-
-		case 1049:
-			errorMessage := fmt.Sprintf("Get rowsAffected failed: %v", err)
-			slog.Error(errorMessage)
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
-			return
-		}
+		errorMessage := fmt.Sprintf("Update mail_otp_enabled failed: %v", errMailOTPUpdateEnabled)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, errMailOTPUpdateEnabled))
+		return
 	}
 
 	// No rows were affected
 	if rowsAffected == 0 {
 		c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
-			"mail": request.Mail,
+			"mail": mail,
 			"msg":  "No rows were affected.",
 		}))
 	} else {
 		c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
-			"mail":             request.Mail,
+			"mail":             mail,
 			"mail_otp_enabled": true,
 		}))
 	}
 }
 
 // @Summary Mail OTP Disable
-// @Description Disable Mail OTP feature
+// @Description Disable Mail OTP feature of the login user
 // @Tags otp
-// @Accept multipart/form-data
+// @Accept application/json
 // @Produce application/json
-// @Param mail formData string false "Mail"
 // @Success 200 {string} string "Success"
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
@@ -84,79 +182,47 @@ func MailOTPEnable(c *gin.Context) {
 // @Failure 404 {string} string "Not found"
 // @Router /api/v1/otp/mail/disable [put]
 func MailOTPDisable(c *gin.Context) {
-	var request mailOperate
-
-	// Check the parameter trasnfer from POST
-	err := c.ShouldBindJSON(&request)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+	mail, ok := loginUser(c, "Mail OTP can only be managed by the login of the user.")
+	if !ok {
 		return
 	}
 
 	// Disable Mail OTP
-	rowsAffected, errCode, errMailOTPUpdateEnabled := mariadb.MailOTPUpdateEnabled(request.Mail, false)
+	rowsAffected, errCode, errMailOTPUpdateEnabled := mariadb.MailOTPUpdateEnabled(mail, false)
 	if errMailOTPUpdateEnabled != nil {
-		switch errCode {
-		case 1002:
-			errorMessage := fmt.Sprintf("Failed to execute SQL syntax: %v", err)
-			slog.Error(errorMessage)
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
-			return
-
-		case 1049:
-			errorMessage := fmt.Sprintf("Get rowsAffected failed: %v", err)
-			slog.Error(errorMessage)
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
-			return
-		}
+		errorMessage := fmt.Sprintf("Update mail_otp_enabled failed: %v", errMailOTPUpdateEnabled)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, errMailOTPUpdateEnabled))
+		return
 	}
 
 	// No rows were affected
 	if rowsAffected == 0 {
 		c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
-			"mail": request.Mail,
+			"mail": mail,
 			"msg":  "No rows were affected.",
 		}))
 	} else {
 		c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
-			"mail":             request.Mail,
+			"mail":             mail,
 			"mail_otp_enabled": false,
 		}))
 	}
-
 }
 
-// @Summary Mail OTP Send
-// @Description To send an OTP using email.
-// @Tags otp
-// @Accept multipart/form-data
-// @Produce application/json
-// @Param mail formData string false "Mail"
-// @Success 200 {string} string "Success"
-// @Failure 400 {string} string "Bad request"
-// @Failure 401 {string} string "Unauthorized"
-// @Failure 403 {string} string "Forbidden"
-// @Failure 404 {string} string "Not found"
-// @Router /api/v1/otp/mail/send [post]
-func MailOTPSend(c *gin.Context) {
-	var request mailOperate
+// sendMailCode mails a new code to the user and stores it under keyPrefix
+// with the SHA1 of the mail. A failure to send the mail is only logged.
+func sendMailCode(c *gin.Context, mail, keyPrefix string) bool {
 	var user string
 
-	// Check the parameter trasnfer from POST
-	err := c.ShouldBindJSON(&request)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
-		return
-	}
-
-	userInfo, err := mariadb.GetUserInfo(request.Mail)
+	userInfo, err := mariadb.GetUserInfo(mail)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1048, err))
-			return
+			return false
 		}
 		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
-		return
+		return false
 	}
 
 	// Mail user name decision logic
@@ -171,7 +237,7 @@ func MailOTPSend(c *gin.Context) {
 			user = match[1]
 		} else {
 			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1054, err))
-			return
+			return false
 		}
 	}
 
@@ -186,15 +252,15 @@ func MailOTPSend(c *gin.Context) {
 		}
 	}
 
-	redisKey := encrypt.HashWithSHA(request.Mail, "sha1")
+	redisKey := encrypt.HashWithSHA(mail, "sha1")
 
-	err = redis.Set("mail_otp:"+redisKey, code, redisTTL)
+	err = redis.Set(keyPrefix+redisKey, code, redisTTL)
 
 	if err != nil {
 		errorMessage := fmt.Sprintf("Redis SET data failed.: %v", err)
 		slog.Error(errorMessage)
 		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1042, err))
-		return
+		return false
 	}
 
 	errSendMailOTP := smtp.SendMailOTP(c, user, userInfo.Mail, code)
@@ -202,6 +268,42 @@ func MailOTPSend(c *gin.Context) {
 		slog.Error(errSendMailOTP.Error())
 	}
 
+	return true
+}
+
+// @Summary Mail OTP Send
+// @Description To send an OTP to the mail of the user during login.
+// @Tags otp
+// @Accept multipart/form-data
+// @Produce application/json
+// @Param challenge_id formData string true "Challenge ID from the login response"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/otp/mail/send [post]
+func MailOTPSend(c *gin.Context) {
+	var request loginChallenge
+
+	// Check the parameter trasnfer from POST
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+		return
+	}
+
+	// The OTP is only sent to the user of the login challenge, the login user
+	// gets the code to enable Mail OTP by /api/v1/otp/mail/setup
+	ch, ok := readChallenge(c, request.ChallengeID, login_challenge.FactorMailOTP)
+	if !ok {
+		return
+	}
+
+	if !sendMailCode(c, ch.Mail, "mail_otp:") {
+		return
+	}
+
 	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, nil))
 }
 
@@ -210,8 +312,9 @@ func MailOTPSend(c *gin.Context) {
 // @Tags otp
 // @Accept multipart/form-data
 // @Produce application/json
-// @Param mail formData string false "Mail"
+// @Param challenge_id formData string false "Challenge ID from the login response"
 // @Param otp_code formData string false "OTP Code"
+// @Param X-Token-Mode header string false "Set to body to get the tokens in the response instead of cookies"
 // @Success 200 {string} string "Success"
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
@@ -219,89 +322,248 @@ func MailOTPSend(c *gin.Context) {
 // @Failure 404 {string} string "Not found"
 // @Router /api/v1/otp/mail/verify [get]
 func MailOTPVerify(c *gin.Context) {
-	var data rdsValeData
+	loginVerified(c, "mail_otp_verify")
+}
+
+// sendSmsCode sends a new OTP code to the phone number of the user, the code
+// is kept in keyPrefix:<sha1 of mail> until it's used or expired.
+func sendSmsCode(c *gin.Context, mail, keyPrefix string) bool {
+	client, err := smsClient()
+	if err != nil {
+		errorMessage := fmt.Sprintf("SMS client initialization failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1097, err))
+		return false
+	}
+	if client == nil {
+		c.JSON(http.StatusServiceUnavailable, utils.ErrorResponse(c, 1097, nil))
+		return false
+	}
+
+	userInfo, err := mariadb.GetUserInfo(mail)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1048, err))
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return false
+	}
+
+	if !userInfo.PhoneNumber.Valid || userInfo.PhoneNumber.String == "" {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1096, nil))
+		return false
+	}
+
+	code := encrypt.RandomNumber(6)
 
-	mail, isMailExists := c.Get("mail")
-	userName, isUserNameExists := c.Get("userName")
-	Result, isMailOTPVerifyExists := c.Get("mail_otp_verify")
+	redisKey := encrypt.HashWithSHA(mail, "sha1")
+
+	err = redis.Set(keyPrefix+redisKey, code, smsOTPTTL)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Redis SET data failed.: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1042, err))
+		return false
+	}
+
+	message := fmt.Sprintf("Your Suglider verification code is %s, it will expire in %s.", code, smsOTPTTL)
+	_, err = client.SendText(userInfo.PhoneNumber.String, smsCountry, message)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Send SMS OTP failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusBadGateway, utils.ErrorResponse(c, 1098, err))
+		return false
+	}
+
+	return true
+}
 
-	// Convert interface to string
-	strMail := fmt.Sprintf("%v", mail)
+// @Summary SMS OTP Setup
+// @Description Send a code to the phone number of the user, SMS OTP is enabled with the code so the user has proved to own the phone.
+// @Tags otp
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/otp/sms/setup [post]
+func SmsOTPSetup(c *gin.Context) {
+	mail, ok := loginUser(c, "SMS OTP can only be managed by the login of the user.")
+	if !ok {
+		return
+	}
 
-	if !isMailExists || !isMailOTPVerifyExists || !isUserNameExists {
-		slog.Error("mail, mail_otp_verify or username are not exists.")
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1052, nil))
+	if !sendSmsCode(c, mail, "sms_otp_setup:") {
 		return
 	}
 
-	verifyResult, ok := Result.(bool)
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, nil))
+}
+
+// @Summary SMS OTP Enable
+// @Description Enable SMS OTP feature with the code sent by the setup API.
+// @Tags otp
+// @Accept application/json
+// @Produce application/json
+// @Param otp_code body string true "The code sent by /api/v1/otp/sms/setup"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/otp/sms/enable [put]
+func SmsOTPEnable(c *gin.Context) {
+	var request otpEnable
+
+	mail, ok := loginUser(c, "SMS OTP can only be managed by the login of the user.")
 	if !ok {
-		slog.Error("OTP verify result value is not bool.")
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1051, nil))
 		return
 	}
 
-	if verifyResult {
-		// Check whether login status exists in redis or not.
-		value, errCode, err := redis.Get("login_status:" + strMail)
+	// Check the parameter trasnfer from POST
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+		return
+	}
 
-		switch errCode {
-		// Key not exists
-		case 1043:
-			errorMessage := fmt.Sprintf("Redis key does not exist: %v", err)
-			slog.Error(errorMessage)
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
-			return
-		case 1044:
-			errorMessage := fmt.Sprintf("Redis GET data failed: %v", err)
-			slog.Error(errorMessage)
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
-			return
-		// Key exists
-		case 0:
-			err := json.Unmarshal([]byte(value), &data)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1063, err))
-				return
-			}
+	// The setup code can be tried only once, a wrong code needs a new one
+	redisKey := encrypt.HashWithSHA(mail, "sha1")
+	value, errCode, err := redis.GetDel("sms_otp_setup:" + redisKey)
 
-			// Change status
-			data.MailOTPPassed = true
+	switch errCode {
+	case 1043:
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1047, nil))
+		return
 
-			jsonData, err := json.Marshal(data)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1063, err))
-				return
-			}
+	case 1044:
+		errorMessage := fmt.Sprintf("Redis GET data failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return
+	}
 
-			redisTTL, _, err := time_convert.ConvertTimeFormat("15m")
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1069, err))
-				return
-			}
+	if subtle.ConstantTimeCompare([]byte(value), []byte(request.OTPCode)) != 1 {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1047, nil))
+		return
+	}
 
-			// Set login_status into redis
-			err = redis.Set("login_status:"+strMail, string(jsonData), redisTTL)
+	// Enable SMS OTP
+	rowsAffected, errCode, errSmsOTPUpdateEnabled := mariadb.SmsOTPUpdateEnabled(mail, true)
+	if errSmsOTPUpdateEnabled != nil {
+		errorMessage := fmt.Sprintf("Update sms_otp_enabled failed: %v", errSmsOTPUpdateEnabled)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, errSmsOTPUpdateEnabled))
+		return
+	}
 
-			if err != nil {
-				errorMessage := fmt.Sprintf("Redis SET data failed.: %v", err)
-				slog.Error(errorMessage)
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1042, err))
-				return
-			}
-		}
+	// No rows were affected
+	if rowsAffected == 0 {
+		c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+			"mail": mail,
+			"msg":  "No rows were affected.",
+		}))
+	} else {
+		c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+			"mail":            mail,
+			"sms_otp_enabled": true,
+		}))
+	}
+}
+
+// @Summary SMS OTP Disable
+// @Description Disable SMS OTP feature of the login user
+// @Tags otp
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/otp/sms/disable [put]
+func SmsOTPDisable(c *gin.Context) {
+	mail, ok := loginUser(c, "SMS OTP can only be managed by the login of the user.")
+	if !ok {
+		return
+	}
 
+	// Disable SMS OTP
+	rowsAffected, errCode, errSmsOTPUpdateEnabled := mariadb.SmsOTPUpdateEnabled(mail, false)
+	if errSmsOTPUpdateEnabled != nil {
+		errorMessage := fmt.Sprintf("Update sms_otp_enabled failed: %v", errSmsOTPUpdateEnabled)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, errSmsOTPUpdateEnabled))
+		return
+	}
+
+	// No rows were affected
+	if rowsAffected == 0 {
 		c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
-			"mail":            strMail,
-			"username":        userName,
-			"mail_otp_verify": true,
+			"mail": mail,
+			"msg":  "No rows were affected.",
 		}))
 	} else {
-		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1047, map[string]interface{}{
-			"mail":            strMail,
-			"username":        userName,
-			"mail_otp_verify": false,
+		c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+			"mail":            mail,
+			"sms_otp_enabled": false,
 		}))
 	}
+}
 
+// @Summary SMS OTP Send
+// @Description To send an OTP to the phone number of the user during login.
+// @Tags otp
+// @Accept multipart/form-data
+// @Produce application/json
+// @Param challenge_id formData string false "Challenge ID from the login response"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/otp/sms/send [post]
+func SmsOTPSend(c *gin.Context) {
+	var request loginChallenge
+
+	// Check the parameter trasnfer from POST
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+		return
+	}
+
+	// SMS is only sent to the user of a login challenge, so anonymous
+	// requests can't send messages to any phone number
+	ch, ok := readChallenge(c, request.ChallengeID, login_challenge.FactorSmsOTP)
+	if !ok {
+		return
+	}
+
+	if !sendSmsCode(c, ch.Mail, "sms_otp:") {
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, nil))
+}
+
+// @Summary SMS OTP Verify
+// @Description If a user has enabled SMS OTP, the API can be used during the login process to verify its validity.
+// @Tags otp
+// @Accept multipart/form-data
+// @Produce application/json
+// @Param challenge_id formData string false "Challenge ID from the login response"
+// @Param otp_code formData string false "OTP Code"
+// @Param X-Token-Mode header string false "Set to body to get the tokens in the response instead of cookies"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/otp/sms/verify [post]
+func SmsOTPVerify(c *gin.Context) {
+	loginVerified(c, "sms_otp_verify")
 }
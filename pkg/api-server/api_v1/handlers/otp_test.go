@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+
+	mariadb "suglider-auth/internal/database"
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/encrypt"
+)
+
+func TestSmsOTPEnable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unit Test (Mock database) Fail: %v\n", err)
+	}
+	defaultDataBase := mariadb.DataBase
+	mariadb.DataBase = sqlx.NewDb(db, "mysql")
+	defer func() {
+		mariadb.DataBase = defaultDataBase
+		db.Close()
+	}()
+
+	// The mail is set by CheckUserJWT in the server
+	router := gin.New()
+	router.PUT("/otp/sms/enable", func(c *gin.Context) {
+		if mail := c.GetHeader("X-Test-Mail"); mail != "" {
+			c.Set("mail", mail)
+		}
+		SmsOTPEnable(c)
+	})
+
+	request := func(mail, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/otp/sms/enable", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Test-Mail", mail)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	setupKey := "sms_otp_setup:" + encrypt.HashWithSHA("alice@example.com", "sha1")
+
+	t.Run("Test a request without user is forbidden", func(t *testing.T) {
+		if w := request("", `{"otp_code": "123456"}`); w.Code != http.StatusForbidden {
+			t.Errorf("Result: %d (%s)\n", w.Code, "Only the login user can enable SMS OTP.")
+		}
+	})
+
+	t.Run("Test SMS OTP needs a setup code", func(t *testing.T) {
+		if w := request("alice@example.com", `{"otp_code": "123456"}`); w.Code != http.StatusUnauthorized {
+			t.Errorf("Result: %d (%s)\n", w.Code, "SMS OTP must not be enabled without a setup code.")
+		}
+	})
+
+	t.Run("Test a wrong code discards the setup code", func(t *testing.T) {
+		if err := server.Set(setupKey, "123456"); err != nil {
+			t.Fatalf("Unit Test (Set code) Fail: %v\n", err)
+		}
+
+		if w := request("alice@example.com", `{"otp_code": "654321"}`); w.Code != http.StatusUnauthorized {
+			t.Errorf("Result: %d (%s)\n", w.Code, "The wrong code must be rejected.")
+		}
+		if server.Exists(setupKey) {
+			t.Errorf("Result: %s (%s)\n", setupKey, "The setup code can be tried only once.")
+		}
+	})
+
+	t.Run("Test the code enables SMS OTP of the login user", func(t *testing.T) {
+		if err := server.Set(setupKey, "123456"); err != nil {
+			t.Fatalf("Unit Test (Set code) Fail: %v\n", err)
+		}
+		mock.ExpectExec(`UPDATE suglider\.user_info SET sms_otp_enabled = \? WHERE mail = \?`).
+			WithArgs(true, "alice@example.com").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		// The mail in the body is not the user to enable
+		w := request("alice@example.com", `{"mail": "bob@example.com", "otp_code": "123456"}`)
+		if w.Code != http.StatusOK {
+			t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "SMS OTP must be enabled.")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unit Test (Database expectations) Fail: %v\n", err)
+		}
+	})
+}
+
+func TestMailOTPEnable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unit Test (Mock database) Fail: %v\n", err)
+	}
+	defaultDataBase := mariadb.DataBase
+	mariadb.DataBase = sqlx.NewDb(db, "mysql")
+	defer func() {
+		mariadb.DataBase = defaultDataBase
+		db.Close()
+	}()
+
+	router := gin.New()
+	router.PUT("/otp/mail/enable", func(c *gin.Context) {
+		c.Set("mail", "alice@example.com")
+		MailOTPEnable(c)
+	})
+
+	request := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/otp/mail/enable", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	setupKey := "mail_otp_setup:" + encrypt.HashWithSHA("alice@example.com", "sha1")
+
+	t.Run("Test Mail OTP needs a setup code", func(t *testing.T) {
+		if err := server.Set(setupKey, "123456"); err != nil {
+			t.Fatalf("Unit Test (Set code) Fail: %v\n", err)
+		}
+
+		if w := request(`{"otp_code": "654321"}`); w.Code != http.StatusUnauthorized || server.Exists(setupKey) {
+			t.Errorf("Result: %d (%s)\n", w.Code, "The wrong code must be rejected and discarded.")
+		}
+	})
+
+	t.Run("Test the code enables Mail OTP of the login user", func(t *testing.T) {
+		if err := server.Set(setupKey, "123456"); err != nil {
+			t.Fatalf("Unit Test (Set code) Fail: %v\n", err)
+		}
+		mock.ExpectExec(`UPDATE suglider\.user_info SET mail_otp_enabled = \? WHERE mail = \?`).
+			WithArgs(true, "alice@example.com").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		w := request(`{"mail": "bob@example.com", "otp_code": "123456"}`)
+		if w.Code != http.StatusOK {
+			t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "Mail OTP must be enabled.")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unit Test (Database expectations) Fail: %v\n", err)
+		}
+	})
+}
+
+func TestMailOTPDisable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unit Test (Mock database) Fail: %v\n", err)
+	}
+	defaultDataBase := mariadb.DataBase
+	mariadb.DataBase = sqlx.NewDb(db, "mysql")
+	defer func() {
+		mariadb.DataBase = defaultDataBase
+		db.Close()
+	}()
+
+	router := gin.New()
+	router.PUT("/otp/mail/disable", func(c *gin.Context) {
+		c.Set("mail", "alice@example.com")
+		MailOTPDisable(c)
+	})
+
+	mock.ExpectExec(`UPDATE suglider\.user_info SET mail_otp_enabled = \? WHERE mail = \?`).
+		WithArgs(false, "alice@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// The step-up of the login user can't be spent on the mail in the body
+	req := httptest.NewRequest(http.MethodPut, "/otp/mail/disable", strings.NewReader(`{"mail": "bob@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "Mail OTP of the login user must be disabled.")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unit Test (Database expectations) Fail: %v\n", err)
+	}
+}
@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	mariadb "suglider-auth/internal/database"
+	"suglider-auth/internal/redis"
+	"suglider-auth/internal/utils"
+	"suglider-auth/pkg/encrypt"
+	"suglider-auth/pkg/login_challenge"
+	"suglider-auth/pkg/passkey"
+	"suglider-auth/pkg/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reauthPassword is the factor of the password, the second factors are named
+// like the ones of login_challenge.
+const reauthPassword = "password"
+
+// The codes to authenticate again are kept apart from the ones of the login,
+// a code sent for a login can't be used in a session and the other way round.
+//
+//   reauth_mail_otp:<sha1 of mail> -> code
+//   reauth_sms_otp:<sha1 of mail>  -> code
+
+var reauthCodePrefixes = map[string]string{
+	login_challenge.FactorMailOTP: "reauth_mail_otp:",
+	login_challenge.FactorSmsOTP:  "reauth_sms_otp:",
+}
+
+// reauthFactors returns the factors the user can use to authenticate again,
+// the password and the 2FA methods of the login. A user without any of them
+// has to log in again.
+func reauthFactors(mail string) ([]string, error) {
+	factors := []string{}
+
+	userInfo, err := mariadb.GetPasswordByMail(mail)
+	if err != nil {
+		return nil, err
+	}
+	if userInfo.Password.Valid {
+		factors = append(factors, reauthPassword)
+	}
+
+	userTwoFactorAuthData, err := mariadb.GetTwoFactorAuthByMail(mail)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(factors, loginFactors(userTwoFactorAuthData)...), nil
+}
+
+// reauthSession returns the user and the ID of the login session, only a
+// session can be authenticated again.
+func reauthSession(c *gin.Context) (string, string, bool) {
+	mail, ok := loginUser(c, "Only the login session of the user can be authenticated again.")
+	if !ok {
+		return "", "", false
+	}
+
+	sid := c.GetString("sid")
+	if sid == "" {
+		c.JSON(http.StatusForbidden, utils.ErrorResponse(c, 1064, map[string]interface{}{
+			"msg": "Only the login session of the user can be authenticated again.",
+		}))
+		return "", "", false
+	}
+
+	return mail, sid, true
+}
+
+// checkReauthFactor responds with the factors of the user when factor isn't
+// one of them.
+func checkReauthFactor(c *gin.Context, mail, factor string) bool {
+	factors, err := reauthFactors(mail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return false
+	}
+	if !hasLoginFactor(factors, factor) {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1118, map[string]interface{}{
+			"factor":  factor,
+			"factors": factors,
+		}))
+		return false
+	}
+	return true
+}
+
+// takeReauthCode checks the code sent by /api/v1/user/reauth/send, the code
+// is deleted so it can be tried only once.
+func takeReauthCode(mail, factor, code string) (bool, int64, error) {
+	redisKey := encrypt.HashWithSHA(mail, "sha1")
+	value, errCode, err := redis.GetDel(reauthCodePrefixes[factor] + redisKey)
+	if errCode == 1043 {
+		return false, 0, nil
+	} else if err != nil {
+		return false, errCode, err
+	}
+
+	return subtle.ConstantTimeCompare([]byte(value), []byte(code)) == 1, 0, nil
+}
+
+// markReauthenticated records the factor in the session and responds with
+// the end of the reauth window.
+func markReauthenticated(c *gin.Context, mail, sid, factor string) {
+	authenticatedAt, errCode, err := session.MarkAuthenticated(sid, factor)
+	if errCode == 1043 {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1090, nil))
+		return
+	} else if err != nil {
+		errorMessage := fmt.Sprintf("Record authentication of session failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return
+	}
+	clearLoginFailures(mail)
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"mail":             mail,
+		"factor":           factor,
+		"authenticated_at": authenticatedAt.Unix(),
+		"reauth_expires":   authenticatedAt.Add(session.ReauthWindow()).Unix(),
+	}))
+}
+
+// @Summary Send Reauthentication Code
+// @Description Send a code by mail or SMS to authenticate the current session again with /api/v1/user/reauth.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Param factor body string true "mail_otp or sms_otp"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/reauth/send [post]
+func ReauthSend(c *gin.Context) {
+	var request reauthSend
+
+	mail, _, ok := reauthSession(c)
+	if !ok {
+		return
+	}
+
+	// Check the parameter trasnfer from POST
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+		return
+	}
+
+	keyPrefix, isCodeFactor := reauthCodePrefixes[request.Factor]
+	if !isCodeFactor {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, map[string]interface{}{
+			"msg": "Only the codes of mail_otp and sms_otp can be sent.",
+		}))
+		return
+	}
+	if !checkReauthFactor(c, mail, request.Factor) {
+		return
+	}
+
+	if request.Factor == login_challenge.FactorMailOTP {
+		ok = sendMailCode(c, mail, keyPrefix)
+	} else {
+		ok = sendSmsCode(c, mail, keyPrefix)
+	}
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, nil))
+}
+
+// @Summary Authenticate Again
+// @Description Verify the password, a TOTP code or a code from /api/v1/user/reauth/send again in the current session, the sensitive operations are allowed for the reauth window afterwards. A passkey is verified by /api/v1/user/reauth/passkey/begin and finish instead.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Param factor body string true "password, totp, mail_otp or sms_otp"
+// @Param password body string false "Password"
+// @Param otp_code body string false "TOTP code or the code sent by /api/v1/user/reauth/send"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/reauth [post]
+func Reauthenticate(c *gin.Context) {
+	var request reauthenticate
+
+	mail, sid, ok := reauthSession(c)
+	if !ok {
+		return
+	}
+
+	// Check the parameter trasnfer from POST
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+		return
+	}
+
+	// The failures are counted with the ones of the login
+	if loginBlocked(c, mail) {
+		return
+	}
+
+	if request.Factor == login_challenge.FactorWebAuthn {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1118, map[string]interface{}{
+			"factor": request.Factor,
+			"msg":    "A passkey is verified by /api/v1/user/reauth/passkey/begin and finish.",
+		}))
+		return
+	}
+	if !checkReauthFactor(c, mail, request.Factor) {
+		return
+	}
+
+	var (
+		passed   bool
+		userName string
+		errCode  int64
+	)
+	switch request.Factor {
+	case reauthPassword:
+		userInfo, err := mariadb.GetPasswordByMail(mail)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+			return
+		}
+		passed = encrypt.VerifySaltedPasswordHash(userInfo.Password.String, request.Password)
+		userName, errCode = userInfo.Username.String, 1004
+
+	case login_challenge.FactorTOTP:
+		totpData, err := mariadb.TotpUserData(mail)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1006, err))
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+			return
+		}
+
+		// The recovery codes are kept for the login, only the devices are accepted
+		passed, err = acceptTotpCode(mail, request.OTPCode)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Record TOTP time step failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+			return
+		}
+		userName, errCode = totpData.UserName.String, 1007
+
+	case login_challenge.FactorMailOTP, login_challenge.FactorSmsOTP:
+		passed, errCode, err = takeReauthCode(mail, request.Factor, request.OTPCode)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Redis GET data failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+			return
+		}
+		errCode = 1047
+	}
+
+	if !passed {
+		recordLoginFailure(c, mail, userName)
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, errCode, map[string]interface{}{
+			"factor": request.Factor,
+		}))
+		return
+	}
+
+	markReauthenticated(c, mail, sid, request.Factor)
+}
+
+// @Summary Begin Passkey Reauthentication
+// @Description Start to authenticate the current session again with a passkey, the options are passed to navigator.credentials.get().
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/reauth/passkey/begin [post]
+func ReauthPasskeyBegin(c *gin.Context) {
+	if !checkWebAuthnEnabled(c) {
+		return
+	}
+
+	mail, _, ok := reauthSession(c)
+	if !ok {
+		return
+	}
+
+	if loginBlocked(c, mail) || !checkReauthFactor(c, mail, login_challenge.FactorWebAuthn) {
+		return
+	}
+
+	user, _, err := loadPasskeyUser(mail)
+	if err != nil {
+		passkeyUserError(c, err)
+		return
+	}
+
+	assertion, ceremonyID, errCode, err := passkey.BeginReauth(user)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Begin WebAuthn reauthentication failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"ceremony_id": ceremonyID,
+		"options":     assertion,
+	}))
+}
+
+// @Summary Finish Passkey Reauthentication
+// @Description Verify the response of navigator.credentials.get(), the sensitive operations are allowed for the reauth window afterwards.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Param ceremony_id query string true "Ceremony ID from the begin response"
+// @Param credential body string true "The PublicKeyCredential from the browser"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/reauth/passkey/finish [post]
+func ReauthPasskeyFinish(c *gin.Context) {
+	if !checkWebAuthnEnabled(c) {
+		return
+	}
+
+	mail, sid, ok := reauthSession(c)
+	if !ok {
+		return
+	}
+
+	if loginBlocked(c, mail) {
+		return
+	}
+
+	ceremony, errCode, err := passkey.TakeCeremony(c.Query("ceremony_id"), passkey.CeremonyReauth)
+	if err != nil {
+		if errCode == 1105 {
+			c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, errCode, nil))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return
+	}
+
+	// The ceremony must have been begun by the same user
+	if ceremony.Mail != mail {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1105, nil))
+		return
+	}
+
+	user, _, err := loadPasskeyUser(mail)
+	if err != nil {
+		passkeyUserError(c, err)
+		return
+	}
+
+	_, cred, err := passkey.FinishLogin(user, ceremony, c.Request, nil)
+	if err != nil {
+		recordLoginFailure(c, mail, "")
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1106, map[string]interface{}{
+			"factor": login_challenge.FactorWebAuthn,
+		}))
+		return
+	}
+	updatePasskey(cred)
+
+	markReauthenticated(c, mail, sid, login_challenge.FactorWebAuthn)
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/encrypt"
+	"suglider-auth/pkg/login_challenge"
+	"suglider-auth/pkg/session"
+	"suglider-auth/pkg/session_store"
+)
+
+func TestRequireRecentAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store := session_store.NewMemoryStore()
+	session.SetStore(store)
+
+	now := time.Now().Unix()
+	err := store.Create("alice-laptop", session_store.SessionData{Mail: "alice@example.com", CreatedAt: now, LastSeen: now}, time.Hour)
+	if err != nil {
+		t.Fatalf("Unit Test (Create session) Fail: %v\n", err)
+	}
+
+	// The principal is set by CheckUserJWT or the API key middleware in the server
+	router := gin.New()
+	router.POST("/policy/add", func(c *gin.Context) {
+		if mail := c.GetHeader("X-Test-Mail"); mail != "" {
+			c.Set("mail", mail)
+			c.Set("sid", c.GetHeader("X-Test-Sid"))
+		}
+		if keyID := c.GetHeader("X-Test-Api-Key"); keyID != "" {
+			c.Set("mail", "alice@example.com")
+			c.Set("api_key_id", keyID)
+		}
+		if clientID := c.GetHeader("X-Test-Client"); clientID != "" {
+			c.Set("client_id", clientID)
+			c.Set("scope", "rbac:write")
+		}
+	}, RequireRecentAuth(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	request := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/policy/add", nil)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	testCases := []struct {
+		name    string
+		headers map[string]string
+		status  int
+		reason  string
+	}{
+		{"client credentials", map[string]string{"X-Test-Client": "backend"}, http.StatusForbidden,
+			"A client has no session to authenticate again."},
+		{"API key", map[string]string{"X-Test-Api-Key": "key-1"}, http.StatusForbidden,
+			"An API key has no session to authenticate again."},
+		{"session authenticated at login", map[string]string{"X-Test-Mail": "alice@example.com", "X-Test-Sid": "alice-laptop"}, http.StatusOK,
+			"The login is within the reauth window."},
+		{"user token without session", map[string]string{"X-Test-Mail": "alice@example.com"}, http.StatusForbidden,
+			"A user must use the login session."},
+		{"expired session", map[string]string{"X-Test-Mail": "alice@example.com", "X-Test-Sid": "alice-phone"}, http.StatusUnauthorized,
+			"An expired session can't be authenticated again."},
+	}
+
+	for _, testCase := range testCases {
+		t.Run("Test "+testCase.name, func(t *testing.T) {
+			if w := request(testCase.headers); w.Code != testCase.status {
+				t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), testCase.reason)
+			}
+		})
+	}
+}
+
+func TestTakeReauthCode(t *testing.T) {
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+
+	mail := "alice@example.com"
+	key := reauthCodePrefixes[login_challenge.FactorMailOTP] + encrypt.HashWithSHA(mail, "sha1")
+
+	t.Run("Test the code can be used once", func(t *testing.T) {
+		if err := server.Set(key, "123456"); err != nil {
+			t.Fatalf("Unit Test (Set code) Fail: %v\n", err)
+		}
+
+		passed, _, err := takeReauthCode(mail, login_challenge.FactorMailOTP, "123456")
+		if err != nil || !passed {
+			t.Fatalf("Result: %v, %v (%s)\n", passed, err, "The code must be accepted.")
+		}
+
+		passed, _, err = takeReauthCode(mail, login_challenge.FactorMailOTP, "123456")
+		if err != nil || passed {
+			t.Errorf("Result: %v, %v (%s)\n", passed, err, "The code must not be accepted twice.")
+		}
+	})
+
+	t.Run("Test a wrong code discards the code", func(t *testing.T) {
+		if err := server.Set(key, "123456"); err != nil {
+			t.Fatalf("Unit Test (Set code) Fail: %v\n", err)
+		}
+
+		passed, _, err := takeReauthCode(mail, login_challenge.FactorMailOTP, "654321")
+		if err != nil || passed {
+			t.Fatalf("Result: %v, %v (%s)\n", passed, err, "A wrong code must not be accepted.")
+		}
+		if server.Exists(key) {
+			t.Errorf("Result: %s (%s)\n", key, "The code can only be tried once.")
+		}
+	})
+
+	t.Run("Test a login code is not accepted", func(t *testing.T) {
+		if err := server.Set("mail_otp:"+encrypt.HashWithSHA(mail, "sha1"), "123456"); err != nil {
+			t.Fatalf("Unit Test (Set code) Fail: %v\n", err)
+		}
+
+		passed, _, err := takeReauthCode(mail, login_challenge.FactorMailOTP, "123456")
+		if err != nil || passed {
+			t.Errorf("Result: %v, %v (%s)\n", passed, err, "The code of the login must not authenticate a session.")
+		}
+	})
+}
@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"suglider-auth/internal/utils"
+	fmtv "suglider-auth/pkg/fmt_validator"
+	"suglider-auth/pkg/refresh_token"
+	"suglider-auth/pkg/session"
+	"suglider-auth/pkg/token_denylist"
+
+	"github.com/gin-gonic/gin"
+)
+
+// revokeSession signs out a session on every device, the access tokens and
+// refresh tokens issued for the session are revoked too.
+func revokeSession(sid string) error {
+	if err := session.DeleteSession(sid); err != nil {
+		return err
+	}
+	if err := token_denylist.RevokeSession(sid); err != nil {
+		return err
+	}
+	return refresh_token.RevokeFamily(sid)
+}
+
+// revokeSessions signs out every session of the user except exceptSID, and
+// returns the IDs shown in the session list of the revoked ones.
+func revokeSessions(mail, exceptSID string) ([]string, error) {
+	_, sids, err := session.ListSessions(mail, "")
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := []string{}
+	for _, sid := range sids {
+		if sid == exceptSID {
+			continue
+		}
+		if err = revokeSession(sid); err != nil {
+			return nil, err
+		}
+		revoked = append(revoked, session.SessionHash(sid))
+	}
+
+	return revoked, nil
+}
+
+// enforceSessionLimit makes room for a new session of the user. Depending on
+// the limit policy, the login is rejected or the oldest sessions are evicted,
+// the evicted ones are reported in the login response by withTokens. It's
+// called by addLimitedSession with the session lock of the user held, csbn
+// looks up the roles of the user for the limit.
+func enforceSessionLimit(c *gin.Context, csbn *CasbinEnforcerConfig, mail string) bool {
+	var roles []string
+	if csbn != nil {
+		var err error
+		roles, err = csbn.GetRolesOfMember(mail)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Get roles of user failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1065, err))
+			return false
+		}
+	}
+
+	maxSessions := session.MaxSessions(roles)
+	if maxSessions <= 0 {
+		return true
+	}
+
+	sessionInfos, sids, err := session.ListSessions(mail, "")
+	if err != nil {
+		errorMessage := fmt.Sprintf("List sessions failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1044, err))
+		return false
+	}
+
+	excess := len(sids) - maxSessions + 1
+	if excess <= 0 {
+		return true
+	}
+
+	if session.LimitPolicy() == session.LimitReject {
+		c.JSON(http.StatusConflict, utils.ErrorResponse(c, 1091, map[string]interface{}{
+			"max_sessions": maxSessions,
+		}))
+		return false
+	}
+
+	oldest := make([]int, len(sids))
+	for i := range oldest {
+		oldest[i] = i
+	}
+	sort.SliceStable(oldest, func(i, j int) bool {
+		return sessionInfos[oldest[i]].CreatedAt < sessionInfos[oldest[j]].CreatedAt
+	})
+
+	evicted := []string{}
+	for _, i := range oldest[:excess] {
+		if err = revokeSession(sids[i]); err != nil {
+			errorMessage := fmt.Sprintf("Revoke session failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1089, err))
+			return false
+		}
+		evicted = append(evicted, sessionInfos[i].ID)
+	}
+
+	c.Set("evicted_sessions", evicted)
+	return true
+}
+
+// sessionOwner returns the mail of the logged in user and the session ID of
+// the request, which is empty for API keys.
+func sessionOwner(c *gin.Context) (string, string, bool) {
+	mail, isMailExists := c.Get("mail")
+	if !isMailExists {
+		c.JSON(http.StatusForbidden, utils.ErrorResponse(c, 1064, map[string]interface{}{
+			"msg": "Sessions can only be managed by a user.",
+		}))
+		return "", "", false
+	}
+
+	return fmt.Sprintf("%v", mail), c.GetString("sid"), true
+}
+
+// memberMail reads the mail of the user from the path of admin endpoints.
+func memberMail(c *gin.Context) (string, bool) {
+	mail, err := url.QueryUnescape(c.Param("mail"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1103, err))
+		return "", false
+	}
+	if !fmtv.MailValidator(mail) {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1062, nil))
+		return "", false
+	}
+	return mail, true
+}
+
+func listSessions(c *gin.Context, mail, currentSID string) {
+	sessionInfos, _, err := session.ListSessions(mail, currentSID)
+	if err != nil {
+		errorMessage := fmt.Sprintf("List sessions failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1044, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"mail":     mail,
+		"sessions": sessionInfos,
+	}))
+}
+
+func revokeOneSession(c *gin.Context, mail string) {
+	id := c.Param("id")
+
+	sid, err := session.FindSession(mail, id)
+	if err != nil {
+		errorMessage := fmt.Sprintf("List sessions failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1044, err))
+		return
+	}
+	if sid == "" {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1088, nil))
+		return
+	}
+
+	if err = revokeSession(sid); err != nil {
+		errorMessage := fmt.Sprintf("Revoke session failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1089, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"mail":    mail,
+		"revoked": []string{id},
+	}))
+}
+
+func revokeOtherSessions(c *gin.Context, mail, exceptSID string) {
+	revoked, err := revokeSessions(mail, exceptSID)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Revoke sessions failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1089, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"mail":    mail,
+		"revoked": revoked,
+	}))
+}
+
+// @Summary List Sessions
+// @Description Show the login sessions of the user on every device.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/sessions [get]
+func SessionList(c *gin.Context) {
+	mail, currentSID, ok := sessionOwner(c)
+	if !ok {
+		return
+	}
+
+	listSessions(c, mail, currentSID)
+}
+
+// @Summary Revoke Session
+// @Description Sign out a session of the user, the tokens of the session are revoked too.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Param id path string true "Session ID from the session list"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/sessions/{id} [delete]
+func SessionRevoke(c *gin.Context) {
+	mail, _, ok := sessionOwner(c)
+	if !ok {
+		return
+	}
+
+	revokeOneSession(c, mail)
+}
+
+// @Summary Revoke Other Sessions
+// @Description Sign out every session of the user except the current one.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/sessions [delete]
+func SessionRevokeOthers(c *gin.Context) {
+	mail, currentSID, ok := sessionOwner(c)
+	if !ok {
+		return
+	}
+
+	revokeOtherSessions(c, mail, currentSID)
+}
+
+// @Summary List Sessions of Member
+// @Description Show the login sessions of any user, it's used by administrators.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Param mail path string true "Enter user mail"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/member-sessions/{mail} [get]
+func MemberSessionList(c *gin.Context) {
+	mail, ok := memberMail(c)
+	if !ok {
+		return
+	}
+
+	listSessions(c, mail, "")
+}
+
+// @Summary Revoke Session of Member
+// @Description Sign out a session of any user, it's used by administrators.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Param mail path string true "Enter user mail"
+// @Param id path string true "Session ID from the session list"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/member-sessions/{mail}/{id} [delete]
+func MemberSessionRevoke(c *gin.Context) {
+	mail, ok := memberMail(c)
+	if !ok {
+		return
+	}
+
+	revokeOneSession(c, mail)
+}
+
+// @Summary Revoke All Sessions of Member
+// @Description Sign out every session of any user, it's used by administrators.
+// @Tags users
+// @Accept application/json
+// @Produce application/json
+// @Param mail path string true "Enter user mail"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/member-sessions/{mail} [delete]
+func MemberSessionRevokeAll(c *gin.Context) {
+	mail, ok := memberMail(c)
+	if !ok {
+		return
+	}
+
+	revokeOtherSessions(c, mail, "")
+}
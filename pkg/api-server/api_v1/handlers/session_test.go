@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/casbin/casbin/v2"
+	"github.com/gin-gonic/gin"
+
+	"suglider-auth/configs"
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/session"
+	"suglider-auth/pkg/session_store"
+)
+
+type sessionListResponse struct {
+	Code int64 `json:"code"`
+	Data struct {
+		Mail     string                `json:"mail"`
+		Sessions []session.SessionInfo `json:"sessions"`
+	} `json:"data"`
+}
+
+// The handlers only need the session store to list the sessions, so they
+// run against the memory store without Redis.
+func TestSessionList(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store := session_store.NewMemoryStore()
+	session.SetStore(store)
+
+	now := time.Now().Unix()
+	for sid, data := range map[string]session_store.SessionData{
+		"alice-laptop": {Mail: "alice@example.com", CreatedAt: now - 60, LastSeen: now},
+		"alice-phone":  {Mail: "alice@example.com", CreatedAt: now, LastSeen: now},
+		"bob-laptop":   {Mail: "bob@example.com", CreatedAt: now, LastSeen: now},
+	} {
+		if err := store.Create(sid, data, time.Hour); err != nil {
+			t.Fatalf("Unit Test (Create session) Fail: %v\n", err)
+		}
+	}
+
+	// The mail and session ID are set by CheckUserJWT in the server
+	router := gin.New()
+	router.GET("/sessions", func(c *gin.Context) {
+		if mail := c.GetHeader("X-Test-Mail"); mail != "" {
+			c.Set("mail", mail)
+			c.Set("sid", c.GetHeader("X-Test-Sid"))
+		}
+		SessionList(c)
+	})
+
+	request := func(mail, sid string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/sessions", nil)
+		req.Header.Set("X-Test-Mail", mail)
+		req.Header.Set("X-Test-Sid", sid)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Test list the sessions of the user", func(t *testing.T) {
+		w := request("alice@example.com", "alice-phone")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The sessions must be listed.")
+		}
+
+		var response sessionListResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Unit Test (Unmarshal response) Fail: %v\n", err)
+		}
+		if response.Data.Mail != "alice@example.com" || len(response.Data.Sessions) != 2 {
+			t.Fatalf("Result: %v (%s)\n", response.Data, "Only the two sessions of the user must be listed.")
+		}
+
+		current := 0
+		for _, sessionInfo := range response.Data.Sessions {
+			if sessionInfo.ID == "alice-phone" || sessionInfo.ID == "alice-laptop" {
+				t.Errorf("Result: %s (%s)\n", sessionInfo.ID, "The session ID must not be shown.")
+			}
+			if sessionInfo.Current {
+				current++
+				if sessionInfo.ID != session.SessionHash("alice-phone") {
+					t.Errorf("Result: %s (%s)\n", sessionInfo.ID, "The wrong session is the current one.")
+				}
+			}
+		}
+		if current != 1 {
+			t.Errorf("Result: %d (%s)\n", current, "Exactly one session must be the current one.")
+		}
+	})
+
+	t.Run("Test an expired session is not listed", func(t *testing.T) {
+		if err := store.Delete("alice-laptop"); err != nil {
+			t.Fatalf("Unit Test (Delete session) Fail: %v\n", err)
+		}
+
+		var response sessionListResponse
+		w := request("alice@example.com", "alice-phone")
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Unit Test (Unmarshal response) Fail: %v\n", err)
+		}
+		if len(response.Data.Sessions) != 1 {
+			t.Errorf("Result: %v (%s)\n", response.Data.Sessions, "The deleted session must not be listed.")
+		}
+	})
+
+	t.Run("Test a request without user is forbidden", func(t *testing.T) {
+		if w := request("", ""); w.Code != http.StatusForbidden {
+			t.Errorf("Result: %d (%s)\n", w.Code, "Only a user can list the sessions.")
+		}
+	})
+}
+
+func TestEnforceSessionLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// The evicted sessions are denylisted in Redis
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+
+	sessionConfig := configs.ApplicationConfig.Session
+	defaultConfig := *sessionConfig
+	defer func() { *sessionConfig = defaultConfig }()
+	sessionConfig.MaxSessions = 2
+
+	store := session_store.NewMemoryStore()
+	session.SetStore(store)
+
+	now := time.Now().Unix()
+	for sid, createdAt := range map[string]int64{"oldest": now - 120, "older": now - 60} {
+		data := session_store.SessionData{Mail: "alice@example.com", CreatedAt: createdAt, LastSeen: now}
+		if err := store.Create(sid, data, time.Hour); err != nil {
+			t.Fatalf("Unit Test (Create session) Fail: %v\n", err)
+		}
+	}
+
+	t.Run("Test the login over the limit is rejected", func(t *testing.T) {
+		sessionConfig.LimitPolicy = session.LimitReject
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		if enforceSessionLimit(c, nil, "alice@example.com") || w.Code != http.StatusConflict {
+			t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The login must be rejected at the limit.")
+		}
+	})
+
+	t.Run("Test the limit of the roles", func(t *testing.T) {
+		sessionConfig.LimitPolicy = session.LimitReject
+		sessionConfig.RoleMaxSessions = map[string]int{"support": 3}
+
+		enforcer, err := casbin.NewCachedEnforcer("../../../../configs/rbac_model.conf")
+		if err != nil {
+			t.Fatalf("Unit Test (New enforcer) Fail: %v\n", err)
+		}
+		if _, err = enforcer.AddGroupingPolicy("alice@example.com", "support"); err != nil {
+			t.Fatalf("Unit Test (Add grouping policy) Fail: %v\n", err)
+		}
+		csbn := &CasbinEnforcerConfig{Enforcer: enforcer}
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		if !enforceSessionLimit(c, csbn, "alice@example.com") || len(c.GetStringSlice("evicted_sessions")) != 0 {
+			t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The role of the user must raise the limit.")
+		}
+
+		sessionConfig.RoleMaxSessions = nil
+	})
+
+	t.Run("Test the oldest session is evicted", func(t *testing.T) {
+		sessionConfig.LimitPolicy = session.LimitEvictOldest
+
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		if !enforceSessionLimit(c, nil, "alice@example.com") {
+			t.Fatalf("Result: %v (%s)\n", false, "The login must make room for the new session.")
+		}
+
+		evicted := c.GetStringSlice("evicted_sessions")
+		if len(evicted) != 1 || evicted[0] != session.SessionHash("oldest") {
+			t.Errorf("Result: %v (%s)\n", evicted, "Only the oldest session must be evicted.")
+		}
+		if _, _, err := store.Read("oldest"); err != session_store.ErrSessionNotFound {
+			t.Errorf("Result: %v (%s)\n", err, "The evicted session must be deleted.")
+		}
+		if !server.Exists("revoked_sid:oldest") {
+			t.Errorf("Result: %s (%s)\n", "oldest", "The tokens of the evicted session must be revoked.")
+		}
+		if _, _, err := store.Read("older"); err != nil {
+			t.Errorf("Result: %v (%s)\n", err, "The other session must be kept.")
+		}
+	})
+
+	t.Run("Test the login under the limit", func(t *testing.T) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		if !enforceSessionLimit(c, nil, "alice@example.com") || len(c.GetStringSlice("evicted_sessions")) != 0 {
+			t.Errorf("Result: %v (%s)\n", c.GetStringSlice("evicted_sessions"), "No session must be evicted under the limit.")
+		}
+	})
+}
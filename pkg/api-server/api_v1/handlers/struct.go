@@ -1,10 +1,51 @@
 package handlers
 
-type otpData struct {
-	Mail    string `json:"mail" binding:"required"`
+type unlockAccount struct {
+	UnlockToken string `json:"unlock_token" binding:"required"`
+}
+
+type reauthenticate struct {
+	Factor   string `json:"factor" binding:"required"`
+	Password string `json:"password"`
+	OTPCode  string `json:"otp_code"`
+}
+
+type reauthSend struct {
+	Factor string `json:"factor" binding:"required"`
+}
+
+type magicLinkSend struct {
+	Mail string `json:"mail" binding:"required"`
+}
+
+type magicLinkLogin struct {
+	Mail     string `json:"mail" binding:"required"`
+	LinkID   string `json:"link_id" binding:"required"`
+	LinkCode string `json:"link_code" binding:"required"`
+}
+
+type totpGenerate struct {
+	Name string `json:"name"`
+}
+
+type totpVerify struct {
+	OTPCode  string `json:"otp_code" binding:"required"`
+	DeviceID string `json:"device_id"`
+}
+
+type challengeOTP struct {
+	ChallengeID string `json:"challenge_id" binding:"required"`
+	OTPCode     string `json:"otp_code" binding:"required"`
+}
+
+type otpEnable struct {
 	OTPCode string `json:"otp_code" binding:"required"`
 }
 
+type loginChallenge struct {
+	ChallengeID string `json:"challenge_id" binding:"required"`
+}
+
 type oAuthResponse struct {
 	ID         string `json:"id"`
 	Email      string `json:"email"`
@@ -43,12 +84,16 @@ type mailOperate struct {
 	Mail string `json:"mail" binding:"required"`
 }
 
+type userLogout struct {
+	Mail         string `json:"mail" binding:"required"`
+	RefreshToken string `json:"refresh_token"` // used when the refresh token is not in the cookie
+}
+
 type phoneNumberOperate struct {
 	PhoneNumber string `json:"phone_number" binding:"required"`
 }
 
 type resetPassword struct {
-	Mail        string `json:"mail" binding:"required"`
 	OldPassword string `json:"old_password" binding:"required"`
 	NewPassword string `json:"new_password" binding:"required"`
 }
@@ -73,18 +118,6 @@ type updatePersonalInfo struct {
 type passwordReset struct {
 	Password string `json:"password"`
 }
-
-type rdsValeData struct {
-	Mail           string   `json:"mail"`
-	AccountPassed  bool     `json:"account_passed"`
-	MailOTPPassed  bool     `json:"mail_otp_passed"`
-	SmsOTPPassed   bool     `json:"sms_otp_passed"`
-	TotpPassed     bool     `json:"totp_passed"`
-	MailOTPEnabled bool     `json:"mail_otp_enabled"`
-	SmsOTPEnabled  bool     `json:"sms_otp_enabled"`
-	TotpEnabled    bool     `json:"totp_enabled"`
-	UserName       UserName `json:"username"`
-}
 type UserName struct {
 	String string `json:"String"`
 	Valid  bool   `json:"Valid"`
@@ -95,3 +128,33 @@ type checkAuthValid struct {
 	SessionID string `json:"session_id" binding:"required"`
 	JWTToken  string `json:"jwt_token" binding:"required"`
 }
+
+type oidcClientRegister struct {
+	ClientName   string   `json:"client_name" binding:"required"`
+	RedirectURIs []string `json:"redirect_uris" binding:"required"`
+	Scope        string   `json:"scope"`
+	Public       bool     `json:"public"` // public clients have no secret and must use PKCE
+}
+
+type oauth2ClientRegister struct {
+	ClientName string `json:"client_name" binding:"required"`
+	Scope      string `json:"scope"`
+}
+
+type apiKeyCreate struct {
+	Name      string `json:"name" binding:"required"`
+	Scope     string `json:"scope"`      // e.g. "user:read totp:write", at least one scope is required
+	ExpiresIn string `json:"expires_in"` // e.g. "720h", empty means apiKeyDefaultTTL
+}
+
+type apiKeyRename struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type webAuthnRegister struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type webAuthnLogin struct {
+	ChallengeID string `json:"challenge_id"` // empty for a passwordless login
+}
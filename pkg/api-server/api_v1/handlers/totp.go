@@ -2,25 +2,28 @@ package handlers
 
 import (
 	"database/sql"
-	"encoding/json"
+	"encoding/base64"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 	mariadb "suglider-auth/internal/database"
-	"suglider-auth/internal/redis"
 	"suglider-auth/internal/utils"
-	"suglider-auth/pkg/time_convert"
 	"suglider-auth/pkg/totp"
 
 	"github.com/gin-gonic/gin"
 )
 
+// defaultTotpDevice is the name of the device when it's not given, the secret
+// from before the devices is migrated to it as well.
+const defaultTotpDevice = "default"
+
 // @Summary Enable TOTP
-// @Description generate QRcode
+// @Description Generate the secret of a new TOTP device, the response has the QR code as a data URI. A device which has not been verified is replaced by a new one of the same name.
 // @Tags totp
 // @Accept multipart/form-data
 // @Produce application/json
-// @Param mail formData string false "Mail"
+// @Param name formData string false "Device Name, default is default"
 // @Success 200 {string} string "Success"
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
@@ -28,7 +31,12 @@ import (
 // @Failure 404 {string} string "Not found"
 // @Router /api/v1/totp/generate [post]
 func TotpGenerate(c *gin.Context) {
-	var request mailOperate
+	var request totpGenerate
+
+	mail, ok := loginUser(c, "TOTP can only be managed by the login of the user.")
+	if !ok {
+		return
+	}
 
 	// Check the parameter trasnfer from POST
 	err := c.ShouldBindJSON(&request)
@@ -37,8 +45,12 @@ func TotpGenerate(c *gin.Context) {
 		return
 	}
 
+	if request.Name == "" {
+		request.Name = defaultTotpDevice
+	}
+
 	// Look up user ID
-	userIDInfo, err := mariadb.LookupUserID(request.Mail)
+	userIDInfo, err := mariadb.LookupUserID(mail)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1006, err))
@@ -49,23 +61,33 @@ func TotpGenerate(c *gin.Context) {
 	}
 
 	// Generate TOTP QRcode
-	// totpInfo, imageData, errCode, err := totp.TotpGernate(request.Mail, userIDInfo.UserID)
-	totpInfo, _, errCode, err := totp.TotpGernate(request.Mail, userIDInfo.UserID)
-	if errCode != 0 {
+	totpInfo, imageData, errCode, err := totp.TotpGernate(mail, userIDInfo.UserID, request.Name)
+	if errCode == 1109 {
+		c.JSON(http.StatusConflict, utils.ErrorResponse(c, errCode, map[string]interface{}{
+			"name": request.Name,
+		}))
+		return
+	} else if errCode != 0 {
 		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return
 	}
-	// c.Header("Content-Type", "image/png")
-	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, totpInfo))
-	// c.Data(http.StatusOK, "image/png", imageData)
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"device_id": totpInfo.DeviceID,
+		"name":      totpInfo.Name,
+		"base32":    totpInfo.Base32,
+		"auth_url":  totpInfo.AuthURL,
+		"qr_code":   "data:image/png;base64," + base64.StdEncoding.EncodeToString(imageData),
+	}))
 }
 
 // @Summary Verify TOTP
-// @Description The API uses the first enabled TOTP feature to verify the TOTP code.
+// @Description Verify the TOTP code of a new device. Without device_id the code is checked with the devices which have not been verified. The response has the recovery codes when TOTP is enabled by this device.
 // @Tags totp
 // @Accept multipart/form-data
 // @Produce application/json
-// @Param mail formData string false "Mail"
 // @Param otp_code formData string false "OTP Code"
+// @Param device_id formData string false "Device ID"
 // @Success 200 {string} string "Success"
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
@@ -73,9 +95,12 @@ func TotpGenerate(c *gin.Context) {
 // @Failure 404 {string} string "Not found"
 // @Router /api/v1/totp/verify [patch]
 func TotpVerify(c *gin.Context) {
-	// The API uses the first enabled TOTP feature to verify the TOTP code.
+	var request totpVerify
 
-	var request otpData
+	mail, ok := loginUser(c, "TOTP can only be managed by the login of the user.")
+	if !ok {
+		return
+	}
 
 	// Check the parameter trasnfer from POST
 	err := c.ShouldBindJSON(&request)
@@ -84,8 +109,8 @@ func TotpVerify(c *gin.Context) {
 		return
 	}
 
-	// To get TOTP secret
-	totpData, err := mariadb.TotpUserData(request.Mail)
+	// To get TOTP status
+	totpData, err := mariadb.TotpUserData(mail)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1006, err))
@@ -95,131 +120,187 @@ func TotpVerify(c *gin.Context) {
 		return
 	}
 
+	devices, err := mariadb.TotpDeviceList(mail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	candidates := []mariadb.TotpDeviceInfo{}
+	for _, device := range devices {
+		if (request.DeviceID == "" && !device.Verified) || device.DeviceID == request.DeviceID {
+			candidates = append(candidates, device)
+		}
+	}
+	if len(candidates) == 0 {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1110, map[string]interface{}{
+			"device_id": request.DeviceID,
+		}))
+		return
+	}
+
 	// Verify TOTP Code from user input
-	valid := totp.TotpValidate(request.OTPCode, totpData.TotpSecret)
+	device, err := totp.TotpAcceptAny(candidates, request.OTPCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
 
-	if !valid {
+	if device == nil {
 		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1007))
 		return
 	}
 
+	if err = mariadb.TotpDeviceVerify(device.DeviceID); err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
 	// Update TOTP enabled and verified column status in database
-	errTotpUpdateVerify := mariadb.TotpUpdateVerify(request.Mail, true, true)
+	errTotpUpdateVerify := mariadb.TotpUpdateVerify(mail, true, true)
 	if errTotpUpdateVerify != nil {
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, errTotpUpdateVerify))
 		return
 	}
 
-	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, nil))
+	data := map[string]interface{}{
+		"device_id": device.DeviceID,
+		"name":      device.Name,
+	}
+
+	// The recovery codes are only shown once, the user has to keep them.
+	// Another device doesn't change the codes.
+	if !totpData.TotpEnabled {
+		recoveryCodes, ok := newRecoveryCodes(c, mail)
+		if !ok {
+			return
+		}
+		data["recovery_codes"] = recoveryCodes
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, data))
 }
 
-// @Summary Verify TOTP Validate
-// @Description If a user has enabled TOTP, the API can be used during the login process to verify its validity.
+// newRecoveryCodes replaces the TOTP recovery codes of the user.
+func newRecoveryCodes(c *gin.Context, mail string) ([]string, bool) {
+	recoveryCodes, codeHashes, err := totp.GenerateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1076, err))
+		return nil, false
+	}
+
+	err = mariadb.ReplaceTotpRecoveryCodes(mail, codeHashes)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1006, err))
+			return nil, false
+		}
+		errorMessage := fmt.Sprintf("Store TOTP recovery codes failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return nil, false
+	}
+
+	return recoveryCodes, true
+}
+
+// @Summary Regenerate TOTP Recovery Codes
+// @Description Replace the recovery codes of the user, the old codes can't be used anymore. The new codes are only shown in this response.
 // @Tags totp
-// @Accept multipart/form-data
+// @Accept application/json
 // @Produce application/json
-// @Param mail formData string false "Mail"
-// @Param otp_code formData string false "OTP Code"
 // @Success 200 {string} string "Success"
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
 // @Failure 403 {string} string "Forbidden"
 // @Failure 404 {string} string "Not found"
-// @Router /api/v1/totp/validate [post]
-func TotpValidate(c *gin.Context) {
-
-	var data rdsValeData
-
-	mail, isMailExists := c.Get("mail")
-	userName, isUserNameExists := c.Get("userName")
-	Result, isTOTPVerifyExists := c.Get("totp_verify")
+// @Router /api/v1/totp/recovery-codes [post]
+func TotpRecoveryCodesRegenerate(c *gin.Context) {
+	mail, ok := loginUser(c, "Recovery codes can only be managed by the login of the user.")
+	if !ok {
+		return
+	}
 
-	// Convert interface to string
-	strMail := fmt.Sprintf("%v", mail)
+	totpData, err := mariadb.TotpUserData(mail)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1006, err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
 
-	if !isMailExists || !isTOTPVerifyExists || !isUserNameExists {
-		slog.Error("mail, totp_verify or username are not exists.")
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1071, nil))
+	if !totpData.TotpEnabled {
+		c.JSON(http.StatusForbidden, utils.ErrorResponse(c, 1053, map[string]interface{}{
+			"totp_enabled": totpData.TotpEnabled,
+		}))
 		return
 	}
 
-	verifyResult, ok := Result.(bool)
+	recoveryCodes, ok := newRecoveryCodes(c, mail)
 	if !ok {
-		slog.Error("OTP verify result value is not bool.")
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1051, nil))
 		return
 	}
 
-	if verifyResult {
-		// Check whether login status exists in redis or not.
-		value, errCode, err := redis.Get("login_status:" + strMail)
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"recovery_codes": recoveryCodes,
+	}))
+}
 
-		switch errCode {
-		// Key not exists
-		case 1043:
-			errorMessage := fmt.Sprintf("Redis key does not exist: %v", err)
-			slog.Error(errorMessage)
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
-			return
-		case 1044:
-			errorMessage := fmt.Sprintf("Redis GET data failed: %v", err)
-			slog.Error(errorMessage)
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
-			return
-		// Key exists
-		case 0:
-			err := json.Unmarshal([]byte(value), &data)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1063, err))
-				return
-			}
-
-			// Change status
-			data.TotpPassed = true
-
-			jsonData, err := json.Marshal(data)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1063, err))
-				return
-			}
-
-			redisTTL, _, err := time_convert.ConvertTimeFormat("15m")
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1069, err))
-				return
-			}
-
-			// Set login_status into redis
-			err = redis.Set("login_status:"+strMail, string(jsonData), redisTTL)
-
-			if err != nil {
-				errorMessage := fmt.Sprintf("Redis SET data failed.: %v", err)
-				slog.Error(errorMessage)
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1042, err))
-				return
-			}
-		}
+// @Summary TOTP Recovery Codes Status
+// @Description Show how many recovery codes of the user have not been used.
+// @Tags totp
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/totp/recovery-codes [get]
+func TotpRecoveryCodesStatus(c *gin.Context) {
+	mail, ok := loginUser(c, "Recovery codes can only be managed by the login of the user.")
+	if !ok {
+		return
+	}
 
-		c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
-			"mail":        strMail,
-			"username":    userName,
-			"totp_verify": true,
-		}))
-	} else {
-		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1047, map[string]interface{}{
-			"mail":        strMail,
-			"username":    userName,
-			"totp_verify": false,
-		}))
+	remaining, err := mariadb.CountTotpRecoveryCodes(mail)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Count TOTP recovery codes failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
 	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"remaining": remaining,
+	}))
 }
 
-// @Summary Disable TOTP
-// @Description disable TOTP
+// @Summary Verify TOTP Validate
+// @Description If a user has enabled TOTP, the API can be used during the login process to verify its validity. A recovery code can be used in place of the TOTP code.
 // @Tags totp
 // @Accept multipart/form-data
 // @Produce application/json
-// @Param mail formData string false "Mail"
+// @Param challenge_id formData string false "Challenge ID from the login response"
+// @Param otp_code formData string false "OTP Code or Recovery Code"
+// @Param X-Token-Mode header string false "Set to body to get the tokens in the response instead of cookies"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/totp/validate [post]
+func TotpValidate(c *gin.Context) {
+	loginVerified(c, "totp_verify")
+}
+
+// @Summary Disable TOTP
+// @Description Disable TOTP of the login user
+// @Tags totp
+// @Accept application/json
+// @Produce application/json
 // @Success 200 {string} string "Success"
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
@@ -227,17 +308,13 @@ func TotpValidate(c *gin.Context) {
 // @Failure 404 {string} string "Not found"
 // @Router /api/v1/totp/disable [put]
 func TotpDisable(c *gin.Context) {
-	var request mailOperate
-
-	// Check the parameter trasnfer from POST
-	err := c.ShouldBindJSON(&request)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+	mail, ok := loginUser(c, "TOTP can only be managed by the login of the user.")
+	if !ok {
 		return
 	}
 
 	// Update TOTP enabled column status in database
-	errTotpUpdateEnabled := mariadb.TotpUpdateEnabled(request.Mail, false)
+	errTotpUpdateEnabled := mariadb.TotpUpdateEnabled(mail, false)
 	if errTotpUpdateEnabled != nil {
 		if errTotpUpdateEnabled == sql.ErrNoRows {
 			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1006, errTotpUpdateEnabled))
@@ -247,6 +324,184 @@ func TotpDisable(c *gin.Context) {
 		return
 	}
 
+	// The recovery codes belong to the TOTP, new codes are generated when
+	// TOTP is verified again
+	errDeleteRecoveryCodes := mariadb.DeleteTotpRecoveryCodes(mail)
+	if errDeleteRecoveryCodes != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, errDeleteRecoveryCodes))
+		return
+	}
+
 	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, nil))
 
 }
+
+// @Summary List TOTP Devices
+// @Description Show the TOTP devices of the user, the secrets are not shown.
+// @Tags totp
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/totp/devices [get]
+func TotpDeviceList(c *gin.Context) {
+	mail, ok := loginUser(c, "TOTP devices can only be managed by the login of the user.")
+	if !ok {
+		return
+	}
+
+	devices, err := mariadb.TotpDeviceList(mail)
+	if err != nil {
+		errorMessage := fmt.Sprintf("List TOTP devices failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"devices": devices,
+	}))
+}
+
+// @Summary TOTP Device QR Code
+// @Description Show the enrollment QR code of a TOTP device which has not been verified, as a PNG image or a data URI.
+// @Tags totp
+// @Accept application/json
+// @Produce image/png
+// @Produce application/json
+// @Param device_id path string true "Device ID"
+// @Param format query string false "png (default) or data-uri"
+// @Param size query int false "Width and height in pixels, default is 200"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/totp/devices/{device_id}/qrcode [get]
+func TotpDeviceQRCode(c *gin.Context) {
+	mail, ok := loginUser(c, "TOTP devices can only be managed by the login of the user.")
+	if !ok {
+		return
+	}
+
+	size, err := strconv.Atoi(c.DefaultQuery("size", "200"))
+	if err != nil || size < 100 || size > 1000 {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1104, map[string]interface{}{
+			"size": c.Query("size"),
+		}))
+		return
+	}
+
+	format := c.DefaultQuery("format", "png")
+	if format != "png" && format != "data-uri" {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1104, map[string]interface{}{
+			"format": format,
+		}))
+		return
+	}
+
+	devices, err := mariadb.TotpDeviceList(mail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	// The secret of a verified device is not shown again
+	deviceID := c.Param("device_id")
+	var device *mariadb.TotpDeviceInfo
+	for i := range devices {
+		if devices[i].DeviceID == deviceID && !devices[i].Verified {
+			device = &devices[i]
+		}
+	}
+	if device == nil {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1110, nil))
+		return
+	}
+
+	imageData, err := totp.QRCode(device.TotpURL, size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1009, err))
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	if format == "png" {
+		c.Data(http.StatusOK, "image/png", imageData)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"device_id": device.DeviceID,
+		"qr_code":   "data:image/png;base64," + base64.StdEncoding.EncodeToString(imageData),
+	}))
+}
+
+// @Summary Remove TOTP Device
+// @Description Delete a TOTP device of the user, TOTP is disabled when no verified device is left.
+// @Tags totp
+// @Accept application/json
+// @Produce application/json
+// @Param device_id path string true "Device ID"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/totp/devices/{device_id} [delete]
+func TotpDeviceDelete(c *gin.Context) {
+	mail, ok := loginUser(c, "TOTP devices can only be managed by the login of the user.")
+	if !ok {
+		return
+	}
+
+	deviceID := c.Param("device_id")
+
+	result, err := mariadb.TotpDeviceDelete(deviceID, mail)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Delete TOTP device failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1110, nil))
+		return
+	}
+
+	devices, err := mariadb.TotpDeviceList(mail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	totpEnabled := false
+	for _, device := range devices {
+		if device.Verified {
+			totpEnabled = true
+		}
+	}
+
+	// Without a verified device the user can't pass TOTP anymore
+	if !totpEnabled {
+		if err = mariadb.TotpUpdateVerify(mail, false, false); err != nil {
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+			return
+		}
+		if err = mariadb.DeleteTotpRecoveryCodes(mail); err != nil {
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"device_id":    deviceID,
+		"totp_enabled": totpEnabled,
+		"msg":          "TOTP device has been removed.",
+	}))
+}
@@ -2,20 +2,20 @@ package handlers
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"regexp"
 	mariadb "suglider-auth/internal/database"
 	smtp "suglider-auth/internal/mail"
-	"suglider-auth/internal/redis"
 	"suglider-auth/internal/utils"
 	"suglider-auth/pkg/encrypt"
 	fmtv "suglider-auth/pkg/fmt_validator"
 	"suglider-auth/pkg/jwt"
+	"suglider-auth/pkg/login_challenge"
+	"suglider-auth/pkg/refresh_token"
 	"suglider-auth/pkg/session"
-	"suglider-auth/pkg/time_convert"
+	"suglider-auth/pkg/token_denylist"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -171,11 +171,10 @@ func UserSignUp(c *gin.Context) {
 }
 
 // @Summary Delete Account
-// @Description delete an existing account.
+// @Description Delete the account of the login user.
 // @Tags users
-// @Accept multipart/form-data
+// @Accept application/json
 // @Produce application/json
-// @Param mail formData string false "Mail"
 // @Success 200 {string} string "Success"
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
@@ -183,16 +182,12 @@ func UserSignUp(c *gin.Context) {
 // @Failure 404 {string} string "Not found"
 // @Router /api/v1/user/delete [delete]
 func UserDelete(c *gin.Context) {
-	var request mailOperate
-
-	// Check the parameter trasnfer from POST
-	err := c.ShouldBindJSON(&request)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+	mail, ok := loginUser(c, "The account can only be deleted by the login of the user.")
+	if !ok {
 		return
 	}
 
-	result, err := mariadb.UserDeleteByMail(request.Mail)
+	result, err := mariadb.UserDeleteByMail(mail)
 	// First, check if error or not
 	if err != nil {
 		errorMessage := fmt.Sprintf("Delete user_info data failed: %v", err)
@@ -208,6 +203,13 @@ func UserDelete(c *gin.Context) {
 	if rowsAffected == 0 {
 		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1003))
 	} else if rowsAffected > 0 {
+		// The tokens and sessions of deleted user must not be used anymore
+		if err = token_denylist.RevokeUser(mail); err != nil {
+			errorMessage := fmt.Sprintf("Revoke tokens of user failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1078, err))
+			return
+		}
 		c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, nil))
 	}
 }
@@ -219,180 +221,102 @@ func UserDelete(c *gin.Context) {
 // @Produce application/json
 // @Param account formData string false "Enter mail or username"
 // @Param password formData string false "Password"
+// @Param X-Token-Mode header string false "Set to body to get the tokens in the response instead of cookies"
 // @Success 200 {string} string "Success"
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
 // @Failure 403 {string} string "Forbidden"
 // @Failure 404 {string} string "Not found"
 // @Router /api/v1/user/login [post]
-func UserLogin(c *gin.Context) {
-
-	mailValue, isMailExists := c.Get("mail")
-	passwordValue, isPasswordExists := c.Get("password")
-
-	if !isMailExists || !isPasswordExists {
-		slog.Error("It's either that the mail doesn't exist or password.")
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1067, nil))
-		return
-	}
-
-	// Convert interface to string
-	mail := fmt.Sprintf("%v", mailValue)
-	password := fmt.Sprintf("%v", passwordValue)
-
-	userInfo, err := mariadb.GetPasswordByMail(mail)
-
-	// No err means user exist
-	if err == nil && userInfo.Password.Valid {
+func UserLogin(csbn *CasbinEnforcerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mailValue, isMailExists := c.Get("mail")
+		passwordValue, isPasswordExists := c.Get("password")
+
+		if !isMailExists || !isPasswordExists {
+			slog.Error("It's either that the mail doesn't exist or password.")
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1067, nil))
+			return
+		}
 
-		// Check password true or false
-		pwdVerify := encrypt.VerifySaltedPasswordHash(userInfo.Password.String, password)
+		// Convert interface to string
+		mail := fmt.Sprintf("%v", mailValue)
+		password := fmt.Sprintf("%v", passwordValue)
 
-		// Password passed
-		if pwdVerify {
+		// The password is not checked while the account or the IP is locked
+		if loginBlocked(c, mail) {
+			return
+		}
 
-			// Check whether user enable 2FA or not.
-			userTwoFactorAuthData, err := mariadb.GetTwoFactorAuthByMail(userInfo.Mail)
+		userInfo, err := mariadb.GetPasswordByMail(mail)
 
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
-				return
-			}
+		// No err means user exist
+		if err == nil && userInfo.Password.Valid {
 
-			// These conditions indicate that user have enabled the 2FA feature.
-			if userTwoFactorAuthData.TotpEnabled.Bool ||
-				userTwoFactorAuthData.SmsOTPEnabled ||
-				userTwoFactorAuthData.MailOTPEnabled {
+			// Check password true or false
+			pwdVerify := encrypt.VerifySaltedPasswordHash(userInfo.Password.String, password)
 
-				// Store value into struct
-				userNameData := &UserName{
-					String: userInfo.Username.String,
-					Valid:  userInfo.Username.Valid,
-				}
+			// Password passed
+			if pwdVerify {
 
-				rdsValue := &rdsValeData{
-					Mail:           userInfo.Mail,
-					UserName:       *userNameData,
-					AccountPassed:  true,
-					MailOTPPassed:  false,
-					SmsOTPPassed:   false,
-					TotpPassed:     false,
-					TotpEnabled:    userTwoFactorAuthData.TotpEnabled.Bool,
-					MailOTPEnabled: userTwoFactorAuthData.MailOTPEnabled,
-					SmsOTPEnabled:  userTwoFactorAuthData.SmsOTPEnabled,
-				}
+				// Check whether user enable 2FA or not.
+				userTwoFactorAuthData, err := mariadb.GetTwoFactorAuthByMail(userInfo.Mail)
 
-				jsonData, err := json.Marshal(rdsValue)
 				if err != nil {
-					c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1063, err))
+					c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
 					return
 				}
 
-				redisTTL, _, err := time_convert.ConvertTimeFormat("15m")
-				if err != nil {
-					c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1069, err))
-					return
-				}
+				// These conditions indicate that user have enabled the 2FA feature.
+				if factors := loginFactors(userTwoFactorAuthData); len(factors) > 0 {
 
-				// Set login_status into redis
-				err = redis.Set("login_status:"+userInfo.Mail, string(jsonData), redisTTL)
+					startLoginChallenge(c, userInfo.Mail, userInfo.Username.String, factors)
 
-				if err != nil {
-					errorMessage := fmt.Sprintf("Redis SET data failed.: %v", err)
-					slog.Error(errorMessage)
-					c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1042, err))
-					return
+					// The user has not enabled the 2FA feature.
+				} else {
+					okSetSession := setSession(c, csbn, userInfo.Mail)
+					if !okSetSession {
+						return
+					}
+					okSetJWT := setJWT(c, userInfo.Mail)
+					if !okSetJWT {
+						return
+					}
+					clearLoginFailures(userInfo.Mail)
+					c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, withTokens(c, map[string]interface{}{
+						"mail":             userInfo.Mail,
+						"username":         userInfo.Username,
+						"totp_enabled":     userTwoFactorAuthData.TotpEnabled.Bool,
+						"mail_otp_enabled": userTwoFactorAuthData.MailOTPEnabled,
+						"sms_otp_enabled":  userTwoFactorAuthData.SmsOTPEnabled,
+					})))
 				}
-
-				c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
-					"mail":             userInfo.Mail,
-					"username":         userInfo.Username,
-					"totp_enabled":     userTwoFactorAuthData.TotpEnabled.Bool,
-					"mail_otp_enabled": userTwoFactorAuthData.MailOTPEnabled,
-					"sms_otp_enabled":  userTwoFactorAuthData.SmsOTPEnabled,
-				}))
-
-				// The user has not enabled the 2FA feature.
+				// Password is not correct.
 			} else {
-				okSetSession := setSession(c, userInfo.Mail)
-				if !okSetSession {
-					return
-				}
-				okSetJWT := setJWT(c, userInfo.Mail)
-				if !okSetJWT {
-					return
-				}
-				// Store value into struct
-				userNameData := &UserName{
-					String: userInfo.Username.String,
-					Valid:  userInfo.Username.Valid,
-				}
-
-				rdsValue := &rdsValeData{
-					Mail:           userInfo.Mail,
-					UserName:       *userNameData,
-					AccountPassed:  true,
-					MailOTPPassed:  false,
-					SmsOTPPassed:   false,
-					TotpPassed:     false,
-					TotpEnabled:    userTwoFactorAuthData.TotpEnabled.Bool,
-					MailOTPEnabled: userTwoFactorAuthData.MailOTPEnabled,
-					SmsOTPEnabled:  userTwoFactorAuthData.SmsOTPEnabled,
-				}
-
-				jsonData, err := json.Marshal(rdsValue)
-				if err != nil {
-					c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1063, err))
-					return
-				}
-
-				redisTTL, _, err := time_convert.ConvertTimeFormat("15m")
-				if err != nil {
-					c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1069, err))
-					return
-				}
-
-				// Set login_status into redis
-				err = redis.Set("login_status:"+userInfo.Mail, string(jsonData), redisTTL)
-
-				if err != nil {
-					errorMessage := fmt.Sprintf("Redis SET data failed.: %v", err)
-					slog.Error(errorMessage)
-					c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1042, err))
-					return
-				}
-
-				c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
-					"mail":             userInfo.Mail,
-					"username":         userInfo.Username,
-					"totp_enabled":     userTwoFactorAuthData.TotpEnabled.Bool,
-					"mail_otp_enabled": userTwoFactorAuthData.MailOTPEnabled,
-					"sms_otp_enabled":  userTwoFactorAuthData.SmsOTPEnabled,
-				}))
+				recordLoginFailure(c, userInfo.Mail, userInfo.Username.String)
+				c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1004))
+				return
 			}
-			// Password is not correct.
-		} else {
-			c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1004))
+		} else if err == nil && !userInfo.Password.Valid {
+			c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1004, map[string]interface{}{
+				"mail": mail,
+				"msg":  "Login failed: password is NULL, indicating the user had previously signed up through OAuth2.",
+			}))
+			return
+			// sql.ErrNoRows indicates that there were no results found for the username provided.
+		} else if err == sql.ErrNoRows {
+			errorMessage := fmt.Sprintf("User Login failed: %v", err)
+			slog.Error(errorMessage)
+			recordLoginFailure(c, "", "")
+			c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1003, err))
 			return
-		}
-	} else if err == nil && !userInfo.Password.Valid {
-		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1004, map[string]interface{}{
-			"mail": mail,
-			"msg":  "Login failed: password is NULL, indicating the user had previously signed up through OAuth2.",
-		}))
-		return
-		// sql.ErrNoRows indicates that there were no results found for the username provided.
-	} else if err == sql.ErrNoRows {
-		errorMessage := fmt.Sprintf("User Login failed: %v", err)
-		slog.Error(errorMessage)
-		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1003, err))
-		return
 
-	} else if err != nil {
-		errorMessage := fmt.Sprintf("Login failed: %v", err)
-		slog.Error(errorMessage)
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
-		return
+		} else if err != nil {
+			errorMessage := fmt.Sprintf("Login failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+			return
+		}
 	}
 }
 
@@ -402,6 +326,7 @@ func UserLogin(c *gin.Context) {
 // @Accept multipart/form-data
 // @Produce application/json
 // @Param mail formData string false "Mail"
+// @Param refresh_token formData string false "Refresh token, when the refresh_token cookie is not used"
 // @Success 200 {string} string "Success"
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
@@ -410,7 +335,7 @@ func UserLogin(c *gin.Context) {
 // @Router /api/v1/user/logout [post]
 func UserLogout(c *gin.Context) {
 
-	var request mailOperate
+	var request userLogout
 
 	// Check the parameter trasnfer from POST
 	err := c.ShouldBindJSON(&request)
@@ -419,18 +344,52 @@ func UserLogout(c *gin.Context) {
 		return
 	}
 
-	redisKey := "login_status:" + request.Mail
+	// Revoke and clear JWT
+	token, err := c.Cookie("token")
+	if bearer := utils.BearerToken(c); bearer != "" {
+		token, err = bearer, nil
+	}
+	var tokenSID string
+	if err == nil {
+		if claims, _, err := jwt.ParseJWT(token); err == nil {
+			err = token_denylist.RevokeToken(claims.ID, claims.ExpiresAt.Time)
+			if err != nil {
+				errorMessage := fmt.Sprintf("Revoke JWT failed: %v", err)
+				slog.Error(errorMessage)
+				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1078, err))
+				return
+			}
+			tokenSID = claims.SessionID
+		}
+	}
+	session.SetCookie(c, "token", "", -1)
 
-	err = redis.Delete("login_status:" + request.Mail)
-	if err != nil {
-		errorMessage := fmt.Sprintf("Delete key(%s) failed: %v", redisKey, err)
-		slog.Error(errorMessage)
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1234, err))
-		return
+	// A client without cookies has no session cookie, the login session is
+	// the one the token was issued for
+	if tokenSID != "" {
+		if err = revokeSession(tokenSID); err != nil {
+			errorMessage := fmt.Sprintf("Revoke session(sid:%s) failed: %v", tokenSID, err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1040, err))
+			return
+		}
 	}
 
-	// Clear JWT
-	c.SetCookie("token", "", -1, "/", "localhost", false, true)
+	// Revoke refresh token
+	refreshToken, err := c.Cookie("refresh_token")
+	if request.RefreshToken != "" {
+		refreshToken, err = request.RefreshToken, nil
+	}
+	if err == nil {
+		errCode, err := refresh_token.Revoke(refreshToken)
+		if err != nil && errCode != 1074 {
+			errorMessage := fmt.Sprintf("Revoke refresh token failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+			return
+		}
+		session.SetCookie(c, "refresh_token", "", -1)
+	}
 
 	// Read session
 	sid, _, errCode, err := session.ReadSession(c)
@@ -474,11 +433,61 @@ func UserLogout(c *gin.Context) {
 
 }
 
+// @Summary User Force Logout
+// @Description Revoke all tokens, refresh tokens and sessions of a user, it's used by administrators.
+// @Tags users
+// @Accept multipart/form-data
+// @Produce application/json
+// @Param mail formData string false "Mail"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/user/force-logout [post]
+func UserForceLogout(c *gin.Context) {
+
+	var request mailOperate
+
+	// Check the parameter trasnfer from POST
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+		return
+	}
+
+	count, err := mariadb.CheckMailExists(request.Mail)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Check whether the mail exists or not failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1046, err))
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1057, nil))
+		return
+	}
+
+	err = token_denylist.RevokeUser(request.Mail)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Revoke tokens of user failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1078, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"mail": request.Mail,
+		"msg":  "Force logout successful!",
+	}))
+}
+
 // @Summary User Refresh JWT
-// @Description user refresh JWT
+// @Description Exchange the refresh token for a new access token, the refresh token is rotated at the same time.
 // @Tags users
 // @Accept multipart/form-data
 // @Produce application/json
+// @Param X-Refresh-Token header string false "Refresh token, when the refresh_token cookie is not used"
 // @Success 200 {string} string "Success"
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
@@ -487,12 +496,20 @@ func UserLogout(c *gin.Context) {
 // @Router /api/v1/user/refresh [get]
 func RefreshJWT(c *gin.Context) {
 
-	cookie, err := c.Cookie("token")
+	cookie, err := c.Cookie("refresh_token")
+
+	// Clients without cookies send the refresh token by its own header, the
+	// Authorization header only carries access tokens. The new tokens are
+	// returned in the body as well.
+	bodyMode := tokenModeBody(c)
+	if header := c.GetHeader("X-Refresh-Token"); header != "" {
+		cookie, err, bodyMode = header, nil, true
+	}
 
 	if err != nil {
 		if err == http.ErrNoCookie {
 			c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1019, map[string]interface{}{
-				"msg": "JWT can't found.",
+				"msg": "Refresh token can't found.",
 			}))
 			return
 		}
@@ -500,27 +517,67 @@ func RefreshJWT(c *gin.Context) {
 		return
 	}
 
-	_, errCode, errParseJWT := jwt.ParseJWT(cookie)
+	// The family ID is the session ID of the login, the refresh token stops
+	// working once the session has expired or been signed out
+	_, familyID, errCode, err := refresh_token.Family(cookie)
 
-	if errParseJWT != nil {
+	switch errCode {
+	case 1074:
+		errorMessage := fmt.Sprintf("Refresh JWT failed: %v", err)
+		slog.Error(errorMessage)
+		session.SetCookie(c, "refresh_token", "", -1)
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, errCode, err))
+		return
 
-		switch errCode {
+	case 1044, 1063:
+		errorMessage := fmt.Sprintf("Refresh JWT failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return
+	}
 
-		case 1015:
-			c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, errCode, err))
-			return
+	data, _, errCode, err := session.GetSession(familyID)
+	if errCode == 0 {
+		// Refreshing is an activity of the session, and the new refresh token
+		// doesn't outlive the absolute timeout of the session
+		errCode, err = session.Touch(c, familyID)
+	}
 
-		case 1016:
-			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, errCode, err))
-			return
+	switch errCode {
+	case 1043:
+		expireRefreshFamily(c, familyID)
+		return
 
-		case 1017:
-			c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, errCode, err))
-			return
-		}
+	case 1044:
+		errorMessage := fmt.Sprintf("Redis GET data failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return
+
+	case 1040, 1042:
+		errorMessage := fmt.Sprintf("Update expiration of session failed: %v", err)
+		slog.Error(errorMessage)
 	}
 
-	token, expireTimeSec, err := jwt.RefreshJWT(cookie)
+	refreshToken, mail, familyID, refreshExpireTimeSec, errCode, err := refresh_token.Rotate(cookie, session.Lifetime(data))
+
+	switch errCode {
+	case 1074, 1075:
+		errorMessage := fmt.Sprintf("Refresh JWT failed: %v", err)
+		slog.Error(errorMessage)
+		session.SetCookie(c, "refresh_token", "", -1)
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, errCode, err))
+		return
+
+	case 1039, 1040, 1042, 1044, 1063, 1068, 1076:
+		errorMessage := fmt.Sprintf("Refresh JWT failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return
+	}
+
+	// The family ID is the session ID of the login
+	token, expireTimeSec, err := jwt.GenerateSessionJWT(mail, familyID)
 
 	if err != nil {
 		errorMessage := fmt.Sprintf("Generate new JWT failed: %v", err)
@@ -530,12 +587,40 @@ func RefreshJWT(c *gin.Context) {
 		return
 	}
 
-	// Set the new token as the users `token` cookie
-	c.SetCookie("token", token, expireTimeSec, "/", "localhost", false, true)
+	if bodyMode {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+			"access_token":       token,
+			"token_type":         "Bearer",
+			"expires_in":         expireTimeSec,
+			"refresh_token":      refreshToken,
+			"refresh_expires_in": refreshExpireTimeSec,
+		}))
+		return
+	}
+
+	// Set the new tokens as the users `token` and `refresh_token` cookie
+	session.SetCookie(c, "token", token, expireTimeSec)
+	session.SetCookie(c, "refresh_token", refreshToken, refreshExpireTimeSec)
 
 	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, nil))
 }
 
+// expireRefreshFamily rejects the refresh token of a session which has
+// expired, the other tokens of the family are revoked as well.
+func expireRefreshFamily(c *gin.Context, familyID string) {
+	if err := refresh_token.RevokeFamily(familyID); err != nil {
+		errorMessage := fmt.Sprintf("Revoke refresh token family failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1040, err))
+		return
+	}
+
+	session.SetCookie(c, "token", "", -1)
+	session.SetCookie(c, "refresh_token", "", -1)
+	c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1090, nil))
+}
+
 // @Summary User Password Expire Check
 // @Description Check whether a user's password has expired or not
 // @Tags users
@@ -728,7 +813,6 @@ func CheckMail(c *gin.Context) {
 // @Tags users
 // @Accept multipart/form-data
 // @Produce application/json
-// @Param mail formData string false "Mail"
 // @Param old_password formData string false "Old Password"
 // @Param new_password formData string false "New Password"
 // @Success 200 {string} string "Success"
@@ -740,6 +824,11 @@ func CheckMail(c *gin.Context) {
 func ChangePassword(c *gin.Context) {
 	var request resetPassword
 
+	mail, ok := loginUser(c, "The password can only be changed by the login of the user.")
+	if !ok {
+		return
+	}
+
 	// Check the parameter trasnfer from POST
 	err := c.ShouldBindJSON(&request)
 	if err != nil {
@@ -747,7 +836,7 @@ func ChangePassword(c *gin.Context) {
 		return
 	}
 
-	userInfo, err := mariadb.GetPasswordByMail(request.Mail)
+	userInfo, err := mariadb.GetPasswordByMail(mail)
 	// No err means user exist
 	if err == nil && userInfo.Password.Valid {
 
@@ -773,6 +862,15 @@ func ChangePassword(c *gin.Context) {
 						c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1027, err))
 						return
 					}
+
+					// Sign out everywhere with the old password
+					err = token_denylist.RevokeUser(userInfo.Mail)
+					if err != nil {
+						errorMessage := fmt.Sprintf("Revoke tokens of user failed: %v", err)
+						slog.Error(errorMessage)
+						c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1078, err))
+						return
+					}
 					c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
 						"mail": userInfo.Mail,
 						"msg":  "Change password successfully.",
@@ -793,7 +891,7 @@ func ChangePassword(c *gin.Context) {
 		}
 	} else if err == nil && !userInfo.Password.Valid {
 		c.JSON(http.StatusForbidden, utils.ErrorResponse(c, 1004, map[string]interface{}{
-			"mail": request.Mail,
+			"mail": mail,
 			"msg":  "Reset failed: password is invalid, indicating the user had previously signed up through OAuth2. Please sign up using genernal or use OAuth2 to login and setup password.",
 		}))
 		return
@@ -1033,14 +1131,29 @@ func CheckAuthValid(c *gin.Context) {
 	var jwtValid bool
 	jwtValid = true
 
-	// Check session valid(exist) or not
-	isExists, err := session.CheckSession(c)
+	// Check session valid(exist) or not, a session of a revoked user isn't
+	// valid even though it still exists
+	var isExists bool
+	if sid := session.CurrentSessionID(c); sid != "" {
+		data, _, errCode, err := session.GetSession(sid)
 
-	if err != nil {
-		errorMessage := fmt.Sprintf("Checking whether key exist or not happen something wrong: %v", err)
-		slog.Error(errorMessage)
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1039, err))
-		return
+		switch errCode {
+		case 0:
+			isRevoked, err := token_denylist.IsUserRevoked(data.Mail, session.IssuedAt(data))
+			if err != nil {
+				errorMessage := fmt.Sprintf("Check token denylist failed: %v", err)
+				slog.Error(errorMessage)
+				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1044, err))
+				return
+			}
+			isExists = !isRevoked
+
+		case 1044:
+			errorMessage := fmt.Sprintf("Checking whether key exist or not happen something wrong: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1039, err))
+			return
+		}
 	}
 
 	// Get client JWT
@@ -1052,14 +1165,23 @@ func CheckAuthValid(c *gin.Context) {
 		jwtValid = false
 	}
 
-	claims, errCode, errParseJWT := jwt.ParseJWT(cookie)
+	claims, _, errParseJWT := jwt.ParseJWT(cookie)
 
 	if errParseJWT != nil {
-		if errCode == 1015 || errCode == 1016 || errCode == 1017 {
-			jwtValid = false
-		}
-	} else if time.Now().Unix() > claims.ExpiresAt.Unix() {
 		jwtValid = false
+	} else {
+		var issuedAt time.Time
+		if claims.IssuedAt != nil {
+			issuedAt = claims.IssuedAt.Time
+		}
+		isRevoked, err := token_denylist.IsTokenRevoked(claims.ID, claims.SessionID, claims.Principal(), issuedAt)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Check token denylist failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1044, err))
+			return
+		}
+		jwtValid = !isRevoked
 	}
 
 	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
@@ -1070,11 +1192,11 @@ func CheckAuthValid(c *gin.Context) {
 }
 
 // @Summary Check Login Status
-// @Description Check user login status
+// @Description Check which 2FA methods of the login challenge have passed
 // @Tags users
 // @Accept multipart/form-data
 // @Produce application/json
-// @Param mail formData string false "Mail"
+// @Param challenge_id formData string false "Challenge ID from the login response"
 // @Success 200 {string} string "Success"
 // @Failure 400 {string} string "Bad request"
 // @Failure 401 {string} string "Unauthorized"
@@ -1082,8 +1204,7 @@ func CheckAuthValid(c *gin.Context) {
 // @Failure 404 {string} string "Not found"
 // @Router /api/v1/user/check-login-status [get]
 func CheckLoginStatus(c *gin.Context) {
-	var data rdsValeData
-	var request mailOperate
+	var request loginChallenge
 
 	// Check the parameter trasnfer from POST
 	err := c.ShouldBindJSON(&request)
@@ -1092,41 +1213,11 @@ func CheckLoginStatus(c *gin.Context) {
 		return
 	}
 
-	// Check whether login status exists in redis or not.
-	value, errCode, err := redis.Get("login_status:" + request.Mail)
-
-	switch errCode {
-	// Key not exists
-	case 1043:
-		errorMessage := fmt.Sprintf("Redis key does not exist: %v", err)
-		slog.Error(errorMessage)
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
-		return
-	case 1044:
-		errorMessage := fmt.Sprintf("Redis GET data failed: %v", err)
-		slog.Error(errorMessage)
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+	ch, errCode, err := login_challenge.Get(request.ChallengeID)
+	if err != nil {
+		challengeError(c, errCode, err)
 		return
-	// Key exists
-	case 0:
-		err := json.Unmarshal([]byte(value), &data)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1063, err))
-			return
-		}
-
-		c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
-			"mail":             data.Mail,
-			"username":         data.UserName,
-			"account_passed":   data.AccountPassed,
-			"mail_otp_passed":  data.MailOTPPassed,
-			"sms_otp_passed":   data.SmsOTPPassed,
-			"totp_passed":      data.TotpPassed,
-			"totp_enabled":     data.TotpEnabled,
-			"mail_otp_enabled": data.MailOTPEnabled,
-			"sms_otp_enabled":  data.SmsOTPEnabled,
-		}))
-
 	}
 
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, challengeStatus(ch)))
 }
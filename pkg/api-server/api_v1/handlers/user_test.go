@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+
+	mariadb "suglider-auth/internal/database"
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/encrypt"
+	"suglider-auth/pkg/jwt"
+	"suglider-auth/pkg/refresh_token"
+	"suglider-auth/pkg/session"
+	"suglider-auth/pkg/session_store"
+)
+
+type tokenResponse struct {
+	Code int64 `json:"code"`
+	Data struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+	} `json:"data"`
+}
+
+// tokenCookies returns the token cookies set by the response.
+func tokenCookies(w *httptest.ResponseRecorder) map[string]string {
+	cookies := map[string]string{}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "token" || cookie.Name == "refresh_token" {
+			cookies[cookie.Name] = cookie.Value
+		}
+	}
+	return cookies
+}
+
+// The login and refresh run against Redis for the tokens, the memory store
+// for the sessions and a mocked database for the user.
+func setupTokenTest(t *testing.T) (*gin.Engine, sqlmock.Sqlmock, session_store.SessionStore) {
+	gin.SetMode(gin.TestMode)
+
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+	store := session_store.NewMemoryStore()
+	session.SetStore(store)
+	if err := jwt.InitKeyRing(&jwt.KeyRingSettings{Algorithm: "ES256"}); err != nil {
+		t.Fatalf("Unit Test (Init key ring) Fail: %v\n", err)
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unit Test (Mock database) Fail: %v\n", err)
+	}
+	defaultDataBase := mariadb.DataBase
+	mariadb.DataBase = sqlx.NewDb(db, "mysql")
+	t.Cleanup(func() {
+		mariadb.DataBase = defaultDataBase
+		db.Close()
+	})
+
+	router := gin.New()
+	router.Use(sessions.Sessions("session-key", cookie.NewStore(session.KeyPairs()...)))
+	// The mail and password are set by the login form middleware in the server
+	router.POST("/user/login", func(c *gin.Context) {
+		c.Set("mail", "alice@example.com")
+		c.Set("password", "password")
+		UserLogin(nil)(c)
+	})
+	router.GET("/user/refresh", RefreshJWT)
+
+	return router, mock, store
+}
+
+func TestUserLoginTokenMode(t *testing.T) {
+	router, mock, _ := setupTokenTest(t)
+
+	password, err := encrypt.SaltedPasswordHash("password")
+	if err != nil {
+		t.Fatalf("Unit Test (Hash password) Fail: %v\n", err)
+	}
+	expectLogin := func() {
+		mock.ExpectQuery(`SELECT username, mail, password FROM suglider\.user_info WHERE mail=\?`).
+			WithArgs("alice@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"username", "mail", "password"}).
+				AddRow("alice", "alice@example.com", password))
+		mock.ExpectQuery(`SELECT totp\.user_id, user_info\.username ,user_info\.mail`).
+			WithArgs("alice@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "username", "mail", "totp_enabled", "mail_otp_enabled", "sms_otp_enabled", "webauthn_enabled"}).
+				AddRow(nil, "alice", "alice@example.com", nil, false, false, false))
+	}
+
+	t.Run("Test the tokens in the body", func(t *testing.T) {
+		expectLogin()
+
+		req := httptest.NewRequest(http.MethodPost, "/user/login", nil)
+		req.Header.Set("X-Token-Mode", "body")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The login must pass.")
+		}
+
+		var response tokenResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Unit Test (Unmarshal response) Fail: %v\n", err)
+		}
+		if response.Data.AccessToken == "" || response.Data.RefreshToken == "" || response.Data.TokenType != "Bearer" || response.Data.ExpiresIn <= 0 {
+			t.Errorf("Result: %s (%s)\n", w.Body.String(), "The tokens must be returned in the body.")
+		}
+		if cookies := tokenCookies(w); len(cookies) != 0 {
+			t.Errorf("Result: %v (%s)\n", cookies, "The tokens must not be set as cookies.")
+		}
+
+		claims, _, err := jwt.ParseJWT(response.Data.AccessToken)
+		if err != nil || claims.Mail != "alice@example.com" {
+			t.Errorf("Result: %v, %v (%s)\n", claims, err, "The access token must be of the user.")
+		}
+	})
+
+	t.Run("Test the tokens in cookies", func(t *testing.T) {
+		expectLogin()
+
+		req := httptest.NewRequest(http.MethodPost, "/user/login", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The login must pass.")
+		}
+
+		var response tokenResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Unit Test (Unmarshal response) Fail: %v\n", err)
+		}
+		if response.Data.AccessToken != "" || response.Data.RefreshToken != "" {
+			t.Errorf("Result: %s (%s)\n", w.Body.String(), "The tokens must not be returned in the body.")
+		}
+		if cookies := tokenCookies(w); cookies["token"] == "" || cookies["refresh_token"] == "" {
+			t.Errorf("Result: %v (%s)\n", cookies, "The tokens must be set as cookies.")
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unit Test (Database queries) Fail: %v\n", err)
+	}
+}
+
+func TestRefreshJWTHeader(t *testing.T) {
+	router, _, store := setupTokenTest(t)
+
+	now := time.Now().Unix()
+	data := session_store.SessionData{Mail: "alice@example.com", CreatedAt: now, LastSeen: now}
+	if err := store.Create("alice-phone", data, time.Hour); err != nil {
+		t.Fatalf("Unit Test (Create session) Fail: %v\n", err)
+	}
+	refreshToken, _, _, err := refresh_token.Issue("alice@example.com", "alice-phone", time.Hour)
+	if err != nil {
+		t.Fatalf("Unit Test (Issue refresh token) Fail: %v\n", err)
+	}
+
+	request := func(refreshToken string) (*httptest.ResponseRecorder, tokenResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/user/refresh", nil)
+		if refreshToken != "" {
+			req.Header.Set("X-Refresh-Token", refreshToken)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response tokenResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	t.Run("Test refresh by the header", func(t *testing.T) {
+		w, response := request(refreshToken)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The refresh token of the header must be accepted.")
+		}
+		if response.Data.AccessToken == "" || response.Data.RefreshToken == "" || response.Data.RefreshToken == refreshToken {
+			t.Errorf("Result: %s (%s)\n", w.Body.String(), "The new tokens must be returned in the body.")
+		}
+		if cookies := tokenCookies(w); len(cookies) != 0 {
+			t.Errorf("Result: %v (%s)\n", cookies, "The tokens must not be set as cookies.")
+		}
+		if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "no-store" {
+			t.Errorf("Result: %s (%s)\n", cacheControl, "The tokens must not be cached.")
+		}
+
+		claims, _, err := jwt.ParseJWT(response.Data.AccessToken)
+		if err != nil || claims.Mail != "alice@example.com" || claims.SessionID != "alice-phone" {
+			t.Errorf("Result: %v, %v (%s)\n", claims, err, "The access token must be bound to the session.")
+		}
+	})
+
+	t.Run("Test the rotated token is rejected", func(t *testing.T) {
+		if w, response := request(refreshToken); w.Code != http.StatusUnauthorized || response.Code != 1075 {
+			t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The reused refresh token must be rejected.")
+		}
+	})
+
+	t.Run("Test no refresh token", func(t *testing.T) {
+		if w, response := request(""); w.Code != http.StatusUnauthorized || response.Code != 1019 {
+			t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The refresh token is required.")
+		}
+	})
+}
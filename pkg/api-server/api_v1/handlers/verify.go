@@ -12,6 +12,7 @@ import (
 	"suglider-auth/internal/utils"
 	"suglider-auth/pkg/encrypt"
 	fmtv "suglider-auth/pkg/fmt_validator"
+	"suglider-auth/pkg/token_denylist"
 )
 
 // @Summary Verify Email Address
@@ -149,6 +150,10 @@ func RestUserPassword(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1027, err))
 			return
 		}
+		if err = token_denylist.RevokeUser(mail); err != nil {
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1078, err))
+			return
+		}
 	}
 
 	c.JSON(
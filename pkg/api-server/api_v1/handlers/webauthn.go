@@ -0,0 +1,465 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	mariadb "suglider-auth/internal/database"
+	"suglider-auth/internal/utils"
+	"suglider-auth/pkg/encrypt"
+	"suglider-auth/pkg/login_challenge"
+	"suglider-auth/pkg/passkey"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// passkeyOwner returns the mail of the user who manages the passkeys, like
+// API keys they can only be managed by the login of the user.
+func passkeyOwner(c *gin.Context) (string, bool) {
+	return loginUser(c, "Passkeys can only be managed by the login of the user.")
+}
+
+func checkWebAuthnEnabled(c *gin.Context) bool {
+	if !passkey.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, utils.ErrorResponse(c, 1099, nil))
+		return false
+	}
+	return true
+}
+
+// loadPasskeyUser returns the user of the WebAuthn ceremonies with the stored
+// credentials, the error is sql.ErrNoRows when the user doesn't exist.
+func loadPasskeyUser(mail string) (*passkey.User, []mariadb.WebAuthnCredentialInfo, error) {
+	userIDInfo, err := mariadb.LookupUserID(mail)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	credentialInfo, err := mariadb.ListWebAuthnCredentials(mail)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	credentials := make([]string, 0, len(credentialInfo))
+	for _, info := range credentialInfo {
+		credentials = append(credentials, info.Credential)
+	}
+
+	user, err := passkey.NewUser(userIDInfo.UserID, mail, "", credentials)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, credentialInfo, nil
+}
+
+func passkeyUserError(c *gin.Context, err error) {
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1057, err))
+		return
+	}
+	errorMessage := fmt.Sprintf("Load WebAuthn credentials failed: %v", err)
+	slog.Error(errorMessage)
+	c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+}
+
+// updatePasskey saves the sign count of the credential after a login.
+func updatePasskey(cred *webauthn.Credential) {
+	jsonData, err := json.Marshal(cred)
+	if err == nil {
+		err = mariadb.UpdateWebAuthnCredential(cred.ID, string(jsonData))
+	}
+	if err != nil {
+		errorMessage := fmt.Sprintf("Update WebAuthn credential failed: %v", err)
+		slog.Error(errorMessage)
+	}
+}
+
+// @Summary Begin Passkey Registration
+// @Description Start to register a passkey of the user, the options are passed to navigator.credentials.create().
+// @Tags webauthn
+// @Accept application/json
+// @Produce application/json
+// @Param name body string true "Passkey Name"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/webauthn/register/begin [post]
+func WebAuthnRegisterBegin(c *gin.Context) {
+	var request webAuthnRegister
+
+	if !checkWebAuthnEnabled(c) {
+		return
+	}
+
+	mail, ok := passkeyOwner(c)
+	if !ok {
+		return
+	}
+
+	// Check the parameter trasnfer from POST
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+		return
+	}
+
+	user, credentialInfo, err := loadPasskeyUser(mail)
+	if err != nil {
+		passkeyUserError(c, err)
+		return
+	}
+
+	for _, info := range credentialInfo {
+		if info.Name == request.Name {
+			c.JSON(http.StatusConflict, utils.ErrorResponse(c, 1108, map[string]interface{}{
+				"name": request.Name,
+			}))
+			return
+		}
+	}
+
+	creation, ceremonyID, errCode, err := passkey.BeginRegistration(user, request.Name)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Begin WebAuthn registration failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"ceremony_id": ceremonyID,
+		"options":     creation,
+	}))
+}
+
+// @Summary Finish Passkey Registration
+// @Description Verify the response of navigator.credentials.create() and save the passkey.
+// @Tags webauthn
+// @Accept application/json
+// @Produce application/json
+// @Param ceremony_id query string true "Ceremony ID from the begin response"
+// @Param credential body string true "The PublicKeyCredential from the browser"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/webauthn/register/finish [post]
+func WebAuthnRegisterFinish(c *gin.Context) {
+	if !checkWebAuthnEnabled(c) {
+		return
+	}
+
+	mail, ok := passkeyOwner(c)
+	if !ok {
+		return
+	}
+
+	ceremony, errCode, err := passkey.TakeCeremony(c.Query("ceremony_id"), passkey.CeremonyRegistration)
+	if err != nil {
+		if errCode == 1105 {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, errCode, nil))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+		return
+	}
+	if ceremony.Mail != mail {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1105, nil))
+		return
+	}
+
+	user, _, err := loadPasskeyUser(mail)
+	if err != nil {
+		passkeyUserError(c, err)
+		return
+	}
+
+	cred, err := passkey.FinishRegistration(user, ceremony, c.Request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1106, err))
+		return
+	}
+
+	jsonData, err := json.Marshal(cred)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1063, err))
+		return
+	}
+
+	credentialID := encrypt.GenertateUUID(true)
+	err = mariadb.InsertWebAuthnCredential(credentialID, mail, ceremony.Name, cred.ID, string(jsonData))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1057, nil))
+		return
+	} else if err != nil {
+		errorMessage := fmt.Sprintf("Insert WebAuthn credential failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"credential_id": credentialID,
+		"name":          ceremony.Name,
+	}))
+}
+
+// @Summary List Passkeys
+// @Description Show the passkeys of the user.
+// @Tags webauthn
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/webauthn/credentials [get]
+func WebAuthnCredentialList(c *gin.Context) {
+	mail, ok := passkeyOwner(c)
+	if !ok {
+		return
+	}
+
+	credentialInfo, err := mariadb.ListWebAuthnCredentials(mail)
+	if err != nil {
+		errorMessage := fmt.Sprintf("List WebAuthn credentials failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"credentials": credentialInfo,
+	}))
+}
+
+// @Summary Delete Passkey
+// @Description Delete a passkey of the user, it can't be used to log in anymore.
+// @Tags webauthn
+// @Accept application/json
+// @Produce application/json
+// @Param credential_id path string true "Credential ID"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/webauthn/credentials/{credential_id} [delete]
+func WebAuthnCredentialDelete(c *gin.Context) {
+	mail, ok := passkeyOwner(c)
+	if !ok {
+		return
+	}
+
+	credentialID := c.Param("credential_id")
+
+	result, err := mariadb.DeleteWebAuthnCredential(credentialID, mail)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Delete WebAuthn credential failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse(c, 1107, nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"credential_id": credentialID,
+		"msg":           "Passkey has been deleted.",
+	}))
+}
+
+// @Summary Begin Passkey Login
+// @Description Start to log in with a passkey, the options are passed to navigator.credentials.get(). With challenge_id the passkey is the second factor of the login, otherwise it's a passwordless login.
+// @Tags webauthn
+// @Accept application/json
+// @Produce application/json
+// @Param challenge_id body string false "Challenge ID from the login response"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/webauthn/login/begin [post]
+func WebAuthnLoginBegin(c *gin.Context) {
+	var request webAuthnLogin
+
+	if !checkWebAuthnEnabled(c) {
+		return
+	}
+
+	// The body is optional for a passwordless login
+	err := c.ShouldBindJSON(&request)
+	if err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse(c, 1001, err))
+		return
+	}
+
+	var (
+		assertion  interface{}
+		ceremonyID string
+		errCode    int64
+	)
+
+	if request.ChallengeID != "" {
+		ch, ok := readChallenge(c, request.ChallengeID, login_challenge.FactorWebAuthn)
+		if !ok {
+			return
+		}
+
+		user, _, err := loadPasskeyUser(ch.Mail)
+		if err != nil {
+			passkeyUserError(c, err)
+			return
+		}
+
+		assertion, ceremonyID, errCode, err = passkey.BeginLogin(user, request.ChallengeID)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Begin WebAuthn login failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+			return
+		}
+	} else {
+		assertion, ceremonyID, errCode, err = passkey.BeginDiscoverableLogin()
+		if err != nil {
+			errorMessage := fmt.Sprintf("Begin WebAuthn login failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+			return
+		}
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, map[string]interface{}{
+		"ceremony_id": ceremonyID,
+		"options":     assertion,
+	}))
+}
+
+// @Summary Finish Passkey Login
+// @Description Verify the response of navigator.credentials.get(). As the second factor it completes the login challenge, a passwordless login is completed at once.
+// @Tags webauthn
+// @Accept application/json
+// @Produce application/json
+// @Param ceremony_id query string true "Ceremony ID from the begin response"
+// @Param credential body string true "The PublicKeyCredential from the browser"
+// @Param X-Token-Mode header string false "Set to body to get the tokens in the response instead of cookies"
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /api/v1/webauthn/login/finish [post]
+func WebAuthnLoginFinish(csbn *CasbinEnforcerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkWebAuthnEnabled(c) {
+			return
+		}
+
+		ceremony, errCode, err := passkey.TakeCeremony(c.Query("ceremony_id"), passkey.CeremonyLogin)
+		if err != nil {
+			if errCode == 1105 {
+				c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, errCode, nil))
+				return
+			}
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
+			return
+		}
+
+		// The passkey is the second factor of the login challenge
+		if ceremony.ChallengeID != "" {
+			ch, ok := readChallenge(c, ceremony.ChallengeID, login_challenge.FactorWebAuthn)
+			if !ok {
+				return
+			}
+
+			user, _, err := loadPasskeyUser(ch.Mail)
+			if err != nil {
+				passkeyUserError(c, err)
+				return
+			}
+
+			_, cred, err := passkey.FinishLogin(user, ceremony, c.Request, nil)
+			verified := err == nil
+			if verified {
+				updatePasskey(cred)
+			}
+
+			ch, ok = finishChallengeFactor(c, csbn, ceremony.ChallengeID, login_challenge.FactorWebAuthn, verified)
+			if !ok {
+				return
+			}
+
+			c.Set("webauthn_verify", verified)
+			c.Set("login_challenge", ch)
+			loginVerified(c, "webauthn_verify")
+			return
+		}
+
+		// A passwordless login, the authenticator has verified the user so the
+		// passkey replaces both the password and the second factor. The failed
+		// assertions count to the lockout like wrong passwords.
+		if loginBlocked(c, "") {
+			return
+		}
+
+		var (
+			mail     string
+			userName sql.NullString
+			blocked  bool
+		)
+		user, cred, err := passkey.FinishLogin(nil, ceremony, c.Request, func(rawID []byte) (*passkey.User, error) {
+			info, err := mariadb.GetWebAuthnCredentialByRawID(rawID)
+			if err != nil {
+				return nil, err
+			}
+			mail, userName = info.Mail, info.UserName
+
+			// The assertion isn't verified while the account is locked, the
+			// response has been written by loginBlocked
+			if loginBlocked(c, info.Mail) {
+				blocked = true
+				return nil, errors.New("the login of the account is blocked")
+			}
+
+			user, _, err := loadPasskeyUser(info.Mail)
+			return user, err
+		})
+		if blocked {
+			return
+		}
+		if err != nil {
+			recordLoginFailure(c, mail, userName.String)
+			c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1106, err))
+			return
+		}
+		updatePasskey(cred)
+		clearLoginFailures(user.Mail)
+
+		if !setSession(c, csbn, user.Mail) || !setJWT(c, user.Mail) {
+			return
+		}
+
+		c.JSON(http.StatusOK, utils.SuccessResponse(c, 200, withTokens(c, map[string]interface{}{
+			"mail":            user.Mail,
+			"username":        userName,
+			"login_completed": true,
+		})))
+	}
+}
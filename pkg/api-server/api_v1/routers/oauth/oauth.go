@@ -6,10 +6,12 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func OAuthHandler(router *gin.RouterGroup) {
+type CasbinEnforcerConfig = handlers.CasbinEnforcerConfig
+
+func OAuthHandler(router *gin.RouterGroup, csbn *CasbinEnforcerConfig) {
 
 	router.GET("/google/login", handlers.OAuthGoogleLogin)
 	router.GET("/google/sign-up", handlers.OAuthGoogleSignUp)
-	router.GET("/google/callback", handlers.OAuthGoogleCallback)
-	router.POST("/google/verify", handlers.OAuthGoogleVerification)
+	router.GET("/google/callback", handlers.OAuthGoogleCallback(csbn))
+	router.POST("/google/verify", handlers.OAuthGoogleVerification(csbn))
 }
@@ -0,0 +1,19 @@
+package oauth2
+
+import (
+	"suglider-auth/pkg/api-server/api_v1/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CasbinEnforcerConfig = handlers.CasbinEnforcerConfig
+
+func OAuth2Handler(router *gin.RouterGroup, csbn *CasbinEnforcerConfig) {
+
+	router.POST("/token", handlers.OAuth2Token)
+	router.POST("/introspect", handlers.OAuth2Introspect(csbn))
+	router.POST("/revoke", handlers.OAuth2Revoke)
+	router.POST("/client", handlers.OAuth2RegisterClient)
+	router.GET("/clients", handlers.OAuth2ListClients)
+	router.DELETE("/client/:client_id", handlers.OAuth2DeleteClient)
+}
@@ -0,0 +1,19 @@
+package oidc
+
+import (
+	"suglider-auth/pkg/api-server/api_v1/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func OidcHandler(router *gin.RouterGroup) {
+
+	router.GET("/authorize", handlers.OidcAuthorize)
+	router.POST("/token", handlers.OidcToken)
+	router.GET("/userinfo", handlers.OidcUserInfo)
+	router.POST("/userinfo", handlers.OidcUserInfo)
+	router.GET("/jwks", handlers.OidcJWKS)
+	router.POST("/client", handlers.OidcRegisterClient)
+	router.GET("/clients", handlers.OidcListClients)
+	router.DELETE("/client/:client_id", handlers.OidcDeleteClient)
+}
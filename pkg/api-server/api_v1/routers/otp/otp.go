@@ -6,10 +6,18 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func OtpHandler(router *gin.RouterGroup) {
+type CasbinEnforcerConfig = handlers.CasbinEnforcerConfig
 
-	router.PUT("/mail/enable", handlers.MailOTPEnable)
-	router.PUT("/mail/disable", handlers.MailOTPDisable)
+func OtpHandler(router *gin.RouterGroup, csbn *CasbinEnforcerConfig) {
+
+	router.POST("/mail/setup", handlers.MailOTPSetup)
+	router.PUT("/mail/enable", handlers.RequireRecentAuth(), handlers.MailOTPEnable)
+	router.PUT("/mail/disable", handlers.RequireRecentAuth(), handlers.MailOTPDisable)
 	router.POST("/mail/send", handlers.MailOTPSend)
-	router.GET("/mail/verify", handlers.ValidateMailOTP(), handlers.MailOTPVerify)
+	router.GET("/mail/verify", handlers.ValidateMailOTP(csbn), handlers.MailOTPVerify)
+	router.POST("/sms/setup", handlers.SmsOTPSetup)
+	router.PUT("/sms/enable", handlers.RequireRecentAuth(), handlers.SmsOTPEnable)
+	router.PUT("/sms/disable", handlers.RequireRecentAuth(), handlers.SmsOTPDisable)
+	router.POST("/sms/send", handlers.SmsOTPSend)
+	router.POST("/sms/verify", handlers.ValidateSmsOTP(csbn), handlers.SmsOTPVerify)
 }
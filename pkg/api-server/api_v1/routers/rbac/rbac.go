@@ -14,10 +14,10 @@ func RbacHandlers(router *gin.RouterGroup, csbn *CasbinEnforcerConfig) {
 	router.GET("/members", handlers.CasbinListMembers(csbn))
 	router.GET("/role/:role", handlers.CasbinGetMembersWithRole(csbn))
 	router.GET("/member/:member", handlers.CasbinGetRolesOfMember(csbn))
-	router.POST("/policy/add", handlers.CasbinAddPolicy(csbn))
-	router.POST("/grouping/add", handlers.CasbinAddGroupingPolicy(csbn))
-	router.DELETE("/policy/delete", handlers.CasbinDeleteSinglePolicy(csbn))
-	router.DELETE("/grouping/delete", handlers.CasbinDeleteSingleGroupingPolicy(csbn))
-	router.DELETE("/policy/:role/delete", handlers.CasbinDeletePolicy(csbn))
-	router.DELETE("/grouping/:member/delete", handlers.CasbinDeleteGroupingPolicy(csbn))
+	router.POST("/policy/add", handlers.RequireRecentAuth(), handlers.CasbinAddPolicy(csbn))
+	router.POST("/grouping/add", handlers.RequireRecentAuth(), handlers.CasbinAddGroupingPolicy(csbn))
+	router.DELETE("/policy/delete", handlers.RequireRecentAuth(), handlers.CasbinDeleteSinglePolicy(csbn))
+	router.DELETE("/grouping/delete", handlers.RequireRecentAuth(), handlers.CasbinDeleteSingleGroupingPolicy(csbn))
+	router.DELETE("/policy/:role/delete", handlers.RequireRecentAuth(), handlers.CasbinDeletePolicy(csbn))
+	router.DELETE("/grouping/:member/delete", handlers.RequireRecentAuth(), handlers.CasbinDeleteGroupingPolicy(csbn))
 }
@@ -1,11 +1,15 @@
 package routers
 
 import (
+	"suglider-auth/pkg/api-server/api_v1/handlers"
 	"suglider-auth/pkg/api-server/api_v1/routers/oauth"
+	"suglider-auth/pkg/api-server/api_v1/routers/oauth2"
+	"suglider-auth/pkg/api-server/api_v1/routers/oidc"
 	"suglider-auth/pkg/api-server/api_v1/routers/otp"
 	"suglider-auth/pkg/api-server/api_v1/routers/rbac"
 	"suglider-auth/pkg/api-server/api_v1/routers/totp"
 	"suglider-auth/pkg/api-server/api_v1/routers/user"
+	"suglider-auth/pkg/api-server/api_v1/routers/webauthn"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,9 +17,10 @@ import (
 type CasbinEnforcerConfig = rbac.CasbinEnforcerConfig
 
 func Apiv1Handler(router *gin.RouterGroup, csbn *CasbinEnforcerConfig) {
+	router.GET("/csrf", handlers.CSRFToken)
 	userRouter := router.Group("/user")
 	{
-		user.UserHandler(userRouter)
+		user.UserHandler(userRouter, csbn)
 	}
 	rbacRouter := router.Group("/rbac")
 	{
@@ -23,14 +28,26 @@ func Apiv1Handler(router *gin.RouterGroup, csbn *CasbinEnforcerConfig) {
 	}
 	totpRouter := router.Group("/totp")
 	{
-		totp.TotpHandler(totpRouter)
+		totp.TotpHandler(totpRouter, csbn)
 	}
 	otpRouter := router.Group("/otp")
 	{
-		otp.OtpHandler(otpRouter)
+		otp.OtpHandler(otpRouter, csbn)
+	}
+	webAuthnRouter := router.Group("/webauthn")
+	{
+		webauthn.WebAuthnHandler(webAuthnRouter, csbn)
 	}
 	oauthRouter := router.Group("/oauth")
 	{
-		oauth.OAuthHandler(oauthRouter)
+		oauth.OAuthHandler(oauthRouter, csbn)
+	}
+	oauth2Router := router.Group("/oauth2")
+	{
+		oauth2.OAuth2Handler(oauth2Router, csbn)
+	}
+	oidcRouter := router.Group("/oidc")
+	{
+		oidc.OidcHandler(oidcRouter)
 	}
 }
@@ -6,10 +6,17 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func TotpHandler(router *gin.RouterGroup) {
+type CasbinEnforcerConfig = handlers.CasbinEnforcerConfig
 
-	router.POST("/generate", handlers.TotpGenerate)
-	router.PATCH("/verify", handlers.TotpVerify)
-	router.POST("/validate", handlers.ValidateTOTP(), handlers.TotpValidate)
-	router.PUT("/disable", handlers.TotpDisable)
+func TotpHandler(router *gin.RouterGroup, csbn *CasbinEnforcerConfig) {
+
+	router.POST("/generate", handlers.RequireRecentAuth(), handlers.TotpGenerate)
+	router.PATCH("/verify", handlers.RequireRecentAuth(), handlers.TotpVerify)
+	router.POST("/validate", handlers.ValidateTOTP(csbn), handlers.TotpValidate)
+	router.PUT("/disable", handlers.RequireRecentAuth(), handlers.TotpDisable)
+	router.GET("/recovery-codes", handlers.TotpRecoveryCodesStatus)
+	router.POST("/recovery-codes", handlers.RequireRecentAuth(), handlers.TotpRecoveryCodesRegenerate)
+	router.GET("/devices", handlers.TotpDeviceList)
+	router.GET("/devices/:device_id/qrcode", handlers.TotpDeviceQRCode)
+	router.DELETE("/devices/:device_id", handlers.RequireRecentAuth(), handlers.TotpDeviceDelete)
 }
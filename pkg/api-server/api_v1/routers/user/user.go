@@ -6,12 +6,15 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func UserHandler(router *gin.RouterGroup) {
+type CasbinEnforcerConfig = handlers.CasbinEnforcerConfig
+
+func UserHandler(router *gin.RouterGroup, csbn *CasbinEnforcerConfig) {
 
 	router.POST("/sign-up", handlers.UserSignUp)
-	router.DELETE("/delete", handlers.UserDelete)
-	router.POST("/login", handlers.LoginStatusCheck(), handlers.UserLogin)
+	router.DELETE("/delete", handlers.RequireRecentAuth(), handlers.UserDelete)
+	router.POST("/login", handlers.LoginStatusCheck(), handlers.UserLogin(csbn))
 	router.POST("/logout", handlers.UserLogout)
+	router.POST("/force-logout", handlers.RequireRecentAuth(), handlers.UserForceLogout)
 	router.GET("/password-expire", handlers.PasswordExpire)
 	router.PATCH("/password-extension", handlers.PasswordExtension)
 	router.GET("/refresh", handlers.RefreshJWT)
@@ -22,9 +25,28 @@ func UserHandler(router *gin.RouterGroup) {
 	router.GET("/check-username", handlers.CheckUserName)
 	router.GET("/check-mail", handlers.CheckMail)
 	router.GET("/check-phone-number", handlers.CheckPhoneNumber)
-	router.PATCH("/change-password", handlers.ChangePassword)
+	router.PATCH("/change-password", handlers.RequireRecentAuth(), handlers.ChangePassword)
 	router.PATCH("/setup-password", handlers.SetUpPassword)
 	router.PUT("/update-personal-info", handlers.UpdatePersonalInfo)
 	router.GET("/check-auth-valid", handlers.CheckAuthValid)
 	router.GET("/check-login-status", handlers.CheckLoginStatus)
+	router.POST("/api-keys", handlers.RequireRecentAuth(), handlers.APIKeyCreate)
+	router.GET("/api-keys", handlers.APIKeyList)
+	router.PATCH("/api-keys/:key_id", handlers.RequireRecentAuth(), handlers.APIKeyRename)
+	router.DELETE("/api-keys/:key_id", handlers.APIKeyRevoke)
+	router.GET("/sessions", handlers.SessionList)
+	router.DELETE("/sessions", handlers.SessionRevokeOthers)
+	router.DELETE("/sessions/:id", handlers.SessionRevoke)
+	router.GET("/member-sessions/:mail", handlers.MemberSessionList)
+	router.DELETE("/member-sessions/:mail", handlers.MemberSessionRevokeAll)
+	router.DELETE("/member-sessions/:mail/:id", handlers.MemberSessionRevoke)
+	router.POST("/unlock", handlers.UnlockAccount)
+	router.POST("/magic-link", handlers.MagicLinkSend)
+	router.POST("/magic-link/login", handlers.MagicLinkLogin(csbn))
+	router.POST("/reauth", handlers.Reauthenticate)
+	router.POST("/reauth/send", handlers.ReauthSend)
+	router.POST("/reauth/passkey/begin", handlers.ReauthPasskeyBegin)
+	router.POST("/reauth/passkey/finish", handlers.ReauthPasskeyFinish)
+	router.GET("/lockouts", handlers.LockoutList)
+	router.DELETE("/lockouts/:scope/:subject", handlers.LockoutClear)
 }
@@ -0,0 +1,19 @@
+package webauthn
+
+import (
+	"suglider-auth/pkg/api-server/api_v1/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CasbinEnforcerConfig = handlers.CasbinEnforcerConfig
+
+func WebAuthnHandler(router *gin.RouterGroup, csbn *CasbinEnforcerConfig) {
+
+	router.POST("/register/begin", handlers.WebAuthnRegisterBegin)
+	router.POST("/register/finish", handlers.WebAuthnRegisterFinish)
+	router.GET("/credentials", handlers.WebAuthnCredentialList)
+	router.DELETE("/credentials/:credential_id", handlers.RequireRecentAuth(), handlers.WebAuthnCredentialDelete)
+	router.POST("/login/begin", handlers.WebAuthnLoginBegin)
+	router.POST("/login/finish", handlers.WebAuthnLoginFinish(csbn))
+}
@@ -3,6 +3,9 @@ package api_server
 import (
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"path"
+	"suglider-auth/pkg/jwt"
+	"suglider-auth/pkg/oidc"
 )
 
 // @Summary Show Information (Simple Health Check)
@@ -23,3 +26,55 @@ func (aa *AuthApiSettings) healthzHandler(c *gin.Context) {
 		"X-FORWARDED-FOR": c.Request.Header.Get("X-Forwarded-For"),
 	})
 }
+
+// @Summary JSON Web Key Set
+// @Description show the public keys which can verify the JWT issued by this server
+// @Tags general
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not found"
+// @Router /.well-known/jwks.json [get]
+func (aa *AuthApiSettings) jwksHandler(c *gin.Context) {
+	// Retired keys stay in the set until tokens signed by them have expired,
+	// so a short cache is enough for verifiers to pick up a rotation.
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwt.GetKeyRing().JWKS())
+}
+
+// @Summary OpenID Provider Configuration
+// @Description show the OpenID Connect discovery document of this server
+// @Tags oidc
+// @Accept application/json
+// @Produce application/json
+// @Success 200 {string} string "Success"
+// @Failure 404 {string} string "Not found"
+// @Router /.well-known/openid-configuration [get]
+func (aa *AuthApiSettings) openidConfigurationHandler(c *gin.Context) {
+	// The subpath is "/" by default, join it so the endpoints have no "//"
+	endpoint := oidc.Issuer + path.Join("/", aa.SubpathPrefix, "api/v1/oidc")
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                oidc.Issuer,
+		"authorization_endpoint":                endpoint + "/authorize",
+		"token_endpoint":                        endpoint + "/token",
+		"userinfo_endpoint":                     endpoint + "/userinfo",
+		"jwks_uri":                              endpoint + "/jwks",
+		"scopes_supported":                      oidc.SupportedScopes,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jwt.GetKeyRing().Algorithm()},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"name", "given_name", "family_name", "preferred_username",
+			"email", "email_verified",
+		},
+	})
+}
@@ -0,0 +1,13 @@
+package api_server
+
+import (
+	"os"
+	"testing"
+
+	"suglider-auth/configs/configtest"
+)
+
+func TestMain(m *testing.M) {
+	configtest.Load()
+	os.Exit(m.Run())
+}
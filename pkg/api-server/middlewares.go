@@ -1,13 +1,23 @@
 package api_server
 
 import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"suglider-auth/configs"
+	mariadb "suglider-auth/internal/database"
 	"suglider-auth/internal/utils"
+	"suglider-auth/pkg/encrypt"
 	"suglider-auth/pkg/jwt"
+	"suglider-auth/pkg/rate_limit"
 	"suglider-auth/pkg/rbac"
 	"suglider-auth/pkg/session"
+	"suglider-auth/pkg/token_denylist"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -18,6 +28,10 @@ func userPrivilege(csbn *rbac.CasbinEnforcerConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		sub, exist := c.Get("mail")
 
+		// Machine clients are granted permissions by the client ID
+		if !exist {
+			sub, exist = c.Get("client_id")
+		}
 		if !exist {
 			sub = "anonymous"
 		}
@@ -29,6 +43,7 @@ func userPrivilege(csbn *rbac.CasbinEnforcerConfig) gin.HandlerFunc {
 			"/sing-up",
 			"/api/v1/user/login",
 			"/api/v1/user/logout",
+			"/api/v1/user/refresh",
 			"/api/v1/user/sing-up",
 			"/api/v1/user/forgot-password",
 			"/api/v1/user/verify-mail",
@@ -38,13 +53,29 @@ func userPrivilege(csbn *rbac.CasbinEnforcerConfig) gin.HandlerFunc {
 			"/api/v1/user/check-phone-number",
 			"/api/v1/user/check-auth-valid",
 			"/api/v1/user/check-login-status",
+			"/api/v1/user/unlock",
+			"/api/v1/user/magic-link",
+			"/api/v1/user/magic-link/login",
 			"/api/v1/totp/validate",
 			"/api/v1/otp/mail/verify",
 			"/api/v1/otp/mail/send",
+			"/api/v1/otp/sms/verify",
+			"/api/v1/otp/sms/send",
+			"/api/v1/webauthn/login/begin",
+			"/api/v1/webauthn/login/finish",
 			"/api/v1/oauth/google/login",
 			"/api/v1/oauth/google/sign-up",
 			"/api/v1/oauth/google/callback",
-			"/api/v1/oauth/google/verify":
+			"/api/v1/oauth/google/verify",
+			"/api/v1/oidc/authorize",
+			"/api/v1/oidc/token",
+			"/api/v1/oidc/userinfo",
+			"/api/v1/oidc/jwks",
+			"/api/v1/oauth2/token",
+			"/api/v1/oauth2/introspect",
+			"/api/v1/oauth2/revoke",
+			"/api/v1/csrf":
+			// Every logged in user can read the CSRF token of the session
 			sub = "anonymous"
 		default:
 		}
@@ -67,9 +98,28 @@ func userPrivilege(csbn *rbac.CasbinEnforcerConfig) gin.HandlerFunc {
 	}
 }
 
+// API keys, machine clients and scoped tokens can't do more than their scope
+// allows, whether RBAC is enabled or not. The scope is only set by
+// CheckUserJWT for these credentials, and an empty one allows nothing.
+func checkScope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope, isScoped := c.Get("scope")
+		if !isScoped || rbac.ScopeAllows(scope.(string), c.Request.URL.Path, c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		c.JSON(http.StatusForbidden, utils.ErrorResponse(c, 1084, map[string]interface{}{
+			"scope": scope,
+		}))
+		c.Abort()
+	}
+}
+
 var apiWhileList = []string{
 	"/api/v1/user/login",
 	"/api/v1/user/logout",
+	"/api/v1/user/refresh",
 	"/api/v1/user/sign-up",
 	"/api/v1/user/forgot-password",
 	"/api/v1/user/verify-mail",
@@ -79,13 +129,27 @@ var apiWhileList = []string{
 	"/api/v1/user/check-phone-number",
 	"/api/v1/user/check-auth-valid",
 	"/api/v1/user/check-login-status",
+	"/api/v1/user/unlock",
+	"/api/v1/user/magic-link",
+	"/api/v1/user/magic-link/login",
 	"/api/v1/totp/validate",
 	"/api/v1/otp/mail/verify",
 	"/api/v1/otp/mail/send",
+	"/api/v1/otp/sms/verify",
+	"/api/v1/otp/sms/send",
+	"/api/v1/webauthn/login/begin",
+	"/api/v1/webauthn/login/finish",
 	"/api/v1/oauth/google/login",
 	"/api/v1/oauth/google/sign-up",
 	"/api/v1/oauth/google/callback",
 	"/api/v1/oauth/google/verify",
+	"/api/v1/oidc/authorize",
+	"/api/v1/oidc/token",
+	"/api/v1/oidc/userinfo",
+	"/api/v1/oidc/jwks",
+	"/api/v1/oauth2/token",
+	"/api/v1/oauth2/introspect",
+	"/api/v1/oauth2/revoke",
 }
 
 func checkAPIWhileList(c *gin.Context) bool {
@@ -98,14 +162,66 @@ func checkAPIWhileList(c *gin.Context) bool {
 	return false
 }
 
-func checkSessionID(c *gin.Context) bool {
-	isExists, _ := session.CheckSession(c)
+// checkAPIKey authenticates the request as the owner of the personal API key,
+// the scope of the key narrows the permissions of the user in userPrivilege.
+func checkAPIKey(c *gin.Context, apiKey string) {
+	apiKeyInfo, err := mariadb.GetAPIKeyByHash(encrypt.HashWithSHA(apiKey, "sha256"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1087, nil))
+		c.Abort()
+		return
+	} else if err != nil {
+		errorMessage := fmt.Sprintf("Get API key failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1002, err))
+		c.Abort()
+		return
+	}
 
-	if isExists {
-		return true
+	if err = mariadb.UpdateAPIKeyLastUsed(apiKeyInfo.KeyID); err != nil {
+		errorMessage := fmt.Sprintf("Update last used time of API key failed: %v", err)
+		slog.Error(errorMessage)
+	}
+
+	c.Set("mail", apiKeyInfo.Mail)
+	c.Set("scope", apiKeyInfo.Scopes)
+	c.Set("api_key_id", apiKeyInfo.KeyID)
+	c.Set("auth_method", "api_key")
+	c.Next()
+}
+
+// abortExpiredSession tells a session which was signed out apart from one
+// which has timed out, so the frontend can show the right message.
+func abortExpiredSession(c *gin.Context, sid string) {
+	isRevoked, err := token_denylist.IsSessionRevoked(sid)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Check token denylist failed: %v", err)
+		slog.Error(errorMessage)
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1044, err))
+		c.Abort()
+		return
+	}
+
+	if isRevoked {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1077, nil))
 	} else {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1090, nil))
+	}
+	c.Abort()
+}
+
+// touchSession extends the session of the request, it returns false when the
+// session has expired. Other failures don't stop the request.
+func touchSession(c *gin.Context, sid string) bool {
+	errCode, err := session.Touch(c, sid)
+	if errCode == 1043 {
+		abortExpiredSession(c, sid)
 		return false
+	} else if err != nil {
+		errorMessage := fmt.Sprintf("Update expiration of session failed: %v", err)
+		slog.Error(errorMessage)
 	}
+	return true
 }
 
 func CheckUserJWT() gin.HandlerFunc {
@@ -117,20 +233,28 @@ func CheckUserJWT() gin.HandlerFunc {
 			return
 		}
 
+		if apiKey := c.GetHeader("X-API-KEY"); apiKey != "" {
+			checkAPIKey(c, apiKey)
+			return
+		}
+
 		cookie, err := c.Cookie("token")
+		authMethod := "cookie"
+
+		// Mobile and CLI clients send the JWT by the Authorization header
+		if bearer := utils.BearerToken(c); bearer != "" {
+			cookie, err, authMethod = bearer, nil, "bearer"
+		}
 
 		if err != nil {
 			if err == http.ErrNoCookie {
-				isExists := checkSessionID(c)
-				if isExists {
-					_, data, errCode, err := session.ReadSession(c)
+				sid := session.CurrentSessionID(c)
+				if sid != "" {
+					data, _, errCode, err := session.GetSession(sid)
 
 					switch errCode {
 					case 1043:
-						errorMessage := fmt.Sprintf("Redis key does not exist: %v", err)
-						slog.Error(errorMessage)
-						c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
-						c.Abort()
+						abortExpiredSession(c, sid)
 						return
 
 					case 1044:
@@ -148,7 +272,26 @@ func CheckUserJWT() gin.HandlerFunc {
 						return
 					}
 
+					isRevoked, err := token_denylist.IsUserRevoked(data.Mail, session.IssuedAt(data))
+					if err != nil {
+						errorMessage := fmt.Sprintf("Check token denylist failed: %v", err)
+						slog.Error(errorMessage)
+						c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1044, err))
+						c.Abort()
+						return
+					}
+					if isRevoked {
+						c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1077, nil))
+						c.Abort()
+						return
+					}
+
 					c.Set("mail", data.Mail)
+					c.Set("sid", sid)
+					c.Set("auth_method", "session")
+					if !touchSession(c, sid) {
+						return
+					}
 					c.Next()
 					return
 				} else {
@@ -168,32 +311,185 @@ func CheckUserJWT() gin.HandlerFunc {
 		claims, errCode, errParseJWT := jwt.ParseJWT(cookie)
 
 		if errParseJWT != nil {
+			c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, errCode, errParseJWT))
+			c.Abort()
+			return
+		}
 
-			switch errCode {
+		var issuedAt time.Time
+		if claims.IssuedAt != nil {
+			issuedAt = claims.IssuedAt.Time
+		}
+		isRevoked, err := token_denylist.IsTokenRevoked(claims.ID, claims.SessionID, claims.Principal(), issuedAt)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Check token denylist failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1044, err))
+			c.Abort()
+			return
+		}
 
-			case 1015:
-				c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, errCode, err))
-				c.Abort()
+		if isRevoked {
+			c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1077, nil))
+			c.Abort()
+			return
+		} else if claims.ClientID != "" {
+			c.Set("client_id", claims.ClientID)
+			c.Set("scope", claims.Scope)
+			c.Set("auth_method", authMethod)
+			c.Next()
+		} else {
+			c.Set("mail", claims.Mail)
+			if claims.Scope != "" {
+				c.Set("scope", claims.Scope)
+			}
+			c.Set("sid", claims.SessionID)
+			c.Set("auth_method", authMethod)
+			if claims.SessionID != "" && !touchSession(c, claims.SessionID) {
 				return
+			}
+			c.Next()
+		}
+	}
+}
 
-			case 1016:
-				c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, errCode, err))
-				c.Abort()
-				return
+// Requests authenticated by cookies must send the CSRF token of the session
+// in the X-CSRF-Token header, the browser doesn't let other sites read it.
+// Bearer tokens and API keys are never sent by the browser automatically,
+// so they are exempt.
+func checkCSRF() gin.HandlerFunc {
+	exemptPaths := map[string]bool{}
+	for _, path := range configs.ApplicationConfig.Server.CsrfExemptPaths {
+		exemptPaths[path] = true
+	}
 
-			case 1017:
-				c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, errCode, err))
-				c.Abort()
-				return
-			}
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
 		}
 
-		if time.Now().Unix() > claims.ExpiresAt.Unix() {
-			c.JSON(http.StatusUnauthorized, utils.ErrorResponse(c, 1050, err))
+		authMethod := c.GetString("auth_method")
+		if (authMethod != "cookie" && authMethod != "session") || exemptPaths[c.Request.URL.Path] {
+			c.Next()
 			return
-		} else {
-			c.Set("mail", claims.Mail)
+		}
+
+		isValid, err := session.CheckCSRFToken(c.GetString("sid"), c.GetHeader("X-CSRF-Token"))
+		if err != nil {
+			errorMessage := fmt.Sprintf("Check CSRF token failed: %v", err)
+			slog.Error(errorMessage)
+			c.JSON(http.StatusInternalServerError, utils.ErrorResponse(c, 1044, err))
+			c.Abort()
+			return
+		}
+		if !isValid {
+			c.JSON(http.StatusForbidden, utils.ErrorResponse(c, 1092, nil))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// requestTarget returns the target identifier of the rate limit from the
+// query string, or from the JSON body which is kept for the handler.
+func requestTarget(c *gin.Context, field string) string {
+	if value := c.Query(field); value != "" {
+		return value
+	}
+	if c.Request.Body == nil {
+		return ""
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var fields map[string]interface{}
+	if err = json.Unmarshal(body, &fields); err != nil {
+		return ""
+	}
+	value, _ := fields[field].(string)
+	return value
+}
+
+// tighter reports whether the result a limits the client more than b, a
+// refused request beats an allowed one.
+func tighter(a, b *rate_limit.Result) bool {
+	if b == nil {
+		return true
+	}
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+	return a.Remaining < b.Remaining
+}
+
+// rateLimit takes a token from every bucket of the request by the rules of
+// [rate_limit]. The RateLimit headers describe the bucket which limits the
+// client the most, a Redis failure doesn't stop the request.
+func rateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rules := rate_limit.Match(c.Request.URL.Path, c.Request.Method)
+		if len(rules) == 0 {
+			c.Next()
+			return
+		}
+
+		var tightest *rate_limit.Result
+		for _, rule := range rules {
+			takes := [][2]string{}
+			if rule.IP.Limit > 0 {
+				takes = append(takes, [2]string{rate_limit.DimensionIP, c.ClientIP()})
+			}
+			if rule.Target.Limit > 0 {
+				if target := requestTarget(c, rule.Field); target != "" {
+					takes = append(takes, [2]string{rate_limit.DimensionTarget, target})
+				}
+			}
+
+			for _, take := range takes {
+				result, err := rate_limit.Take(rule, take[0], take[1])
+				if err != nil {
+					errorMessage := fmt.Sprintf("Rate limit of %s failed: %v", rule.Path, err)
+					slog.Error(errorMessage)
+					continue
+				}
+				if tighter(result, tightest) {
+					tightest = result
+				}
+			}
+		}
+
+		if tightest == nil {
 			c.Next()
+			return
 		}
+
+		c.Header("RateLimit-Limit", strconv.FormatInt(tightest.Limit, 10))
+		c.Header("RateLimit-Remaining", strconv.FormatInt(tightest.Remaining, 10))
+		c.Header("RateLimit-Reset", strconv.FormatInt(rate_limit.Seconds(tightest.Reset), 10))
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", tightest.Limit, rate_limit.Seconds(tightest.Period)))
+
+		if !tightest.Allowed {
+			retryAfter := rate_limit.Seconds(tightest.RetryAfter)
+			c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+			c.JSON(http.StatusTooManyRequests, utils.ErrorResponse(c, 1114, map[string]interface{}{
+				"retry_after": retryAfter,
+				"limited_by":  tightest.Dimension,
+			}))
+			c.Abort()
+			return
+		}
+
+		c.Next()
 	}
 }
@@ -0,0 +1,192 @@
+package api_server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+
+	"suglider-auth/configs"
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/jwt"
+	"suglider-auth/pkg/session"
+	"suglider-auth/pkg/session_store"
+)
+
+func TestCheckUserJWT(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// The token denylist is kept in Redis
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+	if err := jwt.InitKeyRing(&jwt.KeyRingSettings{Algorithm: "ES256"}); err != nil {
+		t.Fatalf("Unit Test (Init key ring) Fail: %v\n", err)
+	}
+
+	router := gin.New()
+	router.GET("/api/v1/user/test", CheckUserJWT(), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("mail"))
+	})
+
+	request := func(token string) (*httptest.ResponseRecorder, int64) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/user/test", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response struct {
+			Code int64 `json:"code"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response.Code
+	}
+
+	t.Run("Test a valid token", func(t *testing.T) {
+		token, _, err := jwt.GenerateScopedJWT("alice@example.com", "")
+		if err != nil {
+			t.Fatalf("Unit Test (Generate JWT) Fail: %v\n", err)
+		}
+
+		if w, _ := request(token); w.Code != http.StatusOK || w.Body.String() != "alice@example.com" {
+			t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The valid token must be accepted.")
+		}
+	})
+
+	t.Run("Test the bearer token takes precedence over the cookie", func(t *testing.T) {
+		bearer, _, err := jwt.GenerateScopedJWT("alice@example.com", "")
+		if err != nil {
+			t.Fatalf("Unit Test (Generate JWT) Fail: %v\n", err)
+		}
+		cookie, _, err := jwt.GenerateScopedJWT("bob@example.com", "")
+		if err != nil {
+			t.Fatalf("Unit Test (Generate JWT) Fail: %v\n", err)
+		}
+
+		testCases := []struct {
+			cookie string
+			reason string
+		}{
+			{cookie, "The mail of the bearer token must be used."},
+			{"not-a-jwt", "The invalid cookie must be ignored."},
+		}
+		for _, testCase := range testCases {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/user/test", nil)
+			req.Header.Set("Authorization", "Bearer "+bearer)
+			req.AddCookie(&http.Cookie{Name: "token", Value: testCase.cookie})
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK || w.Body.String() != "alice@example.com" {
+				t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), testCase.reason)
+			}
+		}
+	})
+
+	t.Run("Test an expired token", func(t *testing.T) {
+		jwt.SetAccessTokenTTL(time.Millisecond)
+		token, _, err := jwt.GenerateScopedJWT("alice@example.com", "")
+		jwt.SetAccessTokenTTL(20 * time.Minute)
+		if err != nil {
+			t.Fatalf("Unit Test (Generate JWT) Fail: %v\n", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		if w, code := request(token); w.Code != http.StatusUnauthorized || code != 1050 {
+			t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), "The expired token must be unauthorized.")
+		}
+	})
+
+	t.Run("Test an invalid token", func(t *testing.T) {
+		jwt.SetAccessTokenAudience("other-service")
+		token, _, err := jwt.GenerateScopedJWT("alice@example.com", "")
+		jwt.SetAccessTokenAudience("suglider-auth")
+		if err != nil {
+			t.Fatalf("Unit Test (Generate JWT) Fail: %v\n", err)
+		}
+
+		testCases := []struct {
+			token  string
+			reason string
+		}{
+			{token, "The token of another audience must be unauthorized."},
+			{"not-a-jwt", "The malformed token must be unauthorized."},
+		}
+		for _, testCase := range testCases {
+			if w, code := request(testCase.token); w.Code != http.StatusUnauthorized || code != 1017 {
+				t.Errorf("Result: %d %s (%s)\n", w.Code, w.Body.String(), testCase.reason)
+			}
+		}
+	})
+}
+
+func TestCheckCSRF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store := session_store.NewMemoryStore()
+	session.SetStore(store)
+
+	now := time.Now().Unix()
+	data := session_store.SessionData{Mail: "alice@example.com", CreatedAt: now, LastSeen: now, CSRFToken: "csrf-token"}
+	if err := store.Create("alice-laptop", data, time.Hour); err != nil {
+		t.Fatalf("Unit Test (Create session) Fail: %v\n", err)
+	}
+
+	serverConfig := configs.ApplicationConfig.Server
+	defaultExemptPaths := serverConfig.CsrfExemptPaths
+	serverConfig.CsrfExemptPaths = []string{"/api/v1/user/webhook"}
+	defer func() { serverConfig.CsrfExemptPaths = defaultExemptPaths }()
+
+	// The auth method and session ID are set by CheckUserJWT in the server
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("auth_method", c.GetHeader("X-Test-Auth-Method"))
+		c.Set("sid", "alice-laptop")
+	}, checkCSRF())
+	router.Any("/api/v1/user/*path", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	testCases := []struct {
+		method     string
+		path       string
+		authMethod string
+		csrfToken  string
+		status     int
+		reason     string
+	}{
+		{http.MethodPost, "/api/v1/user/test", "cookie", "", http.StatusForbidden, "The cookie request without the CSRF token must be forbidden."},
+		{http.MethodDelete, "/api/v1/user/test", "session", "", http.StatusForbidden, "The session request without the CSRF token must be forbidden."},
+		{http.MethodPut, "/api/v1/user/test", "cookie", "other-token", http.StatusForbidden, "The cookie request with a wrong CSRF token must be forbidden."},
+		{http.MethodPost, "/api/v1/user/test", "cookie", "csrf-token", http.StatusOK, "The cookie request with the CSRF token must pass."},
+		{http.MethodPatch, "/api/v1/user/test", "session", "csrf-token", http.StatusOK, "The session request with the CSRF token must pass."},
+		{http.MethodPost, "/api/v1/user/test", "bearer", "", http.StatusOK, "The bearer request is exempt."},
+		{http.MethodPost, "/api/v1/user/test", "api_key", "", http.StatusOK, "The API key request is exempt."},
+		{http.MethodPost, "/api/v1/user/webhook", "cookie", "", http.StatusOK, "The exempt path doesn't need the CSRF token."},
+		{http.MethodGet, "/api/v1/user/test", "cookie", "", http.StatusOK, "The GET request doesn't need the CSRF token."},
+		{http.MethodHead, "/api/v1/user/test", "cookie", "", http.StatusOK, "The HEAD request doesn't need the CSRF token."},
+		{http.MethodOptions, "/api/v1/user/test", "cookie", "", http.StatusOK, "The OPTIONS request doesn't need the CSRF token."},
+	}
+	for _, testCase := range testCases {
+		req := httptest.NewRequest(testCase.method, testCase.path, nil)
+		req.Header.Set("X-Test-Auth-Method", testCase.authMethod)
+		if testCase.csrfToken != "" {
+			req.Header.Set("X-CSRF-Token", testCase.csrfToken)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response struct {
+			Code int64 `json:"code"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != testCase.status || (w.Code == http.StatusForbidden && response.Code != 1092) {
+			t.Errorf("Result: %s %s -> %d %s (%s)\n", testCase.method, testCase.path, w.Code, w.Body.String(), testCase.reason)
+		}
+	}
+}
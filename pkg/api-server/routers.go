@@ -21,26 +21,24 @@ import (
 	mariadb "suglider-auth/internal/database"
 	v1_routers "suglider-auth/pkg/api-server/api_v1/routers"
 	"suglider-auth/pkg/rbac"
-	"suglider-auth/pkg/time_convert"
+	"suglider-auth/pkg/session"
 )
 
 type AuthApiSettings struct {
-	Name             string
-	Version          string
-	SubpathPrefix    string
-	TemplatePath     string
-	StaticPath       string
-	SessionsPath     string
-	CasbinConfig     string
-	CasbinTable      string
-	GracefulTimeout  int
-	ReadTimeout      int
-	WriteTimeout     int
-	MaxHeaderBytes   int
-	EnablePprof      bool
-	EnableRbac       bool
-	CasbinCache      bool
-	SessionsHttpOnly bool
+	Name            string
+	Version         string
+	SubpathPrefix   string
+	TemplatePath    string
+	StaticPath      string
+	CasbinConfig    string
+	CasbinTable     string
+	GracefulTimeout int
+	ReadTimeout     int
+	WriteTimeout    int
+	MaxHeaderBytes  int
+	EnablePprof     bool
+	EnableRbac      bool
+	CasbinCache     bool
 }
 
 type CasbinEnforcerConfig = rbac.CasbinEnforcerConfig
@@ -60,16 +58,12 @@ func (aa *AuthApiSettings) SetupRouter(swag gin.HandlerFunc) *gin.Engine {
 
 	router.Use(gin.Logger())
 
-	cookieStore := cookie.NewStore([]byte("suglider"))
+	cookieStore := cookie.NewStore(session.KeyPairs()...)
 	router.Use(sessions.Sessions("session-key", cookieStore))
 
-	// Set session expire time
-	_, cookieMaxAge, _ := time_convert.ConvertTimeFormat(configs.ApplicationConfig.Session.Timeout)
-	cookieStore.Options(sessions.Options{
-		MaxAge:   cookieMaxAge, // unit second
-		HttpOnly: aa.SessionsHttpOnly,
-		Path:     aa.SessionsPath,
-	})
+	// Set session expire time, the cookie is kept until the absolute timeout
+	_, absoluteTimeout := session.Timeouts()
+	cookieStore.Options(session.CookieOptions(absoluteTimeout))
 
 	if aa.EnablePprof {
 		pprof.Register(router, aa.SubpathPrefix+"debug/pprof")
@@ -83,6 +77,8 @@ func (aa *AuthApiSettings) SetupRouter(swag gin.HandlerFunc) *gin.Engine {
 		router.GET("/swagger/*any", swag)
 	}
 	router.GET(aa.SubpathPrefix+"/healthz", aa.healthzHandler)
+	router.GET(aa.SubpathPrefix+"/.well-known/jwks.json", aa.jwksHandler)
+	router.GET(aa.SubpathPrefix+"/.well-known/openid-configuration", aa.openidConfigurationHandler)
 
 	// Load HTML templates and static resources
 	if aa.TemplatePath == "" {
@@ -109,12 +105,27 @@ func (aa *AuthApiSettings) SetupRouter(swag gin.HandlerFunc) *gin.Engine {
 		slog.Error(err.Error())
 	}
 
+	if err = session.InitStore(mariadb.DataBase); err != nil {
+		errorMessage := fmt.Sprintf("Initial session store failed: %v", err)
+		slog.Error(errorMessage)
+		panic(err)
+	}
+
+	// Throttle before authentication, the public endpoints are the targets
+	router.Use(rateLimit())
+
 	router.Use(CheckUserJWT())
 
+	if configs.ApplicationConfig.Server.EnableCsrf {
+		router.Use(checkCSRF())
+	}
+
 	if aa.EnableRbac {
 		router.Use(userPrivilege(csbn))
 	}
 
+	router.Use(checkScope())
+
 	apiv1Router := router.Group(aa.SubpathPrefix + "/api/v1")
 	{
 		v1_routers.Apiv1Handler(apiv1Router, csbn)
@@ -95,6 +95,18 @@ func AesEncrypt(key, data []byte, mode string) ([]byte, error) {
 		str := cipher.NewCFBEncrypter(blk, meta)
 		str.XORKeyStream(result[aes.BlockSize:], data)
 
+	case "GCM", "gcm":
+		// the nonce is the prefix of the result, the tag is the suffix
+		gcm, err := cipher.NewGCM(blk)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize() + len(data) + gcm.Overhead())
+		if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+			return nil, err
+		}
+		result = gcm.Seal(nonce, nonce, data, nil)
+
 	default:
 		padding := Pkcs5Pad(data, size)
 		result = make([]byte, len(padding))
@@ -135,6 +147,19 @@ func AesDecrypt(key, data []byte, mode string) ([]byte, error) {
 		str := cipher.NewCFBDecrypter(blk, meta)
 		str.XORKeyStream(result, result)
 
+	case "GCM", "gcm":
+		gcm, err := cipher.NewGCM(blk)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < gcm.NonceSize() + gcm.Overhead() {
+			return nil, fmt.Errorf("Error: %s\n", "invalid cipher text")
+		}
+		nonce := data[:gcm.NonceSize()]
+		if result, err = gcm.Open(nil, nonce, data[gcm.NonceSize():], nil); err != nil {
+			return nil, err
+		}
+
 	default:
 		encrypter := cipher.NewCBCDecrypter(blk, key[:size])
 		result = make([]byte, len(data))
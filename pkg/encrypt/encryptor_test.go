@@ -73,6 +73,25 @@ func TestEncrypt(t *testing.T) {
 				"The decrypted data must be the same as origin data.",
 			)
 		}
+
+		if encrypted, err = AesEncrypt(secret, text,"GCM"); err != nil {
+			t.Errorf("Unit Test (AES-GCM encrypt) Fail: %v\n", err)
+		}
+		if decrypted, err = AesDecrypt(secret, encrypted, "GCM"); err != nil {
+			t.Errorf("Unit Test (AES-GCM decrypt) Fail: %v\n", err)
+		}
+		if string(text) != string(decrypted) {
+			t.Errorf(
+				"AES-GCM Test Result:\n    Origin Data: %s\n    Decrypted Data: %s\n    (%s)\n",
+				string(text),
+				string(decrypted),
+				"The decrypted data must be the same as origin data.",
+			)
+		}
+		encrypted[len(encrypted) - 1] ^= 0x01
+		if _, err = AesDecrypt(secret, encrypted, "GCM"); err == nil {
+			t.Errorf("Unit Test (AES-GCM negtive-decrypt) Fail: Must be fail for this test\n")
+		}
 	})
 	t.Run("Test rsa encryption/decryption in parallel", func(t *testing.T) {
 		t.Parallel()
@@ -2,9 +2,13 @@ package encrypt
 
 import (
 	"os"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	crand "crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"fmt"
 	"math/rand"
@@ -33,6 +37,16 @@ func RandomNumber(length int) string {
 	return string(randNumber)
 }
 
+// RandomToken returns a url-safe string with length bytes from crypto/rand,
+// it's suitable for opaque tokens which must not be guessable.
+func RandomToken(length int) (string, error) {
+	buf := make([]byte, length)
+	if _, err := crand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
 func GenertateUUID(noDash bool) string {
 	id := uuid.New().String()
 	if noDash {
@@ -167,3 +181,93 @@ func RsaPublicKeyFromPemFile(file string) (*rsa.PublicKey, error) {
 	}
 	return nil, fmt.Errorf("Error: %s\n", "")
 }
+
+func GenerateECKeyPair(curve string) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
+	var crv elliptic.Curve
+	switch curve {
+	case "P-384", "p384":
+		crv = elliptic.P384()
+	case "P-521", "p521":
+		crv = elliptic.P521()
+	default:
+		crv = elliptic.P256()
+	}
+	privKey, err := ecdsa.GenerateKey(crv, crand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubKey := privKey.PublicKey
+	return privKey, &pubKey, nil
+}
+
+func EcPrivateKeyToPem(key *ecdsa.PrivateKey) (string, error) {
+	privkey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	keyPem := pem.EncodeToMemory(
+		&pem.Block {
+			Type:  "EC PRIVATE KEY",
+			Bytes: privkey,
+		},
+	)
+	return string(keyPem), nil
+}
+
+func EcPrivateKeyFromPem(key string) (*ecdsa.PrivateKey, error) {
+	blk, _ := pem.Decode([]byte(key))
+	if blk == nil {
+		return nil, fmt.Errorf("Error: %s\n", "fail to parse pem")
+	}
+	privkey, err := x509.ParseECPrivateKey(blk.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return privkey, nil
+}
+
+// PrivateKeyToPem encodes a RSA (PKCS1) or EC private key to pem.
+func PrivateKeyToPem(key crypto.Signer) (string, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return RsaPrivateKeyToPem(key)
+	case *ecdsa.PrivateKey:
+		return EcPrivateKeyToPem(key)
+	}
+	return "", fmt.Errorf("Error: %s\n", "unsupported private key type")
+}
+
+// PrivateKeyFromPemFile loads a RSA (PKCS1/PKCS8) or EC private key from a pem file.
+func PrivateKeyFromPemFile(file string) (crypto.Signer, error) {
+	key, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return PrivateKeyFromPem(string(key))
+}
+
+// PrivateKeyFromPem loads a RSA (PKCS1/PKCS8) or EC private key from pem.
+func PrivateKeyFromPem(key string) (crypto.Signer, error) {
+	blk, _ := pem.Decode([]byte(key))
+	if blk == nil {
+		return nil, fmt.Errorf("Error: %s\n", "fail to parse pem")
+	}
+	switch blk.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(blk.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(blk.Bytes)
+	}
+	privkey, err := x509.ParsePKCS8PrivateKey(blk.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch privkey := privkey.(type) {
+	case *rsa.PrivateKey:
+		return privkey, nil
+	case *ecdsa.PrivateKey:
+		return privkey, nil
+	default:
+	}
+	return nil, fmt.Errorf("Error: %s\n", "unsupported private key type")
+}
@@ -0,0 +1,147 @@
+package encrypt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A field is sealed with envelope encryption, the value is encrypted by a
+// random data key with AES-GCM and the data key is encrypted by a versioned
+// key of the key ring. The sealed value is:
+//
+//   enc:<version>:<base64 encrypted data key>:<base64 encrypted value>
+//
+// A value without the prefix is plaintext from before the encryption, it's
+// returned as it is, so the rows can be re-encrypted after the key ring is
+// configured.
+
+const sealedPrefix = "enc:"
+
+// KeyRing keeps the versioned key encryption keys, new values are sealed with
+// the active key and the other keys can only open the values sealed before.
+type KeyRing struct {
+	active   string
+	keys     map[string][]byte
+	indexKey []byte
+}
+
+// NewKeyRing returns the key ring, keys are the AES keys of every version and
+// indexKey is the HMAC key of the blind indexes.
+func NewKeyRing(active string, keys map[string][]byte, indexKey []byte) (*KeyRing, error) {
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("the active key %q is not in the key ring", active)
+	}
+
+	for version, key := range keys {
+		if version == "" || strings.Contains(version, ":") {
+			return nil, fmt.Errorf("the key version %q is invalid", version)
+		}
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("the key %q must be 16, 24 or 32 bytes", version)
+		}
+	}
+
+	if len(indexKey) < 16 {
+		return nil, fmt.Errorf("the index key must be at least 16 bytes")
+	}
+
+	return &KeyRing{
+		active:   active,
+		keys:     keys,
+		indexKey: indexKey,
+	}, nil
+}
+
+// Active returns the version of the key which seals the new values.
+func (kr *KeyRing) Active() string {
+	return kr.active
+}
+
+// Seal encrypts the value with a new data key and the active key.
+func (kr *KeyRing) Seal(value string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := AesEncrypt(kr.keys[kr.active], dataKey, "GCM")
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := AesEncrypt(dataKey, []byte(value), "GCM")
+	if err != nil {
+		return "", err
+	}
+
+	return sealedPrefix + kr.active + ":" +
+		base64.RawURLEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawURLEncoding.EncodeToString(encrypted), nil
+}
+
+// Open decrypts the sealed value, a plaintext value is returned as it is.
+func (kr *KeyRing) Open(value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, sealedPrefix), ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("the sealed value is malformed")
+	}
+
+	key, ok := kr.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("the key %q is not in the key ring", parts[0])
+	}
+
+	wrappedKey, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := AesDecrypt(key, wrappedKey, "GCM")
+	if err != nil {
+		return "", err
+	}
+
+	decrypted, err := AesDecrypt(dataKey, encrypted, "GCM")
+	if err != nil {
+		return "", err
+	}
+
+	return string(decrypted), nil
+}
+
+// NeedsReseal reports whether the value is plaintext or sealed with a key
+// which is not the active one.
+func (kr *KeyRing) NeedsReseal(value string) bool {
+	if !IsSealed(value) {
+		return value != ""
+	}
+	return !strings.HasPrefix(value, sealedPrefix+kr.active+":")
+}
+
+// BlindIndex returns the HMAC of the value, so a sealed column can still be
+// unique and searched by the exact value.
+func (kr *KeyRing) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, kr.indexKey)
+	mac.Write([]byte(value))
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+// IsSealed reports whether the value is sealed by a key ring.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
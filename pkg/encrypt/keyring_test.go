@@ -0,0 +1,59 @@
+package encrypt
+
+import (
+	"testing"
+)
+
+func TestKeyRing(t *testing.T) {
+	oldKey := []byte(RandomString(32, "!@#$%^&*()"))
+	newKey := []byte(RandomString(32, "!@#$%^&*()"))
+	indexKey := []byte(RandomString(32, "!@#$%^&*()"))
+
+	kr, err := NewKeyRing("v1", map[string][]byte{"v1": oldKey}, indexKey)
+	if err != nil {
+		t.Fatalf("Unit Test (New key ring) Fail: %v\n", err)
+	}
+
+	sealed, err := kr.Seal("0912345678")
+	if err != nil {
+		t.Fatalf("Unit Test (Seal) Fail: %v\n", err)
+	}
+	if !IsSealed(sealed) || kr.NeedsReseal(sealed) {
+		t.Errorf("Result: %s (%s)\n", sealed, "The value must be sealed with the active key.")
+	}
+	if opened, err := kr.Open(sealed); err != nil || opened != "0912345678" {
+		t.Errorf("Unit Test (Open) Fail: %s, %v\n", opened, err)
+	}
+	if opened, err := kr.Open("plaintext"); err != nil || opened != "plaintext" {
+		t.Errorf("Unit Test (Open plaintext) Fail: %s, %v\n", opened, err)
+	}
+	if !kr.NeedsReseal("plaintext") || kr.NeedsReseal("") {
+		t.Errorf("Unit Test (Needs reseal plaintext) Fail\n")
+	}
+
+	rotated, err := NewKeyRing("v2", map[string][]byte{"v1": oldKey, "v2": newKey}, indexKey)
+	if err != nil {
+		t.Fatalf("Unit Test (New rotated key ring) Fail: %v\n", err)
+	}
+	if !rotated.NeedsReseal(sealed) {
+		t.Errorf("Unit Test (Needs reseal after rotation) Fail\n")
+	}
+	if opened, err := rotated.Open(sealed); err != nil || opened != "0912345678" {
+		t.Errorf("Unit Test (Open with retired key) Fail: %s, %v\n", opened, err)
+	}
+	if kr.BlindIndex("0912345678") != rotated.BlindIndex("0912345678") {
+		t.Errorf("Unit Test (Blind index) Fail: %s\n", "The blind index must not change with the key rotation.")
+	}
+
+	resealed, err := rotated.Seal("0912345678")
+	if err != nil {
+		t.Fatalf("Unit Test (Reseal) Fail: %v\n", err)
+	}
+	if _, err = kr.Open(resealed); err == nil {
+		t.Errorf("Unit Test (Open with unknown key) Fail: Must be fail for this test\n")
+	}
+
+	if _, err = NewKeyRing("v3", map[string][]byte{"v1": oldKey}, indexKey); err == nil {
+		t.Errorf("Unit Test (Missing active key) Fail: Must be fail for this test\n")
+	}
+}
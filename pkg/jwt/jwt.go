@@ -1,40 +1,98 @@
 package jwt
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+
+	"suglider-auth/pkg/encrypt"
 )
 
-var jwtKey = []byte("suglider")
+// The expiration time of the token
+var accessTokenTTL = 20 * time.Minute
+
+func SetAccessTokenTTL(ttl time.Duration) {
+	if ttl > 0 {
+		accessTokenTTL = ttl
+	}
+}
+
+// The audience of the access token, ParseJWT rejects a token of the other
+// audiences such as the ID token issued to a relying party.
+var accessTokenAudience = "suglider-auth"
+
+func SetAccessTokenAudience(audience string) {
+	if audience != "" {
+		accessTokenAudience = audience
+	}
+}
+
+func init() {
+	// The issued time is compared with the revocation time of the user, see
+	// token_denylist, which has millisecond resolution.
+	jwt.TimePrecision = time.Millisecond
+}
 
 type jwtData struct {
-	Mail string `json:"mail"`
+	Mail      string `json:"mail"`
+	SessionID string `json:"sid,omitempty"`       // the login session, revoked by remote sign-out
+	ClientID  string `json:"client_id,omitempty"` // only set in the token of a machine client
+	Scope     string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateJWT(mail string) (string, int, error) {
+// Principal returns the mail of the user, or the client ID when the token was
+// issued to a machine client by the client credentials grant.
+func (claims *jwtData) Principal() string {
+	if claims.ClientID != "" {
+		return claims.ClientID
+	}
+	return claims.Mail
+}
+
+// GenerateSessionJWT issues an access token bound to the login session, the
+// token is revoked together with the session.
+func GenerateSessionJWT(mail, sid string) (string, int, error) {
+	return generateJWT(&jwtData{Mail: mail, SessionID: sid})
+}
+
+// GenerateScopedJWT issues an access token limited to the space separated
+// scopes, an empty scope means the token is not limited.
+func GenerateScopedJWT(mail, scope string) (string, int, error) {
+	return generateJWT(&jwtData{Mail: mail, Scope: scope})
+}
 
-	// Declare the expiration time of the token
-	expireTime := 20 * time.Minute
-	expirationTime := time.Now().Add(expireTime)
+// GenerateClientJWT issues an access token of a machine client, the client ID
+// is the subject of the token.
+func GenerateClientJWT(clientID, scope string) (string, int, error) {
+	claims := &jwtData{ClientID: clientID, Scope: scope}
+	claims.RegisteredClaims.Subject = clientID
+
+	return generateJWT(claims)
+}
+
+func generateJWT(claims *jwtData) (string, int, error) {
+
+	expireTime := accessTokenTTL
+	issuedTime := time.Now()
+	expirationTime := issuedTime.Add(expireTime)
 
 	// Create the JWT claims, which includes the username and expiry time
-	claims := &jwtData{
-		Mail: mail,
-		RegisteredClaims: jwt.RegisteredClaims{
-			// In JWT, the expiry time is expressed as unix milliseconds
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-		},
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Subject:  claims.RegisteredClaims.Subject,
+		Audience: jwt.ClaimStrings{accessTokenAudience},
+		// The jti is used to revoke a single token before it expires
+		ID:       encrypt.GenertateUUID(true),
+		IssuedAt: jwt.NewNumericDate(issuedTime),
+		// In JWT, the expiry time is expressed as unix milliseconds
+		ExpiresAt: jwt.NewNumericDate(expirationTime),
 	}
 
-	// Declare the token with the algorithm used for signing, and the claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// Generate the JWT string
-	tokenString, err := token.SignedString(jwtKey)
+	// Sign the claims with the active key of key ring, the kid header is included
+	tokenString, err := keyRing.sign(claims)
 	if err != nil {
 		return "", 0, err
 	}
@@ -45,64 +103,31 @@ func GenerateJWT(mail string) (string, int, error) {
 	return tokenString, expireTimeSec, nil
 }
 
+// ParseJWT verifies the access token, the error code is 1050 when the token
+// has expired, 1015 when the signature is invalid and 1017 when the token is
+// malformed or of the other audiences.
 func ParseJWT(token string) (*jwtData, int64, error) {
 
-	var errCode int64
-	errCode = 0
-
 	claims := &jwtData{}
 
-	tkn, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (any, error) {
-		return jwtKey, nil
-	})
+	_, err := jwt.ParseWithClaims(token, claims, keyRing.keyFunc, jwt.WithAudience(accessTokenAudience))
 
 	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, 1050, err
+		}
+		if errors.Is(err, jwt.ErrSignatureInvalid) || errors.Is(err, jwt.ErrTokenUnverifiable) {
 			errorMessage := fmt.Sprintf("JWT signature is invalid: %v", err)
 			slog.Error(errorMessage)
-			errCode = 1015
 
-			return nil, errCode, err
+			return nil, 1015, err
 		}
-		errorMessage := fmt.Sprintf("Parse JWT claim data failed: %v", err)
-		slog.Error(errorMessage)
-		errCode = 1016
-		return nil, errCode, err
-	}
-	if !tkn.Valid {
 		errorMessage := fmt.Sprintf("Token is invalid: %v", err)
 		slog.Error(errorMessage)
-		errCode = 1017
-
-		return nil, errCode, err
-	}
-
-	return claims, errCode, nil
-
-}
-
-func RefreshJWT(token string) (string, int, error) {
 
-	claims := &jwtData{}
-
-	jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (any, error) {
-		return jwtKey, nil
-	})
-
-	// Now, create a new token for the current use, with a renewed expiration time
-	expireTime := 20 * time.Minute
-	expirationTime := time.Now().Add(expireTime)
-	claims.ExpiresAt = jwt.NewNumericDate(expirationTime)
-	newToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	tokenString, err := newToken.SignedString(jwtKey)
-	if err != nil {
-		return "", 0, err
+		return nil, 1017, err
 	}
 
-	// Convert to Second
-	expireTimeSec := int(expireTime.Seconds())
-
-	return tokenString, expireTimeSec, nil
+	return claims, 0, nil
 
 }
@@ -0,0 +1,27 @@
+package jwt
+
+import "time"
+
+// StoredKey is a generated signing key kept by a KeyStore, the private key
+// is PEM encoded.
+type StoredKey struct {
+	Kid        string
+	PrivatePem string
+	CreatedAt  time.Time
+	RetiredAt  time.Time // zero while the key signs
+}
+
+// KeyStore keeps the generated signing keys across restarts, and shares them
+// between the instances so every instance verifies the tokens of the others
+// and publishes the same JWKS.
+type KeyStore interface {
+	// Load returns the active key and the keys retired after retiredSince,
+	// the newest key comes first.
+	Load(retiredSince time.Time) ([]StoredKey, error)
+
+	// Rotate retires the active key previousKid and adds key as the active
+	// one, previousKid is empty when there is no key yet. It returns false
+	// without any change when the active key is not previousKid, which means
+	// another instance has rotated the keys.
+	Rotate(key StoredKey, previousKid string, retiredBefore time.Time) (bool, error)
+}
@@ -0,0 +1,60 @@
+package jwt
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryKeyStore keeps the keys in the process for the tests.
+type memoryKeyStore struct {
+	mu   sync.Mutex
+	keys []StoredKey
+}
+
+func newMemoryKeyStore() *memoryKeyStore {
+	return &memoryKeyStore{}
+}
+
+func (ms *memoryKeyStore) Load(retiredSince time.Time) ([]StoredKey, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	keys := []StoredKey{}
+	for _, key := range ms.keys {
+		if key.RetiredAt.IsZero() || key.RetiredAt.After(retiredSince) {
+			keys = append(keys, key)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (ms *memoryKeyStore) Rotate(key StoredKey, previousKid string, retiredBefore time.Time) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	activeKid := ""
+	for _, stored := range ms.keys {
+		if stored.RetiredAt.IsZero() {
+			activeKid = stored.Kid
+		}
+	}
+	if activeKid != previousKid {
+		return false, nil
+	}
+
+	keep := []StoredKey{}
+	for _, stored := range ms.keys {
+		if stored.RetiredAt.IsZero() {
+			stored.RetiredAt = key.CreatedAt
+		}
+		if stored.RetiredAt.After(retiredBefore) {
+			keep = append(keep, stored)
+		}
+	}
+	ms.keys = append(keep, key)
+	return true, nil
+}
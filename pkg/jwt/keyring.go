@@ -0,0 +1,505 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"suglider-auth/pkg/encrypt"
+)
+
+type KeyRingSettings struct {
+	Algorithm        string        // "RS256" or "ES256"
+	KeyLength        int           // RSA key length used when a key is generated
+	KeyFiles         []string      // the first one signs, the others only verify, they are rotated by the config
+	RotationInterval time.Duration // zero disables the rotation schedule of the generated keys
+	VerifyGrace      time.Duration // how long a retired key still verifies tokens, default is the longest TTL of the signed tokens
+	Store            KeyStore      // keeps the generated keys, nil keeps them in this process only
+}
+
+// The instances load the keys rotated by the others every syncInterval, and
+// a token signed by an unknown key makes it load the keys at most once per
+// reloadInterval.
+const (
+	syncInterval   = time.Minute
+	reloadInterval = 10 * time.Second
+)
+
+type signingKey struct {
+	Kid       string
+	Alg       string
+	Private   crypto.Signer
+	CreatedAt time.Time
+	RetiredAt time.Time
+}
+
+type KeyRing struct {
+	mu       sync.RWMutex
+	settings *KeyRingSettings
+	active   *signingKey
+	retired  []*signingKey
+	stop     chan struct{}
+	loadedAt time.Time // when the keys were loaded from the store last
+}
+
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+var keyRing *KeyRing
+
+// InitKeyRing builds the package key ring which signs and verifies the tokens,
+// and starts the rotation schedule when it is configured.
+func InitKeyRing(settings *KeyRingSettings) error {
+	kr, err := NewKeyRing(settings)
+	if err != nil {
+		return err
+	}
+	if keyRing != nil {
+		keyRing.StopRotation()
+	}
+	keyRing = kr
+	keyRing.StartRotation()
+	return nil
+}
+
+func GetKeyRing() *KeyRing {
+	return keyRing
+}
+
+func NewKeyRing(settings *KeyRingSettings) (*KeyRing, error) {
+	if settings.Algorithm == "" {
+		settings.Algorithm = "RS256"
+	}
+	if settings.Algorithm != "RS256" && settings.Algorithm != "ES256" {
+		return nil, fmt.Errorf("Unsupported JWT signing algorithm: %s", settings.Algorithm)
+	}
+	if settings.VerifyGrace <= 0 {
+		// The client credentials tokens have the TTL of the access tokens
+		settings.VerifyGrace = max(accessTokenTTL, idTokenTTL)
+	}
+
+	kr := &KeyRing{settings: settings}
+	now := time.Now()
+
+	if len(settings.KeyFiles) == 0 {
+		if err := kr.initGeneratedKeys(); err != nil {
+			return nil, err
+		}
+		return kr, nil
+	}
+
+	// The keys of the config are rotated by changing key_files, they are never
+	// generated or stored
+	if settings.RotationInterval > 0 {
+		slog.Warn("The JWT signing keys are loaded from key_files, rotation_interval is ignored.")
+		settings.RotationInterval = 0
+	}
+	settings.Store = nil
+
+	for idx, file := range settings.KeyFiles {
+		privkey, err := encrypt.PrivateKeyFromPemFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Load JWT signing key (%s) failed: %v", file, err)
+		}
+		key, err := newSigningKey(privkey, now)
+		if err != nil {
+			return nil, err
+		}
+		if idx == 0 {
+			if key.Alg != settings.Algorithm {
+				return nil, fmt.Errorf("JWT signing key (%s) does not match algorithm %s", file, settings.Algorithm)
+			}
+			kr.active = key
+			continue
+		}
+		key.RetiredAt = now
+		kr.retired = append(kr.retired, key)
+	}
+
+	return kr, nil
+}
+
+// initGeneratedKeys loads the keys of the store, a key is generated when
+// there is no key yet or the algorithm has been changed.
+func (kr *KeyRing) initGeneratedKeys() error {
+	if kr.settings.Store == nil {
+		slog.Warn("The JWT signing key is generated without a key store, it only lives in this process.")
+		key, err := kr.generateKey()
+		if err != nil {
+			return err
+		}
+		kr.active = key
+		return nil
+	}
+
+	if err := kr.load(); err != nil {
+		return err
+	}
+	if active := kr.signingKey(); active == nil || active.Alg != kr.settings.Algorithm {
+		if err := kr.Rotate(); err != nil {
+			// Another instance may have added its key at the same time
+			if loadErr := kr.load(); loadErr != nil || kr.signingKey() == nil {
+				return err
+			}
+			slog.Warn(fmt.Sprintf("Save JWT signing key failed, the stored key is used: %v", err))
+		}
+	}
+	return nil
+}
+
+// load replaces the keys by the ones of the store.
+func (kr *KeyRing) load() error {
+	now := time.Now()
+
+	storedKeys, err := kr.settings.Store.Load(now.Add(-kr.settings.VerifyGrace))
+	if err != nil {
+		return fmt.Errorf("Load JWT signing keys failed: %v", err)
+	}
+
+	var active *signingKey
+	retired := []*signingKey{}
+	for _, stored := range storedKeys {
+		privkey, err := encrypt.PrivateKeyFromPem(stored.PrivatePem)
+		if err != nil {
+			return fmt.Errorf("Parse JWT signing key (%s) failed: %v", stored.Kid, err)
+		}
+		key, err := newSigningKey(privkey, stored.CreatedAt)
+		if err != nil {
+			return err
+		}
+		key.RetiredAt = stored.RetiredAt
+
+		if key.RetiredAt.IsZero() && active == nil {
+			active = key
+			continue
+		}
+		if key.RetiredAt.IsZero() {
+			key.RetiredAt = now
+		}
+		retired = append(retired, key)
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if active != nil {
+		kr.active = active
+	}
+	kr.retired = retired
+	kr.loadedAt = now
+
+	return nil
+}
+
+func newSigningKey(privkey crypto.Signer, createdAt time.Time) (*signingKey, error) {
+	key := &signingKey{
+		Private:   privkey,
+		CreatedAt: createdAt,
+	}
+	switch privkey.(type) {
+	case *rsa.PrivateKey:
+		key.Alg = "RS256"
+	case *ecdsa.PrivateKey:
+		key.Alg = "ES256"
+	default:
+		return nil, fmt.Errorf("Unsupported JWT signing key type: %T", privkey)
+	}
+	kid, err := thumbprint(key.jwk())
+	if err != nil {
+		return nil, err
+	}
+	key.Kid = kid
+	return key, nil
+}
+
+func (kr *KeyRing) generateKey() (*signingKey, error) {
+	var privkey crypto.Signer
+	var err error
+
+	switch kr.settings.Algorithm {
+	case "ES256":
+		privkey, _, err = encrypt.GenerateECKeyPair("P-256")
+	default:
+		privkey, _, err = encrypt.GenerateRSAKeyPair(kr.settings.KeyLength)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Generate JWT signing key failed: %v", err)
+	}
+
+	return newSigningKey(privkey, time.Now())
+}
+
+// Rotate generates a new signing key. The previous key is retired, it still
+// verifies tokens until VerifyGrace has passed.
+func (kr *KeyRing) Rotate() error {
+	key, err := kr.generateKey()
+	if err != nil {
+		return err
+	}
+
+	if kr.settings.Store != nil {
+		return kr.rotateStore(key)
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	now := time.Now()
+	if kr.active != nil {
+		kr.active.RetiredAt = now
+		kr.retired = append(kr.retired, kr.active)
+	}
+	kr.active = key
+	kr.pruneLocked(now)
+
+	slog.Info(fmt.Sprintf("JWT signing key rotated, new kid: %s", key.Kid))
+
+	return nil
+}
+
+// rotateStore adds the new key to the store and loads the keys again. The
+// key is dropped when another instance has rotated the keys first, the key
+// of that instance is used instead.
+func (kr *KeyRing) rotateStore(key *signingKey) error {
+	privatePem, err := encrypt.PrivateKeyToPem(key.Private)
+	if err != nil {
+		return fmt.Errorf("Encode JWT signing key failed: %v", err)
+	}
+
+	previousKid := ""
+	if active := kr.signingKey(); active != nil {
+		previousKid = active.Kid
+	}
+
+	stored := StoredKey{
+		Kid:        key.Kid,
+		PrivatePem: privatePem,
+		CreatedAt:  key.CreatedAt,
+	}
+	rotated, err := kr.settings.Store.Rotate(stored, previousKid, key.CreatedAt.Add(-kr.settings.VerifyGrace))
+	if err != nil {
+		return fmt.Errorf("Save JWT signing key failed: %v", err)
+	}
+	if rotated {
+		slog.Info(fmt.Sprintf("JWT signing key rotated, new kid: %s", key.Kid))
+	}
+
+	return kr.load()
+}
+
+// sync loads the keys rotated by the other instances, and rotates the keys
+// when the active key is older than the rotation interval.
+func (kr *KeyRing) sync() error {
+	if kr.settings.Store == nil {
+		return kr.Rotate()
+	}
+
+	if err := kr.load(); err != nil {
+		return err
+	}
+	if kr.settings.RotationInterval > 0 && time.Since(kr.signingKey().CreatedAt) >= kr.settings.RotationInterval {
+		return kr.Rotate()
+	}
+	return nil
+}
+
+func (kr *KeyRing) pruneLocked(now time.Time) {
+	keep := kr.retired[:0]
+	for _, key := range kr.retired {
+		if now.Sub(key.RetiredAt) < kr.settings.VerifyGrace {
+			keep = append(keep, key)
+		}
+	}
+	kr.retired = keep
+}
+
+func (kr *KeyRing) StartRotation() {
+	interval := kr.settings.RotationInterval
+	if kr.settings.Store != nil && (interval <= 0 || interval > syncInterval) {
+		interval = syncInterval
+	}
+	if interval <= 0 || kr.stop != nil {
+		return
+	}
+	kr.stop = make(chan struct{})
+
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := kr.sync(); err != nil {
+					slog.Error(fmt.Sprintf("JWT signing key rotation failed: %v", err))
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(kr.stop)
+}
+
+func (kr *KeyRing) StopRotation() {
+	if kr.stop != nil {
+		close(kr.stop)
+		kr.stop = nil
+	}
+}
+
+func (kr *KeyRing) signingKey() *signingKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.active
+}
+
+func (kr *KeyRing) sign(claims jwt.Claims) (string, error) {
+	key := kr.signingKey()
+
+	var method jwt.SigningMethod
+	switch key.Alg {
+	case "ES256":
+		method = jwt.SigningMethodES256
+	default:
+		method = jwt.SigningMethodRS256
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.Kid
+
+	return token.SignedString(key.Private)
+}
+
+// keyFunc looks up the verification key by the kid header, active and
+// non-expired retired keys are accepted.
+func (kr *KeyRing) keyFunc(token *jwt.Token) (any, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok || kid == "" {
+		return nil, fmt.Errorf("Token has no kid header")
+	}
+
+	key, stale := kr.verifyingKey(kid)
+
+	// The key may have been rotated by another instance just now
+	if key == nil && stale {
+		if err := kr.load(); err != nil {
+			slog.Error(err.Error())
+		}
+		key, _ = kr.verifyingKey(kid)
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("Unknown signing key: %s", kid)
+	}
+	if token.Method.Alg() != key.Alg {
+		return nil, fmt.Errorf("Unexpected signing method: %s", token.Method.Alg())
+	}
+	return key.Private.Public(), nil
+}
+
+// verifyingKey returns the key of kid which can still verify tokens, stale
+// tells whether the keys of the store can be loaded again.
+func (kr *KeyRing) verifyingKey(kid string) (*signingKey, bool) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	stale := kr.settings.Store != nil && time.Since(kr.loadedAt) >= reloadInterval
+
+	candidates := append([]*signingKey{kr.active}, kr.retired...)
+	for _, key := range candidates {
+		if key.Kid != kid {
+			continue
+		}
+		if !key.RetiredAt.IsZero() && time.Since(key.RetiredAt) >= kr.settings.VerifyGrace {
+			return nil, false
+		}
+		return key, stale
+	}
+
+	return nil, stale
+}
+
+// JWKS returns the public part of every key which can still verify tokens.
+func (kr *KeyRing) JWKS() JSONWebKeySet {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	kr.pruneLocked(time.Now())
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{kr.active.jwk()}}
+	for _, key := range kr.retired {
+		set.Keys = append(set.Keys, key.jwk())
+	}
+	return set
+}
+
+func (key *signingKey) jwk() JSONWebKey {
+	jwk := JSONWebKey{
+		Kid: key.Kid,
+		Use: "sig",
+		Alg: key.Alg,
+	}
+	switch pubkey := key.Private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pubkey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pubkey.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pubkey.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pubkey.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(pubkey.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(pubkey.Y.FillBytes(make([]byte, size)))
+	}
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint which is used as kid.
+func thumbprint(jwk JSONWebKey) (string, error) {
+	var members any
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		return "", fmt.Errorf("Unsupported key type: %s", jwk.Kty)
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
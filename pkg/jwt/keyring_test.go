@@ -0,0 +1,195 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyRing(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES256"} {
+		alg := alg
+		t.Run("Test sign and rotate with "+alg, func(t *testing.T) {
+			kr, err := NewKeyRing(&KeyRingSettings{
+				Algorithm:   alg,
+				KeyLength:   2048,
+				VerifyGrace: time.Minute,
+			})
+			if err != nil {
+				t.Fatalf("Unit Test (New key ring) Fail: %v\n", err)
+			}
+			keyRing = kr
+
+			token, _, err := GenerateScopedJWT("test@suglider.org", "")
+			if err != nil {
+				t.Fatalf("Unit Test (Generate JWT) Fail: %v\n", err)
+			}
+			claims, _, err := ParseJWT(token)
+			if err != nil {
+				t.Fatalf("Unit Test (Parse JWT) Fail: %v\n", err)
+			}
+			if claims.Mail != "test@suglider.org" {
+				t.Errorf("Result: %s (%s)\n", claims.Mail, "The mail claim is not correct.")
+			}
+
+			if err = kr.Rotate(); err != nil {
+				t.Fatalf("Unit Test (Rotate key) Fail: %v\n", err)
+			}
+			if _, _, err = ParseJWT(token); err != nil {
+				t.Errorf("Unit Test (Parse JWT signed by retired key) Fail: %v\n", err)
+			}
+			if keys := kr.JWKS().Keys; len(keys) != 2 || keys[0].Alg != alg {
+				t.Errorf("Result: %v (%s)\n", keys, "The JWKS must include the active and retired key.")
+			}
+
+			// The retired key is dropped once the grace period has passed.
+			kr.retired[0].RetiredAt = time.Now().Add(-2 * time.Minute)
+			if _, errCode, err := ParseJWT(token); err == nil || errCode != 1015 {
+				t.Errorf("Unit Test (Parse JWT signed by expired key) Fail: %d %v\n", errCode, err)
+			}
+			if keys := kr.JWKS().Keys; len(keys) != 1 {
+				t.Errorf("Result: %v (%s)\n", keys, "The JWKS must only include the active key.")
+			}
+		})
+	}
+
+	t.Run("Test default grace period", func(t *testing.T) {
+		// The ID tokens live longer than the access tokens by default
+		kr, err := NewKeyRing(&KeyRingSettings{Algorithm: "ES256"})
+		if err != nil {
+			t.Fatalf("Unit Test (New key ring) Fail: %v\n", err)
+		}
+		if kr.settings.VerifyGrace != idTokenTTL {
+			t.Errorf("Result: %v (%s)\n", kr.settings.VerifyGrace, "The grace period must cover the ID tokens.")
+		}
+
+		SetIDTokenTTL(time.Minute)
+		defer SetIDTokenTTL(time.Hour)
+		kr, err = NewKeyRing(&KeyRingSettings{Algorithm: "ES256"})
+		if err != nil {
+			t.Fatalf("Unit Test (New key ring) Fail: %v\n", err)
+		}
+		if kr.settings.VerifyGrace != accessTokenTTL {
+			t.Errorf("Result: %v (%s)\n", kr.settings.VerifyGrace, "The grace period must cover the access tokens.")
+		}
+	})
+}
+
+func TestClientJWT(t *testing.T) {
+	kr, err := NewKeyRing(&KeyRingSettings{Algorithm: "ES256"})
+	if err != nil {
+		t.Fatalf("Unit Test (New key ring) Fail: %v\n", err)
+	}
+	keyRing = kr
+
+	token, _, err := GenerateClientJWT("worker", "read write")
+	if err != nil {
+		t.Fatalf("Unit Test (Generate client JWT) Fail: %v\n", err)
+	}
+	claims, _, err := ParseJWT(token)
+	if err != nil {
+		t.Fatalf("Unit Test (Parse client JWT) Fail: %v\n", err)
+	}
+	if claims.Principal() != "worker" || claims.Mail != "" || claims.Scope != "read write" {
+		t.Errorf("Result: %+v (%s)\n", claims, "The client claims are not correct.")
+	}
+}
+
+func TestKeyRingStore(t *testing.T) {
+	store := newMemoryKeyStore()
+	settings := func() *KeyRingSettings {
+		return &KeyRingSettings{Algorithm: "ES256", VerifyGrace: time.Minute, Store: store}
+	}
+
+	first, err := NewKeyRing(settings())
+	if err != nil {
+		t.Fatalf("Unit Test (New key ring) Fail: %v\n", err)
+	}
+	// Another instance, or the same one after a restart
+	second, err := NewKeyRing(settings())
+	if err != nil {
+		t.Fatalf("Unit Test (New key ring) Fail: %v\n", err)
+	}
+	if first.signingKey().Kid != second.signingKey().Kid {
+		t.Fatalf("Result: %s %s (%s)\n", first.signingKey().Kid, second.signingKey().Kid, "The instances must share the stored key.")
+	}
+
+	keyRing = first
+	token, _, err := GenerateScopedJWT("test@suglider.org", "")
+	if err != nil {
+		t.Fatalf("Unit Test (Generate JWT) Fail: %v\n", err)
+	}
+	keyRing = second
+	if _, _, err = ParseJWT(token); err != nil {
+		t.Errorf("Unit Test (Parse JWT of another instance) Fail: %v\n", err)
+	}
+
+	// The key rotated by one instance is loaded by the other on demand
+	if err = first.Rotate(); err != nil {
+		t.Fatalf("Unit Test (Rotate key) Fail: %v\n", err)
+	}
+	keyRing = first
+	rotatedToken, _, err := GenerateScopedJWT("test@suglider.org", "")
+	if err != nil {
+		t.Fatalf("Unit Test (Generate JWT) Fail: %v\n", err)
+	}
+	keyRing = second
+	second.loadedAt = time.Time{}
+	if _, _, err = ParseJWT(rotatedToken); err != nil {
+		t.Errorf("Unit Test (Parse JWT signed by the rotated key) Fail: %v\n", err)
+	}
+	if _, _, err = ParseJWT(token); err != nil {
+		t.Errorf("Unit Test (Parse JWT signed by the retired key) Fail: %v\n", err)
+	}
+	if len(first.JWKS().Keys) != 2 || len(second.JWKS().Keys) != 2 || first.JWKS().Keys[0] != second.JWKS().Keys[0] {
+		t.Errorf("Result: %v %v (%s)\n", first.JWKS(), second.JWKS(), "The instances must publish the same JWKS.")
+	}
+
+	// A stale instance doesn't rotate the keys again
+	staleKid := second.signingKey().Kid
+	second.mu.Lock()
+	second.active = second.retired[0]
+	second.mu.Unlock()
+	if err = second.Rotate(); err != nil {
+		t.Fatalf("Unit Test (Rotate stale key ring) Fail: %v\n", err)
+	}
+	if kid := second.signingKey().Kid; kid != staleKid {
+		t.Errorf("Result: %s (%s)\n", kid, "The key rotated by the other instance must be kept.")
+	}
+	if keys, _ := store.Load(time.Now().Add(-time.Minute)); len(keys) != 2 {
+		t.Errorf("Result: %d keys (%s)\n", len(keys), "Only one rotation must be stored.")
+	}
+}
+
+func TestAccessTokenAudience(t *testing.T) {
+	kr, err := NewKeyRing(&KeyRingSettings{Algorithm: "ES256"})
+	if err != nil {
+		t.Fatalf("Unit Test (New key ring) Fail: %v\n", err)
+	}
+	keyRing = kr
+
+	token, _, err := GenerateScopedJWT("test@suglider.org", "")
+	if err != nil {
+		t.Fatalf("Unit Test (Generate JWT) Fail: %v\n", err)
+	}
+	claims, _, err := ParseJWT(token)
+	if err != nil {
+		t.Fatalf("Unit Test (Parse JWT) Fail: %v\n", err)
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != accessTokenAudience {
+		t.Errorf("Result: %v (%s)\n", claims.Audience, "The access token must have the aud claim.")
+	}
+
+	// An ID token is signed by the same keys, but it isn't an access token.
+	idToken, err := GenerateIDToken(&IDTokenSettings{
+		Issuer:   "http://localhost:9527",
+		Subject:  "test@suglider.org",
+		Audience: "test-client",
+		TTL:      time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Unit Test (Generate ID token) Fail: %v\n", err)
+	}
+	if _, errCode, err := ParseJWT(idToken); err == nil || errCode != 1017 {
+		t.Errorf("Unit Test (Parse ID token as access token) Fail: %d %v\n", errCode, err)
+	}
+}
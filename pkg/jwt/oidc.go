@@ -0,0 +1,67 @@
+package jwt
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The lifetime of the ID tokens, the retired keys must verify them as long as
+// the access tokens, see KeyRingSettings.VerifyGrace.
+var idTokenTTL = time.Hour
+
+func SetIDTokenTTL(ttl time.Duration) {
+	if ttl > 0 {
+		idTokenTTL = ttl
+	}
+}
+
+type IDTokenSettings struct {
+	Issuer       string
+	Subject      string
+	Audience     string
+	Nonce        string
+	AuthTime     time.Time
+	Mail         string // only included when the email scope is granted
+	MailVerified bool
+	TTL          time.Duration
+}
+
+type idTokenData struct {
+	Nonce         string           `json:"nonce,omitempty"`
+	AuthTime      *jwt.NumericDate `json:"auth_time,omitempty"`
+	Email         string           `json:"email,omitempty"`
+	EmailVerified *bool            `json:"email_verified,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateIDToken issues an OpenID Connect ID token, it's signed by the same
+// key ring as the access token so relying parties verify it with the JWKS.
+func GenerateIDToken(settings *IDTokenSettings) (string, error) {
+	issuedTime := time.Now()
+
+	claims := &idTokenData{
+		Nonce: settings.Nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    settings.Issuer,
+			Subject:   settings.Subject,
+			Audience:  jwt.ClaimStrings{settings.Audience},
+			IssuedAt:  jwt.NewNumericDate(issuedTime),
+			ExpiresAt: jwt.NewNumericDate(issuedTime.Add(settings.TTL)),
+		},
+	}
+	if !settings.AuthTime.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(settings.AuthTime)
+	}
+	if settings.Mail != "" {
+		claims.Email = settings.Mail
+		claims.EmailVerified = &settings.MailVerified
+	}
+
+	return keyRing.sign(claims)
+}
+
+// Algorithm returns the algorithm of the active signing key.
+func (kr *KeyRing) Algorithm() string {
+	return kr.signingKey().Alg
+}
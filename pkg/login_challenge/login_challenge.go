@@ -0,0 +1,313 @@
+package login_challenge
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"suglider-auth/configs"
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/encrypt"
+	"suglider-auth/pkg/time_convert"
+)
+
+// A login challenge is created when the password or the OAuth login has
+// passed and the user has enabled 2FA. The client only gets the random
+// challenge ID, the 2FA endpoints never accept the mail of the user. The
+// challenge is deleted once the required factors have passed, or after too
+// many failed attempts.
+//
+//   login_challenge:<id>          -> Challenge
+//   login_challenge_failures:<id> -> the failed attempts, expires with the challenge
+
+const (
+	FactorTOTP     = "totp"
+	FactorMailOTP  = "mail_otp"
+	FactorSmsOTP   = "sms_otp"
+	FactorWebAuthn = "webauthn"
+)
+
+var (
+	challengeTTL    = 15 * time.Minute
+	maxFailures     = 5
+	requiredFactors = 1
+)
+
+type Challenge struct {
+	Mail     string   `json:"mail"`
+	UserName string   `json:"username"`
+	Factors  []string `json:"factors"` // the 2FA methods enabled by the user
+	Passed   []string `json:"passed"`
+	Required int      `json:"required"` // how many factors must pass
+	Failures int      `json:"-"`        // kept by the counter, see Fail
+}
+
+// failChallenge counts a failed attempt and deletes the challenge when there
+// are too many, in one step so concurrent guesses can't pass the limit. It
+// returns the challenge and the failures, or an empty challenge when it
+// doesn't exist.
+var failChallenge = redis.NewScript(`
+local value = redis.call('GET', KEYS[1])
+if not value then
+  return {'', 0}
+end
+local failures = redis.call('INCR', KEYS[2])
+if failures == 1 then
+  redis.call('PEXPIRE', KEYS[2], redis.call('PTTL', KEYS[1]))
+end
+if failures >= tonumber(ARGV[1]) then
+  redis.call('DEL', KEYS[1], KEYS[2])
+end
+return {value, failures}
+`)
+
+// passChallenge adds a passed factor and deletes the challenge when the
+// required factors have passed, in one step so concurrent requests can't pass
+// the same factor twice or complete the login twice. It returns the challenge
+// and 0, or an empty challenge and the error code.
+var passChallenge = redis.NewScript(`
+local function has(list, item)
+  if type(list) ~= 'table' then
+    return false
+  end
+  for _, value in ipairs(list) do
+    if value == item then
+      return true
+    end
+  end
+  return false
+end
+local value = redis.call('GET', KEYS[1])
+if not value then
+  return {'', 1093}
+end
+local ch = cjson.decode(value)
+if not has(ch.factors, ARGV[1]) or has(ch.passed, ARGV[1]) then
+  return {'', 1095}
+end
+if type(ch.passed) ~= 'table' then
+  ch.passed = {}
+end
+table.insert(ch.passed, ARGV[1])
+value = cjson.encode(ch)
+if #ch.passed >= ch.required then
+  redis.call('DEL', KEYS[1], KEYS[2])
+else
+  redis.call('SET', KEYS[1], value, 'KEEPTTL')
+end
+return {value, 0}
+`)
+
+func init() {
+	configs.OnLoad(loadConfig)
+}
+
+func loadConfig() {
+	challengeConfig := configs.ApplicationConfig.LoginChallenge
+	if challengeConfig == nil {
+		return
+	}
+
+	if challengeConfig.TTL != "" {
+		ttl, _, err := time_convert.ConvertTimeFormat(challengeConfig.TTL)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Login challenge TTL string convert to duration failed: %v", err)
+			slog.Error(errorMessage)
+
+			panic(err)
+		}
+		challengeTTL = ttl
+	}
+	if challengeConfig.MaxFailures > 0 {
+		maxFailures = challengeConfig.MaxFailures
+	}
+	if challengeConfig.RequiredFactors > 0 {
+		requiredFactors = challengeConfig.RequiredFactors
+	}
+}
+
+func challengeKey(id string) string {
+	return "login_challenge:" + id
+}
+
+func failuresKey(id string) string {
+	return "login_challenge_failures:" + id
+}
+
+func hasFactor(factors []string, factor string) bool {
+	for _, f := range factors {
+		if f == factor {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the factor is enabled by the user and has not passed yet.
+func (ch *Challenge) Allows(factor string) bool {
+	return hasFactor(ch.Factors, factor) && !hasFactor(ch.Passed, factor)
+}
+
+// Remaining returns the number of factors which still have to pass.
+func (ch *Challenge) Remaining() int {
+	if remaining := ch.Required - len(ch.Passed); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+func (ch *Challenge) Completed() bool {
+	return ch.Remaining() == 0
+}
+
+// RemainingAttempts returns how many failed attempts are left before the
+// challenge is deleted.
+func (ch *Challenge) RemainingAttempts() int {
+	return maxFailures - ch.Failures
+}
+
+// Create starts a login challenge of the user, factors are the 2FA methods
+// enabled by the user.
+func Create(mail, userName string, factors []string) (string, *Challenge, int64, error) {
+	id, err := encrypt.RandomToken(32)
+	if err != nil {
+		return "", nil, 1076, err
+	}
+
+	required := requiredFactors
+	if required > len(factors) {
+		required = len(factors)
+	}
+
+	ch := &Challenge{
+		Mail:     mail,
+		UserName: userName,
+		Factors:  factors,
+		Passed:   []string{},
+		Required: required,
+	}
+
+	jsonData, err := json.Marshal(ch)
+	if err != nil {
+		return "", nil, 1063, err
+	}
+
+	if err = redis.Set(challengeKey(id), string(jsonData), challengeTTL); err != nil {
+		return "", nil, 1042, err
+	}
+
+	return id, ch, 0, nil
+}
+
+// Get returns the challenge, the error code is 1093 when it doesn't exist.
+func Get(id string) (*Challenge, int64, error) {
+	var ch Challenge
+
+	if id == "" {
+		return nil, 1093, fmt.Errorf("login challenge ID is empty")
+	}
+
+	value, errCode, err := redis.Get(challengeKey(id))
+	if errCode == 1043 {
+		return nil, 1093, err
+	} else if err != nil {
+		return nil, errCode, err
+	}
+
+	if err = json.Unmarshal([]byte(value), &ch); err != nil {
+		return nil, 1063, err
+	}
+
+	failures, errCode, err := redis.Get(failuresKey(id))
+	if errCode == 0 {
+		ch.Failures, err = strconv.Atoi(failures)
+		if err != nil {
+			return nil, 1063, err
+		}
+	} else if errCode != 1043 {
+		return nil, errCode, err
+	}
+
+	return &ch, 0, nil
+}
+
+// Fail records a failed attempt, the challenge is deleted with the error
+// code 1094 when there are too many failures.
+func Fail(id string) (*Challenge, int64, error) {
+	var ch Challenge
+
+	if id == "" {
+		return nil, 1093, fmt.Errorf("login challenge ID is empty")
+	}
+
+	reply, err := redis.RunScript(failChallenge, []string{challengeKey(id), failuresKey(id)}, maxFailures)
+	if err != nil {
+		return nil, 1042, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return nil, 1042, fmt.Errorf("unexpected reply of login challenge: %v", reply)
+	}
+	value, isString := values[0].(string)
+	failures, isNumber := values[1].(int64)
+	if !isString || !isNumber {
+		return nil, 1042, fmt.Errorf("unexpected reply of login challenge: %v", reply)
+	}
+	if value == "" {
+		return nil, 1093, fmt.Errorf("login challenge does not exist")
+	}
+
+	if err = json.Unmarshal([]byte(value), &ch); err != nil {
+		return nil, 1063, err
+	}
+	ch.Failures = int(failures)
+
+	if ch.Failures >= maxFailures {
+		return &ch, 1094, fmt.Errorf("too many failed attempts of login challenge")
+	}
+
+	return &ch, 0, nil
+}
+
+// Pass records a passed factor. When the required factors have passed, the
+// challenge is deleted, so only one request can complete the login.
+func Pass(id, factor string) (*Challenge, int64, error) {
+	var ch Challenge
+
+	if id == "" {
+		return nil, 1093, fmt.Errorf("login challenge ID is empty")
+	}
+
+	reply, err := redis.RunScript(passChallenge, []string{challengeKey(id), failuresKey(id)}, factor)
+	if err != nil {
+		return nil, 1042, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return nil, 1042, fmt.Errorf("unexpected reply of login challenge: %v", reply)
+	}
+	value, isString := values[0].(string)
+	errCode, isNumber := values[1].(int64)
+	if !isString || !isNumber {
+		return nil, 1042, fmt.Errorf("unexpected reply of login challenge: %v", reply)
+	}
+	switch errCode {
+	case 0:
+	case 1093:
+		return nil, 1093, fmt.Errorf("login challenge does not exist")
+	case 1095:
+		return nil, 1095, fmt.Errorf("factor %s is not allowed by login challenge", factor)
+	default:
+		return nil, 1042, fmt.Errorf("unexpected reply of login challenge: %v", reply)
+	}
+
+	if err = json.Unmarshal([]byte(value), &ch); err != nil {
+		return nil, 1063, err
+	}
+
+	return &ch, 0, nil
+}
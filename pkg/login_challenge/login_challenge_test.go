@@ -0,0 +1,211 @@
+package login_challenge
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"suglider-auth/internal/redis"
+)
+
+func TestLoginChallenge(t *testing.T) {
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+
+	defaultRequired, defaultMaxFailures := requiredFactors, maxFailures
+	defer func() { requiredFactors, maxFailures = defaultRequired, defaultMaxFailures }()
+	requiredFactors, maxFailures = 1, 3
+
+	t.Run("Test the challenge can only be completed once", func(t *testing.T) {
+		id, ch, _, err := Create("alice@example.com", "alice", []string{FactorTOTP, FactorMailOTP})
+		if err != nil || ch.Required != 1 {
+			t.Fatalf("Result: %v, %v (%s)\n", ch, err, "The challenge must be created.")
+		}
+
+		if _, errCode, err := Pass(id, FactorSmsOTP); err == nil || errCode != 1095 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "A factor not enabled by the user must not pass.")
+		}
+
+		ch, _, err = Pass(id, FactorTOTP)
+		if err != nil || !ch.Completed() {
+			t.Fatalf("Result: %v, %v (%s)\n", ch, err, "The challenge must be completed.")
+		}
+
+		if _, errCode, err := Pass(id, FactorMailOTP); err == nil || errCode != 1093 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "The completed challenge must be deleted.")
+		}
+	})
+
+	t.Run("Test the challenge needs the required factors", func(t *testing.T) {
+		requiredFactors = 2
+		defer func() { requiredFactors = 1 }()
+
+		id, _, _, err := Create("alice@example.com", "alice", []string{FactorTOTP, FactorWebAuthn})
+		if err != nil {
+			t.Fatalf("Unit Test (Create challenge) Fail: %v\n", err)
+		}
+
+		ch, _, err := Pass(id, FactorTOTP)
+		if err != nil || ch.Completed() || ch.Remaining() != 1 {
+			t.Fatalf("Result: %v, %v (%s)\n", ch, err, "One more factor must pass.")
+		}
+		if ttl := server.TTL(challengeKey(id)); ttl != challengeTTL {
+			t.Errorf("Result: %v (%s)\n", ttl, "The challenge must keep the expiration from its creation.")
+		}
+
+		if _, errCode, err := Pass(id, FactorTOTP); err == nil || errCode != 1095 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "A passed factor must not pass again.")
+		}
+		if ch, _, err = Pass(id, FactorWebAuthn); err != nil || !ch.Completed() {
+			t.Errorf("Result: %v, %v (%s)\n", ch, err, "The challenge must be completed by the second factor.")
+		}
+
+		// A user with a single factor can't pass two
+		_, ch, _, err = Create("bob@example.com", "bob", []string{FactorTOTP})
+		if err != nil || ch.Required != 1 {
+			t.Errorf("Result: %v, %v (%s)\n", ch, err, "The required factors must not exceed the enabled ones.")
+		}
+	})
+
+	t.Run("Test the challenge is deleted after too many failures", func(t *testing.T) {
+		id, _, _, err := Create("alice@example.com", "alice", []string{FactorTOTP})
+		if err != nil {
+			t.Fatalf("Unit Test (Create challenge) Fail: %v\n", err)
+		}
+
+		for attempts := 2; attempts > 0; attempts-- {
+			ch, _, err := Fail(id)
+			if err != nil || ch.RemainingAttempts() != attempts {
+				t.Errorf("Result: %v, %v (%s)\n", ch, err, "The failure must be counted.")
+			}
+		}
+
+		if _, errCode, err := Fail(id); err == nil || errCode != 1094 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "The challenge must be given up after too many failures.")
+		}
+		if _, errCode, err := Get(id); err == nil || errCode != 1093 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "The challenge must be deleted.")
+		}
+	})
+
+	t.Run("Test concurrent failures can't pass the limit", func(t *testing.T) {
+		id, _, _, err := Create("alice@example.com", "alice", []string{FactorTOTP})
+		if err != nil {
+			t.Fatalf("Unit Test (Create challenge) Fail: %v\n", err)
+		}
+
+		var wg sync.WaitGroup
+		errCodes := make(chan int64, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errCode, _ := Fail(id)
+				errCodes <- errCode
+			}()
+		}
+		wg.Wait()
+		close(errCodes)
+
+		counts := map[int64]int{}
+		for errCode := range errCodes {
+			counts[errCode]++
+		}
+		if counts[0] != maxFailures-1 || counts[1094] != 1 || counts[1093] != 10-maxFailures {
+			t.Errorf("Result: %v (%s)\n", counts, "Only the allowed failures may be counted.")
+		}
+	})
+
+	t.Run("Test concurrent passes complete the challenge once", func(t *testing.T) {
+		id, _, _, err := Create("alice@example.com", "alice", []string{FactorTOTP, FactorMailOTP})
+		if err != nil {
+			t.Fatalf("Unit Test (Create challenge) Fail: %v\n", err)
+		}
+		if _, _, err = Fail(id); err != nil {
+			t.Fatalf("Unit Test (Fail challenge) Fail: %v\n", err)
+		}
+
+		var wg sync.WaitGroup
+		errCodes := make(chan int64, 10)
+		for i := 0; i < 10; i++ {
+			factor := FactorTOTP
+			if i%2 == 1 {
+				factor = FactorMailOTP
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errCode, _ := Pass(id, factor)
+				errCodes <- errCode
+			}()
+		}
+		wg.Wait()
+		close(errCodes)
+
+		counts := map[int64]int{}
+		for errCode := range errCodes {
+			counts[errCode]++
+		}
+		if counts[0] != 1 || counts[1093] != 9 {
+			t.Errorf("Result: %v (%s)\n", counts, "Only one request may complete the login.")
+		}
+		if server.Exists(challengeKey(id)) || server.Exists(failuresKey(id)) {
+			t.Errorf("Result: %v (%s)\n", server.Keys(), "The completed challenge and its failures must be deleted.")
+		}
+	})
+
+	t.Run("Test concurrent passes of a factor count it once", func(t *testing.T) {
+		requiredFactors = 2
+		defer func() { requiredFactors = 1 }()
+
+		id, _, _, err := Create("alice@example.com", "alice", []string{FactorTOTP, FactorWebAuthn})
+		if err != nil {
+			t.Fatalf("Unit Test (Create challenge) Fail: %v\n", err)
+		}
+
+		var wg sync.WaitGroup
+		errCodes := make(chan int64, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errCode, _ := Pass(id, FactorTOTP)
+				errCodes <- errCode
+			}()
+		}
+		wg.Wait()
+		close(errCodes)
+
+		counts := map[int64]int{}
+		for errCode := range errCodes {
+			counts[errCode]++
+		}
+		if counts[0] != 1 || counts[1095] != 9 {
+			t.Errorf("Result: %v (%s)\n", counts, "The factor may only pass once.")
+		}
+
+		ch, _, err := Get(id)
+		if err != nil || len(ch.Passed) != 1 || ch.Remaining() != 1 {
+			t.Errorf("Result: %v, %v (%s)\n", ch, err, "One more factor must pass.")
+		}
+		if ttl := server.TTL(challengeKey(id)); ttl != challengeTTL {
+			t.Errorf("Result: %v (%s)\n", ttl, "The challenge must keep the expiration from its creation.")
+		}
+	})
+
+	t.Run("Test the challenge expires", func(t *testing.T) {
+		id, _, _, err := Create("alice@example.com", "alice", []string{FactorTOTP})
+		if err != nil {
+			t.Fatalf("Unit Test (Create challenge) Fail: %v\n", err)
+		}
+		server.FastForward(challengeTTL + time.Second)
+
+		if _, errCode, err := Pass(id, FactorTOTP); err == nil || errCode != 1093 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "The expired challenge must not pass.")
+		}
+	})
+}
@@ -0,0 +1,360 @@
+package login_lockout
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"suglider-auth/configs"
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/encrypt"
+	"suglider-auth/pkg/time_convert"
+)
+
+// The failed logins are counted per account and per source IP. Every failure
+// delays the next attempt, the delay doubles with every failure. After too
+// many failures in the window the account or the IP is locked, and every
+// lockout in a row doubles the lockout duration. A successful login of the
+// account resets its counters.
+//
+//   login_failure:<scope>:<hash>       -> count of the failures in the window
+//   login_backoff:<scope>:<hash>       -> exists until the next attempt is allowed
+//   login_lockout:<scope>:<hash>       -> Lockout
+//   login_lockout_level:<scope>:<hash> -> count of the lockouts in a row
+//   login_unlock:<token>               -> <scope>:<hash> of the locked account
+//   login_lockouts                     -> set of <scope>:<hash> which have been locked
+
+const (
+	ScopeAccount = "account"
+	ScopeIP      = "ip"
+
+	lockoutSetKey = "login_lockouts"
+)
+
+var (
+	threshold          int64 = 10
+	ipThreshold        int64 = 100
+	window                   = 15 * time.Minute
+	backoffBase              = time.Second
+	backoffMax               = 30 * time.Second
+	lockoutDuration          = 15 * time.Minute
+	maxLockoutDuration       = 24 * time.Hour
+)
+
+type Lockout struct {
+	Scope     string `json:"scope"`
+	Subject   string `json:"subject"` // the mail or the IP
+	Failures  int64  `json:"failures"`
+	Level     int64  `json:"level"` // how many times it has been locked in a row
+	LockedAt  int64  `json:"locked_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+type subject struct {
+	scope     string
+	value     string
+	threshold int64
+}
+
+func init() {
+	configs.OnLoad(loadConfig)
+}
+
+func loadConfig() {
+	lockoutConfig := configs.ApplicationConfig.Lockout
+	if lockoutConfig == nil {
+		return
+	}
+
+	if lockoutConfig.Threshold > 0 {
+		threshold = lockoutConfig.Threshold
+	}
+	if lockoutConfig.IPThreshold > 0 {
+		ipThreshold = lockoutConfig.IPThreshold
+	}
+
+	durations := []struct {
+		name  string
+		value string
+		field *time.Duration
+	}{
+		{"window", lockoutConfig.Window, &window},
+		{"backoff_base", lockoutConfig.BackoffBase, &backoffBase},
+		{"backoff_max", lockoutConfig.BackoffMax, &backoffMax},
+		{"duration", lockoutConfig.Duration, &lockoutDuration},
+		{"max_duration", lockoutConfig.MaxDuration, &maxLockoutDuration},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		duration, _, err := time_convert.ConvertTimeFormat(d.value)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Lockout %s string convert to duration failed: %v", d.name, err)
+			slog.Error(errorMessage)
+
+			panic(err)
+		}
+		*d.field = duration
+	}
+}
+
+func subjectID(scope, value string) string {
+	return scope + ":" + encrypt.HashWithSHA(strings.ToLower(value), "sha1")
+}
+
+func subjects(mail, ip string) []subject {
+	list := []subject{}
+	if mail != "" {
+		list = append(list, subject{ScopeAccount, mail, threshold})
+	}
+	if ip != "" {
+		list = append(list, subject{ScopeIP, ip, ipThreshold})
+	}
+	return list
+}
+
+// doubled returns base doubled n times, it's never longer than max.
+func doubled(base time.Duration, n int64, max time.Duration) time.Duration {
+	delay := base
+	for i := int64(0); i < n && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+func getLockout(id string) (*Lockout, error) {
+	var lockout Lockout
+
+	value, errCode, err := redis.Get("login_lockout:" + id)
+	if errCode == 1043 {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal([]byte(value), &lockout); err != nil {
+		return nil, err
+	}
+	return &lockout, nil
+}
+
+// Check returns how long the next attempt of the account from the IP has to
+// wait, zero means it's allowed. The lockout is returned when the account or
+// the IP is locked, otherwise it's the delay after the last failure.
+func Check(mail, ip string) (time.Duration, *Lockout, error) {
+	for _, s := range subjects(mail, ip) {
+		id := subjectID(s.scope, s.value)
+
+		lockout, err := getLockout(id)
+		if err != nil {
+			return 0, nil, err
+		}
+		if lockout != nil {
+			retryAfter := time.Until(time.Unix(lockout.ExpiresAt, 0))
+			if retryAfter < time.Second {
+				retryAfter = time.Second
+			}
+			return retryAfter, lockout, nil
+		}
+
+		retryAfter, err := redis.TTL("login_backoff:" + id)
+		if err != nil {
+			return 0, nil, err
+		}
+		if retryAfter > 0 {
+			return retryAfter, nil, nil
+		}
+	}
+
+	return 0, nil, nil
+}
+
+// Fail records a failed attempt of the account from the IP, mail is empty
+// when the account doesn't exist. It returns how long the next attempt has to
+// wait, and the lockout with its unlock token when the account is locked by
+// this failure.
+func Fail(mail, ip string) (time.Duration, *Lockout, string, error) {
+	var (
+		retryAfter    time.Duration
+		lockedAccount *Lockout
+		unlockToken   string
+	)
+
+	for _, s := range subjects(mail, ip) {
+		id := subjectID(s.scope, s.value)
+
+		failures, err := redis.Incr("login_failure:"+id, window)
+		if err != nil {
+			return 0, nil, "", err
+		}
+
+		if failures < s.threshold {
+			delay := doubled(backoffBase, failures-1, backoffMax)
+			if delay <= 0 {
+				continue
+			}
+			if err = redis.Set("login_backoff:"+id, "1", delay); err != nil {
+				return 0, nil, "", err
+			}
+			if delay > retryAfter {
+				retryAfter = delay
+			}
+			continue
+		}
+
+		lockout, token, err := lock(id, s, failures)
+		if err != nil {
+			return 0, nil, "", err
+		}
+		if duration := time.Until(time.Unix(lockout.ExpiresAt, 0)); duration > retryAfter {
+			retryAfter = duration
+		}
+		if s.scope == ScopeAccount {
+			lockedAccount, unlockToken = lockout, token
+		}
+	}
+
+	return retryAfter, lockedAccount, unlockToken, nil
+}
+
+func lock(id string, s subject, failures int64) (*Lockout, string, error) {
+	level, err := redis.Incr("login_lockout_level:"+id, maxLockoutDuration)
+	if err != nil {
+		return nil, "", err
+	}
+	duration := doubled(lockoutDuration, level-1, maxLockoutDuration)
+
+	now := time.Now()
+	lockout := &Lockout{
+		Scope:     s.scope,
+		Subject:   s.value,
+		Failures:  failures,
+		Level:     level,
+		LockedAt:  now.Unix(),
+		ExpiresAt: now.Add(duration).Unix(),
+	}
+
+	jsonData, err := json.Marshal(lockout)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = redis.Set("login_lockout:"+id, string(jsonData), duration); err != nil {
+		return nil, "", err
+	}
+	if err = redis.SAdd(lockoutSetKey, id, maxLockoutDuration); err != nil {
+		return nil, "", err
+	}
+
+	// The failures are counted again after the lockout
+	if err = redis.Delete("login_failure:" + id); err != nil {
+		return nil, "", err
+	}
+
+	// Only the owner of the account can unlock it by the link in the mail
+	var token string
+	if s.scope == ScopeAccount {
+		if token, err = encrypt.RandomToken(32); err != nil {
+			return nil, "", err
+		}
+		if err = redis.Set("login_unlock:"+token, id, duration); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return lockout, token, nil
+}
+
+// Succeed resets the counters of the account after a successful login.
+func Succeed(mail string) error {
+	id := subjectID(ScopeAccount, mail)
+	for _, key := range []string{"login_failure:", "login_backoff:", "login_lockout_level:"} {
+		if err := redis.Delete(key + id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func clearID(id string) error {
+	for _, key := range []string{"login_lockout:", "login_failure:", "login_backoff:", "login_lockout_level:"} {
+		if err := redis.Delete(key + id); err != nil {
+			return err
+		}
+	}
+	return redis.SRem(lockoutSetKey, id)
+}
+
+// Clear removes the lockout and the counters of the account or the IP, it
+// returns the lockout which has been removed.
+func Clear(scope, value string) (*Lockout, error) {
+	id := subjectID(scope, value)
+
+	lockout, err := getLockout(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = clearID(id); err != nil {
+		return nil, err
+	}
+	return lockout, nil
+}
+
+// Unlock removes the lockout of the account by the token in the unlock mail,
+// the error code is 1113 when the token is invalid or expired.
+func Unlock(token string) (*Lockout, int64, error) {
+	if token == "" {
+		return nil, 1113, fmt.Errorf("unlock token is empty")
+	}
+
+	id, errCode, err := redis.GetDel("login_unlock:" + token)
+	if errCode == 1043 {
+		return nil, 1113, err
+	} else if err != nil {
+		return nil, errCode, err
+	}
+
+	lockout, err := getLockout(id)
+	if err != nil {
+		return nil, 1044, err
+	}
+	if lockout == nil {
+		return nil, 1113, fmt.Errorf("the lockout has expired")
+	}
+
+	if err = clearID(id); err != nil {
+		return nil, 1040, err
+	}
+	return lockout, 0, nil
+}
+
+// List returns the accounts and the IPs which are locked now.
+func List() ([]Lockout, error) {
+	ids, err := redis.SMembers(lockoutSetKey)
+	if err != nil {
+		return nil, err
+	}
+
+	lockouts := []Lockout{}
+	for _, id := range ids {
+		lockout, err := getLockout(id)
+		if err != nil {
+			return nil, err
+		}
+		if lockout == nil {
+			if err = redis.SRem(lockoutSetKey, id); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		lockouts = append(lockouts, *lockout)
+	}
+
+	return lockouts, nil
+}
@@ -0,0 +1,117 @@
+package login_lockout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"suglider-auth/internal/redis"
+)
+
+func TestDoubled(t *testing.T) {
+	testCases := []struct {
+		base     time.Duration
+		n        int64
+		max      time.Duration
+		expected time.Duration
+	}{
+		{time.Second, 0, 30 * time.Second, time.Second},
+		{time.Second, 1, 30 * time.Second, 2 * time.Second},
+		{time.Second, 4, 30 * time.Second, 16 * time.Second},
+		{time.Second, 5, 30 * time.Second, 30 * time.Second},
+		{time.Second, 1000, 30 * time.Second, 30 * time.Second},
+		{15 * time.Minute, 6, 24 * time.Hour, 16 * time.Hour},
+		{15 * time.Minute, 7, 24 * time.Hour, 24 * time.Hour},
+		{time.Minute, 3, 30 * time.Second, 30 * time.Second},
+		{0, 3, 30 * time.Second, 0},
+	}
+
+	for _, testCase := range testCases {
+		if delay := doubled(testCase.base, testCase.n, testCase.max); delay != testCase.expected {
+			t.Errorf("Result: doubled(%v, %d, %v) = %v (%s)\n",
+				testCase.base, testCase.n, testCase.max, delay, "The delay must double and never exceed the max.")
+		}
+	}
+}
+
+func TestLockout(t *testing.T) {
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+
+	defaultThreshold := threshold
+	threshold = 3
+	defer func() { threshold = defaultThreshold }()
+
+	mail, ip := "alice@example.com", "10.0.0.1"
+
+	t.Run("Test the backoff doubles after every failure", func(t *testing.T) {
+		for failures, expected := range []time.Duration{backoffBase, 2 * backoffBase} {
+			retryAfter, lockout, _, err := Fail(mail, ip)
+			if err != nil || lockout != nil || retryAfter != expected {
+				t.Errorf("Result: %v, %v, %v (%s %d)\n", retryAfter, lockout, err, "Wrong backoff after failure", failures+1)
+			}
+		}
+
+		retryAfter, lockout, err := Check(mail, ip)
+		if err != nil || lockout != nil || retryAfter <= 0 {
+			t.Errorf("Result: %v, %v, %v (%s)\n", retryAfter, lockout, err, "The next attempt must wait for the backoff.")
+		}
+	})
+
+	var unlockToken string
+	t.Run("Test the account is locked at the threshold", func(t *testing.T) {
+		retryAfter, lockout, token, err := Fail(mail, ip)
+		if err != nil || lockout == nil || token == "" {
+			t.Fatalf("Result: %v, %v, %v (%s)\n", retryAfter, lockout, err, "The account must be locked.")
+		}
+		if lockout.Scope != ScopeAccount || lockout.Subject != mail || lockout.Failures != 3 || lockout.Level != 1 {
+			t.Errorf("Result: %v (%s)\n", lockout, "The lockout is not correct.")
+		}
+		if retryAfter < lockoutDuration-time.Second {
+			t.Errorf("Result: %v (%s)\n", retryAfter, "The next attempt must wait for the lockout.")
+		}
+		unlockToken = token
+
+		_, lockout, err = Check(mail, "10.0.0.2")
+		if err != nil || lockout == nil {
+			t.Errorf("Result: %v, %v (%s)\n", lockout, err, "The account must be locked from every IP.")
+		}
+		if retryAfter, lockout, err = Check("bob@example.com", "10.0.0.2"); err != nil || lockout != nil || retryAfter != 0 {
+			t.Errorf("Result: %v, %v, %v (%s)\n", retryAfter, lockout, err, "Another account must not be locked.")
+		}
+	})
+
+	t.Run("Test unlock by the token", func(t *testing.T) {
+		lockout, _, err := Unlock(unlockToken)
+		if err != nil || lockout == nil || lockout.Subject != mail {
+			t.Fatalf("Result: %v, %v (%s)\n", lockout, err, "The account must be unlocked.")
+		}
+
+		if _, lockout, err = Check(mail, "10.0.0.2"); err != nil || lockout != nil {
+			t.Errorf("Result: %v, %v (%s)\n", lockout, err, "The account must not be locked after unlock.")
+		}
+		if _, errCode, err := Unlock(unlockToken); err == nil || errCode != 1113 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "The unlock token can only be used once.")
+		}
+	})
+
+	t.Run("Test a successful login resets the failures", func(t *testing.T) {
+		mail := "carol@example.com"
+		for i := 0; i < 2; i++ {
+			if _, _, _, err := Fail(mail, ""); err != nil {
+				t.Fatalf("Unit Test (Record failure) Fail: %v\n", err)
+			}
+		}
+		if err := Succeed(mail); err != nil {
+			t.Fatalf("Unit Test (Reset failures) Fail: %v\n", err)
+		}
+
+		retryAfter, lockout, _, err := Fail(mail, "")
+		if err != nil || lockout != nil || retryAfter != backoffBase {
+			t.Errorf("Result: %v, %v, %v (%s)\n", retryAfter, lockout, err, "The failures must be counted from the start.")
+		}
+	})
+}
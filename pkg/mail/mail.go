@@ -78,6 +78,28 @@ type OTPmailReplace struct {
 	OTPcode string
 }
 
+type RecoveryCodeReplace struct {
+	Name      string
+	UsedAt    string
+	Remaining int
+}
+
+type MagicLinkReplace struct {
+	Name        string
+	ExpiresIn   string
+	Url         string
+	Uri         string
+	QueryParams string
+}
+
+type AccountLockedReplace struct {
+	Name        string
+	LockedUntil string
+	Url         string
+	Uri         string
+	QueryParams string
+}
+
 func (hm *HtmlMail) GenerateVerifyMail(ctx context.Context, tempFile, userName, queryParams string) (string, error) {
 	tmplFile, err := ioutil.ReadFile(tempFile)
 	if err != nil {
@@ -144,3 +166,76 @@ func (hm *HtmlMail) GenerateOTPMail(ctx context.Context, code, firstName, tempFi
 	}
 	return buf.String(), nil
 }
+
+func (hm *HtmlMail) GenerateRecoveryCodeUsedMail(ctx context.Context, userName, usedAt string, remaining int, tempFile string) (string, error) {
+	tmplFile, err := ioutil.ReadFile(tempFile)
+	if err != nil {
+		return "", err
+	}
+
+	tmpl, err := template.New("htmlMail").Parse(string(tmplFile))
+	if err != nil {
+		return "", err
+	}
+	replaceContent := RecoveryCodeReplace{
+		Name:      userName,
+		UsedAt:    usedAt,
+		Remaining: remaining,
+	}
+
+	buf := new(bytes.Buffer)
+	if err = tmpl.Execute(buf, replaceContent); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (hm *HtmlMail) GenerateAccountLockedMail(ctx context.Context, tempFile, userName, lockedUntil, queryParams string) (string, error) {
+	tmplFile, err := ioutil.ReadFile(tempFile)
+	if err != nil {
+		return "", err
+	}
+
+	tmpl, err := template.New("htmlMail").Parse(string(tmplFile))
+	if err != nil {
+		return "", err
+	}
+	replaceContent := AccountLockedReplace{
+		Name:        userName,
+		LockedUntil: lockedUntil,
+		Url:         hm.RequestUrl.Url,
+		Uri:         fmt.Sprintf("%s%s", hm.RequestUrl.Path, "/user/unlock"),
+		QueryParams: queryParams,
+	}
+
+	buf := new(bytes.Buffer)
+	if err = tmpl.Execute(buf, replaceContent); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (hm *HtmlMail) GenerateMagicLinkMail(ctx context.Context, tempFile, userName, expiresIn, queryParams string) (string, error) {
+	tmplFile, err := ioutil.ReadFile(tempFile)
+	if err != nil {
+		return "", err
+	}
+
+	tmpl, err := template.New("htmlMail").Parse(string(tmplFile))
+	if err != nil {
+		return "", err
+	}
+	replaceContent := MagicLinkReplace{
+		Name:        userName,
+		ExpiresIn:   expiresIn,
+		Url:         hm.RequestUrl.Url,
+		Uri:         fmt.Sprintf("%s%s", hm.RequestUrl.Path, "/user/magic-link"),
+		QueryParams: queryParams,
+	}
+
+	buf := new(bytes.Buffer)
+	if err = tmpl.Execute(buf, replaceContent); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
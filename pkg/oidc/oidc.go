@@ -0,0 +1,156 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"suglider-auth/configs"
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/encrypt"
+	"suglider-auth/pkg/time_convert"
+)
+
+// Authorization codes are single-use, only the SHA-256 of a code is stored in
+// redis and it's deleted when the code is exchanged.
+//
+//   oidc_code:<hash> -> AuthorizationCode
+
+var (
+	Issuer     = "http://localhost:9527"
+	LoginUrl   string
+	codeTTL    = 5 * time.Minute
+	IDTokenTTL = time.Hour
+)
+
+var SupportedScopes = []string{"openid", "profile", "email"}
+
+type AuthorizationCode struct {
+	ClientID      string `json:"client_id"`
+	RedirectURI   string `json:"redirect_uri"`
+	Mail          string `json:"mail"`
+	Scope         string `json:"scope"`
+	Nonce         string `json:"nonce"`
+	CodeChallenge string `json:"code_challenge"`
+	AuthTime      int64  `json:"auth_time"` // unix milliseconds
+}
+
+func init() {
+	configs.OnLoad(loadConfig)
+}
+
+func loadConfig() {
+	oidcConfig := configs.ApplicationConfig.Oidc
+	if oidcConfig == nil {
+		return
+	}
+
+	if oidcConfig.Issuer != "" {
+		Issuer = strings.TrimSuffix(oidcConfig.Issuer, "/")
+	}
+	LoginUrl = oidcConfig.LoginUrl
+
+	if oidcConfig.CodeTTL != "" {
+		codeTTL = parseTTL(oidcConfig.CodeTTL)
+	}
+	if oidcConfig.IDTokenTTL != "" {
+		IDTokenTTL = parseTTL(oidcConfig.IDTokenTTL)
+	}
+}
+
+func parseTTL(ttl string) time.Duration {
+	duration, _, err := time_convert.ConvertTimeFormat(ttl)
+	if err != nil {
+		errorMessage := fmt.Sprintf("OIDC TTL string convert to duration failed: %v", err)
+		slog.Error(errorMessage)
+
+		panic(err)
+	}
+	return duration
+}
+
+// IssueCode stores the authorization request of a logged in user and returns
+// the code which the client exchanges at the token endpoint.
+func IssueCode(data *AuthorizationCode) (string, int64, error) {
+	code, err := encrypt.RandomToken(32)
+	if err != nil {
+		return "", 1076, err
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return "", 1068, err
+	}
+
+	err = redis.Set("oidc_code:"+hashCode(code), string(jsonData), codeTTL)
+	if err != nil {
+		return "", 1042, err
+	}
+
+	return code, 0, nil
+}
+
+// ExchangeCode consumes the authorization code, a code can only be exchanged once.
+func ExchangeCode(code string) (*AuthorizationCode, int64, error) {
+	var data AuthorizationCode
+
+	value, errCode, err := redis.GetDel("oidc_code:" + hashCode(code))
+	switch errCode {
+	case 1043:
+		return nil, 1079, fmt.Errorf("The authorization code is invalid or expired.")
+	case 1044:
+		return nil, errCode, err
+	}
+
+	if err = json.Unmarshal([]byte(value), &data); err != nil {
+		return nil, 1063, err
+	}
+
+	return &data, 0, nil
+}
+
+// VerifyPKCE checks the code verifier against the S256 code challenge (RFC 7636).
+func VerifyPKCE(verifier, challenge string) bool {
+	if verifier == "" || challenge == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// HasScope reports whether the space separated scope list includes the item.
+func HasScope(scope, item string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterScope keeps the requested scopes which are allowed.
+func FilterScope(requested, allowed string) string {
+	var granted []string
+	for _, s := range strings.Fields(requested) {
+		if HasScope(allowed, s) && !HasScope(strings.Join(granted, " "), s) {
+			granted = append(granted, s)
+		}
+	}
+	return strings.Join(granted, " ")
+}
+
+// HasRedirectURI reports whether the redirect URI is registered, it must match exactly.
+func HasRedirectURI(redirectURIs, uri string) bool {
+	return HasScope(redirectURIs, uri)
+}
+
+func hashCode(code string) string {
+	return encrypt.HashWithSHA(code, "sha256")
+}
@@ -0,0 +1,91 @@
+package oidc
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"suglider-auth/internal/redis"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	// The example of RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	testCases := []struct {
+		verifier  string
+		challenge string
+		expected  bool
+		reason    string
+	}{
+		{verifier, challenge, true, "The verifier of the S256 challenge must pass."},
+		{verifier + "x", challenge, false, "Another verifier must not pass."},
+		{verifier, verifier, false, "The plain challenge must not pass."},
+		{"", challenge, false, "An empty verifier must not pass."},
+		{verifier, "", false, "An empty challenge must not pass."},
+	}
+	for _, testCase := range testCases {
+		if result := VerifyPKCE(testCase.verifier, testCase.challenge); result != testCase.expected {
+			t.Errorf("Result: %s %s -> %v (%s)\n", testCase.verifier, testCase.challenge, result, testCase.reason)
+		}
+	}
+}
+
+func TestHasRedirectURI(t *testing.T) {
+	redirectURIs := "https://app.example.com/callback http://localhost:3000/callback"
+
+	testCases := []struct {
+		uri      string
+		expected bool
+		reason   string
+	}{
+		{"https://app.example.com/callback", true, "The registered URI must match."},
+		{"http://localhost:3000/callback", true, "Every registered URI must match."},
+		{"https://app.example.com/callback/", false, "The URI must match exactly."},
+		{"https://app.example.com/callback?next=/", false, "The URI must match exactly."},
+		{"https://evil.example.com/callback", false, "An unregistered URI must not match."},
+		{"", false, "An empty URI must not match."},
+	}
+	for _, testCase := range testCases {
+		if result := HasRedirectURI(redirectURIs, testCase.uri); result != testCase.expected {
+			t.Errorf("Result: %s -> %v (%s)\n", testCase.uri, result, testCase.reason)
+		}
+	}
+}
+
+func TestExchangeCode(t *testing.T) {
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+
+	code, _, err := IssueCode(&AuthorizationCode{ClientID: "app", Mail: "alice@example.com", Scope: "openid"})
+	if err != nil {
+		t.Fatalf("Unit Test (Issue code) Fail: %v\n", err)
+	}
+	if server.Exists("oidc_code:" + code) {
+		t.Errorf("Result: %v (%s)\n", server.Keys(), "Only the hash of the code must be stored.")
+	}
+
+	data, _, err := ExchangeCode(code)
+	if err != nil || data.ClientID != "app" || data.Mail != "alice@example.com" {
+		t.Fatalf("Result: %v, %v (%s)\n", data, err, "The code must be exchanged.")
+	}
+
+	if _, errCode, err := ExchangeCode(code); err == nil || errCode != 1079 {
+		t.Errorf("Result: %d, %v (%s)\n", errCode, err, "The code must only be exchanged once.")
+	}
+	if _, errCode, err := ExchangeCode("unknown"); err == nil || errCode != 1079 {
+		t.Errorf("Result: %d, %v (%s)\n", errCode, err, "An unknown code must be invalid.")
+	}
+
+	code, _, err = IssueCode(&AuthorizationCode{ClientID: "app"})
+	if err != nil {
+		t.Fatalf("Unit Test (Issue code) Fail: %v\n", err)
+	}
+	server.FastForward(codeTTL)
+	if _, errCode, err := ExchangeCode(code); err == nil || errCode != 1079 {
+		t.Errorf("Result: %d, %v (%s)\n", errCode, err, "The expired code must be invalid.")
+	}
+}
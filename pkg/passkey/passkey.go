@@ -0,0 +1,309 @@
+package passkey
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"suglider-auth/configs"
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/encrypt"
+	"suglider-auth/pkg/time_convert"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// A WebAuthn ceremony has two requests, the begin request returns the options
+// for the browser and a ceremony ID, the finish request sends the ceremony ID
+// with the response of the authenticator. The state between the requests is
+// kept in Redis and can only be used once.
+//
+//   webauthn_ceremony:<id> -> Ceremony
+
+const (
+	CeremonyRegistration = "registration"
+	CeremonyLogin        = "login"
+	CeremonyReauth       = "reauth"
+)
+
+var (
+	web         *webauthn.WebAuthn
+	ceremonyTTL = 5 * time.Minute
+)
+
+type Ceremony struct {
+	Kind        string               `json:"kind"`
+	Mail        string               `json:"mail,omitempty"`
+	Name        string               `json:"name,omitempty"`         // the name of the new credential
+	ChallengeID string               `json:"challenge_id,omitempty"` // the login challenge when a passkey is the second factor
+	Session     webauthn.SessionData `json:"session"`
+}
+
+// User is the user of the WebAuthn ceremonies, the WebAuthn ID is the user_id.
+type User struct {
+	ID          []byte
+	Mail        string
+	DisplayName string
+	Credentials []webauthn.Credential
+}
+
+func init() {
+	configs.OnLoad(loadConfig)
+}
+
+func loadConfig() {
+	webAuthnConfig := configs.ApplicationConfig.WebAuthn
+	if webAuthnConfig == nil || webAuthnConfig.RPID == "" {
+		return
+	}
+
+	if webAuthnConfig.CeremonyTTL != "" {
+		ttl, _, err := time_convert.ConvertTimeFormat(webAuthnConfig.CeremonyTTL)
+		if err != nil {
+			errorMessage := fmt.Sprintf("WebAuthn ceremony TTL string convert to duration failed: %v", err)
+			slog.Error(errorMessage)
+
+			panic(err)
+		}
+		ceremonyTTL = ttl
+	}
+
+	displayName := webAuthnConfig.RPDisplayName
+	if displayName == "" {
+		displayName = webAuthnConfig.RPID
+	}
+
+	var err error
+	web, err = webauthn.New(&webauthn.Config{
+		RPID:          webAuthnConfig.RPID,
+		RPDisplayName: displayName,
+		RPOrigins:     webAuthnConfig.RPOrigins,
+	})
+	if err != nil {
+		errorMessage := fmt.Sprintf("WebAuthn initialization failed: %v", err)
+		slog.Error(errorMessage)
+
+		panic(err)
+	}
+}
+
+// Enabled reports whether [webauthn] is configured.
+func Enabled() bool {
+	return web != nil
+}
+
+// NewUser returns the user of the ceremonies, userID is the hex of user_id
+// and credentials are the stored credentials in JSON.
+func NewUser(userID, mail, displayName string, credentials []string) (*User, error) {
+	id, err := hex.DecodeString(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		ID:          id,
+		Mail:        mail,
+		DisplayName: displayName,
+		Credentials: make([]webauthn.Credential, 0, len(credentials)),
+	}
+	if user.DisplayName == "" {
+		user.DisplayName = mail
+	}
+
+	for _, credential := range credentials {
+		var cred webauthn.Credential
+		if err = json.Unmarshal([]byte(credential), &cred); err != nil {
+			return nil, err
+		}
+		user.Credentials = append(user.Credentials, cred)
+	}
+
+	return user, nil
+}
+
+func (user *User) WebAuthnID() []byte {
+	return user.ID
+}
+
+func (user *User) WebAuthnName() string {
+	return user.Mail
+}
+
+func (user *User) WebAuthnDisplayName() string {
+	return user.DisplayName
+}
+
+func (user *User) WebAuthnCredentials() []webauthn.Credential {
+	return user.Credentials
+}
+
+func (user *User) WebAuthnIcon() string {
+	return ""
+}
+
+func ceremonyKey(id string) string {
+	return "webauthn_ceremony:" + id
+}
+
+func saveCeremony(ceremony *Ceremony) (string, int64, error) {
+	id, err := encrypt.RandomToken(32)
+	if err != nil {
+		return "", 1076, err
+	}
+
+	jsonData, err := json.Marshal(ceremony)
+	if err != nil {
+		return "", 1063, err
+	}
+
+	if err = redis.Set(ceremonyKey(id), string(jsonData), ceremonyTTL); err != nil {
+		return "", 1042, err
+	}
+
+	return id, 0, nil
+}
+
+// TakeCeremony returns the ceremony and deletes it, the error code is 1105
+// when it doesn't exist or is not the expected kind.
+func TakeCeremony(id, kind string) (*Ceremony, int64, error) {
+	var ceremony Ceremony
+
+	if id == "" {
+		return nil, 1105, fmt.Errorf("WebAuthn ceremony ID is empty")
+	}
+
+	value, errCode, err := redis.GetDel(ceremonyKey(id))
+	if errCode == 1043 {
+		return nil, 1105, err
+	} else if err != nil {
+		return nil, errCode, err
+	}
+
+	if err = json.Unmarshal([]byte(value), &ceremony); err != nil {
+		return nil, 1063, err
+	}
+	if ceremony.Kind != kind {
+		return nil, 1105, fmt.Errorf("WebAuthn ceremony is not %s", kind)
+	}
+
+	return &ceremony, 0, nil
+}
+
+// BeginRegistration starts the registration of a new credential named name,
+// the existing credentials of the user are excluded.
+func BeginRegistration(user *User, name string) (*protocol.CredentialCreation, string, int64, error) {
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.Credentials))
+	for _, cred := range user.Credentials {
+		exclusions = append(exclusions, cred.Descriptor())
+	}
+
+	creation, session, err := web.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return nil, "", 1106, err
+	}
+
+	id, errCode, err := saveCeremony(&Ceremony{
+		Kind:    CeremonyRegistration,
+		Mail:    user.Mail,
+		Name:    name,
+		Session: *session,
+	})
+	if err != nil {
+		return nil, "", errCode, err
+	}
+
+	return creation, id, 0, nil
+}
+
+// FinishRegistration verifies the response of the authenticator in the body
+// of the request and returns the new credential.
+func FinishRegistration(user *User, ceremony *Ceremony, r *http.Request) (*webauthn.Credential, error) {
+	return web.FinishRegistration(user, ceremony.Session, r)
+}
+
+// BeginLogin starts a login with the credentials of the user, which is the
+// second factor of the login challenge challengeID.
+func BeginLogin(user *User, challengeID string) (*protocol.CredentialAssertion, string, int64, error) {
+	return beginAssertion(user, CeremonyLogin, challengeID)
+}
+
+// BeginReauth starts to authenticate the user of a session again, the
+// ceremony can't be finished as a login.
+func BeginReauth(user *User) (*protocol.CredentialAssertion, string, int64, error) {
+	return beginAssertion(user, CeremonyReauth, "")
+}
+
+func beginAssertion(user *User, kind, challengeID string) (*protocol.CredentialAssertion, string, int64, error) {
+	assertion, session, err := web.BeginLogin(user)
+	if err != nil {
+		return nil, "", 1106, err
+	}
+
+	id, errCode, err := saveCeremony(&Ceremony{
+		Kind:        kind,
+		Mail:        user.Mail,
+		ChallengeID: challengeID,
+		Session:     *session,
+	})
+	if err != nil {
+		return nil, "", errCode, err
+	}
+
+	return assertion, id, 0, nil
+}
+
+// BeginDiscoverableLogin starts a passwordless login, the authenticator
+// chooses the credential and must verify the user.
+func BeginDiscoverableLogin() (*protocol.CredentialAssertion, string, int64, error) {
+	assertion, session, err := web.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return nil, "", 1106, err
+	}
+
+	id, errCode, err := saveCeremony(&Ceremony{
+		Kind:    CeremonyLogin,
+		Session: *session,
+	})
+	if err != nil {
+		return nil, "", errCode, err
+	}
+
+	return assertion, id, 0, nil
+}
+
+// FinishLogin verifies the assertion in the body of the request and returns
+// the credential with the updated sign count. lookup is only called for a
+// passwordless login to find the user of the credential.
+func FinishLogin(user *User, ceremony *Ceremony, r *http.Request, lookup func(rawID []byte) (*User, error)) (*User, *webauthn.Credential, error) {
+	var (
+		cred *webauthn.Credential
+		err  error
+	)
+
+	if user != nil {
+		cred, err = web.FinishLogin(user, ceremony.Session, r)
+	} else {
+		cred, err = web.FinishDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			user, err = lookup(rawID)
+			return user, err
+		}, ceremony.Session, r)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cred.Authenticator.CloneWarning {
+		return nil, nil, fmt.Errorf("the sign count of the credential indicates a cloned authenticator")
+	}
+
+	return user, cred, nil
+}
@@ -0,0 +1,76 @@
+package passkey
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-webauthn/webauthn/webauthn"
+
+	"suglider-auth/internal/redis"
+)
+
+func TestCeremony(t *testing.T) {
+	if !Enabled() {
+		t.Skip("[webauthn] is not configured")
+	}
+
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+
+	credential, err := json.Marshal(webauthn.Credential{ID: []byte("credential-1"), PublicKey: []byte("public-key")})
+	if err != nil {
+		t.Fatalf("Unit Test (Marshal credential) Fail: %v\n", err)
+	}
+	user, err := NewUser(hex.EncodeToString([]byte("user-1")), "alice@example.com", "", []string{string(credential)})
+	if err != nil {
+		t.Fatalf("Unit Test (New user) Fail: %v\n", err)
+	}
+	if user.DisplayName != user.Mail || len(user.WebAuthnCredentials()) != 1 {
+		t.Errorf("Result: %v (%s)\n", user, "The user must have the mail as display name and the stored credential.")
+	}
+
+	t.Run("Test the ceremony can only be taken once", func(t *testing.T) {
+		_, id, _, err := BeginLogin(user, "challenge-1")
+		if err != nil {
+			t.Fatalf("Unit Test (Begin login) Fail: %v\n", err)
+		}
+		if ttl := server.TTL(ceremonyKey(id)); ttl != ceremonyTTL {
+			t.Errorf("Result: %v (%s)\n", ttl, "The ceremony must expire.")
+		}
+
+		ceremony, _, err := TakeCeremony(id, CeremonyLogin)
+		if err != nil || ceremony.Mail != user.Mail || ceremony.ChallengeID != "challenge-1" {
+			t.Fatalf("Result: %v, %v (%s)\n", ceremony, err, "The ceremony must be taken.")
+		}
+
+		if _, errCode, err := TakeCeremony(id, CeremonyLogin); err == nil || errCode != 1105 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "The ceremony must not be taken twice.")
+		}
+	})
+
+	t.Run("Test the ceremony is only taken as its kind", func(t *testing.T) {
+		_, id, _, err := BeginReauth(user)
+		if err != nil {
+			t.Fatalf("Unit Test (Begin reauth) Fail: %v\n", err)
+		}
+
+		if _, errCode, err := TakeCeremony(id, CeremonyLogin); err == nil || errCode != 1105 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "A reauth ceremony must not be finished as a login.")
+		}
+		if server.Exists(ceremonyKey(id)) {
+			t.Errorf("Result: %s (%s)\n", id, "The ceremony of the wrong kind must be discarded.")
+		}
+	})
+
+	t.Run("Test an unknown ceremony", func(t *testing.T) {
+		for _, id := range []string{"", "unknown"} {
+			if _, errCode, err := TakeCeremony(id, CeremonyRegistration); err == nil || errCode != 1105 {
+				t.Errorf("Result: %q, %d, %v (%s)\n", id, errCode, err, "An unknown ceremony must be rejected.")
+			}
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package rate_limit
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"suglider-auth/configs"
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/encrypt"
+	"suglider-auth/pkg/time_convert"
+)
+
+// Every rule of [rate_limit] has a token bucket per IP and a token bucket
+// per target identifier of the route, such as the mail which receives the
+// message. A bucket holds up to limit tokens and gets limit tokens back in
+// period, a request takes one token and is refused when the bucket is empty.
+//
+//   rate_limit:<path>:<ip|target>:<hash> -> {tokens, ts}
+
+const (
+	DimensionIP     = "ip"
+	DimensionTarget = "target"
+)
+
+// tokenBucket refills the bucket by the elapsed time and takes a token.
+// It returns whether the request is allowed, the remaining tokens, the
+// milliseconds until the next token and until the bucket is full.
+var tokenBucket = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+  tokens = capacity
+  ts = now
+end
+if now > ts then
+  tokens = math.min(capacity, tokens + (now - ts) * rate)
+end
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  retry = math.ceil((1 - tokens) / rate)
+end
+
+local reset = math.ceil((capacity - tokens) / rate)
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], reset + 1000)
+
+return {allowed, math.floor(tokens), retry, reset}
+`)
+
+var rules = map[string][]Rule{}
+
+// Bucket is the limit and the period of a token bucket, a zero limit means
+// there is no bucket.
+type Bucket struct {
+	Limit  int64
+	Period time.Duration
+}
+
+type Rule struct {
+	Path   string
+	Method string // empty means every method
+	IP     Bucket
+	Target Bucket
+	Field  string // the query parameter or the JSON field of the target
+}
+
+// Result is the state of the bucket after the request has taken a token.
+type Result struct {
+	Dimension  string
+	Allowed    bool
+	Limit      int64
+	Period     time.Duration
+	Remaining  int64
+	RetryAfter time.Duration // until the next token when the request is refused
+	Reset      time.Duration // until the bucket is full
+}
+
+func parseBucket(path, name string, limit int64, period string) Bucket {
+	if limit <= 0 {
+		return Bucket{}
+	}
+
+	duration, _, err := time_convert.ConvertTimeFormat(period)
+	if err != nil || duration <= 0 {
+		err = fmt.Errorf("the %s period %q of %s is invalid: %v", name, period, path, err)
+		errorMessage := fmt.Sprintf("Rate limit config failed: %v", err)
+		slog.Error(errorMessage)
+
+		panic(err)
+	}
+
+	return Bucket{Limit: limit, Period: duration}
+}
+
+func init() {
+	configs.OnLoad(loadConfig)
+}
+
+func loadConfig() {
+	rateLimitConfig := configs.ApplicationConfig.RateLimit
+	if rateLimitConfig == nil || !rateLimitConfig.Enabled {
+		return
+	}
+
+	for _, ruleConfig := range rateLimitConfig.Rules {
+		rule := Rule{
+			Path:   ruleConfig.Path,
+			Method: strings.ToUpper(ruleConfig.Method),
+			IP:     parseBucket(ruleConfig.Path, "ip", ruleConfig.IPLimit, ruleConfig.IPPeriod),
+			Target: parseBucket(ruleConfig.Path, "target", ruleConfig.TargetLimit, ruleConfig.TargetPeriod),
+			Field:  ruleConfig.Target,
+		}
+		if rule.Target.Limit > 0 && rule.Field == "" {
+			err := fmt.Errorf("the target of %s is empty", rule.Path)
+			errorMessage := fmt.Sprintf("Rate limit config failed: %v", err)
+			slog.Error(errorMessage)
+
+			panic(err)
+		}
+		rules[rule.Path] = append(rules[rule.Path], rule)
+	}
+}
+
+// Match returns the rules of the request.
+func Match(path, method string) []Rule {
+	matched := []Rule{}
+	for _, rule := range rules[path] {
+		if rule.Method == "" || rule.Method == method {
+			matched = append(matched, rule)
+		}
+	}
+	return matched
+}
+
+// Take takes a token from the bucket of the rule, value is the IP or the
+// target identifier.
+func Take(rule Rule, dimension, value string) (*Result, error) {
+	bucket := rule.IP
+	if dimension == DimensionTarget {
+		bucket = rule.Target
+	}
+
+	key := fmt.Sprintf("rate_limit:%s:%s:%s", rule.Path, dimension,
+		encrypt.HashWithSHA(strings.ToLower(strings.TrimSpace(value)), "sha1"))
+
+	// tokens per millisecond
+	rate := float64(bucket.Limit) / float64(bucket.Period.Milliseconds())
+
+	reply, err := redis.RunScript(tokenBucket, []string{key}, bucket.Limit, rate, time.Now().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 4 {
+		return nil, fmt.Errorf("unexpected reply of token bucket: %v", reply)
+	}
+	numbers := make([]int64, len(values))
+	for i, v := range values {
+		if numbers[i], ok = v.(int64); !ok {
+			return nil, fmt.Errorf("unexpected reply of token bucket: %v", reply)
+		}
+	}
+
+	return &Result{
+		Dimension:  dimension,
+		Allowed:    numbers[0] == 1,
+		Limit:      bucket.Limit,
+		Period:     bucket.Period,
+		Remaining:  numbers[1],
+		RetryAfter: time.Duration(numbers[2]) * time.Millisecond,
+		Reset:      time.Duration(numbers[3]) * time.Millisecond,
+	}, nil
+}
+
+// Seconds rounds the duration up to seconds for the headers.
+func Seconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
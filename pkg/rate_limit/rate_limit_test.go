@@ -0,0 +1,118 @@
+package rate_limit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/encrypt"
+)
+
+func TestTake(t *testing.T) {
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+
+	rule := Rule{
+		Path:   "/api/v1/user/magic-link",
+		Method: "POST",
+		IP:     Bucket{Limit: 3, Period: time.Hour},
+		Target: Bucket{Limit: 2, Period: time.Second},
+		Field:  "mail",
+	}
+
+	t.Run("Test the bucket is emptied by the requests", func(t *testing.T) {
+		for remaining := int64(2); remaining >= 0; remaining-- {
+			result, err := Take(rule, DimensionIP, "10.0.0.1")
+			if err != nil {
+				t.Fatalf("Unit Test (Take token) Fail: %v\n", err)
+			}
+			if !result.Allowed || result.Remaining != remaining || result.Limit != 3 || result.RetryAfter != 0 {
+				t.Errorf("Result: %+v (%s)\n", result, "The request must take a token from the bucket.")
+			}
+		}
+
+		result, err := Take(rule, DimensionIP, "10.0.0.1")
+		if err != nil {
+			t.Fatalf("Unit Test (Take token) Fail: %v\n", err)
+		}
+		if result.Allowed || result.Remaining != 0 {
+			t.Errorf("Result: %+v (%s)\n", result, "The request must be refused when the bucket is empty.")
+		}
+		// A token comes back every 20 minutes, the bucket is full after an hour
+		if result.RetryAfter <= 19*time.Minute || result.RetryAfter > 20*time.Minute {
+			t.Errorf("Result: %v (%s)\n", result.RetryAfter, "The retry time must be until the next token.")
+		}
+		if result.Reset <= 59*time.Minute || result.Reset > time.Hour {
+			t.Errorf("Result: %v (%s)\n", result.Reset, "The reset time must be until the bucket is full.")
+		}
+		key := "rate_limit:/api/v1/user/magic-link:ip:" + encrypt.HashWithSHA("10.0.0.1", "sha1")
+		if ttl := server.TTL(key); ttl <= 0 {
+			t.Errorf("Result: %v (%s)\n", ttl, "The bucket must expire.")
+		}
+	})
+
+	t.Run("Test every value has its own bucket", func(t *testing.T) {
+		result, err := Take(rule, DimensionIP, "10.0.0.2")
+		if err != nil || !result.Allowed || result.Remaining != 2 {
+			t.Errorf("Result: %+v, %v (%s)\n", result, err, "Another IP must have a full bucket.")
+		}
+
+		result, err = Take(rule, DimensionTarget, "Alice@Example.com ")
+		if err != nil || !result.Allowed || result.Limit != 2 || result.Remaining != 1 {
+			t.Errorf("Result: %+v, %v (%s)\n", result, err, "The target must use the target bucket.")
+		}
+		result, err = Take(rule, DimensionTarget, "alice@example.com")
+		if err != nil || !result.Allowed || result.Remaining != 0 {
+			t.Errorf("Result: %+v, %v (%s)\n", result, err, "The case and spaces of the target must be ignored.")
+		}
+	})
+
+	t.Run("Test the tokens come back", func(t *testing.T) {
+		result, err := Take(rule, DimensionTarget, "alice@example.com")
+		if err != nil || result.Allowed {
+			t.Fatalf("Result: %+v, %v (%s)\n", result, err, "The target bucket must be empty.")
+		}
+
+		time.Sleep(600 * time.Millisecond)
+
+		result, err = Take(rule, DimensionTarget, "alice@example.com")
+		if err != nil || !result.Allowed {
+			t.Errorf("Result: %+v, %v (%s)\n", result, err, "A token must come back after a while.")
+		}
+	})
+}
+
+func TestMatch(t *testing.T) {
+	defaultRules := rules
+	rules = map[string][]Rule{
+		"/api/v1/otp/mail/send": {{Path: "/api/v1/otp/mail/send", Method: "POST"}},
+		"/api/v1/user/login":    {{Path: "/api/v1/user/login"}},
+	}
+	defer func() { rules = defaultRules }()
+
+	testCases := []struct {
+		path, method string
+		matched      int
+		reason       string
+	}{
+		{"/api/v1/otp/mail/send", "POST", 1, "The rule of the method must match."},
+		{"/api/v1/otp/mail/send", "GET", 0, "The rule of another method must not match."},
+		{"/api/v1/user/login", "GET", 1, "The rule without method must match every method."},
+		{"/api/v1/user/logout", "POST", 0, "A path without rule must not match."},
+	}
+	for _, testCase := range testCases {
+		if matched := Match(testCase.path, testCase.method); len(matched) != testCase.matched {
+			t.Errorf("Result: %v (%s)\n", matched, testCase.reason)
+		}
+	}
+
+	for d, expected := range map[time.Duration]int64{0: 0, time.Millisecond: 1, time.Second: 1, 1500 * time.Millisecond: 2} {
+		if seconds := Seconds(d); seconds != expected {
+			t.Errorf("Result: %d (%s %v)\n", seconds, "The seconds must be rounded up for", d)
+		}
+	}
+}
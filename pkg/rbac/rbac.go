@@ -79,6 +79,7 @@ func (cec *CasbinEnforcerConfig) InitPolicies() error {
 		"/sign-up",
 		"/api/v1/user/login",
 		"/api/v1/user/logout",
+		"/api/v1/user/refresh",
 		"/api/v1/user/sign-up",
 		"/api/v1/user/forgot-password",
 		"/api/v1/user/verify-mail",
@@ -88,12 +89,27 @@ func (cec *CasbinEnforcerConfig) InitPolicies() error {
 		"/api/v1/user/check-phone-number",
 		"/api/v1/user/check-auth-valid",
 		"/api/v1/user/check-login-status",
+		"/api/v1/user/unlock",
+		"/api/v1/user/magic-link",
+		"/api/v1/user/magic-link/login",
 		"/api/v1/totp/validate",
 		"/api/v1/otp/mail/verify",
 		"/api/v1/otp/mail/send",
+		"/api/v1/otp/sms/verify",
+		"/api/v1/otp/sms/send",
+		"/api/v1/webauthn/login/begin",
+		"/api/v1/webauthn/login/finish",
 		"/api/v1/oauth/google/login",
 		"/api/v1/oauth/google/sign-up",
 		"/api/v1/oauth/google/callback",
+		"/api/v1/oidc/authorize",
+		"/api/v1/oidc/token",
+		"/api/v1/oidc/userinfo",
+		"/api/v1/oidc/jwks",
+		"/api/v1/oauth2/token",
+		"/api/v1/oauth2/introspect",
+		"/api/v1/oauth2/revoke",
+		"/api/v1/csrf",
 	}
 	for _, item := range anonymousPolicies {
 		if ok, err := cec.Enforcer.Enforcer.AddPolicy("anonymous", item, "GET"); !ok {
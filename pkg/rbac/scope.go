@@ -0,0 +1,50 @@
+package rbac
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Scopes narrow what an API key or a scoped token can do, the Casbin policies
+// of the subject still apply. A scope is "<group>:read", "<group>:write" or
+// "*", the group is the API group after /api/v1/, e.g. user, totp or rbac.
+// The read scope only allows GET, the write scope allows every method.
+
+var scopePattern = regexp.MustCompile(`^[a-z0-9-]+:(read|write)$`)
+
+// ValidScope reports whether there is at least one scope and every space
+// separated scope is well formed.
+func ValidScope(scope string) bool {
+	if strings.TrimSpace(scope) == "" {
+		return false
+	}
+	for _, s := range strings.Fields(scope) {
+		if s != "*" && !scopePattern.MatchString(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// ScopeAllows reports whether the scope allows the request, an empty scope
+// allows nothing.
+func ScopeAllows(scope, path, method string) bool {
+	group := ""
+	if idx := strings.Index(path, "/api/v1/"); idx >= 0 {
+		group, _, _ = strings.Cut(path[idx+len("/api/v1/"):], "/")
+	}
+
+	for _, s := range strings.Fields(scope) {
+		if s == "*" {
+			return true
+		}
+		name, access, ok := strings.Cut(s, ":")
+		if !ok || group == "" || name != group {
+			continue
+		}
+		if access == "write" || (access == "read" && method == "GET") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package rbac
+
+import (
+	"testing"
+)
+
+func TestScope(t *testing.T) {
+	cases := []struct {
+		scope  string
+		path   string
+		method string
+		allow  bool
+	}{
+		{"", "/api/v1/rbac/roles", "GET", false},
+		{"*", "/api/v1/rbac/policy/add", "POST", true},
+		{"user:read", "/api/v1/user/check-mail", "GET", true},
+		{"user:read", "/api/v1/user/delete", "DELETE", false},
+		{"user:write", "/api/v1/user/delete", "DELETE", true},
+		{"user:write totp:read", "/api/v1/totp/generate", "POST", false},
+		{"user:write totp:read", "/prefix/api/v1/totp/validate", "GET", true},
+		{"openid profile", "/api/v1/user/check-mail", "GET", false},
+	}
+
+	for _, tc := range cases {
+		if allow := ScopeAllows(tc.scope, tc.path, tc.method); allow != tc.allow {
+			t.Errorf("Result: %v (%s %s with scope %q)\n", allow, tc.method, tc.path, tc.scope)
+		}
+	}
+
+	if !ValidScope("user:read rbac:write *") {
+		t.Errorf("Result: %v (%s)\n", false, "The scope must be valid.")
+	}
+	if ValidScope("user:delete") || ValidScope("openid") || ValidScope(" ") {
+		t.Errorf("Result: %v (%s)\n", true, "The scope must be invalid.")
+	}
+}
@@ -0,0 +1,205 @@
+package refresh_token
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"suglider-auth/configs"
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/encrypt"
+	"suglider-auth/pkg/time_convert"
+	"suglider-auth/pkg/token_denylist"
+)
+
+// Refresh tokens are opaque strings, only the SHA-256 of a token is stored in
+// redis. All tokens rotated from the same login belong to one family, reusing
+// a rotated token revokes the whole family.
+//
+//   refresh_token:<hash>   -> tokenData
+//   refresh_used:<hash>    -> set once the token has been rotated
+//   refresh_family:<id>    -> mail, deleted when the family is revoked
+
+var refreshTokenTTL = 168 * time.Hour
+
+type tokenData struct {
+	Mail     string `json:"mail"`
+	FamilyID string `json:"family_id"`
+	IssuedAt int64  `json:"issued_at"` // the login time of the family in unix milliseconds
+}
+
+func init() {
+	configs.OnLoad(loadConfig)
+}
+
+func loadConfig() {
+	jwtConfig := configs.ApplicationConfig.Jwt
+	if jwtConfig == nil || jwtConfig.RefreshTokenTTL == "" {
+		return
+	}
+
+	ttl, _, err := time_convert.ConvertTimeFormat(jwtConfig.RefreshTokenTTL)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Refresh token TTL string convert to duration failed: %v", err)
+		slog.Error(errorMessage)
+
+		panic(err)
+	}
+	refreshTokenTTL = ttl
+}
+
+// tokenTTL is the lifetime of a new token, it doesn't outlive maxTTL which
+// is the rest of the session lifetime. A zero maxTTL means no limit.
+func tokenTTL(maxTTL time.Duration) time.Duration {
+	if maxTTL > 0 && maxTTL < refreshTokenTTL {
+		return maxTTL
+	}
+	return refreshTokenTTL
+}
+
+// Issue creates a refresh token of a new family, it's used after a login.
+// The session ID of the login is used as the family ID, so signing out a
+// session also revokes its refresh tokens.
+func Issue(mail, familyID string, maxTTL time.Duration) (string, int, int64, error) {
+	if familyID == "" {
+		familyID = encrypt.GenertateUUID(true)
+	}
+
+	ttl := tokenTTL(maxTTL)
+	err := redis.Set("refresh_family:"+familyID, mail, ttl)
+	if err != nil {
+		return "", 0, 1042, err
+	}
+
+	return issue(mail, familyID, time.Now().UnixMilli(), ttl)
+}
+
+func issue(mail, familyID string, issuedAt int64, ttl time.Duration) (string, int, int64, error) {
+	token, err := encrypt.RandomToken(32)
+	if err != nil {
+		return "", 0, 1076, err
+	}
+
+	jsonData, err := json.Marshal(tokenData{Mail: mail, FamilyID: familyID, IssuedAt: issuedAt})
+	if err != nil {
+		return "", 0, 1068, err
+	}
+
+	err = redis.Set("refresh_token:"+hashToken(token), string(jsonData), ttl)
+	if err != nil {
+		return "", 0, 1042, err
+	}
+
+	return token, int(ttl.Seconds()), 0, nil
+}
+
+// Family returns the mail and the family ID of the refresh token without
+// consuming it, the error code is 1074 when the token is invalid or expired.
+func Family(token string) (string, string, int64, error) {
+	data, errCode, err := lookup(token)
+	if err != nil {
+		return "", "", errCode, err
+	}
+
+	return data.Mail, data.FamilyID, 0, nil
+}
+
+// Rotate consumes the refresh token and returns a new one of the same family
+// with the mail and family ID it belongs to, the new token doesn't outlive
+// maxTTL.
+func Rotate(token string, maxTTL time.Duration) (string, string, string, int, int64, error) {
+	data, errCode, err := lookup(token)
+	if err != nil {
+		return "", "", "", 0, errCode, err
+	}
+
+	familyKey := "refresh_family:" + data.FamilyID
+	isExists, err := redis.Exists(familyKey)
+	if err != nil {
+		return "", "", "", 0, 1039, err
+	}
+	if !isExists {
+		return "", "", "", 0, 1074, fmt.Errorf("The refresh token family has been revoked.")
+	}
+
+	// Password change, account deletion or force logout revoke every family of the user
+	isRevoked, err := token_denylist.IsUserRevoked(data.Mail, time.UnixMilli(data.IssuedAt))
+	if err != nil {
+		return "", "", "", 0, 1044, err
+	}
+	if isRevoked {
+		if err = redis.Delete(familyKey); err != nil {
+			return "", "", "", 0, 1040, err
+		}
+		return "", "", "", 0, 1074, fmt.Errorf("The refresh token has been revoked.")
+	}
+
+	// SETNX makes sure a token can only be rotated once, even with concurrent requests.
+	ok, err := redis.SetNX("refresh_used:"+hashToken(token), "1", refreshTokenTTL)
+	if err != nil {
+		return "", "", "", 0, 1042, err
+	}
+	if !ok {
+		slog.Warn(fmt.Sprintf("Refresh token reuse detected, revoke token family %s of %s.", data.FamilyID, data.Mail))
+		if err = redis.Delete(familyKey); err != nil {
+			return "", "", "", 0, 1040, err
+		}
+		return "", "", "", 0, 1075, fmt.Errorf("The refresh token has already been used.")
+	}
+
+	// Extend the family with the lifetime of the new token
+	ttl := tokenTTL(maxTTL)
+	err = redis.Set(familyKey, data.Mail, ttl)
+	if err != nil {
+		return "", "", "", 0, 1042, err
+	}
+
+	newToken, expireTimeSec, errCode, err := issue(data.Mail, data.FamilyID, data.IssuedAt, ttl)
+	if err != nil {
+		return "", "", "", 0, errCode, err
+	}
+
+	return newToken, data.Mail, data.FamilyID, expireTimeSec, 0, nil
+}
+
+// Revoke revokes the family of the refresh token, it's used by logout.
+func Revoke(token string) (int64, error) {
+	data, errCode, err := lookup(token)
+	if err != nil {
+		return errCode, err
+	}
+
+	if err = redis.Delete("refresh_family:" + data.FamilyID); err != nil {
+		return 1040, err
+	}
+
+	return 0, nil
+}
+
+// RevokeFamily revokes the refresh tokens of the family, it's used by remote sign-out.
+func RevokeFamily(familyID string) error {
+	return redis.Delete("refresh_family:" + familyID)
+}
+
+func lookup(token string) (*tokenData, int64, error) {
+	var data tokenData
+
+	value, errCode, err := redis.Get("refresh_token:" + hashToken(token))
+	switch errCode {
+	case 1043:
+		return nil, 1074, fmt.Errorf("The refresh token is invalid or expired.")
+	case 1044:
+		return nil, errCode, err
+	}
+
+	if err = json.Unmarshal([]byte(value), &data); err != nil {
+		return nil, 1063, err
+	}
+
+	return &data, 0, nil
+}
+
+func hashToken(token string) string {
+	return encrypt.HashWithSHA(token, "sha256")
+}
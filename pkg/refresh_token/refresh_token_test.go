@@ -0,0 +1,110 @@
+package refresh_token
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"suglider-auth/internal/redis"
+)
+
+func TestRefreshToken(t *testing.T) {
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+
+	t.Run("Test rotate a refresh token", func(t *testing.T) {
+		token, _, _, err := Issue("alice@example.com", "alice-laptop", 0)
+		if err != nil {
+			t.Fatalf("Unit Test (Issue refresh token) Fail: %v\n", err)
+		}
+
+		newToken, mail, familyID, _, _, err := Rotate(token, 0)
+		if err != nil {
+			t.Fatalf("Unit Test (Rotate refresh token) Fail: %v\n", err)
+		}
+		if newToken == token || mail != "alice@example.com" || familyID != "alice-laptop" {
+			t.Errorf("Result: %s, %s (%s)\n", mail, familyID, "The new token must belong to the same family.")
+		}
+
+		if _, _, _, _, _, err = Rotate(newToken, 0); err != nil {
+			t.Errorf("Result: %v (%s)\n", err, "The new token must be rotated again.")
+		}
+	})
+
+	t.Run("Test reuse of a rotated token revokes the family", func(t *testing.T) {
+		token, _, _, err := Issue("alice@example.com", "alice-phone", 0)
+		if err != nil {
+			t.Fatalf("Unit Test (Issue refresh token) Fail: %v\n", err)
+		}
+		newToken, _, _, _, _, err := Rotate(token, 0)
+		if err != nil {
+			t.Fatalf("Unit Test (Rotate refresh token) Fail: %v\n", err)
+		}
+
+		_, _, _, _, errCode, err := Rotate(token, 0)
+		if err == nil || errCode != 1075 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "The reused token must be detected.")
+		}
+		if server.Exists("refresh_family:alice-phone") {
+			t.Errorf("Result: %s (%s)\n", "alice-phone", "The family of the reused token must be revoked.")
+		}
+
+		_, _, _, _, errCode, err = Rotate(newToken, 0)
+		if err == nil || errCode != 1074 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "The tokens rotated from the reused token must be rejected.")
+		}
+	})
+
+	t.Run("Test revoke a family", func(t *testing.T) {
+		token, _, _, err := Issue("bob@example.com", "bob-laptop", 0)
+		if err != nil {
+			t.Fatalf("Unit Test (Issue refresh token) Fail: %v\n", err)
+		}
+		if err = RevokeFamily("bob-laptop"); err != nil {
+			t.Fatalf("Unit Test (Revoke family) Fail: %v\n", err)
+		}
+
+		if _, _, _, _, errCode, err := Rotate(token, 0); err == nil || errCode != 1074 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "The token of a revoked family must be rejected.")
+		}
+	})
+
+	t.Run("Test revoke the user", func(t *testing.T) {
+		token, _, _, err := Issue("carol@example.com", "carol-laptop", 0)
+		if err != nil {
+			t.Fatalf("Unit Test (Issue refresh token) Fail: %v\n", err)
+		}
+		notBefore := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		if err = server.Set("revoked_before:carol@example.com", notBefore); err != nil {
+			t.Fatalf("Unit Test (Set not-before) Fail: %v\n", err)
+		}
+
+		if _, _, _, _, errCode, err := Rotate(token, 0); err == nil || errCode != 1074 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "The token issued before the user was revoked must be rejected.")
+		}
+	})
+
+	t.Run("Test the token doesn't outlive the session", func(t *testing.T) {
+		_, expireTimeSec, _, err := Issue("dave@example.com", "dave-laptop", time.Hour)
+		if err != nil {
+			t.Fatalf("Unit Test (Issue refresh token) Fail: %v\n", err)
+		}
+		if expireTimeSec != int(time.Hour.Seconds()) || server.TTL("refresh_family:dave-laptop") != time.Hour {
+			t.Errorf("Result: %d (%s)\n", expireTimeSec, "The lifetime must be capped at the session.")
+		}
+
+		if ttl := tokenTTL(0); ttl != refreshTokenTTL {
+			t.Errorf("Result: %v (%s)\n", ttl, "Without a session the configured lifetime is used.")
+		}
+	})
+
+	t.Run("Test an unknown token", func(t *testing.T) {
+		if _, _, errCode, err := Family("unknown"); err == nil || errCode != 1074 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "An unknown token must be invalid.")
+		}
+	})
+}
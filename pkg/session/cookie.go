@@ -0,0 +1,110 @@
+package session
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"suglider-auth/configs"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// The key used by the session cookie when [session] has no auth_key
+const defaultAuthKey = "suglider"
+
+// KeyPairs returns the authentication and encryption keys of the session
+// cookie for cookie.NewStore. The first pair signs new cookies, the previous
+// pairs still verify the cookies signed before a key rotation.
+func KeyPairs() [][]byte {
+	sessionConfig := configs.ApplicationConfig.Session
+	if sessionConfig == nil {
+		return [][]byte{[]byte(defaultAuthKey), nil}
+	}
+
+	authKey := sessionConfig.AuthKey
+	if authKey == "" {
+		slog.Warn("The auth_key of [session] is empty, the default key is used to sign the session cookie.")
+		authKey = defaultAuthKey
+	}
+
+	keys := []configs.SessionKey{{AuthKey: authKey, EncryptionKey: sessionConfig.EncryptionKey}}
+	keys = append(keys, sessionConfig.PreviousKeys...)
+
+	keyPairs := [][]byte{}
+	for _, key := range keys {
+		switch len(key.EncryptionKey) {
+		case 0:
+			keyPairs = append(keyPairs, []byte(key.AuthKey), nil)
+		case 16, 24, 32:
+			keyPairs = append(keyPairs, []byte(key.AuthKey), []byte(key.EncryptionKey))
+		default:
+			err := fmt.Errorf("the encryption key of session cookie must be 16, 24 or 32 bytes")
+			slog.Error(err.Error())
+
+			panic(err)
+		}
+	}
+
+	return keyPairs
+}
+
+func sameSite() http.SameSite {
+	sessionConfig := configs.ApplicationConfig.Session
+	if sessionConfig == nil {
+		return http.SameSiteDefaultMode
+	}
+
+	switch strings.ToLower(sessionConfig.SameSite) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteDefaultMode
+	}
+}
+
+// CookieOptions returns the attributes of the session cookie.
+func CookieOptions(maxAge time.Duration) sessions.Options {
+	options := sessions.Options{
+		MaxAge:   int(maxAge.Seconds()), // unit second
+		SameSite: sameSite(),
+	}
+
+	if sessionConfig := configs.ApplicationConfig.Session; sessionConfig != nil {
+		options.Path = sessionConfig.Path
+		options.Domain = sessionConfig.Domain
+		options.Secure = sessionConfig.Secure
+		options.HttpOnly = sessionConfig.HttpOnly
+	}
+
+	return options
+}
+
+// SetCookie sets a cookie of the tokens, the domain, secure and same site
+// attributes are the same as the session cookie. A negative maxAge deletes
+// the cookie.
+func SetCookie(c *gin.Context, name, value string, maxAge int) {
+	domain, secure := "", false
+	if sessionConfig := configs.ApplicationConfig.Session; sessionConfig != nil {
+		domain, secure = sessionConfig.Domain, sessionConfig.Secure
+	}
+
+	c.SetSameSite(sameSite())
+	c.SetCookie(name, value, maxAge, "/", domain, secure, true)
+}
+
+// saveCookie writes the session cookie, which expires with the absolute timeout.
+func saveCookie(c *gin.Context, sid string, maxAge time.Duration) error {
+	session := sessions.Default(c)
+
+	session.Options(CookieOptions(maxAge))
+	session.Set("sid", sid)
+	return session.Save()
+}
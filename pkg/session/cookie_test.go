@@ -0,0 +1,199 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+
+	"suglider-auth/configs"
+)
+
+func TestCookieKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	sessionConfig := configs.ApplicationConfig.Session
+	defaultConfig := *sessionConfig
+	defer func() { *sessionConfig = defaultConfig }()
+
+	oldKey := configs.SessionKey{AuthKey: "old-auth-key", EncryptionKey: "0123456789abcdef"}
+	olderKey := configs.SessionKey{AuthKey: "older-auth-key"}
+
+	// The router of an instance which signs the session cookie by the keys
+	newRouter := func(keyPairs [][]byte) *gin.Engine {
+		router := gin.New()
+		router.Use(sessions.Sessions("session-key", cookie.NewStore(keyPairs...)))
+		router.GET("/set", func(c *gin.Context) {
+			if err := saveCookie(c, "alice-laptop", time.Hour); err != nil {
+				c.Status(http.StatusInternalServerError)
+			}
+		})
+		router.GET("/get", func(c *gin.Context) {
+			c.String(http.StatusOK, CurrentSessionID(c))
+		})
+		return router
+	}
+	sessionCookie := func(router *gin.Engine) *http.Cookie {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/set", nil))
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == "session-key" {
+				return cookie
+			}
+		}
+		t.Fatalf("Result: %v (%s)\n", w.Result().Cookies(), "The session cookie must be set.")
+		return nil
+	}
+	readSID := func(router *gin.Engine, cookie *http.Cookie) string {
+		req := httptest.NewRequest(http.MethodGet, "/get", nil)
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	t.Run("Test the order of the keys", func(t *testing.T) {
+		sessionConfig.AuthKey = "new-auth-key"
+		sessionConfig.EncryptionKey = "0123456789abcdef0123456789abcdef"
+		sessionConfig.PreviousKeys = []configs.SessionKey{oldKey, olderKey}
+
+		expected := [][]byte{
+			[]byte("new-auth-key"), []byte("0123456789abcdef0123456789abcdef"),
+			[]byte("old-auth-key"), []byte("0123456789abcdef"),
+			[]byte("older-auth-key"), nil,
+		}
+		if keyPairs := KeyPairs(); !reflect.DeepEqual(keyPairs, expected) {
+			t.Errorf("Result: %q (%s)\n", keyPairs, "The new key must come first, then the previous keys in order.")
+		}
+	})
+
+	t.Run("Test the rotation of the keys", func(t *testing.T) {
+		sessionConfig.AuthKey = oldKey.AuthKey
+		sessionConfig.EncryptionKey = oldKey.EncryptionKey
+		sessionConfig.PreviousKeys = nil
+		oldRouter := newRouter(KeyPairs())
+		oldCookie := sessionCookie(oldRouter)
+
+		sessionConfig.AuthKey = "new-auth-key"
+		sessionConfig.EncryptionKey = "0123456789abcdef0123456789abcdef"
+		sessionConfig.PreviousKeys = []configs.SessionKey{oldKey}
+		rotatedRouter := newRouter(KeyPairs())
+
+		if sid := readSID(rotatedRouter, oldCookie); sid != "alice-laptop" {
+			t.Errorf("Result: %s (%s)\n", sid, "The previous key must still verify the cookie.")
+		}
+
+		newCookie := sessionCookie(rotatedRouter)
+		if sid := readSID(rotatedRouter, newCookie); sid != "alice-laptop" {
+			t.Errorf("Result: %s (%s)\n", sid, "The new key must verify its own cookie.")
+		}
+		if sid := readSID(oldRouter, newCookie); sid != "" {
+			t.Errorf("Result: %s (%s)\n", sid, "The new cookie must be signed by the new key.")
+		}
+
+		// A key which has been removed doesn't verify the cookies anymore
+		sessionConfig.PreviousKeys = nil
+		if sid := readSID(newRouter(KeyPairs()), oldCookie); sid != "" {
+			t.Errorf("Result: %s (%s)\n", sid, "The removed key must not verify the cookie.")
+		}
+	})
+
+	t.Run("Test the length of the encryption key", func(t *testing.T) {
+		testCases := []struct {
+			encryptionKey string
+			previousKey   string
+			reason        string
+		}{
+			{"too-short", "", "The encryption key must be 16, 24 or 32 bytes."},
+			{"0123456789abcdef", "0123456789abcdef0", "The previous encryption key must be 16, 24 or 32 bytes."},
+		}
+		for _, testCase := range testCases {
+			sessionConfig.AuthKey = "new-auth-key"
+			sessionConfig.EncryptionKey = testCase.encryptionKey
+			sessionConfig.PreviousKeys = []configs.SessionKey{{AuthKey: "old-auth-key", EncryptionKey: testCase.previousKey}}
+
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("Result: %s %s (%s)\n", testCase.encryptionKey, testCase.previousKey, testCase.reason)
+					}
+				}()
+				KeyPairs()
+			}()
+		}
+	})
+}
+
+func TestCookieOptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	sessionConfig := configs.ApplicationConfig.Session
+	defaultConfig := *sessionConfig
+	defer func() { *sessionConfig = defaultConfig }()
+
+	sessionConfig.Path = "/"
+	sessionConfig.Domain = "auth.example.com"
+	sessionConfig.Secure = true
+	sessionConfig.HttpOnly = true
+
+	testCases := []struct {
+		sameSite string
+		expected http.SameSite
+	}{
+		{"lax", http.SameSiteLaxMode},
+		{"Strict", http.SameSiteStrictMode},
+		{"NONE", http.SameSiteNoneMode},
+		{"", http.SameSiteDefaultMode},
+		{"unknown", http.SameSiteDefaultMode},
+	}
+	for _, testCase := range testCases {
+		sessionConfig.SameSite = testCase.sameSite
+
+		options := CookieOptions(time.Hour)
+		expected := sessions.Options{
+			Path:     "/",
+			Domain:   "auth.example.com",
+			MaxAge:   3600,
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: testCase.expected,
+		}
+		if options != expected {
+			t.Errorf("Result: %s -> %+v (%s)\n", testCase.sameSite, options, "The session cookie must have the configured attributes.")
+		}
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		SetCookie(c, "token", "value", 60)
+
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("Result: %v (%s)\n", cookies, "The token cookie must be set.")
+		}
+		// The default mode leaves the SameSite attribute out of the header
+		expectedSameSite := testCase.expected
+		if expectedSameSite == http.SameSiteDefaultMode {
+			expectedSameSite = 0
+		}
+		cookie := cookies[0]
+		if cookie.Name != "token" || cookie.Value != "value" || cookie.MaxAge != 60 || cookie.Path != "/" ||
+			cookie.Domain != "auth.example.com" || !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != expectedSameSite {
+			t.Errorf("Result: %s -> %+v (%s)\n", testCase.sameSite, cookie, "The token cookie must have the attributes of the session cookie.")
+		}
+	}
+
+	t.Run("Test delete the cookie", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		SetCookie(c, "refresh_token", "", -1)
+
+		if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+			t.Errorf("Result: %v (%s)\n", cookies, "A negative max age must delete the cookie.")
+		}
+	})
+}
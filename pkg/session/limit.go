@@ -0,0 +1,52 @@
+package session
+
+import (
+	"suglider-auth/configs"
+)
+
+// Session limit policies decide what happens when a user who already holds
+// the maximum number of sessions logs in.
+const (
+	LimitEvictOldest = "evict_oldest"
+	LimitReject      = "reject"
+)
+
+// LimitPolicy returns the session limit policy, the oldest sessions are
+// evicted by default.
+func LimitPolicy() string {
+	sessionConfig := configs.ApplicationConfig.Session
+	if sessionConfig == nil || sessionConfig.LimitPolicy != LimitReject {
+		return LimitEvictOldest
+	}
+	return LimitReject
+}
+
+// MaxSessions returns the maximum number of sessions of a user with the
+// roles, 0 means unlimited. When several roles override the global default,
+// the largest limit wins.
+func MaxSessions(roles []string) int {
+	sessionConfig := configs.ApplicationConfig.Session
+	if sessionConfig == nil {
+		return 0
+	}
+
+	maxSessions, overridden := 0, false
+	for _, role := range roles {
+		limit, ok := sessionConfig.RoleMaxSessions[role]
+		if !ok {
+			continue
+		}
+		if limit < 0 {
+			limit = 0
+		}
+		if !overridden || (maxSessions != 0 && (limit == 0 || limit > maxSessions)) {
+			maxSessions = limit
+		}
+		overridden = true
+	}
+
+	if !overridden {
+		return sessionConfig.MaxSessions
+	}
+	return maxSessions
+}
@@ -0,0 +1,57 @@
+package session
+
+import (
+	"testing"
+
+	"suglider-auth/configs"
+)
+
+func TestSessionLimit(t *testing.T) {
+	sessionConfig := configs.ApplicationConfig.Session
+	defaultConfig := *sessionConfig
+	defer func() { *sessionConfig = defaultConfig }()
+
+	sessionConfig.MaxSessions = 3
+	sessionConfig.RoleMaxSessions = map[string]int{
+		"support":  5,
+		"operator": 10,
+		"admin":    0,
+		"kiosk":    -1,
+		"guest":    1,
+	}
+
+	t.Run("Test the limit of the roles", func(t *testing.T) {
+		testCases := []struct {
+			roles    []string
+			expected int
+			reason   string
+		}{
+			{nil, 3, "A user without role has the global limit."},
+			{[]string{"member"}, 3, "A role without override has the global limit."},
+			{[]string{"guest"}, 1, "The role overrides the global limit, even with a smaller one."},
+			{[]string{"guest", "support", "operator"}, 10, "The largest limit of the roles wins."},
+			{[]string{"support", "admin"}, 0, "An unlimited role wins."},
+			{[]string{"kiosk"}, 0, "A negative limit means unlimited."},
+			{[]string{"admin", "guest"}, 0, "An unlimited role wins whatever the order."},
+		}
+		for _, testCase := range testCases {
+			if maxSessions := MaxSessions(testCase.roles); maxSessions != testCase.expected {
+				t.Errorf("Result: %v -> %d (%s)\n", testCase.roles, maxSessions, testCase.reason)
+			}
+		}
+	})
+
+	t.Run("Test the limit policy", func(t *testing.T) {
+		for policy, expected := range map[string]string{
+			"":             LimitEvictOldest,
+			"evict_oldest": LimitEvictOldest,
+			"reject":       LimitReject,
+			"unknown":      LimitEvictOldest,
+		} {
+			sessionConfig.LimitPolicy = policy
+			if limitPolicy := LimitPolicy(); limitPolicy != expected {
+				t.Errorf("Result: %q -> %s (%s)\n", policy, limitPolicy, "The oldest sessions are evicted unless the logins are rejected.")
+			}
+		}
+	})
+}
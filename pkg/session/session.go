@@ -1,19 +1,188 @@
 package session
 
 import (
-	"encoding/json"
+	"crypto/subtle"
 	"fmt"
+	"log/slog"
 	"suglider-auth/configs"
-	"suglider-auth/internal/redis"
 	"suglider-auth/pkg/encrypt"
+	"suglider-auth/pkg/session_store"
 	"suglider-auth/pkg/time_convert"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
 )
 
-type sessionData struct {
-	Mail string `json:"mail"`
+type sessionData = session_store.SessionData
+
+type SessionInfo struct {
+	ID        string `json:"id"` // the hash of the session ID, the session ID itself is a secret
+	CreatedAt int64  `json:"created_at"`
+	LastSeen  int64  `json:"last_seen"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Current   bool   `json:"current"`
+}
+
+// The last seen time is written at most once per touchInterval
+const touchInterval = time.Minute
+
+// A session expires after idleTimeout without any request, and every request
+// extends it until absoluteTimeout after the login. The cookie lives until
+// the absolute timeout, so an expired session can be told apart from a
+// request without any session.
+var (
+	idleTimeout     = 2 * time.Hour
+	absoluteTimeout = 2 * time.Hour
+)
+
+// Sensitive operations need the user to be authenticated again when the
+// last authentication of the session is older than reauthWindow.
+var reauthWindow = 10 * time.Minute
+
+func init() {
+	// The sessions are kept in Redis unless another store is set by InitStore
+	store = session_store.NewRedisStore(absoluteTimeout)
+	configs.OnLoad(loadConfig)
+}
+
+func loadConfig() {
+	defer func() {
+		store = session_store.NewRedisStore(absoluteTimeout)
+	}()
+
+	sessionConfig := configs.ApplicationConfig.Session
+	if sessionConfig == nil {
+		return
+	}
+
+	idle, absolute := sessionConfig.IdleTimeout, sessionConfig.AbsoluteTimeout
+	if idle == "" {
+		idle = sessionConfig.Timeout
+	}
+	if absolute == "" {
+		absolute = sessionConfig.Timeout
+	}
+
+	idleTimeout = parseTimeout(idle, idleTimeout)
+	absoluteTimeout = parseTimeout(absolute, absoluteTimeout)
+	reauthWindow = parseTimeout(sessionConfig.ReauthWindow, reauthWindow)
+
+	if idleTimeout > absoluteTimeout {
+		idleTimeout = absoluteTimeout
+	}
+}
+
+func parseTimeout(ttl string, defaultTimeout time.Duration) time.Duration {
+	if ttl == "" {
+		return defaultTimeout
+	}
+
+	duration, _, err := time_convert.ConvertTimeFormat(ttl)
+	if err != nil {
+		errorMessage := fmt.Sprintf("TTL string convert to duration failed: %v", err)
+		slog.Error(errorMessage)
+
+		panic(err)
+	}
+
+	return duration
+}
+
+var store session_store.SessionStore
+
+// InitStore selects the session store by the store setting of [session], the
+// database is only used by the mariadb store.
+func InitStore(db *sqlx.DB) error {
+	backend := "redis"
+	if sessionConfig := configs.ApplicationConfig.Session; sessionConfig != nil && sessionConfig.Store != "" {
+		backend = sessionConfig.Store
+	}
+
+	switch backend {
+	case "redis":
+		SetStore(session_store.NewRedisStore(absoluteTimeout))
+	case "memory":
+		SetStore(session_store.NewMemoryStore())
+	case "mariadb":
+		SetStore(session_store.NewMariaDBStore(db))
+	default:
+		return fmt.Errorf("unknown session store: %s", backend)
+	}
+
+	slog.Info(fmt.Sprintf("The session store is %s.", backend))
+
+	return nil
+}
+
+// SetStore replaces the session store, e.g. by a memory store in tests.
+func SetStore(sessionStore session_store.SessionStore) {
+	store = sessionStore
+}
+
+// Timeouts returns the idle timeout and the absolute timeout of sessions.
+func Timeouts() (time.Duration, time.Duration) {
+	return idleTimeout, absoluteTimeout
+}
+
+// ReauthWindow returns how long an authentication of the session is recent
+// enough for sensitive operations.
+func ReauthWindow() time.Duration {
+	return reauthWindow
+}
+
+// AuthenticatedAt returns when the password or a second factor was verified
+// last in the session, it's the login of a session created before the time
+// was recorded.
+func AuthenticatedAt(data sessionData) time.Time {
+	if data.AuthAt == 0 {
+		return time.Unix(data.CreatedAt, 0)
+	}
+	return time.Unix(data.AuthAt, 0)
+}
+
+// IssuedAt returns the login time of the session in milliseconds, which is
+// checked against the revocation time of the user.
+func IssuedAt(data sessionData) time.Time {
+	if data.IssuedAt == 0 {
+		return time.Unix(data.CreatedAt, 0)
+	}
+	return time.UnixMilli(data.IssuedAt)
+}
+
+// MarkAuthenticated records that the factor has just been verified in the
+// session. The error code is 1043 when the session has expired.
+func MarkAuthenticated(sid, factor string) (time.Time, int64, error) {
+	now := time.Now()
+	authAt := now.Unix()
+
+	err := store.Touch(sid, session_store.SessionUpdate{AuthAt: &authAt, AuthBy: &factor}, 0)
+	if err == session_store.ErrSessionNotFound {
+		return time.Time{}, 1043, err
+	} else if err != nil {
+		return time.Time{}, 1042, err
+	}
+
+	return now, 0, nil
+}
+
+// remainingTTL is how long the session can live from now on, it's not
+// positive once the session has reached the absolute timeout.
+func remainingTTL(data sessionData, now time.Time) (time.Duration, time.Duration) {
+	absoluteTTL := time.Unix(data.CreatedAt, 0).Add(absoluteTimeout).Sub(now)
+	if absoluteTTL < idleTimeout {
+		return absoluteTTL, absoluteTTL
+	}
+	return idleTimeout, absoluteTTL
+}
+
+// Lifetime returns how long the session can live at most from now on, the
+// credentials issued for the session don't outlive it.
+func Lifetime(data sessionData) time.Duration {
+	_, absoluteTTL := remainingTTL(data, time.Now())
+	return absoluteTTL
 }
 
 func AddSession(c *gin.Context, mail string) (string, int64, error) {
@@ -21,29 +190,39 @@ func AddSession(c *gin.Context, mail string) (string, int64, error) {
 	var errCode int64
 	errCode = 0
 
-	sessionValue := sessionData{
-		Mail: mail,
-	}
-
-	jsonSessionValue, err := json.Marshal(sessionValue)
+	// The CSRF token is bound to the session, see CSRFToken
+	csrfToken, err := encrypt.RandomToken(32)
 	if err != nil {
 		errCode = 1041
 		return "", errCode, err
 	}
 
+	nowTime := time.Now()
+	now := nowTime.Unix()
+	// A session is only created after the login has been authenticated
+	sessionValue := sessionData{
+		Mail:      mail,
+		CreatedAt: now,
+		IssuedAt:  nowTime.UnixMilli(),
+		LastSeen:  now,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		CSRFToken: csrfToken,
+		AuthAt:    now,
+		AuthBy:    "login",
+	}
+
 	// Genertate session ID with no Dash
 	sessionID := encrypt.GenertateUUID(true)
 
-	session := sessions.Default(c)
-
-	session.Set("sid", sessionID)
-	session.Save()
-
-	redisKey := "sid:" + sessionID
-	redisValue := string(jsonSessionValue)
+	err = saveCookie(c, sessionID, absoluteTimeout)
+	if err != nil {
+		errCode = 1042
+		return "", errCode, err
+	}
 
-	redisTTL, _, _ := time_convert.ConvertTimeFormat(configs.ApplicationConfig.Session.Timeout)
-	err = redis.Set(redisKey, redisValue, redisTTL)
+	sessionTTL, _ := remainingTTL(sessionValue, time.Unix(now, 0))
+	err = store.Create(sessionID, sessionValue, sessionTTL)
 	if err != nil {
 		errCode = 1042
 		return "", errCode, err
@@ -53,60 +232,192 @@ func AddSession(c *gin.Context, mail string) (string, int64, error) {
 }
 
 func CheckSession(c *gin.Context) (bool, error) {
-	session := sessions.Default(c)
-	sid := session.Get("sid")
-
-	// Process key format
-	sessionKey := fmt.Sprintf("sid:%s", sid)
+	sid := CurrentSessionID(c)
+	if sid == "" {
+		return false, nil
+	}
 
-	// Exists() function will return bool
-	isExists, err := redis.Exists(sessionKey)
-	if err != nil {
+	_, _, err := store.Read(sid)
+	if err == session_store.ErrSessionNotFound {
+		return false, nil
+	} else if err != nil {
 		return false, err
 	}
 
-	return isExists, nil
+	return true, nil
 }
 
 func DeleteSession(sid string) error {
-	// Process key format
-	sessionKey := fmt.Sprintf("sid:%s", sid)
-	err := redis.Delete(sessionKey)
+	return store.Delete(sid)
+}
+
+// CurrentSessionID returns the session ID of the cookie, it's empty when the
+// request has no session.
+func CurrentSessionID(c *gin.Context) string {
+	sid, ok := sessions.Default(c).Get("sid").(string)
+	if !ok {
+		return ""
+	}
+	return sid
+}
+
+// SessionHash is the ID of a session shown to the users.
+func SessionHash(sid string) string {
+	return encrypt.HashWithSHA(sid, "sha256")
+}
+
+// ListSessions returns the sessions of the user with their session IDs,
+// currentSID marks the session of the request.
+func ListSessions(mail, currentSID string) ([]SessionInfo, []string, error) {
+	sids, err := store.ListByUser(mail)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	return nil
+	sessionInfos := []SessionInfo{}
+	activeSIDs := []string{}
+	for _, sid := range sids {
+		data, _, errCode, err := GetSession(sid)
+		if errCode == 1043 {
+			// The session has expired after it was listed
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		sessionInfos = append(sessionInfos, SessionInfo{
+			ID:        SessionHash(sid),
+			CreatedAt: data.CreatedAt,
+			LastSeen:  data.LastSeen,
+			IP:        data.IP,
+			UserAgent: data.UserAgent,
+			Current:   sid == currentSID,
+		})
+		activeSIDs = append(activeSIDs, sid)
+	}
+
+	return sessionInfos, activeSIDs, nil
 }
 
-func ReadSession(c *gin.Context) (string, sessionData, int64, error) {
+// FindSession looks up the session ID of the user by the hash in SessionInfo.
+func FindSession(mail, id string) (string, error) {
+	_, sids, err := ListSessions(mail, "")
+	if err != nil {
+		return "", err
+	}
 
-	var errCode int64
-	errCode = 0
+	for _, sid := range sids {
+		if SessionHash(sid) == id {
+			return sid, nil
+		}
+	}
+
+	return "", nil
+}
 
-	var data sessionData
+// Touch extends the idle timeout of the session up to the absolute timeout,
+// and records the last seen time, IP and user agent of the session. The
+// error code is 1043 when the session has expired.
+func Touch(c *gin.Context, sid string) (int64, error) {
+	data, _, errCode, err := GetSession(sid)
+	if err != nil {
+		return errCode, err
+	}
+
+	now := time.Now()
+	sessionTTL, cookieTTL := remainingTTL(data, now)
+	if sessionTTL <= 0 {
+		if err = DeleteSession(sid); err != nil {
+			return 1040, err
+		}
+		return 1043, fmt.Errorf("session has reached the absolute timeout")
+	}
 
-	session := sessions.Default(c)
-	sid := session.Get("sid")
+	var update session_store.SessionUpdate
+	seen := now.Sub(time.Unix(data.LastSeen, 0)) >= touchInterval
+	if seen {
+		lastSeen, ip, userAgent := now.Unix(), c.ClientIP(), c.Request.UserAgent()
+		update = session_store.SessionUpdate{LastSeen: &lastSeen, IP: &ip, UserAgent: &userAgent}
+	}
 
-	// Convert interface{} type to String type
-	strSid := fmt.Sprintf("%v", sid)
+	err = store.Touch(sid, update, sessionTTL)
+	if err == session_store.ErrSessionNotFound {
+		return 1043, err
+	} else if err != nil {
+		return 1042, err
+	}
 
-	// Process key format
-	sessionKey := fmt.Sprintf("sid:%s", sid)
+	// The cookie is refreshed together with the last seen time
+	if seen && sid == CurrentSessionID(c) {
+		if err = saveCookie(c, sid, cookieTTL); err != nil {
+			return 1042, err
+		}
+	}
 
-	// Get value
-	value, errCode, err := redis.Get(sessionKey)
+	return 0, nil
+}
+
+// CSRFToken returns the CSRF token of the session, a session created before
+// the tokens were introduced gets one now.
+func CSRFToken(sid string) (string, int64, error) {
+	data, _, errCode, err := GetSession(sid)
 	if err != nil {
-		return "", sessionData{}, errCode, err
+		return "", errCode, err
+	}
+	if data.CSRFToken != "" {
+		return data.CSRFToken, 0, nil
+	}
+
+	data.CSRFToken, err = encrypt.RandomToken(32)
+	if err != nil {
+		return "", 1041, err
 	}
 
-	err = json.Unmarshal([]byte(value), &data)
+	err = store.Touch(sid, session_store.SessionUpdate{CSRFToken: &data.CSRFToken}, 0)
+	if err == session_store.ErrSessionNotFound {
+		return "", 1043, err
+	} else if err != nil {
+		return "", 1042, err
+	}
+
+	return data.CSRFToken, 0, nil
+}
+
+// CheckCSRFToken reports whether the token is the CSRF token of the session.
+func CheckCSRFToken(sid, token string) (bool, error) {
+	data, _, errCode, err := GetSession(sid)
+	if errCode == 1043 {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if data.CSRFToken == "" || token == "" {
+		return false, nil
+	}
+	return subtle.ConstantTimeCompare([]byte(data.CSRFToken), []byte(token)) == 1, nil
+}
+
+func ReadSession(c *gin.Context) (string, sessionData, int64, error) {
+	sid := CurrentSessionID(c)
+
+	data, _, errCode, err := GetSession(sid)
 	if err != nil {
-		errCode = 1063
 		return "", sessionData{}, errCode, err
 	}
 
-	return strSid, data, errCode, nil
+	return sid, data, errCode, nil
+}
+
+// GetSession reads the session by its ID instead of the cookie of the request,
+// it also returns the remaining lifetime of the session.
+func GetSession(sid string) (sessionData, time.Duration, int64, error) {
+	data, ttl, err := store.Read(sid)
+	if err == session_store.ErrSessionNotFound {
+		return sessionData{}, 0, 1043, err
+	} else if err != nil {
+		return sessionData{}, 0, 1044, err
+	}
 
+	return data, ttl, 0, nil
 }
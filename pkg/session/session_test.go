@@ -0,0 +1,86 @@
+package session
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"suglider-auth/pkg/session_store"
+)
+
+func TestSessionTimeout(t *testing.T) {
+	defaultIdle, defaultAbsolute := idleTimeout, absoluteTimeout
+	idleTimeout, absoluteTimeout = 30*time.Minute, 2*time.Hour
+	defer func() { idleTimeout, absoluteTimeout = defaultIdle, defaultAbsolute }()
+
+	memoryStore := session_store.NewMemoryStore()
+	SetStore(memoryStore)
+
+	// The session times are in seconds
+	now := time.Unix(time.Now().Unix(), 0)
+
+	t.Run("Test the remaining lifetime of a session", func(t *testing.T) {
+		testCases := []struct {
+			createdAt   time.Time
+			sessionTTL  time.Duration
+			absoluteTTL time.Duration
+			reason      string
+		}{
+			{now, 30 * time.Minute, 2 * time.Hour, "A new session is extended by the idle timeout."},
+			{now.Add(-100 * time.Minute), 20 * time.Minute, 20 * time.Minute, "The session doesn't outlive the absolute timeout."},
+			{now.Add(-3 * time.Hour), -time.Hour, -time.Hour, "The session has reached the absolute timeout."},
+		}
+		for _, testCase := range testCases {
+			sessionTTL, absoluteTTL := remainingTTL(sessionData{CreatedAt: testCase.createdAt.Unix()}, now)
+			if sessionTTL != testCase.sessionTTL || absoluteTTL != testCase.absoluteTTL {
+				t.Errorf("Result: %v, %v (%s)\n", sessionTTL, absoluteTTL, testCase.reason)
+			}
+		}
+
+		if lifetime := Lifetime(sessionData{CreatedAt: now.Add(-time.Hour).Unix()}); lifetime > time.Hour || lifetime < time.Hour-time.Minute {
+			t.Errorf("Result: %v (%s)\n", lifetime, "The lifetime must be the rest until the absolute timeout.")
+		}
+	})
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+
+	t.Run("Test touch extends the session up to the absolute timeout", func(t *testing.T) {
+		data := sessionData{Mail: "alice@example.com", CreatedAt: now.Add(-100 * time.Minute).Unix(), LastSeen: now.Unix()}
+		if err := memoryStore.Create("alice-laptop", data, time.Minute); err != nil {
+			t.Fatalf("Unit Test (Create session) Fail: %v\n", err)
+		}
+
+		if errCode, err := Touch(c, "alice-laptop"); err != nil {
+			t.Fatalf("Result: %d, %v (%s)\n", errCode, err, "The session must be touched.")
+		}
+
+		_, ttl, err := memoryStore.Read("alice-laptop")
+		if err != nil || ttl > 20*time.Minute || ttl < 19*time.Minute {
+			t.Errorf("Result: %v, %v (%s)\n", ttl, err, "The session must be extended until the absolute timeout.")
+		}
+	})
+
+	t.Run("Test touch deletes a session after the absolute timeout", func(t *testing.T) {
+		data := sessionData{Mail: "alice@example.com", CreatedAt: now.Add(-3 * time.Hour).Unix(), LastSeen: now.Unix()}
+		if err := memoryStore.Create("alice-phone", data, time.Minute); err != nil {
+			t.Fatalf("Unit Test (Create session) Fail: %v\n", err)
+		}
+
+		if errCode, err := Touch(c, "alice-phone"); err == nil || errCode != 1043 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "The session must expire at the absolute timeout.")
+		}
+		if _, _, err := memoryStore.Read("alice-phone"); err != session_store.ErrSessionNotFound {
+			t.Errorf("Result: %v (%s)\n", err, "The expired session must be deleted.")
+		}
+	})
+
+	t.Run("Test touch of a missing session", func(t *testing.T) {
+		if errCode, err := Touch(c, "missing"); err == nil || errCode != 1043 {
+			t.Errorf("Result: %d, %v (%s)\n", errCode, err, "The missing session must be expired.")
+		}
+	})
+}
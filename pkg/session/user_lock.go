@@ -0,0 +1,65 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/encrypt"
+)
+
+// The sessions of a user are created one at a time, otherwise concurrent
+// logins could all pass the session limit before any of them is stored.
+// The lock expires by itself if the instance holding it dies. It's kept in
+// Redis whichever session store is used, the instances share it.
+//
+//   session_lock:<mail> -> a random owner token
+
+const (
+	userLockTTL   = 5 * time.Second
+	userLockWait  = 3 * time.Second
+	userLockRetry = 50 * time.Millisecond
+)
+
+var ErrUserLocked = errors.New("another login of the user is in progress")
+
+// unlockUser only deletes the lock of the owner, the lock may have expired
+// and been taken by another login meanwhile.
+var unlockUser = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// LockUser waits until no other session of the user is being created, the
+// returned function releases the lock. It returns ErrUserLocked when the
+// lock isn't released in time.
+func LockUser(mail string) (func(), error) {
+	key := "session_lock:" + mail
+	owner := encrypt.GenertateUUID(true)
+	deadline := time.Now().Add(userLockWait)
+
+	for {
+		ok, err := redis.SetNX(key, owner, userLockTTL)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrUserLocked
+		}
+		time.Sleep(userLockRetry)
+	}
+
+	return func() {
+		if _, err := redis.RunScript(unlockUser, []string{key}, owner); err != nil {
+			errorMessage := fmt.Sprintf("Release the session lock of user failed: %v", err)
+			slog.Error(errorMessage)
+		}
+	}, nil
+}
@@ -0,0 +1,79 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"suglider-auth/internal/redis"
+)
+
+func TestLockUser(t *testing.T) {
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+
+	t.Run("Test the lock waits for the other login", func(t *testing.T) {
+		unlock, err := LockUser("alice@example.com")
+		if err != nil {
+			t.Fatalf("Unit Test (Lock user) Fail: %v\n", err)
+		}
+
+		released := make(chan time.Time, 1)
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			released <- time.Now()
+			unlock()
+		}()
+
+		unlockAgain, err := LockUser("alice@example.com")
+		lockedAt := time.Now()
+		if err != nil {
+			t.Fatalf("Result: %v (%s)\n", err, "The lock must be taken after it's released.")
+		}
+		if releasedAt := <-released; lockedAt.Before(releasedAt) {
+			t.Errorf("Result: %v (%s)\n", releasedAt, "The lock must not be taken while it's held.")
+		}
+		unlockAgain()
+
+		if server.Exists("session_lock:alice@example.com") {
+			t.Errorf("Result: %s (%s)\n", "alice@example.com", "The lock must be released.")
+		}
+	})
+
+	t.Run("Test the locks of other users don't wait", func(t *testing.T) {
+		unlock, err := LockUser("bob@example.com")
+		if err != nil {
+			t.Fatalf("Unit Test (Lock user) Fail: %v\n", err)
+		}
+		defer unlock()
+
+		start := time.Now()
+		unlockCarol, err := LockUser("carol@example.com")
+		if err != nil || time.Since(start) >= userLockRetry {
+			t.Errorf("Result: %v, %v (%s)\n", time.Since(start), err, "Another user must be locked at once.")
+		}
+		unlockCarol()
+	})
+
+	t.Run("Test an expired lock is not released by its old owner", func(t *testing.T) {
+		unlockExpired, err := LockUser("dave@example.com")
+		if err != nil {
+			t.Fatalf("Unit Test (Lock user) Fail: %v\n", err)
+		}
+		server.FastForward(userLockTTL)
+
+		unlock, err := LockUser("dave@example.com")
+		if err != nil {
+			t.Fatalf("Result: %v (%s)\n", err, "The expired lock must be taken.")
+		}
+
+		unlockExpired()
+		if !server.Exists("session_lock:dave@example.com") {
+			t.Errorf("Result: %s (%s)\n", "dave@example.com", "Only the owner can release the lock.")
+		}
+		unlock()
+	})
+}
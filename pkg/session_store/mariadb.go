@@ -0,0 +1,145 @@
+package session_store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MariaDBStore keeps the sessions in the user_session table, so they outlive
+// a flush of Redis. The expiration is a unix time, the expired rows are
+// removed when a new session is created.
+type MariaDBStore struct {
+	db *sqlx.DB
+}
+
+func NewMariaDBStore(db *sqlx.DB) *MariaDBStore {
+	return &MariaDBStore{db: db}
+}
+
+type sessionRow struct {
+	Data      string `db:"data"`
+	ExpiresAt int64  `db:"expires_at"`
+}
+
+func (ms *MariaDBStore) Create(sid string, data SessionData, ttl time.Duration) error {
+	jsonSessionValue, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if _, err = ms.db.Exec("DELETE FROM suglider.user_session WHERE expires_at <= ?", now.Unix()); err != nil {
+		return err
+	}
+
+	_, err = ms.db.Exec(
+		"INSERT INTO suglider.user_session(sid, mail, data, expires_at) VALUES (?, ?, ?, ?)",
+		sid, data.Mail, string(jsonSessionValue), now.Add(ttl).Unix(),
+	)
+	return err
+}
+
+func (ms *MariaDBStore) Read(sid string) (SessionData, time.Duration, error) {
+	var (
+		row  sessionRow
+		data SessionData
+	)
+
+	now := time.Now()
+	err := ms.db.Get(&row, "SELECT data, expires_at FROM suglider.user_session WHERE sid = ? AND expires_at > ?", sid, now.Unix())
+	if err == sql.ErrNoRows {
+		return SessionData{}, 0, ErrSessionNotFound
+	} else if err != nil {
+		return SessionData{}, 0, err
+	}
+
+	if err = json.Unmarshal([]byte(row.Data), &data); err != nil {
+		return SessionData{}, 0, err
+	}
+
+	return data, time.Unix(row.ExpiresAt, 0).Sub(now), nil
+}
+
+func (ms *MariaDBStore) Touch(sid string, update SessionUpdate, ttl time.Duration) error {
+	// JSON_SET only writes the changed fields of the session JSON
+	paths := []string{}
+	args := []interface{}{}
+	set := func(path string, value interface{}) {
+		paths = append(paths, "'"+path+"', ?")
+		args = append(args, value)
+	}
+	if update.LastSeen != nil {
+		set("$.last_seen", *update.LastSeen)
+	}
+	if update.IP != nil {
+		set("$.ip", *update.IP)
+	}
+	if update.UserAgent != nil {
+		set("$.user_agent", *update.UserAgent)
+	}
+	if update.CSRFToken != nil {
+		set("$.csrf_token", *update.CSRFToken)
+	}
+	if update.AuthAt != nil {
+		set("$.auth_at", *update.AuthAt)
+	}
+	if update.AuthBy != nil {
+		set("$.auth_by", *update.AuthBy)
+	}
+
+	now := time.Now()
+	columns := []string{}
+	if len(paths) > 0 {
+		columns = append(columns, "data = JSON_SET(data, "+strings.Join(paths, ", ")+")")
+	}
+	if ttl > 0 {
+		columns = append(columns, "expires_at = ?")
+		args = append(args, now.Add(ttl).Unix())
+	}
+	if len(columns) == 0 {
+		_, _, err := ms.Read(sid)
+		return err
+	}
+	args = append(args, sid, now.Unix())
+
+	result, err := ms.db.Exec(
+		"UPDATE suglider.user_session SET "+strings.Join(columns, ", ")+" WHERE sid = ? AND expires_at > ?",
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// MariaDB doesn't count a row updated to the same values, the session
+	// is looked up again to tell it apart from an expired one.
+	if affected == 0 {
+		_, _, err = ms.Read(sid)
+		return err
+	}
+
+	return nil
+}
+
+func (ms *MariaDBStore) Delete(sid string) error {
+	_, err := ms.db.Exec("DELETE FROM suglider.user_session WHERE sid = ?", sid)
+	return err
+}
+
+func (ms *MariaDBStore) ListByUser(mail string) ([]string, error) {
+	sids := []string{}
+
+	err := ms.db.Select(&sids, "SELECT sid FROM suglider.user_session WHERE mail = ? AND expires_at > ?", mail, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	return sids, nil
+}
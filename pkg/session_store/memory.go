@@ -0,0 +1,96 @@
+package session_store
+
+import (
+	"sync"
+	"time"
+)
+
+type memorySession struct {
+	data      SessionData
+	expiresAt time.Time
+}
+
+// MemoryStore keeps the sessions in the process, it's meant for tests and a
+// single node for development. The sessions are lost when the server stops.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+	now      func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]memorySession),
+		now:      time.Now,
+	}
+}
+
+// get returns the live session, an expired one is removed. The lock must be held.
+func (ms *MemoryStore) get(sid string) (memorySession, bool) {
+	session, ok := ms.sessions[sid]
+	if !ok {
+		return memorySession{}, false
+	}
+	if !ms.now().Before(session.expiresAt) {
+		delete(ms.sessions, sid)
+		return memorySession{}, false
+	}
+	return session, true
+}
+
+func (ms *MemoryStore) Create(sid string, data SessionData, ttl time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.sessions[sid] = memorySession{data: data, expiresAt: ms.now().Add(ttl)}
+	return nil
+}
+
+func (ms *MemoryStore) Read(sid string) (SessionData, time.Duration, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	session, ok := ms.get(sid)
+	if !ok {
+		return SessionData{}, 0, ErrSessionNotFound
+	}
+	return session.data, session.expiresAt.Sub(ms.now()), nil
+}
+
+func (ms *MemoryStore) Touch(sid string, update SessionUpdate, ttl time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	session, ok := ms.get(sid)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	update.apply(&session.data)
+	if ttl > 0 {
+		session.expiresAt = ms.now().Add(ttl)
+	}
+	ms.sessions[sid] = session
+	return nil
+}
+
+func (ms *MemoryStore) Delete(sid string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.sessions, sid)
+	return nil
+}
+
+func (ms *MemoryStore) ListByUser(mail string) ([]string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	sids := []string{}
+	for sid := range ms.sessions {
+		session, ok := ms.get(sid)
+		if ok && session.data.Mail == mail {
+			sids = append(sids, sid)
+		}
+	}
+	return sids, nil
+}
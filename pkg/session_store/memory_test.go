@@ -0,0 +1,94 @@
+package session_store
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	alice := SessionData{Mail: "alice@example.com", CreatedAt: now.Unix()}
+	bob := SessionData{Mail: "bob@example.com", CreatedAt: now.Unix()}
+
+	if err := store.Create("a1", alice, time.Minute); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := store.Create("a2", alice, time.Hour); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := store.Create("b1", bob, time.Hour); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	t.Run("Test read and list by user", func(t *testing.T) {
+		data, ttl, err := store.Read("a1")
+		if err != nil || data.Mail != alice.Mail || ttl != time.Minute {
+			t.Errorf("Read returns %v, %v, %v", data, ttl, err)
+		}
+
+		sids, err := store.ListByUser(alice.Mail)
+		sort.Strings(sids)
+		if err != nil || len(sids) != 2 || sids[0] != "a1" || sids[1] != "a2" {
+			t.Errorf("ListByUser returns %v, %v", sids, err)
+		}
+	})
+
+	t.Run("Test touch extends the session", func(t *testing.T) {
+		lastSeen := now.Unix()
+		if err := store.Touch("a1", SessionUpdate{LastSeen: &lastSeen}, 10*time.Minute); err != nil {
+			t.Fatalf("Touch failed: %v", err)
+		}
+
+		data, ttl, err := store.Read("a1")
+		if err != nil || data.LastSeen != now.Unix() || ttl != 10*time.Minute {
+			t.Errorf("Read returns %v, %v, %v", data, ttl, err)
+		}
+	})
+
+	t.Run("Test touch only changes the updated fields", func(t *testing.T) {
+		authBy := "totp"
+		if err := store.Touch("a1", SessionUpdate{AuthBy: &authBy}, 0); err != nil {
+			t.Fatalf("Touch failed: %v", err)
+		}
+
+		data, ttl, err := store.Read("a1")
+		if err != nil || data.AuthBy != "totp" || data.LastSeen != now.Unix() || data.Mail != alice.Mail {
+			t.Errorf("Read returns %v, %v", data, err)
+		}
+		if ttl != 10*time.Minute {
+			t.Errorf("Touch without ttl changes the lifetime to %v", ttl)
+		}
+	})
+
+	t.Run("Test expired session is gone", func(t *testing.T) {
+		now = now.Add(30 * time.Minute)
+
+		if _, _, err := store.Read("a1"); err != ErrSessionNotFound {
+			t.Errorf("Read of expired session returns %v", err)
+		}
+		if err := store.Touch("a1", SessionUpdate{}, time.Hour); err != ErrSessionNotFound {
+			t.Errorf("Touch of expired session returns %v", err)
+		}
+
+		sids, err := store.ListByUser(alice.Mail)
+		if err != nil || len(sids) != 1 || sids[0] != "a2" {
+			t.Errorf("ListByUser returns %v, %v", sids, err)
+		}
+	})
+
+	t.Run("Test delete", func(t *testing.T) {
+		if err := store.Delete("b1"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if err := store.Delete("b1"); err != nil {
+			t.Errorf("Delete of missing session returns %v", err)
+		}
+		if _, _, err := store.Read("b1"); err != ErrSessionNotFound {
+			t.Errorf("Read of deleted session returns %v", err)
+		}
+	})
+}
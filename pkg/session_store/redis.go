@@ -0,0 +1,147 @@
+package session_store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"suglider-auth/internal/redis"
+)
+
+// RedisStore keeps a session in the key sid:<sid>, and every session of a
+// user is indexed by the set user_sessions:<mail>. A member whose sid key has
+// expired is removed when the sessions of the user are listed.
+type RedisStore struct {
+	// The index of a user lives as long as the longest session
+	indexTTL time.Duration
+}
+
+// touchSession changes the fields of the session JSON in place, so the
+// fields which are not updated keep the value written by any other request.
+// It returns 0 when the session doesn't exist.
+var touchSession = redis.NewScript(`
+local value = redis.call('GET', KEYS[1])
+if not value then
+  return 0
+end
+
+local data = cjson.decode(value)
+for field, fieldValue in pairs(cjson.decode(ARGV[1])) do
+  data[field] = fieldValue
+end
+
+local ttl = tonumber(ARGV[2])
+if ttl > 0 then
+  redis.call('SET', KEYS[1], cjson.encode(data), 'PX', ttl)
+else
+  redis.call('SET', KEYS[1], cjson.encode(data), 'KEEPTTL')
+end
+return 1
+`)
+
+func NewRedisStore(indexTTL time.Duration) *RedisStore {
+	return &RedisStore{indexTTL: indexTTL}
+}
+
+func sessionKey(sid string) string {
+	return fmt.Sprintf("sid:%s", sid)
+}
+
+func indexKey(mail string) string {
+	return "user_sessions:" + mail
+}
+
+func (rs *RedisStore) Create(sid string, data SessionData, ttl time.Duration) error {
+	jsonSessionValue, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if err = redis.Set(sessionKey(sid), string(jsonSessionValue), ttl); err != nil {
+		return err
+	}
+
+	return redis.SAdd(indexKey(data.Mail), sid, rs.indexTTL)
+}
+
+func (rs *RedisStore) Read(sid string) (SessionData, time.Duration, error) {
+	var data SessionData
+
+	value, errCode, err := redis.Get(sessionKey(sid))
+	if errCode == 1043 {
+		return SessionData{}, 0, ErrSessionNotFound
+	} else if err != nil {
+		return SessionData{}, 0, err
+	}
+
+	if err = json.Unmarshal([]byte(value), &data); err != nil {
+		return SessionData{}, 0, err
+	}
+
+	ttl, err := redis.TTL(sessionKey(sid))
+	if err != nil {
+		return SessionData{}, 0, err
+	}
+
+	return data, ttl, nil
+}
+
+func (rs *RedisStore) Touch(sid string, update SessionUpdate, ttl time.Duration) error {
+	jsonUpdate, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	reply, err := redis.RunScript(touchSession, []string{sessionKey(sid)}, string(jsonUpdate), ttl.Milliseconds())
+	if err != nil {
+		return err
+	}
+	if touched, _ := reply.(int64); touched == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (rs *RedisStore) Delete(sid string) error {
+	// Remove the session from the index of the user
+	data, _, err := rs.Read(sid)
+	if err == ErrSessionNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err = redis.SRem(indexKey(data.Mail), sid); err != nil {
+		return err
+	}
+
+	return redis.Delete(sessionKey(sid))
+}
+
+func (rs *RedisStore) ListByUser(mail string) ([]string, error) {
+	sids, err := redis.SMembers(indexKey(mail))
+	if err != nil {
+		return nil, err
+	}
+
+	activeSIDs := []string{}
+	for _, sid := range sids {
+		isExists, err := redis.Exists(sessionKey(sid))
+		if err != nil {
+			return nil, err
+		}
+
+		// The session has expired
+		if !isExists {
+			if err = redis.SRem(indexKey(mail), sid); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		activeSIDs = append(activeSIDs, sid)
+	}
+
+	return activeSIDs, nil
+}
@@ -0,0 +1,80 @@
+package session_store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"suglider-auth/internal/redis"
+)
+
+func TestRedisStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+
+	store := NewRedisStore(time.Hour)
+	alice := SessionData{Mail: "alice@example.com", CreatedAt: 1700000000, LastSeen: 1700000000, IP: "10.0.0.1"}
+
+	if err := store.Create("a1", alice, time.Hour); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	t.Run("Test touch only changes the updated fields", func(t *testing.T) {
+		authAt, authBy := int64(1700000100), "totp"
+		if err := store.Touch("a1", SessionUpdate{AuthAt: &authAt, AuthBy: &authBy}, 0); err != nil {
+			t.Fatalf("Touch failed: %v", err)
+		}
+
+		data, ttl, err := store.Read("a1")
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if data.AuthAt != authAt || data.AuthBy != authBy {
+			t.Errorf("Read returns %v, the updated fields are not written", data)
+		}
+		if data.Mail != alice.Mail || data.LastSeen != alice.LastSeen || data.IP != alice.IP {
+			t.Errorf("Read returns %v, the other fields are changed", data)
+		}
+		if ttl != time.Hour {
+			t.Errorf("Touch without ttl changes the lifetime to %v", ttl)
+		}
+	})
+
+	t.Run("Test touch extends the session", func(t *testing.T) {
+		lastSeen := int64(1700000200)
+		if err := store.Touch("a1", SessionUpdate{LastSeen: &lastSeen}, 10*time.Minute); err != nil {
+			t.Fatalf("Touch failed: %v", err)
+		}
+
+		data, ttl, err := store.Read("a1")
+		if err != nil || data.LastSeen != lastSeen || data.AuthBy != "totp" || ttl != 10*time.Minute {
+			t.Errorf("Read returns %v, %v, %v", data, ttl, err)
+		}
+	})
+
+	t.Run("Test touch of missing session", func(t *testing.T) {
+		if err := store.Touch("missing", SessionUpdate{}, time.Hour); err != ErrSessionNotFound {
+			t.Errorf("Touch of missing session returns %v", err)
+		}
+		if server.Exists(sessionKey("missing")) {
+			t.Errorf("Touch of missing session creates it")
+		}
+	})
+
+	t.Run("Test list and delete", func(t *testing.T) {
+		sids, err := store.ListByUser(alice.Mail)
+		if err != nil || len(sids) != 1 || sids[0] != "a1" {
+			t.Errorf("ListByUser returns %v, %v", sids, err)
+		}
+
+		if err = store.Delete("a1"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if _, _, err = store.Read("a1"); err != ErrSessionNotFound {
+			t.Errorf("Read of deleted session returns %v", err)
+		}
+	})
+}
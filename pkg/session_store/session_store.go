@@ -0,0 +1,78 @@
+package session_store
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrSessionNotFound is returned when the session doesn't exist or has expired.
+var ErrSessionNotFound = errors.New("session not found")
+
+type SessionData struct {
+	Mail      string `json:"mail"`
+	CreatedAt int64  `json:"created_at"`
+	IssuedAt  int64  `json:"issued_at,omitempty"` // CreatedAt in unix milliseconds, compared with the token denylist
+	LastSeen  int64  `json:"last_seen"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	CSRFToken string `json:"csrf_token,omitempty"`
+	AuthAt    int64  `json:"auth_at,omitempty"` // when the password or a second factor was verified last
+	AuthBy    string `json:"auth_by,omitempty"` // the factor verified at AuthAt
+}
+
+// SessionUpdate holds the fields of a session to change, a nil field keeps
+// its value. Only these fields are written, so concurrent updates of
+// different fields don't overwrite each other.
+type SessionUpdate struct {
+	LastSeen  *int64  `json:"last_seen,omitempty"`
+	IP        *string `json:"ip,omitempty"`
+	UserAgent *string `json:"user_agent,omitempty"`
+	CSRFToken *string `json:"csrf_token,omitempty"`
+	AuthAt    *int64  `json:"auth_at,omitempty"`
+	AuthBy    *string `json:"auth_by,omitempty"`
+}
+
+// apply copies the changed fields into the data.
+func (update SessionUpdate) apply(data *SessionData) {
+	if update.LastSeen != nil {
+		data.LastSeen = *update.LastSeen
+	}
+	if update.IP != nil {
+		data.IP = *update.IP
+	}
+	if update.UserAgent != nil {
+		data.UserAgent = *update.UserAgent
+	}
+	if update.CSRFToken != nil {
+		data.CSRFToken = *update.CSRFToken
+	}
+	if update.AuthAt != nil {
+		data.AuthAt = *update.AuthAt
+	}
+	if update.AuthBy != nil {
+		data.AuthBy = *update.AuthBy
+	}
+}
+
+// SessionStore keeps the login sessions, the session ID is the key and every
+// session expires after its TTL. Only the sessions are kept by the store,
+// Redis is required by every store for the tokens, the OTPs, the rate limits
+// and the session lock of users.
+type SessionStore interface {
+	// Create saves a new session which expires after ttl.
+	Create(sid string, data SessionData, ttl time.Duration) error
+
+	// Read returns the session and its remaining lifetime.
+	Read(sid string) (SessionData, time.Duration, error)
+
+	// Touch changes the fields of an existing session and extends its
+	// lifetime to ttl, a ttl of 0 keeps the lifetime. A missing session is
+	// not created again.
+	Touch(sid string, update SessionUpdate, ttl time.Duration) error
+
+	// Delete removes the session, it's not an error when the session is gone.
+	Delete(sid string) error
+
+	// ListByUser returns the IDs of the live sessions of the user.
+	ListByUser(mail string) ([]string, error)
+}
@@ -115,6 +115,15 @@ func (hsc *HinetSmsClient) Check() (bool, error) {
 	return false, fmt.Errorf("Hinet sms api is unhealth!")
 }
 
+// SendText sends a plain text message, the message type is chosen by Send.
+func (hsc *HinetSmsClient) SendText(phoneNumber, countryCode, text string) (string, error) {
+	return hsc.Send(HinetSmsMessage {
+		PhoneNumber: phoneNumber,
+		CountryCode: countryCode,
+		Message:     text,
+	})
+}
+
 func (hsc *HinetSmsClient) Send(data interface{}) (string, error) {
 	var (
 		smsType  string
@@ -2,6 +2,7 @@ package sms
 
 type SmsSender interface {
 	Send(data interface{}) (string, error)
+	SendText(phoneNumber, countryCode, text string) (string, error)
 	SendQuery(data interface{}) (string, error)
 	SendCancelSms(data interface{}) error
 	Check() (bool, error)
@@ -10,3 +11,8 @@ type SmsSender interface {
 type SmsClient struct {
 	Sender       SmsSender
 }
+
+// SendText sends a plain text message with the provider of the client.
+func (sc *SmsClient) SendText(phoneNumber, countryCode, text string) (string, error) {
+	return sc.Sender.SendText(phoneNumber, countryCode, text)
+}
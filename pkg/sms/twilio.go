@@ -73,6 +73,15 @@ func (tc *TwilioClient) Check() (bool, error) {
 	return false, nil
 }
 
+// SendText sends a plain text message immediately.
+func (tc *TwilioClient) SendText(phoneNumber, countryCode, text string) (string, error) {
+	return tc.Send(TwilioSmsMessage {
+		PhoneNumber: phoneNumber,
+		CountryCode: countryCode,
+		Message:     text,
+	})
+}
+
 func (tc *TwilioClient) Send(data interface{}) (string, error) {
 	msgBody := data.(TwilioSmsMessage)
 
@@ -106,7 +115,7 @@ func (tc *TwilioClient) Send(data interface{}) (string, error) {
 	params := &twilioApi.CreateMessageParams{}
 	params.SetFrom(tc.PhoneNumber)
 	params.SetTo(pn.Format(num, pn.E164))
-	params.SetTo(msgBody.Message)
+	params.SetBody(msgBody.Message)
 
 	if msgBody.SendAt != "" && msgBody.MessagingServiceSid != "" {
 		params.SetScheduleType("fixed")
@@ -0,0 +1,138 @@
+package token_denylist
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"suglider-auth/configs"
+	"suglider-auth/internal/redis"
+	"suglider-auth/pkg/time_convert"
+)
+
+// The denylist keeps three kinds of redis keys:
+//
+//   revoked_jti:<jti>     -> a single JWT, expires with the token
+//   revoked_sid:<sid>     -> every JWT issued for the login session
+//   revoked_before:<mail> -> unix milliseconds, every credential of the user
+//                            issued at or before it is rejected
+//
+// The last one expires after the longest lifetime of any credential, so
+// the key is gone once nothing issued before it could still be valid.
+
+var notBeforeTTL time.Duration
+
+func init() {
+	configs.OnLoad(loadConfig)
+}
+
+func loadConfig() {
+	ttls := []string{"20m", "168h"}
+
+	if jwtConfig := configs.ApplicationConfig.Jwt; jwtConfig != nil {
+		ttls = append(ttls, jwtConfig.AccessTokenTTL, jwtConfig.RefreshTokenTTL)
+	}
+	if sessionConfig := configs.ApplicationConfig.Session; sessionConfig != nil {
+		ttls = append(ttls, sessionConfig.Timeout, sessionConfig.AbsoluteTimeout)
+	}
+
+	for _, ttl := range ttls {
+		if ttl == "" {
+			continue
+		}
+		duration, _, err := time_convert.ConvertTimeFormat(ttl)
+		if err != nil {
+			errorMessage := fmt.Sprintf("TTL string convert to duration failed: %v", err)
+			slog.Error(errorMessage)
+
+			panic(err)
+		}
+		if duration > notBeforeTTL {
+			notBeforeTTL = duration
+		}
+	}
+}
+
+// RevokeToken puts the jti of a JWT into the denylist until it expires.
+func RevokeToken(jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return nil
+	}
+
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	return redis.Set("revoked_jti:"+jti, "1", ttl)
+}
+
+// RevokeUser rejects every token, refresh token and session of the user
+// which was issued until now.
+func RevokeUser(mail string) error {
+	notBefore := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	return redis.Set("revoked_before:"+mail, notBefore, notBeforeTTL)
+}
+
+// RevokeSession rejects every JWT carrying the session ID, the tokens are
+// still refreshed from the same session until its refresh family is revoked.
+func RevokeSession(sid string) error {
+	if sid == "" {
+		return nil
+	}
+
+	return redis.Set("revoked_sid:"+sid, "1", notBeforeTTL)
+}
+
+// IsSessionRevoked reports whether the session was signed out by RevokeSession,
+// it tells a forced logout apart from a session which has timed out.
+func IsSessionRevoked(sid string) (bool, error) {
+	if sid == "" {
+		return false, nil
+	}
+
+	return redis.Exists("revoked_sid:" + sid)
+}
+
+func IsTokenRevoked(jti, sid, mail string, issuedAt time.Time) (bool, error) {
+	isRevoked, err := IsSessionRevoked(sid)
+	if err != nil {
+		return false, err
+	}
+	if isRevoked {
+		return true, nil
+	}
+
+	if jti != "" {
+		isExists, err := redis.Exists("revoked_jti:" + jti)
+		if err != nil {
+			return false, err
+		}
+		if isExists {
+			return true, nil
+		}
+	}
+
+	return IsUserRevoked(mail, issuedAt)
+}
+
+func IsUserRevoked(mail string, issuedAt time.Time) (bool, error) {
+	value, errCode, err := redis.Get("revoked_before:" + mail)
+	switch errCode {
+	case 1043:
+		return false, nil
+	case 1044:
+		return false, err
+	}
+
+	notBefore, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false, err
+	}
+
+	// The credentials record their issued time in milliseconds, so a login
+	// right after the revocation is valid.
+	return issuedAt.UnixMilli() <= notBefore, nil
+}
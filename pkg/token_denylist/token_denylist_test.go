@@ -0,0 +1,95 @@
+package token_denylist
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"suglider-auth/internal/redis"
+)
+
+func TestTokenDenylist(t *testing.T) {
+	server := miniredis.RunT(t)
+	if err := redis.Connect(server.Host(), server.Port(), ""); err != nil {
+		t.Fatalf("Unit Test (Connect redis) Fail: %v\n", err)
+	}
+
+	t.Run("Test the not-before time of the user", func(t *testing.T) {
+		notBefore := time.Unix(1700000000, 0)
+		if err := server.Set("revoked_before:alice@example.com", strconv.FormatInt(notBefore.UnixMilli(), 10)); err != nil {
+			t.Fatalf("Unit Test (Set not-before) Fail: %v\n", err)
+		}
+
+		testCases := []struct {
+			issuedAt time.Time
+			revoked  bool
+			reason   string
+		}{
+			{notBefore.Add(-time.Hour), true, "A credential issued before the revocation is revoked."},
+			{notBefore, true, "A credential issued in the millisecond of the revocation is revoked."},
+			{notBefore.Add(time.Millisecond), false, "A credential issued right after the revocation is valid."},
+			{notBefore.Add(time.Second), false, "A credential issued after the revocation is valid."},
+		}
+		for _, testCase := range testCases {
+			isRevoked, err := IsUserRevoked("alice@example.com", testCase.issuedAt)
+			if err != nil || isRevoked != testCase.revoked {
+				t.Errorf("Result: %v, %v (%s)\n", isRevoked, err, testCase.reason)
+			}
+		}
+
+		isRevoked, err := IsUserRevoked("bob@example.com", notBefore)
+		if err != nil || isRevoked {
+			t.Errorf("Result: %v, %v (%s)\n", isRevoked, err, "Another user is not revoked.")
+		}
+	})
+
+	t.Run("Test revoke a user", func(t *testing.T) {
+		issuedAt := time.Now()
+		if err := RevokeUser("carol@example.com"); err != nil {
+			t.Fatalf("Unit Test (Revoke user) Fail: %v\n", err)
+		}
+
+		isRevoked, err := IsUserRevoked("carol@example.com", issuedAt)
+		if err != nil || !isRevoked {
+			t.Errorf("Result: %v, %v (%s)\n", isRevoked, err, "The credential issued until now must be revoked.")
+		}
+		isRevoked, err = IsUserRevoked("carol@example.com", time.Now().Add(time.Millisecond))
+		if err != nil || isRevoked {
+			t.Errorf("Result: %v, %v (%s)\n", isRevoked, err, "The credential issued right after the revocation must be valid.")
+		}
+		if ttl := server.TTL("revoked_before:carol@example.com"); ttl != notBeforeTTL {
+			t.Errorf("Result: %v (%s)\n", ttl, "The not-before time must outlive every credential.")
+		}
+	})
+
+	t.Run("Test revoke a token and a session", func(t *testing.T) {
+		if err := RevokeToken("jti-1", time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("Unit Test (Revoke token) Fail: %v\n", err)
+		}
+		if err := RevokeSession("sid-1"); err != nil {
+			t.Fatalf("Unit Test (Revoke session) Fail: %v\n", err)
+		}
+
+		testCases := []struct {
+			jti, sid string
+			revoked  bool
+			reason   string
+		}{
+			{"jti-1", "sid-2", true, "The revoked jti must be rejected."},
+			{"jti-2", "sid-1", true, "Every token of the revoked session must be rejected."},
+			{"jti-2", "sid-2", false, "The other tokens are valid."},
+		}
+		for _, testCase := range testCases {
+			isRevoked, err := IsTokenRevoked(testCase.jti, testCase.sid, "dave@example.com", time.Now())
+			if err != nil || isRevoked != testCase.revoked {
+				t.Errorf("Result: %v, %v (%s)\n", isRevoked, err, testCase.reason)
+			}
+		}
+
+		if err := RevokeToken("jti-3", time.Now().Add(-time.Minute)); err != nil || server.Exists("revoked_jti:jti-3") {
+			t.Errorf("Result: %v (%s)\n", err, "An expired token doesn't need to be denylisted.")
+		}
+	})
+}
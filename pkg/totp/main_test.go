@@ -0,0 +1,13 @@
+package totp
+
+import (
+	"os"
+	"testing"
+
+	"suglider-auth/configs/configtest"
+)
+
+func TestMain(m *testing.M) {
+	configtest.Load()
+	os.Exit(m.Run())
+}
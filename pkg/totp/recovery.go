@@ -0,0 +1,56 @@
+package totp
+
+import (
+	"crypto/rand"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"suglider-auth/pkg/encrypt"
+)
+
+// Recovery codes look like "k7m2p-x9q4t", the characters which are easily
+// confused (0/o, 1/l/i) are not used.
+const (
+	RecoveryCodeCount    = 10
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	recoveryCodeLength   = 10
+)
+
+var recoveryCodeFormat = regexp.MustCompile(`^[a-z0-9]{5}-?[a-z0-9]{5}$`)
+
+// GenerateRecoveryCodes returns new recovery codes and their hashes, only the
+// hashes are stored.
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	hashes := make([]string, 0, RecoveryCodeCount)
+	max := big.NewInt(int64(len(recoveryCodeAlphabet)))
+
+	for c := 0; c < RecoveryCodeCount; c++ {
+		buf := make([]byte, recoveryCodeLength)
+		for i := range buf {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return nil, nil, err
+			}
+			buf[i] = recoveryCodeAlphabet[n.Int64()]
+		}
+		code := string(buf[:5]) + "-" + string(buf[5:])
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// IsRecoveryCode reports whether the code from the user is a recovery code
+// rather than a TOTP code.
+func IsRecoveryCode(code string) bool {
+	return recoveryCodeFormat.MatchString(strings.ToLower(strings.TrimSpace(code)))
+}
+
+// HashRecoveryCode ignores the case and the dash of the code.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(code)), "-", "")
+	return encrypt.HashWithSHA(normalized, "sha256")
+}
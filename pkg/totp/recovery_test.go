@@ -0,0 +1,60 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecoveryCode(t *testing.T) {
+	t.Run("Test generate recovery codes", func(t *testing.T) {
+		codes, hashes, err := GenerateRecoveryCodes()
+		if err != nil {
+			t.Fatalf("Unit Test (Generate recovery codes) Fail: %v\n", err)
+		}
+		if len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+			t.Fatalf("Result: %d, %d (%s)\n", len(codes), len(hashes), "The number of recovery codes is not correct.")
+		}
+
+		seen := map[string]bool{}
+		for i, code := range codes {
+			if !IsRecoveryCode(code) {
+				t.Errorf("Result: %s (%s)\n", code, "The generated code must be a recovery code.")
+			}
+			if strings.ContainsAny(code, "01ilo") {
+				t.Errorf("Result: %s (%s)\n", code, "The characters easily confused must not be used.")
+			}
+			if hashes[i] != HashRecoveryCode(code) {
+				t.Errorf("Result: %s (%s)\n", code, "The hash doesn't belong to the code.")
+			}
+			if seen[code] {
+				t.Errorf("Result: %s (%s)\n", code, "The recovery codes must be unique.")
+			}
+			seen[code] = true
+		}
+	})
+
+	t.Run("Test the recovery code is normalized", func(t *testing.T) {
+		hashed := HashRecoveryCode("k7m2p-x9q4t")
+
+		for _, code := range []string{"k7m2px9q4t", "K7M2P-X9Q4T", " k7m2p-x9q4t\n", "K7m2pX9q4T"} {
+			if !IsRecoveryCode(code) {
+				t.Errorf("Result: %q (%s)\n", code, "The code must be recognized as a recovery code.")
+			}
+			if HashRecoveryCode(code) != hashed {
+				t.Errorf("Result: %q (%s)\n", code, "The case, dash and spaces of the code must be ignored.")
+			}
+		}
+
+		if HashRecoveryCode("k7m2p-x9q4u") == hashed {
+			t.Errorf("Result: %s (%s)\n", "k7m2p-x9q4u", "Another code must not have the same hash.")
+		}
+	})
+
+	t.Run("Test a TOTP code is not a recovery code", func(t *testing.T) {
+		for _, code := range []string{"123456", "12345678", "k7m2p--x9q4t", "k7m2p-x9q4", "k7m2p x9q4t"} {
+			if IsRecoveryCode(code) {
+				t.Errorf("Result: %q (%s)\n", code, "The code must not be recognized as a recovery code.")
+			}
+		}
+	})
+}
@@ -2,28 +2,142 @@ package totp
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"fmt"
 	"image/png"
 	"log/slog"
+	"strings"
+	"time"
+
+	"suglider-auth/configs"
 	mariadb "suglider-auth/internal/database"
+	"suglider-auth/pkg/encrypt"
 
+	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
 )
 
+// The settings of [totp] are used to generate new secrets. A code is
+// validated with the digits, period and algorithm in the otpauth URL of the
+// secret, so the secrets generated before the settings change still work.
+var (
+	issuer         = "Suglider"
+	digits         = otp.DigitsSix
+	period    uint = 30
+	algorithm      = otp.AlgorithmSHA1
+	skew      uint = 1
+)
+
+func init() {
+	configs.OnLoad(loadConfig)
+}
+
+func loadConfig() {
+	totpConfig := configs.ApplicationConfig.Totp
+	if totpConfig == nil {
+		return
+	}
+
+	if totpConfig.Issuer != "" {
+		issuer = totpConfig.Issuer
+	}
+
+	switch totpConfig.Digits {
+	case 0, 6:
+		digits = otp.DigitsSix
+	case 8:
+		digits = otp.DigitsEight
+	default:
+		err := fmt.Errorf("unsupported TOTP digits: %d", totpConfig.Digits)
+		slog.Error(err.Error())
+		panic(err)
+	}
+
+	if totpConfig.Period > 0 {
+		period = totpConfig.Period
+	}
+
+	switch strings.ToUpper(totpConfig.Algorithm) {
+	case "", "SHA1":
+		algorithm = otp.AlgorithmSHA1
+	case "SHA256":
+		algorithm = otp.AlgorithmSHA256
+	case "SHA512":
+		algorithm = otp.AlgorithmSHA512
+	default:
+		err := fmt.Errorf("unsupported TOTP algorithm: %s", totpConfig.Algorithm)
+		slog.Error(err.Error())
+		panic(err)
+	}
+
+	skew = totpConfig.Skew
+}
+
 type totpInfo struct {
-	Base32  string `json:"base32"`
-	AuthURL string `json:"auth_url"`
+	DeviceID string `json:"device_id"`
+	Name     string `json:"name"`
+	Base32   string `json:"base32"`
+	AuthURL  string `json:"auth_url"`
 }
 
-func TotpGernate(mail, userID string) (*totpInfo, []byte, int64, error) {
+// QRCode renders the otpauth URL of a secret as a PNG image.
+func QRCode(totpURL string, size int) ([]byte, error) {
+	key, err := otp.NewKeyFromURL(totpURL)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := key.Image(size, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// TotpGernate creates a TOTP device named name, an unverified device of the
+// same name is replaced. The error code is 1109 when a verified device has
+// the name.
+func TotpGernate(mail, userID, name string) (*totpInfo, []byte, int64, error) {
 
 	var errCode int64
 	errCode = 0
 
+	devices, err := mariadb.TotpDeviceList(mail)
+	if err != nil {
+		errorMessage := fmt.Sprintf("List TOTP devices failed: %v", err)
+		slog.Error(errorMessage)
+		errCode = 1002
+		return nil, nil, errCode, err
+	}
+
+	for _, device := range devices {
+		if device.Name != name {
+			continue
+		}
+		if device.Verified {
+			errCode = 1109
+			return nil, nil, errCode, fmt.Errorf("TOTP device %s already exists", name)
+		}
+		if _, err = mariadb.TotpDeviceDelete(device.DeviceID, mail); err != nil {
+			errorMessage := fmt.Sprintf("Delete unverified TOTP device failed: %v", err)
+			slog.Error(errorMessage)
+			errCode = 1002
+			return nil, nil, errCode, err
+		}
+	}
+
 	// Generate TOTP key
 	key, err := totp.Generate(totp.GenerateOpts{
-		Issuer:      "Suglider",
+		Issuer:      issuer,
 		AccountName: mail,
+		Period:      period,
+		Digits:      digits,
+		Algorithm:   algorithm,
 	})
 	if err != nil {
 		errorMessage := fmt.Sprintf("Generate TOTP key have something problem: %v", err)
@@ -32,11 +146,6 @@ func TotpGernate(mail, userID string) (*totpInfo, []byte, int64, error) {
 		return nil, nil, errCode, err
 	}
 
-	totpData := &totpInfo{
-		Base32:  key.Secret(),
-		AuthURL: key.URL(),
-	}
-
 	// Convert TOTP key into a QR code encoded as a PNG image.
 	var buf bytes.Buffer
 	img, err := key.Image(200, 200)
@@ -55,44 +164,90 @@ func TotpGernate(mail, userID string) (*totpInfo, []byte, int64, error) {
 		return nil, nil, errCode, errEncode
 	}
 
-	// Check whether the user_id exists in the totp table or not.
-	count, err := mariadb.TotpUserCheck(userID)
+	// The totp row keeps whether the user has enabled TOTP
+	if err = mariadb.TotpEnsureUser(userID); err != nil {
+		errorMessage := fmt.Sprintf("Insert totp table failed: %v", err)
+		slog.Error(errorMessage)
+		errCode = 1013
+		return nil, nil, errCode, err
+	}
+
+	totpData := &totpInfo{
+		DeviceID: encrypt.GenertateUUID(true),
+		Name:     name,
+		Base32:   key.Secret(),
+		AuthURL:  key.URL(),
+	}
 
+	err = mariadb.TotpDeviceStore(totpData.DeviceID, userID, name, key.Secret(), key.URL())
 	if err != nil {
-		errorMessage := fmt.Sprintf("Check whether the user_id exists or not failed: %v", err)
+		errorMessage := fmt.Sprintf("Insert totp_device table failed: %v", err)
 		slog.Error(errorMessage)
-		errCode = 1011
+		errCode = 1013
 		return nil, nil, errCode, err
 	}
 
-	if count == 1 {
-		err := mariadb.TotpUpdateSecret(mail, key.Secret(), key.URL())
+	return totpData, buf.Bytes(), errCode, nil
+}
+
+// TotpValidate returns the time step of the code when it's valid, totpURL is
+// the otpauth URL stored with the secret.
+func TotpValidate(totpCode, totpKey, totpURL string) (int64, bool) {
+	opts := totp.ValidateOpts{
+		Period:    period,
+		Digits:    digits,
+		Algorithm: algorithm,
+	}
+	if key, err := otp.NewKeyFromURL(totpURL); err == nil {
+		opts.Period = uint(key.Period())
+		opts.Digits = key.Digits()
+		opts.Algorithm = key.Algorithm()
+	}
+
+	totpCode = strings.TrimSpace(totpCode)
+	if len(totpCode) != opts.Digits.Length() {
+		return 0, false
+	}
+
+	now := time.Now().Unix()
+	current := now / int64(opts.Period)
+	for i := -int64(skew); i <= int64(skew); i++ {
+		step := current + i
+		code, err := totp.GenerateCodeCustom(totpKey, time.Unix(step*int64(opts.Period), 0), opts)
 		if err != nil {
-			errorMessage := fmt.Sprintf("Update totp table failed: %v", err)
-			slog.Error(errorMessage)
-			errCode = 1012
-			return nil, nil, errCode, err
+			return 0, false
 		}
-	} else {
-		err := mariadb.TotpStoreSecret(userID, key.Secret(), key.URL())
-		if err != nil {
-			errorMessage := fmt.Sprintf("Insert totp table failed: %v", err)
-			slog.Error(errorMessage)
-			errCode = 1013
-			return nil, nil, errCode, err
+		if subtle.ConstantTimeCompare([]byte(code), []byte(totpCode)) == 1 {
+			return step, true
 		}
 	}
 
-	return totpData, buf.Bytes(), errCode, nil
+	return 0, false
 }
 
-func TotpValidate(totpCode, totpKey string) bool {
+// TotpAccept validates the code with the TOTP device and records its time
+// step, so a code can't be replayed. A code of an earlier step than the last
+// accepted one is rejected as well.
+func TotpAccept(device mariadb.TotpDeviceInfo, totpCode string) (bool, error) {
+	step, valid := TotpValidate(totpCode, device.TotpSecret, device.TotpURL)
+	if !valid {
+		return false, nil
+	}
 
-	valid := totp.Validate(totpCode, totpKey)
+	return mariadb.TotpUseStep(device.DeviceID, step)
+}
 
-	if valid {
-		return true
-	} else {
-		return false
+// TotpAcceptAny tries the code with every device, it returns the device which
+// accepts the code.
+func TotpAcceptAny(devices []mariadb.TotpDeviceInfo, totpCode string) (*mariadb.TotpDeviceInfo, error) {
+	for i := range devices {
+		accepted, err := TotpAccept(devices[i], totpCode)
+		if err != nil {
+			return nil, err
+		}
+		if accepted {
+			return &devices[i], nil
+		}
 	}
+	return nil, nil
 }
@@ -0,0 +1,153 @@
+package totp
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+
+	mariadb "suglider-auth/internal/database"
+)
+
+func generateKey(t *testing.T, keyPeriod uint) (*otp.Key, totp.ValidateOpts) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      "Suglider",
+		AccountName: "alice@example.com",
+		Period:      keyPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		t.Fatalf("Unit Test (Generate TOTP key) Fail: %v\n", err)
+	}
+
+	return key, totp.ValidateOpts{Period: keyPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+}
+
+func codeAt(t *testing.T, key *otp.Key, opts totp.ValidateOpts, step int64) string {
+	code, err := totp.GenerateCodeCustom(key.Secret(), time.Unix(step*int64(opts.Period), 0), opts)
+	if err != nil {
+		t.Fatalf("Unit Test (Generate TOTP code) Fail: %v\n", err)
+	}
+	return code
+}
+
+func TestTotpValidate(t *testing.T) {
+	defaultSkew := skew
+	skew = 1
+	defer func() { skew = defaultSkew }()
+
+	t.Run("Test the codes within the skew", func(t *testing.T) {
+		key, opts := generateKey(t, 30)
+		current := time.Now().Unix() / 30
+
+		testCases := []struct {
+			offset int64
+			valid  bool
+			reason string
+		}{
+			{0, true, "The code of the current period must be accepted."},
+			{-1, true, "The code of the previous period is within the skew."},
+			{1, true, "The code of the next period is within the skew."},
+			{-2, false, "The code before the skew must be rejected."},
+			{2, false, "The code after the skew must be rejected."},
+		}
+		for _, testCase := range testCases {
+			step, valid := TotpValidate(codeAt(t, key, opts, current+testCase.offset), key.Secret(), key.URL())
+			if valid != testCase.valid {
+				t.Errorf("Result: %v (%s)\n", valid, testCase.reason)
+			}
+			if valid && step != current+testCase.offset {
+				t.Errorf("Result: %d (%s)\n", step, "The time step of the code must be returned.")
+			}
+		}
+	})
+
+	t.Run("Test the period of the URL is used", func(t *testing.T) {
+		key, opts := generateKey(t, 60)
+		current := time.Now().Unix() / 60
+
+		if _, valid := TotpValidate(codeAt(t, key, opts, current), key.Secret(), key.URL()); !valid {
+			t.Errorf("Result: %v (%s)\n", valid, "The code must be validated with the period of its secret.")
+		}
+	})
+
+	t.Run("Test a malformed code", func(t *testing.T) {
+		key, opts := generateKey(t, 30)
+		code := codeAt(t, key, opts, time.Now().Unix()/30)
+
+		if _, valid := TotpValidate(" "+code+" ", key.Secret(), key.URL()); !valid {
+			t.Errorf("Result: %v (%s)\n", valid, "The spaces around the code must be ignored.")
+		}
+		if _, valid := TotpValidate(code[:5], key.Secret(), key.URL()); valid {
+			t.Errorf("Result: %v (%s)\n", valid, "A code of the wrong length must be rejected.")
+		}
+	})
+}
+
+func TestTotpAccept(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unit Test (Mock database) Fail: %v\n", err)
+	}
+	defaultDataBase := mariadb.DataBase
+	mariadb.DataBase = sqlx.NewDb(db, "mysql")
+	defer func() {
+		mariadb.DataBase = defaultDataBase
+		db.Close()
+	}()
+
+	key, opts := generateKey(t, 30)
+	step := time.Now().Unix() / 30
+	device := mariadb.TotpDeviceInfo{DeviceID: "device-1", TotpSecret: key.Secret(), TotpURL: key.URL()}
+
+	// The step is only recorded when it's later than the last accepted one,
+	// the database reports no update for a replayed code
+	useStep := `UPDATE suglider\.totp_device SET last_used_step = \?, last_used_at = NOW\(\) WHERE device_id = \? AND last_used_step < \?`
+	mock.ExpectExec(useStep).WithArgs(step, "device-1", step).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(useStep).WithArgs(step, "device-1", step).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	accepted, err := TotpAccept(device, codeAt(t, key, opts, step))
+	if err != nil || !accepted {
+		t.Errorf("Result: %v, %v (%s)\n", accepted, err, "The code must be accepted the first time.")
+	}
+
+	accepted, err = TotpAccept(device, codeAt(t, key, opts, step))
+	if err != nil || accepted {
+		t.Errorf("Result: %v, %v (%s)\n", accepted, err, "The replayed code must be rejected.")
+	}
+
+	if accepted, err = TotpAccept(device, "000000x"); err != nil || accepted {
+		t.Errorf("Result: %v, %v (%s)\n", accepted, err, "An invalid code must be rejected without recording the step.")
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unit Test (Database expectations) Fail: %v\n", err)
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	key, _ := generateKey(t, 30)
+
+	imageData, err := QRCode(key.URL(), 256)
+	if err != nil {
+		t.Fatalf("Unit Test (Render QR code) Fail: %v\n", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		t.Fatalf("Result: %v (%s)\n", err, "The QR code must be a PNG image.")
+	}
+	if bounds := img.Bounds(); bounds.Dx() != 256 || bounds.Dy() != 256 {
+		t.Errorf("Result: %v (%s)\n", bounds, "The QR code must have the requested size.")
+	}
+
+	if _, err = QRCode("not a url\x7f", 256); err == nil {
+		t.Errorf("Result: %v (%s)\n", err, "An invalid otpauth URL must be rejected.")
+	}
+}